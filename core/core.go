@@ -75,17 +75,19 @@ type Plugin interface {
 // InstanceInfo encapsulates data that is specific to a running instance of
 // netplugin like label of host on which it is started.
 type InstanceInfo struct {
-	StateDriver StateDriver `json:"-"`
-	HostLabel   string      `json:"host-label"`
-	CtrlIP      string      `json:"ctrl-ip"`
-	VtepIP      string      `json:"vtep-ip"`
-	UplinkIntf  []string    `json:"uplink-if"`
-	RouterIP    string      `json:"router-ip"`
-	FwdMode     string      `json:"fwd-mode"`
-	ArpMode     string      `json:"arp-mode"`
-	DbURL       string      `json:"db-url"`
-	PluginMode  string      `json:"plugin-mode"`
-	HostPvtNW   int         `json:"host-pvt-nw"`
+	StateDriver   StateDriver `json:"-"`
+	HostLabel     string      `json:"host-label"`
+	CtrlIP        string      `json:"ctrl-ip"`
+	VtepIP        string      `json:"vtep-ip"`
+	UplinkIntf    []string    `json:"uplink-if"`
+	RouterIP      string      `json:"router-ip"`
+	FwdMode       string      `json:"fwd-mode"`
+	ArpMode       string      `json:"arp-mode"`
+	DbURL         string      `json:"db-url"`
+	PluginMode    string      `json:"plugin-mode"`
+	HostPvtNW     int         `json:"host-pvt-nw"`
+	NodeProxy     string      `json:"node-proxy"`     // iptables | ipvs
+	IPVSScheduler string      `json:"ipvs-scheduler"` // rr | lc
 }
 
 // PortSpec defines protocol/port info required to host the service
@@ -98,9 +100,12 @@ type PortSpec struct {
 
 // ServiceSpec defines a service to be proxied
 type ServiceSpec struct {
-	IPAddress   string
-	Ports       []PortSpec
-	ExternalIPs []string // externally visible IPs
+	IPAddress       string
+	Ports           []PortSpec
+	ExternalIPs     []string          // externally visible IPs
+	SessionAffinity string            // ClientIP or empty for no affinity
+	AffinityTimeout uint32            // ClientIP affinity timeout in seconds
+	Weights         map[string]uint16 // provider weights, provider IP as key
 }
 
 // Driver implements the programming logic
@@ -113,6 +118,7 @@ type NetworkDriver interface {
 	Deinit()
 	CreateNetwork(id string) error
 	DeleteNetwork(id, nwType, encap string, pktTag, extPktTag int, gateway string, tenant string) error
+	UpdateNetwork(id, prevGateway string) error
 	CreateEndpoint(id string) error
 	UpdateEndpointGroup(id string) error
 	DeleteEndpoint(id string) error
@@ -132,6 +138,8 @@ type NetworkDriver interface {
 	SvcProviderUpdate(svcName string, providers []string)
 	// Get endpoint stats
 	GetEndpointStats() ([]byte, error)
+	// Get policy rule stats
+	GetPolicyRuleStats() ([]byte, error)
 	// return current state in json form
 	InspectState() ([]byte, error)
 	// return bgp in json form
@@ -180,6 +188,16 @@ type StateDriver interface {
 	WatchAllState(baseKey string, stateType State,
 		unmarshal func([]byte, interface{}) error, rsps chan WatchState) error
 	ClearState(key string) error
+
+	// ReadStateRevision reads a state along with its revision. The revision
+	// of a state changes every time it is written.
+	ReadStateRevision(key string, value State,
+		unmarshal func([]byte, interface{}) error) (uint64, error)
+	// WriteStateIfRevision writes a state only if it is still at the given
+	// revision, and fails with ErrRevisionMismatch otherwise. Revision 0
+	// writes the state only if it doesn't exist yet.
+	WriteStateIfRevision(key string, value State,
+		marshal func(interface{}) ([]byte, error), revision uint64) error
 }
 
 // Resource defines a allocatable unit. A resource is uniquely identified
@@ -91,3 +91,12 @@ func ErrIfKeyExists(err error) error {
 
 	return err
 }
+
+// ErrRevisionMismatch is the error returned by conditional state writes when
+// the state was changed since it was read.
+const ErrRevisionMismatch = "Revision mismatch"
+
+// IsRevisionMismatch checks if the error message contains "Revision mismatch".
+func IsRevisionMismatch(err error) bool {
+	return err != nil && strings.Contains(err.Error(), ErrRevisionMismatch)
+}
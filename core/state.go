@@ -16,6 +16,43 @@ type WatchableState interface {
 	WatchAll(rsps chan WatchState) error
 }
 
+// RevisionState allows for the rest of core.State, plus a read that returns
+// the revision of the state and a write that only succeeds if the state is
+// still at that revision.
+type RevisionState interface {
+	State
+	ReadRevision(id string) (uint64, error)
+	WriteIfRevision(revision uint64) error
+}
+
+// maxStateUpdateRetries is the number of times UpdateState retries an update
+// that conflicts with concurrent writes
+const maxStateUpdateRetries = 10
+
+// UpdateState reads the state for id, applies update to it and writes it
+// back only if it was not changed in the meantime. Conflicting updates are
+// retried on a fresh read of the state, so update must only depend on the
+// state it is applied to.
+func UpdateState(s RevisionState, id string, update func() error) error {
+	for i := 0; i < maxStateUpdateRetries; i++ {
+		revision, err := s.ReadRevision(id)
+		if err != nil {
+			return err
+		}
+
+		if err := update(); err != nil {
+			return err
+		}
+
+		err = s.WriteIfRevision(revision)
+		if !IsRevisionMismatch(err) {
+			return err
+		}
+	}
+
+	return Errorf("%s: too many conflicting updates of %s", ErrRevisionMismatch, id)
+}
+
 // CommonState defines the fields common to all core.State implementations.
 // This struct shall be embedded as anonymous field in all structs that
 // implement core.State
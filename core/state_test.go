@@ -0,0 +1,98 @@
+package core
+
+import (
+	"testing"
+)
+
+// testRevisionState is a counter whose stored value is changed by a
+// concurrent writer before each of its first conflicts writes
+type testRevisionState struct {
+	CommonState
+	Count     int
+	stored    int
+	revision  uint64
+	conflicts int
+}
+
+func (s *testRevisionState) Read(id string) error {
+	s.Count = s.stored
+	return nil
+}
+
+func (s *testRevisionState) ReadAll() ([]State, error) {
+	return nil, Errorf("Shouldn't be called!")
+}
+
+func (s *testRevisionState) Write() error {
+	return Errorf("Shouldn't be called!")
+}
+
+func (s *testRevisionState) Clear() error {
+	return Errorf("Shouldn't be called!")
+}
+
+func (s *testRevisionState) ReadRevision(id string) (uint64, error) {
+	return s.revision, s.Read(id)
+}
+
+func (s *testRevisionState) WriteIfRevision(revision uint64) error {
+	if s.conflicts > 0 {
+		// a concurrent writer increments the counter first
+		s.conflicts--
+		s.stored++
+		s.revision++
+	}
+	if revision != s.revision {
+		return Errorf("%s for %s", ErrRevisionMismatch, s.ID)
+	}
+
+	s.stored = s.Count
+	s.revision++
+	return nil
+}
+
+func TestUpdateStateRetry(t *testing.T) {
+	s := &testRevisionState{conflicts: 2}
+	s.ID = "counter"
+
+	err := UpdateState(s, s.ID, func() error {
+		s.Count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error updating state. Err: %v", err)
+	}
+
+	// both concurrent increments and ours are kept
+	if s.stored != 3 {
+		t.Fatalf("Unexpected count %d after conflicting updates, expected 3", s.stored)
+	}
+}
+
+func TestUpdateStateConflicts(t *testing.T) {
+	s := &testRevisionState{conflicts: maxStateUpdateRetries}
+	s.ID = "counter"
+
+	err := UpdateState(s, s.ID, func() error {
+		s.Count++
+		return nil
+	})
+	if !IsRevisionMismatch(err) {
+		t.Fatalf("Update succeeded despite conflicting writes. Err: %v", err)
+	}
+	if s.stored != maxStateUpdateRetries {
+		t.Fatalf("Unexpected count %d after failed update", s.stored)
+	}
+}
+
+func TestUpdateStateError(t *testing.T) {
+	s := &testRevisionState{}
+	s.ID = "counter"
+
+	err := UpdateState(s, s.ID, func() error {
+		return Errorf("update failed")
+	})
+	if err == nil || IsRevisionMismatch(err) || s.revision != 0 {
+		t.Fatalf("Failed update was written. Err: %v", err)
+	}
+}
@@ -30,6 +30,11 @@ func (d *FakeNetEpDriver) DeleteNetwork(id, nwType, encap string, pktTag, extPkt
 	return core.Errorf("Not implemented")
 }
 
+// UpdateNetwork is not implemented.
+func (d *FakeNetEpDriver) UpdateNetwork(id, prevGateway string) error {
+	return core.Errorf("Not implemented")
+}
+
 // CreateEndpoint is not implemented.
 func (d *FakeNetEpDriver) CreateEndpoint(id string) error {
 	return core.Errorf("Not implemented")
@@ -104,6 +109,11 @@ func (d *FakeNetEpDriver) GetEndpointStats() ([]byte, error) {
 	return []byte{}, core.Errorf("Not implemented")
 }
 
+// GetPolicyRuleStats is not implemented
+func (d *FakeNetEpDriver) GetPolicyRuleStats() ([]byte, error) {
+	return []byte{}, core.Errorf("Not implemented")
+}
+
 // InspectState is not implemented
 func (d *FakeNetEpDriver) InspectState() ([]byte, error) {
 	return []byte{}, core.Errorf("Not implemented")
@@ -0,0 +1,254 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"encoding/binary"
+	"net"
+	osexec "os/exec"
+	"syscall"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// IPVS is programmed over the IPVS generic netlink family, the constants are
+// from linux/genetlink.h and linux/ip_vs.h
+const (
+	genlCtrlID             = 0x10
+	genlCtrlCmdGetFamily   = 3
+	genlCtrlAttrFamilyID   = 1
+	genlCtrlAttrFamilyName = 2
+
+	ipvsGenlName    = "IPVS"
+	ipvsGenlVersion = 1
+
+	ipvsCmdNewService = 1
+	ipvsCmdSetService = 2
+	ipvsCmdDelService = 3
+	ipvsCmdNewDest    = 5
+	ipvsCmdSetDest    = 6
+	ipvsCmdDelDest    = 7
+
+	ipvsCmdAttrService = 1
+	ipvsCmdAttrDest    = 2
+
+	ipvsSvcAttrAF        = 1
+	ipvsSvcAttrProtocol  = 2
+	ipvsSvcAttrAddr      = 3
+	ipvsSvcAttrPort      = 4
+	ipvsSvcAttrSchedName = 6
+	ipvsSvcAttrFlags     = 7
+	ipvsSvcAttrTimeout   = 8
+	ipvsSvcAttrNetmask   = 9
+
+	ipvsDestAttrAddr      = 1
+	ipvsDestAttrPort      = 2
+	ipvsDestAttrFwdMethod = 3
+	ipvsDestAttrWeight    = 4
+	ipvsDestAttrUThresh   = 5
+	ipvsDestAttrLThresh   = 6
+
+	ipvsConnFMasq      = 0x0
+	ipvsSvcFPersistent = 0x1
+)
+
+// ipvsService is an IPVS virtual server
+type ipvsService struct {
+	Protocol   string // TCP or UDP
+	Address    net.IP
+	Port       uint16
+	Scheduler  string
+	Persistent bool   // clients stick to their real server
+	Timeout    uint32 // persistence timeout in seconds
+}
+
+// ipvsDest is a real server of an IPVS virtual server
+type ipvsDest struct {
+	Address net.IP
+	Port    uint16
+	Weight  int
+}
+
+// ipvsClient programs IPVS virtual servers and their real servers
+type ipvsClient interface {
+	NewService(svc *ipvsService) error
+	UpdateService(svc *ipvsService) error
+	DelService(svc *ipvsService) error
+	NewDest(svc *ipvsService, dest *ipvsDest) error
+	UpdateDest(svc *ipvsService, dest *ipvsDest) error
+	DelDest(svc *ipvsService, dest *ipvsDest) error
+}
+
+// genlMsg is the generic netlink header of a request
+type genlMsg struct {
+	cmd     uint8
+	version uint8
+}
+
+func (m *genlMsg) Len() int {
+	return 4
+}
+
+func (m *genlMsg) Serialize() []byte {
+	return []byte{m.cmd, m.version, 0, 0}
+}
+
+// genlFamilyID looks up the id of a generic netlink family
+func genlFamilyID(name string) (uint16, error) {
+	req := nl.NewNetlinkRequest(genlCtrlID, 0)
+	req.AddData(&genlMsg{cmd: genlCtrlCmdGetFamily, version: 1})
+	req.AddData(nl.NewRtAttr(genlCtrlAttrFamilyName, nl.ZeroTerminated(name)))
+
+	msgs, err := req.Execute(syscall.NETLINK_GENERIC, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range msgs {
+		if len(msg) < 4 {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(msg[4:])
+		if err != nil {
+			return 0, err
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type == genlCtrlAttrFamilyID && len(attr.Value) >= 2 {
+				return nl.NativeEndian().Uint16(attr.Value), nil
+			}
+		}
+	}
+
+	return 0, core.Errorf("generic netlink family %s not found", name)
+}
+
+// ipvsNetlink is an ipvsClient over netlink
+type ipvsNetlink struct {
+	familyID uint16
+}
+
+// newIPVSNetlink loads the ipvs module and looks up the IPVS netlink family
+func newIPVSNetlink() (*ipvsNetlink, error) {
+	// ipvs may be built into the kernel, the family lookup tells if it
+	// is missing
+	osexec.Command("modprobe", "ip_vs").CombinedOutput()
+
+	familyID, err := genlFamilyID(ipvsGenlName)
+	if err != nil {
+		return nil, core.Errorf("ipvs is not available. Err: %v", err)
+	}
+
+	return &ipvsNetlink{familyID: familyID}, nil
+}
+
+func ipvsProto(protocol string) uint16 {
+	if protocol == "UDP" {
+		return syscall.IPPROTO_UDP
+	}
+	return syscall.IPPROTO_TCP
+}
+
+func ipvsPort(port uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, port)
+	return b
+}
+
+// serviceAttr builds the service attribute of a request, the scheduler and
+// flags are only needed to add or change a service
+func serviceAttr(svc *ipvsService, full bool) *nl.RtAttr {
+	attr := nl.NewRtAttr(ipvsCmdAttrService, nil)
+	nl.NewRtAttrChild(attr, ipvsSvcAttrAF, nl.Uint16Attr(syscall.AF_INET))
+	nl.NewRtAttrChild(attr, ipvsSvcAttrProtocol, nl.Uint16Attr(ipvsProto(svc.Protocol)))
+	nl.NewRtAttrChild(attr, ipvsSvcAttrAddr, svc.Address.To4())
+	nl.NewRtAttrChild(attr, ipvsSvcAttrPort, ipvsPort(svc.Port))
+	if !full {
+		return attr
+	}
+
+	flags := uint32(0)
+	if svc.Persistent {
+		flags = ipvsSvcFPersistent
+	}
+	flagsAttr := make([]byte, 8)
+	nl.NativeEndian().PutUint32(flagsAttr[0:4], flags)
+	nl.NativeEndian().PutUint32(flagsAttr[4:8], 0xffffffff)
+
+	nl.NewRtAttrChild(attr, ipvsSvcAttrSchedName, nl.ZeroTerminated(svc.Scheduler))
+	nl.NewRtAttrChild(attr, ipvsSvcAttrFlags, flagsAttr)
+	nl.NewRtAttrChild(attr, ipvsSvcAttrTimeout, nl.Uint32Attr(svc.Timeout))
+	nl.NewRtAttrChild(attr, ipvsSvcAttrNetmask, nl.Uint32Attr(0xffffffff))
+	return attr
+}
+
+// destAttr builds the real server attribute of a request, real servers are
+// reached by NAT
+func destAttr(dest *ipvsDest, full bool) *nl.RtAttr {
+	attr := nl.NewRtAttr(ipvsCmdAttrDest, nil)
+	nl.NewRtAttrChild(attr, ipvsDestAttrAddr, dest.Address.To4())
+	nl.NewRtAttrChild(attr, ipvsDestAttrPort, ipvsPort(dest.Port))
+	if !full {
+		return attr
+	}
+
+	nl.NewRtAttrChild(attr, ipvsDestAttrFwdMethod, nl.Uint32Attr(ipvsConnFMasq))
+	nl.NewRtAttrChild(attr, ipvsDestAttrWeight, nl.Uint32Attr(uint32(dest.Weight)))
+	nl.NewRtAttrChild(attr, ipvsDestAttrUThresh, nl.Uint32Attr(0))
+	nl.NewRtAttrChild(attr, ipvsDestAttrLThresh, nl.Uint32Attr(0))
+	return attr
+}
+
+func (h *ipvsNetlink) execute(cmd uint8, attrs ...*nl.RtAttr) error {
+	req := nl.NewNetlinkRequest(int(h.familyID), syscall.NLM_F_ACK)
+	req.AddData(&genlMsg{cmd: cmd, version: ipvsGenlVersion})
+	for _, attr := range attrs {
+		req.AddData(attr)
+	}
+
+	_, err := req.Execute(syscall.NETLINK_GENERIC, 0)
+	return err
+}
+
+// NewService adds a virtual server
+func (h *ipvsNetlink) NewService(svc *ipvsService) error {
+	return h.execute(ipvsCmdNewService, serviceAttr(svc, true))
+}
+
+// UpdateService changes the scheduler and persistence of a virtual server
+func (h *ipvsNetlink) UpdateService(svc *ipvsService) error {
+	return h.execute(ipvsCmdSetService, serviceAttr(svc, true))
+}
+
+// DelService deletes a virtual server and its real servers
+func (h *ipvsNetlink) DelService(svc *ipvsService) error {
+	return h.execute(ipvsCmdDelService, serviceAttr(svc, false))
+}
+
+// NewDest adds a real server to a virtual server
+func (h *ipvsNetlink) NewDest(svc *ipvsService, dest *ipvsDest) error {
+	return h.execute(ipvsCmdNewDest, serviceAttr(svc, false), destAttr(dest, true))
+}
+
+// UpdateDest changes the weight of a real server
+func (h *ipvsNetlink) UpdateDest(svc *ipvsService, dest *ipvsDest) error {
+	return h.execute(ipvsCmdSetDest, serviceAttr(svc, false), destAttr(dest, true))
+}
+
+// DelDest removes a real server from a virtual server
+func (h *ipvsNetlink) DelDest(svc *ipvsService, dest *ipvsDest) error {
+	return h.execute(ipvsCmdDelDest, serviceAttr(svc, false), destAttr(dest, false))
+}
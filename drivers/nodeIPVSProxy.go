@@ -0,0 +1,397 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+)
+
+// Node proxy implementations
+const (
+	NodeProxyIPTables = "iptables"
+	NodeProxyIPVS     = "ipvs"
+)
+
+// IPVS schedulers of the ipvs node proxy, providers with weights are
+// scheduled by the weighted variant of the scheduler
+const (
+	IPVSSchedulerRR = "rr"
+	IPVSSchedulerLC = "lc"
+)
+
+// ipvsVirtServer is a virtual server of a node port with its real servers
+type ipvsVirtServer struct {
+	svc   ipvsService
+	dests map[string]ipvsDest // real server address:port as key
+}
+
+// NodeIPVSProxy is a node proxy that programs each node port of a service
+// as an IPVS virtual server on the node addresses, with the local providers
+// as real servers
+type NodeIPVSProxy struct {
+	Mutex       sync.Mutex
+	SvcMap      map[string]core.ServiceSpec // service name as key
+	ProvMap     map[string]Presence         // service name as key
+	LocalIP     map[string]string           // globalIP as key
+	scheduler   string
+	ipvs        ipvsClient
+	nodeAddrs   func() ([]net.IP, error)              // node addresses of the virtual servers
+	virtServers map[string]map[string]*ipvsVirtServer // service name, then virtual server key
+}
+
+// NewNodeIPVSProxy creates an instance of the ipvs node proxy
+func NewNodeIPVSProxy(scheduler string) (*NodeIPVSProxy, error) {
+	if scheduler == "" {
+		scheduler = IPVSSchedulerRR
+	}
+	if scheduler != IPVSSchedulerRR && scheduler != IPVSSchedulerLC {
+		return nil, core.Errorf("invalid ipvs scheduler %s", scheduler)
+	}
+
+	ipvs, err := newIPVSNetlink()
+	if err != nil {
+		log.Errorf("Failed to setup ipvs node proxy. Err: %v", err)
+		return nil, err
+	}
+
+	return newNodeIPVSProxy(scheduler, ipvs, localNodeAddrs), nil
+}
+
+func newNodeIPVSProxy(scheduler string, ipvs ipvsClient, nodeAddrs func() ([]net.IP, error)) *NodeIPVSProxy {
+	proxy := NodeIPVSProxy{}
+	proxy.SvcMap = make(map[string]core.ServiceSpec)
+	proxy.ProvMap = make(map[string]Presence)
+	proxy.LocalIP = make(map[string]string)
+	proxy.scheduler = scheduler
+	proxy.ipvs = ipvs
+	proxy.nodeAddrs = nodeAddrs
+	proxy.virtServers = make(map[string]map[string]*ipvsVirtServer)
+	return &proxy
+}
+
+// localNodeAddrs returns the ipv4 addresses of the node, loopback addresses
+// can't be ipvs virtual servers
+func localNodeAddrs() ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	nodeAddrs := []net.IP{}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil || ipNet.IP.IsLoopback() {
+			continue
+		}
+		nodeAddrs = append(nodeAddrs, ipNet.IP.To4())
+	}
+
+	return nodeAddrs, nil
+}
+
+// DeleteLocalIP removes an entry from the localIP map
+func (p *NodeIPVSProxy) DeleteLocalIP(globalIP string) {
+	// strip cidr
+	globalIP = strings.Split(globalIP, "/")[0]
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+	delete(p.LocalIP, globalIP)
+}
+
+// AddLocalIP adds an entry to the localIP map
+func (p *NodeIPVSProxy) AddLocalIP(globalIP, localIP string) {
+	// strip cidr
+	globalIP = strings.Split(globalIP, "/")[0]
+	localIP = strings.Split(localIP, "/")[0]
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+	p.LocalIP[globalIP] = localIP
+}
+
+// AddSvcSpec adds a service to the proxy
+func (p *NodeIPVSProxy) AddSvcSpec(svcName string, spec *core.ServiceSpec) error {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+	log.Infof("IPVS node proxy AddSvcSpec: %s", svcName)
+	// Determine if this is a node service
+	isNodeSvc := false
+	for _, port := range spec.Ports {
+		if port.NodePort != 0 && (port.Protocol == "TCP" || port.Protocol == "UDP") {
+			isNodeSvc = true
+			if nodePortClash(p.SvcMap, svcName, port.NodePort, port.Protocol) {
+				return nil
+			}
+		}
+	}
+
+	if !isNodeSvc {
+		p.deleteSvc(svcName) // delete it if it exists
+		return nil
+	}
+
+	p.SvcMap[svcName] = *spec
+	p.syncSvc(svcName)
+	return nil
+}
+
+// DelSvcSpec deletes a service from the proxy
+func (p *NodeIPVSProxy) DelSvcSpec(svcName string, spec *core.ServiceSpec) error {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+	p.deleteSvc(svcName) // delete it if it exists
+	return nil
+}
+
+// SvcProviderUpdate updates the local providers of a service
+func (p *NodeIPVSProxy) SvcProviderUpdate(svcName string, providers []string) {
+	log.Infof("IPVS node proxy SvcProviderUpdate: %s %v", svcName, providers)
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+
+	provMap := Presence{
+		Items: make(map[string]bool),
+	}
+	for _, prov := range providers {
+		if _, found := p.LocalIP[prov]; found {
+			provMap.Items[prov] = true
+		}
+	}
+
+	if len(provMap.Items) == 0 {
+		delete(p.ProvMap, svcName)
+	} else {
+		p.ProvMap[svcName] = provMap
+	}
+
+	p.syncSvc(svcName)
+}
+
+// syncSvc updates the virtual servers of a service to its spec and local
+// providers
+func (p *NodeIPVSProxy) syncSvc(svcName string) {
+	virtServers := make(map[string]*ipvsVirtServer)
+	spec, found := p.SvcMap[svcName]
+	pMap, provFound := p.ProvMap[svcName]
+	if found && provFound {
+		nodeAddrs, err := p.nodeAddrs()
+		if err != nil {
+			log.Errorf("Failed to get node addresses for svc %s, err: %v", svcName, err)
+			return
+		}
+		virtServers = p.svcVirtServers(&spec, pMap, nodeAddrs)
+	}
+
+	p.applyVirtServers(svcName, virtServers)
+}
+
+// svcVirtServers builds the virtual servers of the node ports of a service
+// on each node address. Real servers are weighted by the provider weights,
+// providers with weight 0 only get traffic if all providers have weight 0.
+// ClientIP affinity makes the virtual servers persistent for the affinity
+// timeout.
+func (p *NodeIPVSProxy) svcVirtServers(spec *core.ServiceSpec, providers Presence,
+	nodeAddrs []net.IP) map[string]*ipvsVirtServer {
+	scheduler := p.scheduler
+	if len(spec.Weights) > 0 {
+		scheduler = "w" + scheduler
+	}
+
+	weights := make(map[string]int)
+	totalWeight := 0
+	for prov := range providers.Items {
+		if _, found := p.LocalIP[prov]; !found {
+			continue
+		}
+		weights[prov] = provWeight(spec, prov)
+		totalWeight += weights[prov]
+	}
+	if totalWeight == 0 {
+		for prov := range weights {
+			weights[prov] = 1
+		}
+	}
+
+	affinity := spec.SessionAffinity == svcAffinityClientIP && spec.AffinityTimeout != 0
+
+	virtServers := make(map[string]*ipvsVirtServer)
+	for _, port := range spec.Ports {
+		if port.NodePort == 0 || (port.Protocol != "TCP" && port.Protocol != "UDP") {
+			continue
+		}
+
+		for _, addr := range nodeAddrs {
+			vs := &ipvsVirtServer{
+				svc: ipvsService{
+					Protocol:  port.Protocol,
+					Address:   addr,
+					Port:      port.NodePort,
+					Scheduler: scheduler,
+				},
+				dests: make(map[string]ipvsDest),
+			}
+			if affinity {
+				vs.svc.Persistent = true
+				vs.svc.Timeout = spec.AffinityTimeout
+			}
+
+			for prov, weight := range weights {
+				localProv := net.ParseIP(p.LocalIP[prov]).To4()
+				if localProv == nil {
+					continue
+				}
+				dest := ipvsDest{
+					Address: localProv,
+					Port:    port.ProvPort,
+					Weight:  weight,
+				}
+				vs.dests[destKey(&dest)] = dest
+			}
+
+			virtServers[virtServerKey(&vs.svc)] = vs
+		}
+	}
+
+	return virtServers
+}
+
+func virtServerKey(svc *ipvsService) string {
+	return fmt.Sprintf("%s/%s:%d", svc.Protocol, svc.Address, svc.Port)
+}
+
+func destKey(dest *ipvsDest) string {
+	return fmt.Sprintf("%s:%d", dest.Address, dest.Port)
+}
+
+// applyVirtServers programs the difference between the virtual servers of a
+// service and the ones it has in ipvs. Virtual servers that fail to program
+// are left out so that the next sync tries them again.
+func (p *NodeIPVSProxy) applyVirtServers(svcName string, virtServers map[string]*ipvsVirtServer) {
+	oldServers := p.virtServers[svcName]
+	newServers := make(map[string]*ipvsVirtServer)
+
+	for key, vs := range oldServers {
+		if _, found := virtServers[key]; found {
+			continue
+		}
+		if err := p.ipvs.DelService(&vs.svc); err != nil {
+			log.Errorf("Failed to delete virtual server %s of svc %s, err: %v", key, svcName, err)
+			newServers[key] = vs
+			continue
+		}
+		log.Infof("Svc %s -- deleted virtual server %s", svcName, key)
+	}
+
+	for key, vs := range virtServers {
+		oldVs, found := oldServers[key]
+		if !found {
+			if err := p.addVirtServer(vs); err != nil {
+				log.Errorf("Failed to add virtual server %s of svc %s, err: %v", key, svcName, err)
+				continue
+			}
+			log.Infof("Svc %s -- added virtual server %s", svcName, key)
+			newServers[key] = vs
+			continue
+		}
+
+		if err := p.updateVirtServer(oldVs, vs); err != nil {
+			log.Errorf("Failed to update virtual server %s of svc %s, err: %v", key, svcName, err)
+		}
+		newServers[key] = oldVs
+	}
+
+	if len(newServers) == 0 {
+		delete(p.virtServers, svcName)
+	} else {
+		p.virtServers[svcName] = newServers
+	}
+}
+
+// addVirtServer adds a virtual server and its real servers, a virtual server
+// left over from an earlier run is replaced
+func (p *NodeIPVSProxy) addVirtServer(vs *ipvsVirtServer) error {
+	if err := p.ipvs.NewService(&vs.svc); err != nil {
+		if delErr := p.ipvs.DelService(&vs.svc); delErr != nil {
+			return err
+		}
+		if err := p.ipvs.NewService(&vs.svc); err != nil {
+			return err
+		}
+	}
+
+	for key, dest := range vs.dests {
+		if err := p.ipvs.NewDest(&vs.svc, &dest); err != nil {
+			log.Errorf("Failed to add real server %s, err: %v", key, err)
+			delete(vs.dests, key)
+		}
+	}
+
+	return nil
+}
+
+// updateVirtServer brings a programmed virtual server to the new one, oldVs
+// is updated with what was programmed
+func (p *NodeIPVSProxy) updateVirtServer(oldVs, vs *ipvsVirtServer) error {
+	if !reflect.DeepEqual(oldVs.svc, vs.svc) {
+		if err := p.ipvs.UpdateService(&vs.svc); err != nil {
+			return err
+		}
+		oldVs.svc = vs.svc
+	}
+
+	for key, dest := range oldVs.dests {
+		if _, found := vs.dests[key]; found {
+			continue
+		}
+		if err := p.ipvs.DelDest(&oldVs.svc, &dest); err != nil {
+			log.Errorf("Failed to delete real server %s, err: %v", key, err)
+			continue
+		}
+		delete(oldVs.dests, key)
+	}
+
+	for key, dest := range vs.dests {
+		oldDest, found := oldVs.dests[key]
+		if found && oldDest.Weight == dest.Weight {
+			continue
+		}
+
+		var err error
+		if found {
+			err = p.ipvs.UpdateDest(&oldVs.svc, &dest)
+		} else {
+			err = p.ipvs.NewDest(&oldVs.svc, &dest)
+		}
+		if err != nil {
+			log.Errorf("Failed to program real server %s, err: %v", key, err)
+			continue
+		}
+		oldVs.dests[key] = dest
+	}
+
+	return nil
+}
+
+func (p *NodeIPVSProxy) deleteSvc(svcName string) {
+	p.applyVirtServers(svcName, nil)
+	delete(p.SvcMap, svcName)
+}
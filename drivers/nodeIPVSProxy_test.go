@@ -0,0 +1,144 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+)
+
+// fakeIPVS records the ipvs operations of the proxy
+type fakeIPVS struct {
+	ops []string
+}
+
+func (f *fakeIPVS) svcOp(op string, svc *ipvsService) error {
+	f.ops = append(f.ops, fmt.Sprintf("%s %s %s %v %d", op, virtServerKey(svc), svc.Scheduler,
+		svc.Persistent, svc.Timeout))
+	return nil
+}
+
+func (f *fakeIPVS) destOp(op string, svc *ipvsService, dest *ipvsDest) error {
+	f.ops = append(f.ops, fmt.Sprintf("%s %s %s %d", op, virtServerKey(svc), destKey(dest), dest.Weight))
+	return nil
+}
+
+func (f *fakeIPVS) NewService(svc *ipvsService) error    { return f.svcOp("new-svc", svc) }
+func (f *fakeIPVS) UpdateService(svc *ipvsService) error { return f.svcOp("set-svc", svc) }
+func (f *fakeIPVS) DelService(svc *ipvsService) error    { return f.svcOp("del-svc", svc) }
+
+func (f *fakeIPVS) NewDest(svc *ipvsService, dest *ipvsDest) error {
+	return f.destOp("new-dest", svc, dest)
+}
+func (f *fakeIPVS) UpdateDest(svc *ipvsService, dest *ipvsDest) error {
+	return f.destOp("set-dest", svc, dest)
+}
+func (f *fakeIPVS) DelDest(svc *ipvsService, dest *ipvsDest) error {
+	return f.destOp("del-dest", svc, dest)
+}
+
+// verifyOps checks the operations since the last check, in any order
+func (f *fakeIPVS) verifyOps(t *testing.T, expOps []string) {
+	ops := f.ops
+	f.ops = nil
+	sort.Strings(ops)
+	sort.Strings(expOps)
+	if len(ops) == 0 && len(expOps) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(ops, expOps) {
+		t.Fatalf("ipvs operations are %q, expected %q", ops, expOps)
+	}
+}
+
+func TestNodeIPVSProxy(t *testing.T) {
+	ipvs := &fakeIPVS{}
+	proxy := newNodeIPVSProxy(IPVSSchedulerLC, ipvs, func() ([]net.IP, error) {
+		return []net.IP{net.ParseIP("10.1.1.1").To4()}, nil
+	})
+	proxy.AddLocalIP("23.4.5.6/16", "172.20.0.2/16")
+	proxy.AddLocalIP("23.4.5.7/16", "172.20.0.3/16")
+
+	spec := core.ServiceSpec{
+		IPAddress: "10.254.0.10",
+		Ports: []core.PortSpec{
+			{Protocol: "TCP", SvcPort: 80, ProvPort: 8080, NodePort: 30080},
+			{Protocol: "UDP", SvcPort: 53, ProvPort: 5353, NodePort: 30053},
+			{Protocol: "TCP", SvcPort: 443, ProvPort: 8443},
+		},
+	}
+
+	// virtual servers are added once the service has local providers
+	proxy.AddSvcSpec("ipvsService", &spec)
+	ipvs.verifyOps(t, nil)
+	proxy.SvcProviderUpdate("ipvsService", []string{"23.4.5.6", "23.4.5.7", "23.4.5.9"})
+	ipvs.verifyOps(t, []string{
+		"new-svc TCP/10.1.1.1:30080 lc false 0",
+		"new-dest TCP/10.1.1.1:30080 172.20.0.2:8080 1",
+		"new-dest TCP/10.1.1.1:30080 172.20.0.3:8080 1",
+		"new-svc UDP/10.1.1.1:30053 lc false 0",
+		"new-dest UDP/10.1.1.1:30053 172.20.0.2:5353 1",
+		"new-dest UDP/10.1.1.1:30053 172.20.0.3:5353 1",
+	})
+
+	// a node port of another service clashes
+	proxy.AddSvcSpec("clashService", &core.ServiceSpec{
+		Ports: []core.PortSpec{{Protocol: "TCP", SvcPort: 80, ProvPort: 80, NodePort: 30080}},
+	})
+	proxy.SvcProviderUpdate("clashService", []string{"23.4.5.6"})
+	ipvs.verifyOps(t, nil)
+
+	// removed providers are removed from the virtual servers
+	proxy.SvcProviderUpdate("ipvsService", []string{"23.4.5.7"})
+	ipvs.verifyOps(t, []string{
+		"del-dest TCP/10.1.1.1:30080 172.20.0.2:8080 1",
+		"del-dest UDP/10.1.1.1:30053 172.20.0.2:5353 1",
+	})
+
+	// weights and client ip affinity change the virtual servers in place
+	spec.Ports = spec.Ports[:1]
+	spec.Weights = map[string]uint16{"23.4.5.7": 3}
+	spec.SessionAffinity = "ClientIP"
+	spec.AffinityTimeout = 600
+	proxy.AddSvcSpec("ipvsService", &spec)
+	ipvs.verifyOps(t, []string{
+		"del-svc UDP/10.1.1.1:30053 lc false 0",
+		"set-svc TCP/10.1.1.1:30080 wlc true 600",
+		"set-dest TCP/10.1.1.1:30080 172.20.0.3:8080 3",
+	})
+
+	// a provider with weight 0 gets traffic if all providers have weight 0
+	spec.Weights = map[string]uint16{"23.4.5.6": 0, "23.4.5.7": 0}
+	proxy.AddSvcSpec("ipvsService", &spec)
+	proxy.SvcProviderUpdate("ipvsService", []string{"23.4.5.6", "23.4.5.7"})
+	ipvs.verifyOps(t, []string{
+		"set-dest TCP/10.1.1.1:30080 172.20.0.3:8080 1",
+		"new-dest TCP/10.1.1.1:30080 172.20.0.2:8080 1",
+	})
+
+	proxy.DelSvcSpec("ipvsService", &spec)
+	ipvs.verifyOps(t, []string{
+		"del-svc TCP/10.1.1.1:30080 wlc true 600",
+	})
+	if len(proxy.virtServers) != 0 {
+		t.Fatalf("virtual servers left after delete: %v", proxy.virtServers)
+	}
+}
@@ -16,8 +16,11 @@ limitations under the License.
 package drivers
 
 import (
+	"bytes"
 	"fmt"
 	osexec "os/exec"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 
@@ -26,7 +29,8 @@ import (
 )
 
 const (
-	contivNPChain = "CONTIV-NODEPORT"
+	contivNPChain       = "CONTIV-NODEPORT"
+	svcAffinityClientIP = "ClientIP"
 )
 
 // Presence indicates presence of an item
@@ -34,6 +38,16 @@ type Presence struct {
 	Items map[string]bool
 }
 
+// NodeProxy exposes the node ports of services on the host, the iptables
+// NodeSvcProxy and the ipvs NodeIPVSProxy implement it
+type NodeProxy interface {
+	AddLocalIP(globalIP, localIP string)
+	DeleteLocalIP(globalIP string)
+	AddSvcSpec(svcName string, spec *core.ServiceSpec) error
+	DelSvcSpec(svcName string, spec *core.ServiceSpec) error
+	SvcProviderUpdate(svcName string, providers []string)
+}
+
 // NodeSvcProxy holds service proxy info
 type NodeSvcProxy struct {
 	Mutex        sync.Mutex
@@ -41,6 +55,7 @@ type NodeSvcProxy struct {
 	ProvMap      map[string]Presence         // service name as key
 	LocalIP      map[string]string           // globalIP as key
 	ipTablesPath string
+	restorePath  string              // iptables-restore path
 	natRules     map[string][]string // natRule for the service
 }
 
@@ -50,6 +65,10 @@ func NewNodeProxy() (*NodeSvcProxy, error) {
 	if err != nil {
 		return nil, err
 	}
+	restorePath, err := osexec.LookPath("iptables-restore")
+	if err != nil {
+		return nil, err
+	}
 
 	// Install contiv chain and jump
 	out, err := osexec.Command(ipTablesPath, "-t", "nat", "-N",
@@ -86,6 +105,7 @@ func NewNodeProxy() (*NodeSvcProxy, error) {
 	proxy.ProvMap = make(map[string]Presence)
 	proxy.LocalIP = make(map[string]string)
 	proxy.ipTablesPath = ipTablesPath
+	proxy.restorePath = restorePath
 	proxy.natRules = make(map[string][]string)
 	return &proxy, nil
 }
@@ -109,17 +129,22 @@ func (p *NodeSvcProxy) AddLocalIP(globalIP, localIP string) {
 	p.LocalIP[globalIP] = localIP
 }
 
-func (p *NodeSvcProxy) detectClash(svcName string, nodePort uint16) bool {
+func (p *NodeSvcProxy) detectClash(svcName string, nodePort uint16, protocol string) bool {
+	return nodePortClash(p.SvcMap, svcName, nodePort, protocol)
+}
+
+// nodePortClash checks if a node port of a service is used by another service
+func nodePortClash(svcMap map[string]core.ServiceSpec, svcName string, nodePort uint16, protocol string) bool {
 	// verify if there is a clashing nodeport
-	for svc, s := range p.SvcMap {
+	for svc, s := range svcMap {
 		if svc == svcName {
 			continue
 		}
 
 		for _, port := range s.Ports {
-			if port.NodePort == nodePort && port.Protocol == "TCP" {
-				log.Errorf("CONTIV-NODEPORT: %s/%d clashes with %s/%d",
-					svcName, nodePort, svc, nodePort)
+			if port.NodePort == nodePort && port.Protocol == protocol {
+				log.Errorf("CONTIV-NODEPORT: %s/%d/%s clashes with %s/%d/%s",
+					svcName, nodePort, protocol, svc, nodePort, protocol)
 				return true
 			}
 		}
@@ -136,9 +161,9 @@ func (p *NodeSvcProxy) AddSvcSpec(svcName string, spec *core.ServiceSpec) error
 	// Determine if this is a node service
 	isNodeSvc := false
 	for _, port := range spec.Ports {
-		if port.NodePort != 0 && port.Protocol == "TCP" {
+		if port.NodePort != 0 && (port.Protocol == "TCP" || port.Protocol == "UDP") {
 			isNodeSvc = true
-			if p.detectClash(svcName, port.NodePort) {
+			if p.detectClash(svcName, port.NodePort, port.Protocol) {
 				return nil
 			}
 		}
@@ -199,113 +224,157 @@ func (p *NodeSvcProxy) SvcProviderUpdate(svcName string, providers []string) {
 	p.syncSvc(svcName)
 }
 
-func (p *NodeSvcProxy) execNATRule(act, dport, dest string) (string, error) {
-	out, err := osexec.Command(p.ipTablesPath, "-t", "nat", act,
-		contivNPChain, "-p", "tcp", "-m", "tcp", "--dport",
-		dport, "-j", "DNAT", "--to-destination",
-		dest).CombinedOutput()
-	return string(out), err
-}
+// syncSvc updates the NAT rules of a service to its spec and local providers
 func (p *NodeSvcProxy) syncSvc(svcName string) {
 	// check if the service is active
-	_, found := p.SvcMap[svcName]
-
+	spec, found := p.SvcMap[svcName]
 	if !found {
 		p.deleteSvcRules(svcName)
 		return
 	}
 
 	pMap, found := p.ProvMap[svcName]
-	if !found {
+	if !found || len(pMap.Items) == 0 {
 		p.deleteSvcRules(svcName)
 		return
 	}
 
-	active := false
-	prov := ""
-	for prov = range pMap.Items {
-		active = true
-		break
+	natRules := p.svcNATRules(&spec, pMap)
+	oldRules, found := p.natRules[svcName]
+	if found && reflect.DeepEqual(oldRules, natRules) {
+		log.Infof("Svc %s -- all rules present", svcName)
+		return
 	}
 
-	if active {
-		p.installSvcRules(svcName, p.LocalIP[prov])
+	p.natRules[svcName] = natRules
+	if err := p.restoreNATRules(); err != nil {
+		log.Errorf("Failed to install rules of svc %s, err: %v", svcName, err)
+		if found {
+			p.natRules[svcName] = oldRules
+		} else {
+			delete(p.natRules, svcName)
+		}
 		return
 	}
-	p.deleteSvcRules(svcName)
+
+	log.Infof("Svc %s -- installed rules %v", svcName, natRules)
 }
-func findString(lines []string, matchStr string) bool {
-	for _, line := range lines {
-		if strings.Contains(line, matchStr) {
-			return true
-		}
-	}
 
-	return false
+// provWeight returns the weight of a provider of a service, providers without
+// a weight have weight 1
+func provWeight(spec *core.ServiceSpec, prov string) int {
+	weight, found := spec.Weights[prov]
+	if !found {
+		return 1
+	}
+	return int(weight)
 }
 
-func (p *NodeSvcProxy) installSvcRules(svcName, prov string) {
-	allPresent := true
-	spec := p.SvcMap[svcName]
-	providers := p.ProvMap[svcName]
-	natRules, found := p.natRules[svcName]
-	provToUse := prov
-	if found {
-		if len(natRules) == 0 {
-			found = false
+// svcNATRules builds the NAT rules of the node ports of a service. Traffic is
+// spread over the local providers by their weight, each rule takes its share
+// of the traffic the rules before it left over. Providers with weight 0 only
+// get traffic if all providers have weight 0. With ClientIP affinity a client
+// goes back to the provider it was sent to for the affinity timeout.
+func (p *NodeSvcProxy) svcNATRules(spec *core.ServiceSpec, providers Presence) []string {
+	// sort the providers so that the rules only change with the providers
+	provs := []string{}
+	weights := make(map[string]int)
+	totalWeight := 0
+	for prov := range providers.Items {
+		if _, found := p.LocalIP[prov]; !found {
+			continue
+		}
+		if weight := provWeight(spec, prov); weight > 0 {
+			provs = append(provs, prov)
+			weights[prov] = weight
+			totalWeight += weight
 		}
 	}
-
-	if !found {
-		allPresent = false
-	} else {
-		// find the in-use provider
+	if len(provs) == 0 {
 		for prov := range providers.Items {
-			localProv := p.LocalIP[prov]
-			if strings.Contains(natRules[0], localProv) {
-				provToUse = localProv
-				break
+			if _, found := p.LocalIP[prov]; found {
+				provs = append(provs, prov)
+				weights[prov] = 1
+				totalWeight++
+			}
+		}
+	}
+	sort.Strings(provs)
+
+	affinity := spec.SessionAffinity == svcAffinityClientIP && spec.AffinityTimeout != 0
+
+	natRules := []string{}
+	for _, port := range spec.Ports {
+		proto := strings.ToLower(port.Protocol)
+		if port.NodePort == 0 || (proto != "tcp" && proto != "udp") {
+			continue
+		}
+		match := fmt.Sprintf("-p %s -m %s --dport %d", proto, proto, port.NodePort)
+
+		// clients seen within the affinity timeout go to their provider
+		if affinity {
+			for _, prov := range provs {
+				localProv := p.LocalIP[prov]
+				natRules = append(natRules, fmt.Sprintf("%s -m recent --name %s --rcheck --seconds %d --reap "+
+					"-j DNAT --to-destination %s:%d", match, recentListName(proto, port.NodePort, localProv),
+					spec.AffinityTimeout, localProv, port.ProvPort))
 			}
 		}
 
-		// Check if all required NAT rules are present
-		for _, port := range spec.Ports {
-			matchStr := fmt.Sprintf(":%d", port.ProvPort)
-			if !findString(natRules, matchStr) {
-				allPresent = false
-				break
+		remaining := totalWeight
+		for _, prov := range provs {
+			localProv := p.LocalIP[prov]
+			weight := weights[prov]
+			rule := match
+			if remaining > weight {
+				rule += fmt.Sprintf(" -m statistic --mode random --probability %.5f",
+					float64(weight)/float64(remaining))
+			}
+			if affinity {
+				rule += " -m recent --name " + recentListName(proto, port.NodePort, localProv) + " --set"
 			}
+			rule += fmt.Sprintf(" -j DNAT --to-destination %s:%d", localProv, port.ProvPort)
+			natRules = append(natRules, rule)
+			remaining -= weight
 		}
 	}
 
-	if allPresent {
-		log.Infof("Svc %s -- all rules present", svcName)
-		return
-	}
+	return natRules
+}
 
-	// Remove all previous rules and install new ones
-	p.deleteSvcRules(svcName)
+// recentListName is the name of the recent list of the clients sent to a
+// provider from a node port
+func recentListName(proto string, nodePort uint16, localProv string) string {
+	return fmt.Sprintf("contiv-%s-%d-%s", proto, nodePort, localProv)
+}
 
-	natRules = make([]string, 0, len(spec.Ports))
-	for _, port := range spec.Ports {
-		if port.NodePort == 0 || port.Protocol != "TCP" {
-			continue
+// restoreNATRules replaces the rules of the contiv nodeport chain with the
+// NAT rules of all services in one iptables-restore
+func (p *NodeSvcProxy) restoreNATRules() error {
+	svcNames := []string{}
+	for svcName := range p.natRules {
+		svcNames = append(svcNames, svcName)
+	}
+	sort.Strings(svcNames)
+
+	var rules bytes.Buffer
+	rules.WriteString("*nat\n")
+	rules.WriteString(":" + contivNPChain + " - [0:0]\n")
+	for _, svcName := range svcNames {
+		for _, rule := range p.natRules[svcName] {
+			fmt.Fprintf(&rules, "-A %s %s\n", contivNPChain, rule)
 		}
+	}
+	rules.WriteString("COMMIT\n")
 
-		dport := fmt.Sprintf("%d", port.NodePort)
-		dest := fmt.Sprintf("%s:%d", provToUse, port.ProvPort)
-		out, err := p.execNATRule("-A", dport, dest)
-		addRule := dport + "/" + dest
-		if err != nil {
-			log.Errorf("Failed to add rule: %s, err: %v - %s",
-				addRule, err, out)
-		} else {
-			natRules = append(natRules, addRule)
-			log.Infof("Added %s", addRule)
-		}
+	cmd := osexec.Command(p.restorePath, "--noflush")
+	cmd.Stdin = &rules
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return core.Errorf("iptables-restore failed: %v - %s", err, out)
 	}
 
-	p.natRules[svcName] = natRules
+	return nil
 }
 
 func (p *NodeSvcProxy) deleteSvcRules(svcName string) {
@@ -313,19 +382,16 @@ func (p *NodeSvcProxy) deleteSvcRules(svcName string) {
 	if !found {
 		return
 	}
+
 	// Remove all rules
-	for _, rule := range natRules {
-		delRule := strings.Split(rule, "/")
-		out, err := p.execNATRule("-D", delRule[0], delRule[1])
-		if err != nil {
-			log.Errorf("Failed to delete rule: %s, err: %v - %s",
-				rule, err, out)
-		} else {
-			log.Infof("Deleted %s", rule)
-		}
+	delete(p.natRules, svcName)
+	if err := p.restoreNATRules(); err != nil {
+		log.Errorf("Failed to delete rules of svc %s, err: %v", svcName, err)
+		p.natRules[svcName] = natRules
+		return
 	}
 
-	delete(p.natRules, svcName)
+	log.Infof("Svc %s -- deleted rules", svcName)
 }
 
 func (p *NodeSvcProxy) deleteSvc(svcName string) {
@@ -18,6 +18,8 @@ package drivers
 import (
 	"fmt"
 	osexec "os/exec"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/contiv/netplugin/core"
@@ -26,12 +28,23 @@ import (
 var ipTablesPath string
 
 func verifyNATRule(nodePort uint16, destIP string, destPort uint16) error {
-	dport := fmt.Sprintf("%d", nodePort)
-	dest := fmt.Sprintf("%s:%d", destIP, destPort)
-	_, err := osexec.Command(ipTablesPath, "-t", "nat", "-C", contivNPChain,
-		"-p", "tcp", "-m", "tcp", "--dport", dport, "-j",
-		"DNAT", "--to-destination", dest).CombinedOutput()
-	return err
+	return verifyProtoNATRule("tcp", nodePort, destIP, destPort)
+}
+
+func verifyProtoNATRule(proto string, nodePort uint16, destIP string, destPort uint16) error {
+	dport := fmt.Sprintf("-p %s -m %s --dport %d ", proto, proto, nodePort)
+	dest := fmt.Sprintf("-j DNAT --to-destination %s:%d", destIP, destPort)
+	out, err := osexec.Command(ipTablesPath, "-t", "nat", "-S",
+		contivNPChain).CombinedOutput()
+	if err != nil {
+		return err
+	}
+	for _, rule := range strings.Split(string(out), "\n") {
+		if strings.Contains(rule, dport) && strings.HasSuffix(rule, dest) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no %s rule for %d => %s:%d", proto, nodePort, destIP, destPort)
 }
 
 func TestNodeProxy(t *testing.T) {
@@ -118,12 +131,17 @@ func TestNodeProxy(t *testing.T) {
 
 	// Issue another provider update
 	driver.HostProxy.SvcProviderUpdate("LipService", []string{"23.4.5.6", "23.4.5.7", "23.4.5.8"})
-	// verify no change to rule
+	// verify traffic is spread over both local providers
 	err = verifyNATRule(19201, "172.20.0.2", 9601)
 	if err != nil {
 		t.Errorf("NAT rule not found for 19201=>172.20.0.2:9601 -- err: %v",
 			err)
 	}
+	err = verifyNATRule(19201, "172.20.0.3", 9601)
+	if err != nil {
+		t.Errorf("NAT rule not found for 19201=>172.20.0.3:9601 -- err: %v",
+			err)
+	}
 
 	// Add a second service with same nodeport
 	svcPortsNew := make([]core.PortSpec, 1)
@@ -165,6 +183,29 @@ func TestNodeProxy(t *testing.T) {
 		t.Errorf("NAT rule for 19202 => 172.20.0.3:9602 still exists")
 	}
 
+	// Add a udp nodePort service on the same nodeport
+	svcUDP := core.ServiceSpec{
+		IPAddress: "10.254.0.12",
+		Ports: []core.PortSpec{{
+			Protocol: "UDP",
+			SvcPort:  53,
+			ProvPort: 5353,
+			NodePort: 19201,
+		}},
+	}
+	driver.HostProxy.AddSvcSpec("UDPService", &svcUDP)
+	driver.HostProxy.SvcProviderUpdate("UDPService", []string{"23.4.5.8"})
+	err = verifyProtoNATRule("udp", 19201, "172.20.0.3", 5353)
+	if err != nil {
+		t.Errorf("NAT rule not found for udp 19201=>172.20.0.3:5353 -- err: %v",
+			err)
+	}
+	driver.HostProxy.DelSvcSpec("UDPService", &svcUDP)
+	err = verifyProtoNATRule("udp", 19201, "172.20.0.3", 5353)
+	if err == nil {
+		t.Errorf("NAT rule still exists for udp 19201=>172.20.0.3:5353")
+	}
+
 	// Delete the service
 	driver.HostProxy.DelSvcSpec("LipService", &svc)
 	err = verifyNATRule(19201, "172.20.0.2", 9601)
@@ -172,3 +213,66 @@ func TestNodeProxy(t *testing.T) {
 		t.Errorf("NAT rule still exists for 19201=>172.20.0.2:9601")
 	}
 }
+
+func TestNodeProxyNATRules(t *testing.T) {
+	proxy := &NodeSvcProxy{
+		LocalIP: map[string]string{
+			"23.4.5.6": "172.20.0.2",
+			"23.4.5.7": "172.20.0.3",
+			"23.4.5.8": "172.20.0.4",
+		},
+	}
+	spec := &core.ServiceSpec{
+		IPAddress: "10.254.0.10",
+		Ports: []core.PortSpec{
+			{Protocol: "TCP", SvcPort: 80, ProvPort: 8080, NodePort: 30080},
+			{Protocol: "UDP", SvcPort: 53, ProvPort: 5353, NodePort: 30053},
+			{Protocol: "TCP", SvcPort: 443, ProvPort: 8443},
+		},
+	}
+	providers := Presence{Items: map[string]bool{"23.4.5.6": true, "23.4.5.7": true, "23.4.5.9": true}}
+
+	// traffic is spread evenly over the local providers
+	expRules := []string{
+		"-p tcp -m tcp --dport 30080 -m statistic --mode random --probability 0.50000 -j DNAT --to-destination 172.20.0.2:8080",
+		"-p tcp -m tcp --dport 30080 -j DNAT --to-destination 172.20.0.3:8080",
+		"-p udp -m udp --dport 30053 -m statistic --mode random --probability 0.50000 -j DNAT --to-destination 172.20.0.2:5353",
+		"-p udp -m udp --dport 30053 -j DNAT --to-destination 172.20.0.3:5353",
+	}
+	if rules := proxy.svcNATRules(spec, providers); !reflect.DeepEqual(rules, expRules) {
+		t.Fatalf("NAT rules are %q, expected %q", rules, expRules)
+	}
+
+	// weights and client ip affinity
+	spec.Ports = spec.Ports[:1]
+	spec.Weights = map[string]uint16{"23.4.5.6": 3, "23.4.5.7": 1}
+	spec.SessionAffinity = "ClientIP"
+	spec.AffinityTimeout = 600
+	expRules = []string{
+		"-p tcp -m tcp --dport 30080 -m recent --name contiv-tcp-30080-172.20.0.2 --rcheck --seconds 600 --reap -j DNAT --to-destination 172.20.0.2:8080",
+		"-p tcp -m tcp --dport 30080 -m recent --name contiv-tcp-30080-172.20.0.3 --rcheck --seconds 600 --reap -j DNAT --to-destination 172.20.0.3:8080",
+		"-p tcp -m tcp --dport 30080 -m statistic --mode random --probability 0.75000 -m recent --name contiv-tcp-30080-172.20.0.2 --set -j DNAT --to-destination 172.20.0.2:8080",
+		"-p tcp -m tcp --dport 30080 -m recent --name contiv-tcp-30080-172.20.0.3 --set -j DNAT --to-destination 172.20.0.3:8080",
+	}
+	if rules := proxy.svcNATRules(spec, providers); !reflect.DeepEqual(rules, expRules) {
+		t.Fatalf("NAT rules are %q, expected %q", rules, expRules)
+	}
+
+	// providers with weight 0 only get traffic if all have weight 0
+	spec.SessionAffinity = ""
+	spec.Weights = map[string]uint16{"23.4.5.6": 0, "23.4.5.7": 1}
+	expRules = []string{
+		"-p tcp -m tcp --dport 30080 -j DNAT --to-destination 172.20.0.3:8080",
+	}
+	if rules := proxy.svcNATRules(spec, providers); !reflect.DeepEqual(rules, expRules) {
+		t.Fatalf("NAT rules are %q, expected %q", rules, expRules)
+	}
+	spec.Weights = map[string]uint16{"23.4.5.6": 0, "23.4.5.7": 0}
+	expRules = []string{
+		"-p tcp -m tcp --dport 30080 -m statistic --mode random --probability 0.50000 -j DNAT --to-destination 172.20.0.2:8080",
+		"-p tcp -m tcp --dport 30080 -j DNAT --to-destination 172.20.0.3:8080",
+	}
+	if rules := proxy.svcNATRules(spec, providers); !reflect.DeepEqual(rules, expRules) {
+		t.Fatalf("NAT rules are %q, expected %q", rules, expRules)
+	}
+}
@@ -194,6 +194,18 @@ func (sw *OvsSwitch) CreateNetwork(pktTag uint16, extPktTag uint32, defaultGw st
 	return nil
 }
 
+// UpdateNetwork updates the gateway of an existing network/vlan
+func (sw *OvsSwitch) UpdateNetwork(pktTag uint16, extPktTag uint32, oldGw, newGw string, Vrf string) error {
+	if sw.ofnetAgent != nil {
+		err := sw.ofnetAgent.UpdateNetwork(pktTag, extPktTag, oldGw, newGw, Vrf)
+		if err != nil {
+			log.Errorf("Error updating vlan/vni %d/%d. Err: %v", pktTag, extPktTag, err)
+			return err
+		}
+	}
+	return nil
+}
+
 // DeleteNetwork deletes a network/vlan
 func (sw *OvsSwitch) DeleteNetwork(pktTag uint16, extPktTag uint32, gateway string, Vrf string) error {
 	// Delete vlan/vni mapping
@@ -1027,6 +1039,21 @@ func (sw *OvsSwitch) GetEndpointStats() (map[string]*ofnet.OfnetEndpointStats, e
 	return stats, nil
 }
 
+// GetPolicyRuleStats invokes ofnetAgent api
+func (sw *OvsSwitch) GetPolicyRuleStats() (map[string]*ofnet.OfnetPolicyRuleStats, error) {
+	if sw.ofnetAgent == nil {
+		return nil, errors.New("No ofnet agent")
+	}
+
+	stats, err := sw.ofnetAgent.GetPolicyRuleStats()
+	if err != nil {
+		log.Errorf("Error: %v", err)
+		return nil, err
+	}
+
+	return stats, nil
+}
+
 // InspectState ireturns ofnet state in json form
 func (sw *OvsSwitch) InspectState() (interface{}, error) {
 	if sw.ofnetAgent == nil {
@@ -52,8 +52,9 @@ type OvsDriverOperState struct {
 	core.CommonState
 
 	// used to allocate port names. XXX: should it be user controlled?
-	CurrPortNum      int                `json:"currPortNum"`
-	LocalEpInfo      map[string]*EpInfo `json:"LocalEpInfo"` // info about local endpoints
+	CurrPortNum      int                       `json:"currPortNum"`
+	LocalEpInfo      map[string]*EpInfo        `json:"LocalEpInfo"` // info about local endpoints
+	LocalVLANs       map[string]*LocalVLANInfo `json:"LocalVLANs"`  // local vlans of vxlan networks
 	localEpInfoMutex sync.Mutex
 }
 
@@ -87,7 +88,7 @@ type OvsDriver struct {
 	localIP    string                // Local IP address
 	switchDb   map[string]*OvsSwitch // OVS switch instances
 	lock       sync.Mutex            // lock for modifying shared state
-	HostProxy  *NodeSvcProxy
+	HostProxy  NodeProxy
 	nameServer *nameserver.NetpluginNameServer
 }
 
@@ -146,6 +147,9 @@ func (d *OvsDriver) Init(info *core.InstanceInfo) error {
 		// create local endpoint info map
 		d.oper.LocalEpInfo = make(map[string]*EpInfo)
 
+		// create local vlan map
+		d.oper.LocalVLANs = make(map[string]*LocalVLANInfo)
+
 		// write the oper
 		err = d.oper.Write()
 		if err != nil {
@@ -163,6 +167,16 @@ func (d *OvsDriver) Init(info *core.InstanceInfo) error {
 		}
 	}
 
+	// make sure LocalVLANs exists
+	if d.oper.LocalVLANs == nil {
+		d.oper.LocalVLANs = make(map[string]*LocalVLANInfo)
+		// write the oper
+		err = d.oper.Write()
+		if err != nil {
+			return err
+		}
+	}
+
 	log.Infof("Initializing ovsdriver")
 
 	// Init switch DB
@@ -218,7 +232,14 @@ func (d *OvsDriver) Init(info *core.InstanceInfo) error {
 	netutils.SetIPMasquerade(hostPortName, netmask)
 
 	// Initialize the node proxy
-	d.HostProxy, err = NewNodeProxy()
+	switch info.NodeProxy {
+	case "", NodeProxyIPTables:
+		d.HostProxy, err = NewNodeProxy()
+	case NodeProxyIPVS:
+		d.HostProxy, err = NewNodeIPVSProxy(info.IPVSScheduler)
+	default:
+		err = core.Errorf("invalid node proxy %s", info.NodeProxy)
+	}
 
 	return err
 }
@@ -294,10 +315,18 @@ func (d *OvsDriver) CreateNetwork(id string) error {
 	var sw *OvsSwitch
 	if cfgNw.PktTagType == "vxlan" {
 		sw = d.switchDb["vxlan"]
-	} else {
-		sw = d.switchDb["vlan"]
+
+		// vxlan networks are added with the first local endpoint, a mapped
+		// network is added again after a restart
+		localVLAN, found := d.getLocalVLAN(id)
+		if !found {
+			log.Infof("net %s has no local endpoints, not adding it", id)
+			return nil
+		}
+		return sw.CreateNetwork(localVLAN, uint32(cfgNw.ExtPktTag), cfgNw.Gateway, cfgNw.Tenant)
 	}
 
+	sw = d.switchDb["vlan"]
 	return sw.CreateNetwork(uint16(cfgNw.PktTag), uint32(cfgNw.ExtPktTag), cfgNw.Gateway, cfgNw.Tenant)
 }
 
@@ -330,9 +359,48 @@ func (d *OvsDriver) DeleteNetwork(id, nwType, encap string, pktTag, extPktTag in
 		}
 	}
 
+	// vxlan networks are in the switch only while they have a local vlan
+	if encap == "vxlan" {
+		localVLAN, found, err := d.freeLocalVLAN(id)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return nil
+		}
+		pktTag = int(localVLAN)
+	}
+
 	return sw.DeleteNetwork(uint16(pktTag), uint32(extPktTag), gateway, tenant)
 }
 
+// UpdateNetwork updates the gateway of a network by named identifier
+func (d *OvsDriver) UpdateNetwork(id, prevGateway string) error {
+	cfgNw := mastercfg.CfgNetworkState{}
+	cfgNw.StateDriver = d.oper.StateDriver
+	err := cfgNw.Read(id)
+	if err != nil {
+		log.Errorf("Failed to read net %s \n", id)
+		return err
+	}
+	log.Infof("update net %+v \n", cfgNw)
+
+	// Find the switch based on network type
+	var sw *OvsSwitch
+	if cfgNw.PktTagType == "vxlan" {
+		sw = d.switchDb["vxlan"]
+
+		localVLAN, found := d.getLocalVLAN(id)
+		if !found {
+			return nil
+		}
+		return sw.UpdateNetwork(localVLAN, uint32(cfgNw.ExtPktTag), prevGateway, cfgNw.Gateway, cfgNw.Tenant)
+	}
+
+	sw = d.switchDb["vlan"]
+	return sw.UpdateNetwork(uint16(cfgNw.PktTag), uint32(cfgNw.ExtPktTag), prevGateway, cfgNw.Gateway, cfgNw.Tenant)
+}
+
 // CreateEndpoint creates an endpoint by named identifier
 func (d *OvsDriver) CreateEndpoint(id string) error {
 	var (
@@ -361,6 +429,7 @@ func (d *OvsDriver) CreateEndpoint(id string) error {
 
 	pktTagType := cfgNw.PktTagType
 	pktTag := cfgNw.PktTag
+	nwPktTag := cfgNw.PktTag
 	cfgEpGroup := &mastercfg.EndpointGroupState{}
 	// Read pkt tags from endpoint group if available
 	if cfgEp.EndpointGroupKey != "" {
@@ -406,8 +475,16 @@ func (d *OvsDriver) CreateEndpoint(id string) error {
 		if operEp.Matches(cfgEp) {
 			log.Printf("Found matching oper state for ep %s, noop", id)
 
+			if pktTagType == "vxlan" {
+				nwPktTag, err = d.allocLocalVLAN(sw, &cfgNw, id)
+				if err != nil {
+					return err
+				}
+				pktTag = nwPktTag
+			}
+
 			// Ask the switch to update the port
-			err = sw.UpdatePort(operEp.PortName, cfgEp, pktTag, cfgNw.PktTag, dscp, skipVethPair)
+			err = sw.UpdatePort(operEp.PortName, cfgEp, pktTag, nwPktTag, dscp, skipVethPair)
 			if err != nil {
 				log.Errorf("Error creating port %s. Err: %v", intfName, err)
 				return err
@@ -420,6 +497,20 @@ func (d *OvsDriver) CreateEndpoint(id string) error {
 		d.DeleteEndpoint(operEp.ID)
 	}
 
+	// endpoints of vxlan networks use the local vlan of the network
+	if pktTagType == "vxlan" {
+		nwPktTag, err = d.allocLocalVLAN(sw, &cfgNw, id)
+		if err != nil {
+			return err
+		}
+		pktTag = nwPktTag
+		defer func() {
+			if err != nil {
+				d.releaseLocalVLAN(sw, &cfgNw, id)
+			}
+		}()
+	}
+
 	if cfgNw.NwType == "infra" {
 		// For infra nw, port name is network name
 		intfName = cfgNw.NetworkName
@@ -435,7 +526,7 @@ func (d *OvsDriver) CreateEndpoint(id string) error {
 	ovsPortName := getOvsPortName(intfName, skipVethPair)
 
 	// Ask the switch to create the port
-	err = sw.CreatePort(intfName, cfgEp, pktTag, cfgNw.PktTag, cfgEpGroup.Burst, dscp, skipVethPair, epgBandwidth)
+	err = sw.CreatePort(intfName, cfgEp, pktTag, nwPktTag, cfgEpGroup.Burst, dscp, skipVethPair, epgBandwidth)
 	if err != nil {
 		log.Errorf("Error creating port %s. Err: %v", intfName, err)
 		return err
@@ -562,6 +653,11 @@ func (d *OvsDriver) DeleteEndpoint(id string) error {
 	delete(d.oper.LocalEpInfo, id)
 	d.oper.localEpInfoMutex.Unlock()
 
+	// the last endpoint of a vxlan network frees its local vlan
+	if cfgNw.PktTagType == "vxlan" {
+		return d.releaseLocalVLAN(sw, &cfgNw, id)
+	}
+
 	return nil
 }
 
@@ -668,8 +764,11 @@ func convSvcSpec(spec *core.ServiceSpec) *ofnet.ServiceSpec {
 	}
 
 	ofnetSS := ofnet.ServiceSpec{
-		IpAddress: spec.IPAddress,
-		Ports:     pSpec,
+		IpAddress:       spec.IPAddress,
+		Ports:           pSpec,
+		SessionAffinity: spec.SessionAffinity,
+		AffinityTimeout: spec.AffinityTimeout,
+		Weights:         spec.Weights,
 	}
 	return &ofnetSS
 }
@@ -759,6 +858,39 @@ func (d *OvsDriver) GetEndpointStats() ([]byte, error) {
 	return jsonStats, nil
 }
 
+// GetPolicyRuleStats gets policy rule stats from all ovs instances
+func (d *OvsDriver) GetPolicyRuleStats() ([]byte, error) {
+	vxlanStats, err := d.switchDb["vxlan"].GetPolicyRuleStats()
+	if err != nil {
+		log.Errorf("Error getting vxlan policy stats. Err: %v", err)
+		return []byte{}, err
+	}
+
+	vlanStats, err := d.switchDb["vlan"].GetPolicyRuleStats()
+	if err != nil {
+		log.Errorf("Error getting vlan policy stats. Err: %v", err)
+		return []byte{}, err
+	}
+
+	// the same rule can be installed on both switches
+	for key, val := range vxlanStats {
+		if stats, ok := vlanStats[key]; ok {
+			stats.PacketCount += val.PacketCount
+			stats.ByteCount += val.ByteCount
+		} else {
+			vlanStats[key] = val
+		}
+	}
+
+	jsonStats, err := json.Marshal(vlanStats)
+	if err != nil {
+		log.Errorf("Error encoding policy stats. Err: %v", err)
+		return jsonStats, err
+	}
+
+	return jsonStats, nil
+}
+
 // InspectState returns driver state as json string
 func (d *OvsDriver) InspectState() ([]byte, error) {
 	driverState := make(map[string]interface{})
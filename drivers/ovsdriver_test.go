@@ -32,8 +32,12 @@ const (
 	createEpIDStateful         = "testCreateEpStateful"
 	createEpIDStatefulMismatch = "testCreateEpStatefulMismatch"
 	deleteEpID                 = "testDeleteEp"
+	vxlanEpID                  = "testVxlanEp"
+	vxlanEpID2                 = "testVxlanEp2"
 	testOvsNwID                = "testNetID"
 	testOvsNwIDStateful        = "testNetIDStateful"
+	testOvsVxlanNwID           = "testVxlanNetID"
+	testOvsVxlanNwID2          = "testVxlanNetID2"
 	testOvsEpGroupID           = "10"
 	testOvsEpGroupIDStateful   = "11"
 	testOvsEpgHandle           = 10
@@ -98,6 +102,21 @@ func createCommonState(stateDriver core.StateDriver) error {
 		}
 	}
 
+	for idx, nwID := range []string{testOvsVxlanNwID, testOvsVxlanNwID2} {
+		cfgNw := &mastercfg.CfgNetworkState{}
+		cfgNw.ID = nwID
+		cfgNw.PktTagType = "vxlan"
+		cfgNw.ExtPktTag = testExtPktTag + idx + 1
+		cfgNw.SubnetIP = testSubnetIP
+		cfgNw.SubnetLen = testSubnetLen
+		cfgNw.Gateway = testGateway
+		cfgNw.Tenant = testTenant
+		cfgNw.StateDriver = stateDriver
+		if err := cfgNw.Write(); err != nil {
+			return err
+		}
+	}
+
 	{
 		cfgEpGroup := &mastercfg.EndpointGroupState{}
 		cfgEpGroup.StateDriver = stateDriver
@@ -175,6 +194,18 @@ func createCommonState(stateDriver core.StateDriver) error {
 		}
 	}
 
+	for epID, nwID := range map[string]string{vxlanEpID: testOvsVxlanNwID, vxlanEpID2: testOvsVxlanNwID2} {
+		cfgEp := &mastercfg.CfgEndpointState{}
+		cfgEp.ID = epID
+		cfgEp.NetID = nwID
+		cfgEp.IPAddress = testEpAddress
+		cfgEp.MacAddress = testEpMacAddress
+		cfgEp.StateDriver = stateDriver
+		if err := cfgEp.Write(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -510,6 +541,74 @@ func TestOvsDriverDeleteEndpoint(t *testing.T) {
 	}
 }
 
+func TestOvsDriverVxlanLocalVLAN(t *testing.T) {
+	driver := initOvsDriver(t, bridgeMode, defPvtNW)
+	defer func() { driver.Deinit() }()
+
+	// vxlan networks get a local vlan with their first local endpoint
+	for _, nwID := range []string{testOvsVxlanNwID, testOvsVxlanNwID2} {
+		if err := driver.CreateNetwork(nwID); err != nil {
+			t.Fatalf("network creation failed. Error: %s", err)
+		}
+		if _, found := driver.getLocalVLAN(nwID); found {
+			t.Fatalf("network %s has a local vlan without local endpoints", nwID)
+		}
+	}
+	defer func() {
+		driver.DeleteNetwork(testOvsVxlanNwID, "", "vxlan", 0, testExtPktTag+1, testGateway, testTenant)
+		driver.DeleteNetwork(testOvsVxlanNwID2, "", "vxlan", 0, testExtPktTag+2, testGateway, testTenant)
+	}()
+
+	if err := driver.CreateEndpoint(vxlanEpID); err != nil {
+		t.Fatalf("endpoint creation failed. Error: %s", err)
+	}
+	if vlan, found := driver.getLocalVLAN(testOvsVxlanNwID); !found || vlan != 1 {
+		t.Fatalf("network %s local vlan mismatch. expected: 1, rcvd: %d", testOvsVxlanNwID, vlan)
+	}
+
+	if err := driver.CreateEndpoint(vxlanEpID2); err != nil {
+		t.Fatalf("endpoint creation failed. Error: %s", err)
+	}
+	if vlan, found := driver.getLocalVLAN(testOvsVxlanNwID2); !found || vlan != 2 {
+		t.Fatalf("network %s local vlan mismatch. expected: 2, rcvd: %d", testOvsVxlanNwID2, vlan)
+	}
+
+	// the last local endpoint frees the local vlan
+	time.Sleep(1 * time.Second)
+	if err := driver.DeleteEndpoint(vxlanEpID); err != nil {
+		t.Fatalf("endpoint deletion failed. Error: %s", err)
+	}
+	if _, found := driver.getLocalVLAN(testOvsVxlanNwID); found {
+		t.Fatalf("network %s has a local vlan after its last local endpoint was deleted", testOvsVxlanNwID)
+	}
+
+	if err := driver.CreateEndpoint(vxlanEpID); err != nil {
+		t.Fatalf("endpoint creation failed. Error: %s", err)
+	}
+	defer func() { driver.DeleteEndpoint(vxlanEpID) }()
+	defer func() { driver.DeleteEndpoint(vxlanEpID2) }()
+	if vlan, found := driver.getLocalVLAN(testOvsVxlanNwID); !found || vlan != 1 {
+		t.Fatalf("network %s local vlan mismatch. expected: 1, rcvd: %d", testOvsVxlanNwID, vlan)
+	}
+}
+
+func TestNextLocalVLAN(t *testing.T) {
+	localVLANs := map[string]*LocalVLANInfo{
+		"net1": {VLAN: 1},
+		"net3": {VLAN: 3},
+	}
+	if vlan, err := nextLocalVLAN(localVLANs); err != nil || vlan != 2 {
+		t.Fatalf("local vlan mismatch. expected: 2, rcvd: %d, err: %v", vlan, err)
+	}
+
+	for vlan := uint16(1); vlan <= maxLocalVLAN; vlan++ {
+		localVLANs[fmt.Sprintf("net%d", vlan)] = &LocalVLANInfo{VLAN: vlan}
+	}
+	if _, err := nextLocalVLAN(localVLANs); err == nil {
+		t.Fatalf("local vlan allocation succeeded with all local vlans in use")
+	}
+}
+
 func TestOvsDriverUplinkBridgeMode(t *testing.T) {
 	driver := initOvsDriver(t, bridgeMode, defPvtNW)
 	defer func() { driver.Deinit() }()
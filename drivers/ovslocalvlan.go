@@ -0,0 +1,139 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drivers
+
+import (
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// A vxlan network is carried on the vxlan bridge of a host in a local vlan.
+// Local vlans are allocated by each host, only while the host has endpoints
+// in the network, so a host can map up to maxLocalVLAN vxlan networks at a
+// time while the cluster can have as many vxlan networks as vnis. The
+// network is added to the switch with the first local endpoint and removed
+// with the last one.
+
+const maxLocalVLAN = 4094
+
+// LocalVLANInfo is the local vlan of a vxlan network on a host and the local
+// endpoints in the network
+type LocalVLANInfo struct {
+	VLAN      uint16          `json:"VLAN"`
+	Endpoints map[string]bool `json:"Endpoints"`
+}
+
+// nextLocalVLAN returns the lowest vlan not used by a vxlan network
+func nextLocalVLAN(localVLANs map[string]*LocalVLANInfo) (uint16, error) {
+	inUse := make(map[uint16]bool, len(localVLANs))
+	for _, localVLAN := range localVLANs {
+		inUse[localVLAN.VLAN] = true
+	}
+
+	for vlan := uint16(1); vlan <= maxLocalVLAN; vlan++ {
+		if !inUse[vlan] {
+			return vlan, nil
+		}
+	}
+
+	return 0, core.Errorf("no local vlans available")
+}
+
+// getLocalVLAN returns the local vlan of a vxlan network, found is false if
+// the host has no endpoints in the network
+func (d *OvsDriver) getLocalVLAN(networkID string) (uint16, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	localVLAN, found := d.oper.LocalVLANs[networkID]
+	if !found {
+		return 0, false
+	}
+	return localVLAN.VLAN, true
+}
+
+// allocLocalVLAN adds a local endpoint to a vxlan network and returns the
+// local vlan of the network. The first endpoint allocates the vlan and adds
+// the network to the switch.
+func (d *OvsDriver) allocLocalVLAN(sw *OvsSwitch, cfgNw *mastercfg.CfgNetworkState, epID string) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	localVLAN, found := d.oper.LocalVLANs[cfgNw.ID]
+	if !found {
+		vlan, err := nextLocalVLAN(d.oper.LocalVLANs)
+		if err != nil {
+			log.Errorf("Error allocating local vlan for net %s. Err: %v", cfgNw.ID, err)
+			return 0, err
+		}
+
+		err = sw.CreateNetwork(vlan, uint32(cfgNw.ExtPktTag), cfgNw.Gateway, cfgNw.Tenant)
+		if err != nil {
+			return 0, err
+		}
+
+		log.Infof("Mapped net %s vxlan %d to local vlan %d", cfgNw.ID, cfgNw.ExtPktTag, vlan)
+		localVLAN = &LocalVLANInfo{VLAN: vlan, Endpoints: make(map[string]bool)}
+		d.oper.LocalVLANs[cfgNw.ID] = localVLAN
+	} else if localVLAN.Endpoints[epID] {
+		return int(localVLAN.VLAN), nil
+	}
+
+	localVLAN.Endpoints[epID] = true
+	return int(localVLAN.VLAN), d.oper.Write()
+}
+
+// releaseLocalVLAN removes a local endpoint from a vxlan network. The last
+// endpoint removes the network from the switch and frees its local vlan.
+func (d *OvsDriver) releaseLocalVLAN(sw *OvsSwitch, cfgNw *mastercfg.CfgNetworkState, epID string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	localVLAN, found := d.oper.LocalVLANs[cfgNw.ID]
+	if !found || !localVLAN.Endpoints[epID] {
+		return nil
+	}
+
+	delete(localVLAN.Endpoints, epID)
+	if len(localVLAN.Endpoints) == 0 {
+		err := sw.DeleteNetwork(localVLAN.VLAN, uint32(cfgNw.ExtPktTag), cfgNw.Gateway, cfgNw.Tenant)
+		if err != nil {
+			log.Errorf("Error removing net %s local vlan %d. Err: %v", cfgNw.ID, localVLAN.VLAN, err)
+		}
+
+		log.Infof("Unmapped net %s vxlan %d from local vlan %d", cfgNw.ID, cfgNw.ExtPktTag, localVLAN.VLAN)
+		delete(d.oper.LocalVLANs, cfgNw.ID)
+	}
+
+	return d.oper.Write()
+}
+
+// freeLocalVLAN frees the local vlan of a deleted vxlan network, found is
+// false if the network wasn't mapped
+func (d *OvsDriver) freeLocalVLAN(networkID string) (uint16, bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	localVLAN, found := d.oper.LocalVLANs[networkID]
+	if !found {
+		return 0, false, nil
+	}
+
+	delete(d.oper.LocalVLANs, networkID)
+	return localVLAN.VLAN, true, d.oper.Write()
+}
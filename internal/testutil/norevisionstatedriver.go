@@ -0,0 +1,66 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testutil provides helpers shared by the unit-tests
+package testutil
+
+import (
+	"github.com/contiv/netplugin/core"
+)
+
+// RevisionlessStateDriver is a state driver that doesn't keep revisions of
+// the states it stores, like the stub drivers of unit-tests
+type RevisionlessStateDriver interface {
+	core.Driver
+	Init(instInfo *core.InstanceInfo) error
+	Deinit()
+	Write(key string, value []byte) error
+	Read(key string) ([]byte, error)
+	ReadAll(baseKey string) ([][]byte, error)
+	WatchAll(baseKey string, rsps chan [2][]byte) error
+	WriteState(key string, value core.State,
+		marshal func(interface{}) ([]byte, error)) error
+	ReadState(key string, value core.State,
+		unmarshal func([]byte, interface{}) error) error
+	ReadAllState(baseKey string, stateType core.State,
+		unmarshal func([]byte, interface{}) error) ([]core.State, error)
+	WatchAllState(baseKey string, stateType core.State,
+		unmarshal func([]byte, interface{}) error, rsps chan core.WatchState) error
+	ClearState(key string) error
+}
+
+// noRevisionStateDriver implements core.StateDriver on a revisionless driver
+type noRevisionStateDriver struct {
+	RevisionlessStateDriver
+}
+
+// NoRevisions returns a core.StateDriver for a driver that doesn't keep
+// revisions. States are always read at revision 0 and conditional writes
+// always write, so it must only be used with a single writer.
+func NoRevisions(d RevisionlessStateDriver) core.StateDriver {
+	return &noRevisionStateDriver{d}
+}
+
+// ReadStateRevision reads a state at revision 0
+func (d *noRevisionStateDriver) ReadStateRevision(key string, value core.State,
+	unmarshal func([]byte, interface{}) error) (uint64, error) {
+	return 0, d.ReadState(key, value, unmarshal)
+}
+
+// WriteStateIfRevision writes a state whatever its revision
+func (d *noRevisionStateDriver) WriteStateIfRevision(key string, value core.State,
+	marshal func(interface{}) ([]byte, error), revision uint64) error {
+	return d.WriteState(key, value, marshal)
+}
@@ -176,7 +176,10 @@ func requestAddress(w http.ResponseWriter, r *http.Request) {
 		networkID = strings.Split(areq.PoolID, "|")[0]
 	}
 
-	// Build an alloc request to be sent to master
+	// Build an alloc request to be sent to master. Docker does not tell
+	// which container the address is for, so addresses reserved for a
+	// container are only handed out when requested explicitly, and are
+	// checked when the endpoint joins the container.
 	allocReq := master.AddressAllocRequest{
 		AddressPool:          addrPool,
 		NetworkID:            networkID,
@@ -200,15 +203,11 @@ func requestAddress(w http.ResponseWriter, r *http.Request) {
 		// FIXME: Remove this hack when we stop supporting docker 1.9
 		addr = areq.Address + "/" + subnetLen
 	} else {
-		// Make a REST call to master
-		var allocResp master.AddressAllocResponse
-		err = cluster.MasterPostReq("/plugin/allocAddress", &allocReq, &allocResp)
+		addr, err = cluster.AllocAddress(netPlugin.PluginConfig.Instance.HostLabel, &allocReq)
 		if err != nil {
 			httpError(w, "master failed to allocate address", err)
 			return
 		}
-
-		addr = allocResp.IPv4Address
 	}
 
 	// build response
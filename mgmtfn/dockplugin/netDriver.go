@@ -206,10 +206,11 @@ func createEndpoint(hostname string) func(http.ResponseWriter, *http.Request) {
 				IPv6Address: strings.Split(cereq.Interface.AddressIPv6, "/")[0],
 				ServiceName: serviceName,
 			},
+			// docker only tells which container the endpoint is for when it joins
+			WorkloadAtJoin: true,
 		}
 
-		var mresp master.CreateEndpointResponse
-		err = cluster.MasterPostReq("/plugin/createEndpoint", &mreq, &mresp)
+		mresp, err := cluster.CreateEndpoint(netPlugin.PluginConfig.Instance.HostLabel, &mreq)
 		if err != nil {
 			httpError(w, "master failed to create endpoint", err)
 			return
@@ -316,6 +317,26 @@ func join(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// check the addresses of the endpoint against the ip reservations of
+	// its container, which is only known now
+	if len(nw.ReservedIPs) > 0 {
+		joinReq := master.JoinEndpointRequest{
+			TenantName:  tenantName,
+			NetworkName: netName,
+			EndpointID:  jr.EndpointID,
+		}
+		joinReq.Workload.ContainerName, err = getEndpointContainerName(jr.NetworkID, jr.EndpointID)
+		if err != nil {
+			log.Warnf("Unable to find the container of endpoint %s. Err: %v", jr.EndpointID, err)
+		}
+
+		err = cluster.JoinEndpoint(&joinReq)
+		if err != nil {
+			httpError(w, "Endpoint can not join the container", err)
+			return
+		}
+	}
+
 	joinResp := api.JoinResponse{
 		InterfaceName: &api.InterfaceName{
 			SrcName:   ep.PortName,
@@ -450,6 +471,30 @@ func netdGetNetwork(networkID string) (*mastercfg.CfgNetworkState, error) {
 	return nwCfg, nil
 }
 
+// getEndpointContainerName returns the name of the container an endpoint
+// joins. Docker names endpoints after their container, and lists them in the
+// network before they join without locking the container being started.
+func getEndpointContainerName(nwID, epID string) (string, error) {
+	docker, err := dockerclient.NewDockerClient("unix:///var/run/docker.sock", nil)
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return "", errors.New("Unable to connect to docker")
+	}
+
+	nw, err := docker.InspectNetwork(nwID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, ep := range nw.Containers {
+		if ep.EndpointID == epID {
+			return ep.Name, nil
+		}
+	}
+
+	return "", errors.New("Endpoint not found in docker network")
+}
+
 // GetDockerNetworkName gets network name from network UUID
 func GetDockerNetworkName(nwID string) (string, string, string, error) {
 	// first see if we can find the network in docknet oper state
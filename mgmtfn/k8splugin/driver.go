@@ -39,11 +39,13 @@ import (
 
 // epSpec contains the spec of the Endpoint to be created
 type epSpec struct {
-	Tenant     string `json:"tenant,omitempty"`
-	Network    string `json:"network,omitempty"`
-	Group      string `json:"group,omitempty"`
-	EndpointID string `json:"endpointid,omitempty"`
-	Name       string `json:"name,omitempty"`
+	Tenant     string            `json:"tenant,omitempty"`
+	Network    string            `json:"network,omitempty"`
+	Group      string            `json:"group,omitempty"`
+	EndpointID string            `json:"endpointid,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	Namespace  string            `json:"namespace,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
 }
 
 // epAttr contains the assigned attributes of the created ep
@@ -137,10 +139,14 @@ func createEP(req *epSpec) (*epAttr, error) {
 			Host:        pluginHost,
 			ServiceName: req.Group,
 		},
+		Workload: master.WorkloadInfo{
+			PodName:      req.Name,
+			PodNamespace: req.Namespace,
+			Labels:       req.Labels,
+		},
 	}
 
-	var mresp master.CreateEndpointResponse
-	err = cluster.MasterPostReq("/plugin/createEndpoint", &mreq, &mresp)
+	mresp, err := cluster.CreateEndpoint(netPlugin.PluginConfig.Instance.HostLabel, &mreq)
 	if err != nil {
 		epCleanUp(req)
 		return nil, err
@@ -361,6 +367,8 @@ func getEPSpec(pInfo *cniapi.CNIPodAttr) (*epSpec, error) {
 	resp.Group = epg
 	resp.EndpointID = pInfo.InfraContainerID
 	resp.Name = pInfo.Name
+	resp.Namespace = pInfo.K8sNameSpace
+	resp.Labels, _ = kubeAPIClient.GetPodLabels(pInfo.K8sNameSpace, pInfo.Name)
 
 	return &resp, nil
 }
@@ -135,6 +135,7 @@ func NewAPIClient(serverURL, caFile, keyFile, certFile, authToken string) *APICl
 func (p *podInfo) setDefaults(ns, name string) {
 	p.nameSpace = ns
 	p.name = name
+	p.labels = make(map[string]string)
 	p.labels["io.contiv.tenant"] = "default"
 	p.labels["io.contiv.network"] = "default-net"
 	p.labels["io.contiv.net-group"] = ""
@@ -233,6 +234,28 @@ func (c *APIClient) GetPodLabel(ns, name, label string) (string, error) {
 	return "", nil
 }
 
+// GetPodLabels retrieves all the labels of a pod
+func (c *APIClient) GetPodLabels(ns, name string) (map[string]string, error) {
+
+	// If cache does not match, fetch
+	if c.podCache.nameSpace != ns || c.podCache.name != name {
+		err := c.fetchPodLabels(ns, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p := &c.podCache
+	p.labelsMutex.Lock()
+	defer p.labelsMutex.Unlock()
+	labels := make(map[string]string)
+	for key, val := range p.labels {
+		labels[key] = val
+	}
+
+	return labels, nil
+}
+
 // WatchServices watches the services object on the api server
 func (c *APIClient) WatchServices(respCh chan SvcWatchResp) {
 	ctx, _ := context.WithCancel(context.Background())
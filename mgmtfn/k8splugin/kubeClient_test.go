@@ -96,6 +96,11 @@ func (d *KubeTestNetDrv) DeleteNetwork(id, nwType, encap string, pktTag, extPktT
 	return nil
 }
 
+// UpdateNetwork is not implemented.
+func (d *KubeTestNetDrv) UpdateNetwork(id, prevGateway string) error {
+	return nil
+}
+
 // CreateEndpoint is not implemented.
 func (d *KubeTestNetDrv) CreateEndpoint(id string) error {
 	return nil
@@ -161,6 +166,11 @@ func (d *KubeTestNetDrv) GetEndpointStats() ([]byte, error) {
 	return []byte{}, core.Errorf("Not implemented")
 }
 
+// GetPolicyRuleStats is not implemented
+func (d *KubeTestNetDrv) GetPolicyRuleStats() ([]byte, error) {
+	return []byte{}, core.Errorf("Not implemented")
+}
+
 // InspectState is not implemented
 func (d *KubeTestNetDrv) InspectState() ([]byte, error) {
 	return []byte{}, core.Errorf("Not implemented")
@@ -247,12 +247,17 @@ func (cniReq *cniServer) createCniEndPoint() error {
 			Host:        hostName,
 			ServiceName: cniReq.endPointLabels[cniapi.LabelNetworkGroup],
 		},
+		Workload: master.WorkloadInfo{
+			// mesos identifies containers by their id
+			ContainerName: cniReq.pluginArgs.CniContainerid,
+			Labels:        cniReq.endPointLabels,
+		},
 	}
 
 	cniLog.Infof("endpoint-req: epid:%s cont-id:%s ", epReq.EndpointID, epReq.ConfigEP.Container)
 
-	epResp := master.CreateEndpointResponse{}
-	if err = cluster.MasterPostReq("/plugin/createEndpoint", &epReq, &epResp); err != nil {
+	epResp, err := cluster.CreateEndpoint(netPlugin.PluginConfig.Instance.HostLabel, &epReq)
+	if err != nil {
 		cniLog.Errorf("failed to create endpoint in master: %s", err.Error())
 		return err
 	}
@@ -278,7 +283,7 @@ func (cniReq *cniServer) createCniEndPoint() error {
 
 	cniLog.Debugf("read new network config +%v", nwState)
 
-	if err = cniReq.configureNetNs(ovsEpDriver, &epResp, nwState); err != nil {
+	if err = cniReq.configureNetNs(ovsEpDriver, epResp, nwState); err != nil {
 		goto cleanupNetplugin
 	}
 
@@ -0,0 +1,139 @@
+package netctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+
+	"github.com/codegangsta/cli"
+	"gopkg.in/yaml.v2"
+)
+
+// configChange is a single object change reported by netmaster
+type configChange struct {
+	Operation  string `json:"operation"`
+	ObjectType string `json:"objectType"`
+	ObjectKey  string `json:"objectKey"`
+}
+
+// applyResult is the result of applying or diffing a desired state
+type applyResult struct {
+	Changes []configChange `json:"changes"`
+	Applied int            `json:"applied"`
+	Error   string         `json:"error,omitempty"`
+}
+
+func applyURL(ctx *cli.Context) string {
+	return fmt.Sprintf("%s/api/v1/apply/", baseURL(ctx))
+}
+
+func diffURL(ctx *cli.Context) string {
+	return fmt.Sprintf("%s/api/v1/diff/", baseURL(ctx))
+}
+
+// yamlToJSON converts the maps decoded from yaml into maps json can encode
+func yamlToJSON(value interface{}) interface{} {
+	switch val := value.(type) {
+	case map[interface{}]interface{}:
+		obj := map[string]interface{}{}
+		for key, elem := range val {
+			obj[fmt.Sprintf("%v", key)] = yamlToJSON(elem)
+		}
+		return obj
+	case []interface{}:
+		for idx, elem := range val {
+			val[idx] = yamlToJSON(elem)
+		}
+		return val
+	}
+
+	return value
+}
+
+// readConfigFile reads a desired state or cluster config file in yaml or
+// json format and returns it as json
+func readConfigFile(ctx *cli.Context) []byte {
+	if len(ctx.Args()) != 0 {
+		errExit(ctx, exitHelp, "More arguments than required", true)
+	}
+
+	fileName := ctx.String("file")
+	if fileName == "" {
+		errExit(ctx, exitHelp, "Config file required", true)
+	}
+
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		errExit(ctx, exitIO, err.Error(), false)
+	}
+
+	var desired interface{}
+	if err := yaml.Unmarshal(content, &desired); err != nil {
+		errExit(ctx, exitInvalid, fmt.Sprintf("Error parsing %s: %v", fileName, err), false)
+	}
+
+	jdata, err := json.Marshal(yamlToJSON(desired))
+	if err != nil {
+		errExit(ctx, exitInvalid, fmt.Sprintf("Error parsing %s: %v", fileName, err), false)
+	}
+
+	return jdata
+}
+
+// writeChanges prints the changes of a desired state, along with their
+// status if they were applied
+func writeChanges(result *applyResult, applied bool) {
+	if len(result.Changes) == 0 {
+		fmt.Println("No changes")
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	defer writer.Flush()
+
+	if applied {
+		writer.Write([]byte("Operation\tType\tKey\tStatus\n"))
+		writer.Write([]byte("---------\t----\t---\t------\n"))
+	} else {
+		writer.Write([]byte("Operation\tType\tKey\n"))
+		writer.Write([]byte("---------\t----\t---\n"))
+	}
+
+	for idx, change := range result.Changes {
+		line := fmt.Sprintf("%s\t%s\t%s", change.Operation, change.ObjectType, change.ObjectKey)
+		if applied {
+			status := "skipped"
+			if idx < result.Applied {
+				status = "done"
+			} else if idx == result.Applied {
+				status = "failed"
+			}
+			line += "\t" + status
+		}
+		writer.Write([]byte(line + "\n"))
+	}
+}
+
+func applyDesiredState(ctx *cli.Context) {
+	desired := readConfigFile(ctx)
+
+	var result applyResult
+	errCheck(ctx, postObject(ctx, applyURL(ctx), desired, &result))
+
+	writeChanges(&result, true)
+	if result.Error != "" {
+		errExit(ctx, exitInvalid, fmt.Sprintf("Applied %d of %d changes. Err: %s",
+			result.Applied, len(result.Changes), result.Error), false)
+	}
+}
+
+func diffDesiredState(ctx *cli.Context) {
+	desired := readConfigFile(ctx)
+
+	var result applyResult
+	errCheck(ctx, postObject(ctx, diffURL(ctx), desired, &result))
+
+	writeChanges(&result, false)
+}
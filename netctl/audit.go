@@ -0,0 +1,65 @@
+package netctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// auditEntry is a configuration change recorded in the netmaster audit log
+type auditEntry struct {
+	Timestamp  time.Time       `json:"timestamp"`
+	Client     string          `json:"client"`
+	Tenant     string          `json:"tenant,omitempty"`
+	ObjectType string          `json:"objectType"`
+	ObjectKey  string          `json:"objectKey"`
+	Operation  string          `json:"operation"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+}
+
+func auditURL(ctx *cli.Context) string {
+	query := url.Values{}
+	if tenant := ctx.String("tenant"); tenant != "" {
+		query.Set("tenant", tenant)
+	}
+	if object := ctx.String("object"); object != "" {
+		query.Set("object", object)
+	}
+
+	return fmt.Sprintf("%s/api/v1/audit/?%s", baseURL(ctx), query.Encode())
+}
+
+func listAuditEntries(ctx *cli.Context) {
+	if len(ctx.Args()) != 0 {
+		errExit(ctx, exitHelp, "More arguments than required", true)
+	}
+
+	entries := []auditEntry{}
+	errCheck(ctx, getObject(ctx, auditURL(ctx), &entries))
+
+	if ctx.Bool("json") {
+		dumpJSONList(ctx, entries)
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	defer writer.Flush()
+	writer.Write([]byte("Time\tClient\tTenant\tOperation\tType\tKey\n"))
+	writer.Write([]byte("----\t------\t------\t---------\t----\t---\n"))
+	for _, entry := range entries {
+		writer.Write([]byte(fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t%v\n",
+			entry.Timestamp.Format(time.RFC3339),
+			entry.Client,
+			entry.Tenant,
+			entry.Operation,
+			entry.ObjectType,
+			entry.ObjectKey,
+		)))
+	}
+}
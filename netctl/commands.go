@@ -23,6 +23,31 @@ var quietFlag = cli.BoolFlag{
 	Usage: "Only display name field",
 }
 
+var dryRunFlag = cli.BoolFlag{
+	Name:  "dry-run",
+	Usage: "Show the change plan without making any changes",
+}
+
+var fileFlag = cli.StringFlag{
+	Name:  "file, f",
+	Usage: "Desired state file in yaml or json format",
+}
+
+var exportFileFlag = cli.StringFlag{
+	Name:  "file, f",
+	Usage: "Write the cluster config to a file instead of stdout",
+}
+
+var importFileFlag = cli.StringFlag{
+	Name:  "file, f",
+	Usage: "Cluster config file in yaml or json format",
+}
+
+var keepAllocationsFlag = cli.BoolFlag{
+	Name:  "keep-allocations",
+	Usage: "Keep the pkt-tags and service ips allocated in the exported cluster",
+}
+
 // NetmasterFlags encapsulates the flags required for talking to the netmaster.
 var NetmasterFlags = []cli.Flag{
 	cli.StringFlag{
@@ -41,6 +66,108 @@ var Commands = []cli.Command{
 		Usage:  "Version Information",
 		Action: showVersion,
 	},
+	{
+		Name:   "apply",
+		Usage:  "Apply the desired state of tenants from a file",
+		Flags:  []cli.Flag{fileFlag},
+		Action: applyDesiredState,
+	},
+	{
+		Name:   "diff",
+		Usage:  "Show the changes needed to apply the desired state of tenants from a file",
+		Flags:  []cli.Flag{fileFlag},
+		Action: diffDesiredState,
+	},
+	{
+		Name:   "export",
+		Usage:  "Export the configuration of the cluster",
+		Flags:  []cli.Flag{exportFileFlag},
+		Action: exportConfig,
+	},
+	{
+		Name:   "import",
+		Usage:  "Import a cluster configuration into an empty cluster",
+		Flags:  []cli.Flag{importFileFlag, keepAllocationsFlag},
+		Action: importConfig,
+	},
+	{
+		Name:  "audit",
+		Usage: "Configuration change audit log",
+		Subcommands: []cli.Command{
+			{
+				Name:  "ls",
+				Usage: "List configuration changes",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "tenant, t",
+						Usage: "Only list changes of the tenant",
+					},
+					cli.StringFlag{
+						Name:  "object, o",
+						Usage: "Only list changes of the object type or key",
+					},
+					jsonFlag,
+				},
+				Action: listAuditEntries,
+			},
+		},
+	},
+	{
+		Name:    "reservation",
+		Aliases: []string{"resv"},
+		Usage:   "Static ip address reservations",
+		Subcommands: []cli.Command{
+			{
+				Name:      "ls",
+				Aliases:   []string{"list"},
+				Usage:     "List ip reservations",
+				ArgsUsage: " ",
+				Flags: []cli.Flag{
+					tenantFlag,
+					allFlag,
+					jsonFlag,
+					cli.StringFlag{
+						Name:  "network, n",
+						Usage: "Only list reservations of the network",
+					},
+				},
+				Action: listIPReservations,
+			},
+			{
+				Name:      "create",
+				Usage:     "Reserve an ip address for a container, pod or labels",
+				ArgsUsage: "[network] [ip-address]",
+				Flags: []cli.Flag{
+					tenantFlag,
+					cli.StringFlag{
+						Name:  "container, c",
+						Usage: "Name of the container the address is reserved for, docker containers must request it with --ip",
+					},
+					cli.StringFlag{
+						Name:  "pod, p",
+						Usage: "Name of the pod the address is reserved for",
+					},
+					cli.StringFlag{
+						Name:  "namespace",
+						Usage: "Namespace of the pod the address is reserved for",
+					},
+					cli.StringSliceFlag{
+						Name:  "label, l",
+						Usage: "Label of the workloads the address is reserved for, example app=db",
+					},
+				},
+				Action: createIPReservation,
+			},
+			{
+				Name:      "rm",
+				Aliases:   []string{"delete"},
+				Usage:     "Delete an ip reservation",
+				ArgsUsage: "[network] [ip-address]",
+				Flags:     []cli.Flag{tenantFlag},
+				Action:    deleteIPReservation,
+			},
+		},
+	},
 	{
 		Name:  "group",
 		Usage: "Endpoint Group manipulation tools",
@@ -65,11 +192,44 @@ var Commands = []cli.Command{
 					},
 					cli.StringFlag{
 						Name:  "ip-pool, r",
-						Usage: "IP Address range, example 10.36.0.1-10.36.0.10",
+						Usage: "IP Address ranges, example 10.36.0.1-10.36.0.10,10.36.0.20-10.36.0.30",
+					},
+					cli.StringFlag{
+						Name:  "ipv6-pool",
+						Usage: "IPv6 Address range, example 2016:0617::10-2016:0617::100",
 					},
+					dryRunFlag,
 				},
 				Action: createEndpointGroup,
 			},
+			{
+				Name:      "policy-add",
+				Usage:     "Add a policy to an endpoint group",
+				ArgsUsage: "[group] [policy]",
+				Flags:     []cli.Flag{tenantFlag},
+				Action:    addEndpointGroupPolicy,
+			},
+			{
+				Name:      "policy-rm",
+				Usage:     "Remove a policy from an endpoint group",
+				ArgsUsage: "[group] [policy]",
+				Flags:     []cli.Flag{tenantFlag},
+				Action:    removeEndpointGroupPolicy,
+			},
+			{
+				Name:      "pool-add",
+				Usage:     "Add an ip address range to the pool of an endpoint group",
+				ArgsUsage: "[group] [ip-range]",
+				Flags:     []cli.Flag{tenantFlag},
+				Action:    addEndpointGroupPool,
+			},
+			{
+				Name:      "pool-rm",
+				Usage:     "Remove an ip address range from the pool of an endpoint group",
+				ArgsUsage: "[group] [ip-range]",
+				Flags:     []cli.Flag{tenantFlag},
+				Action:    removeEndpointGroupPool,
+			},
 			{
 				Name:      "inspect",
 				Usage:     "Inspect a EndpointGroup",
@@ -82,7 +242,7 @@ var Commands = []cli.Command{
 				Aliases:   []string{"delete"},
 				Usage:     "Delete an endpoint group",
 				ArgsUsage: "[group]",
-				Flags:     []cli.Flag{tenantFlag},
+				Flags:     []cli.Flag{tenantFlag, dryRunFlag},
 				Action:    deleteEndpointGroup,
 			},
 			{
@@ -179,12 +339,27 @@ var Commands = []cli.Command{
 				Flags:     []cli.Flag{tenantFlag, jsonFlag},
 				Action:    inspectNetwork,
 			},
+			{
+				Name:      "ipam-check",
+				Usage:     "Check for leaked and double-allocated addresses",
+				ArgsUsage: "[network]",
+				Flags: []cli.Flag{
+					tenantFlag,
+					allFlag,
+					jsonFlag,
+					cli.BoolFlag{
+						Name:  "repair",
+						Usage: "Free leaked addresses and allocate unallocated addresses",
+					},
+				},
+				Action: checkIPAM,
+			},
 			{
 				Name:      "rm",
 				Aliases:   []string{"delete"},
 				Usage:     "Delete a network",
 				ArgsUsage: "[network]",
-				Flags:     []cli.Flag{tenantFlag},
+				Flags:     []cli.Flag{tenantFlag, dryRunFlag},
 				Action:    deleteNetwork,
 			},
 			{
@@ -223,6 +398,19 @@ var Commands = []cli.Command{
 						Name:  "gatewayv6, g6",
 						Usage: "IPv6 Gateway",
 					},
+					cli.IntFlag{
+						Name:  "quarantine-time",
+						Usage: "Seconds a released address is held back before it is reused",
+					},
+					cli.IntFlag{
+						Name:  "quarantine-count",
+						Usage: "Number of released addresses held back before they are reused",
+					},
+					cli.StringFlag{
+						Name:  "service-ip-pool",
+						Usage: "Comma separated address ranges for service VIPs",
+					},
+					dryRunFlag,
 				},
 				Action: createNetwork,
 			},
@@ -251,7 +439,17 @@ var Commands = []cli.Command{
 				Name:      "create",
 				Usage:     "Create a tenant",
 				ArgsUsage: "[tenant]",
-				Action:    createTenant,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "vlan-range, v",
+						Usage: "Vlan id range owned by the tenant",
+					},
+					cli.StringFlag{
+						Name:  "vxlan-range, x",
+						Usage: "Vxlan VNID range owned by the tenant",
+					},
+				},
+				Action: createTenant,
 			},
 			{
 				Name:      "inspect",
@@ -307,7 +505,7 @@ var Commands = []cli.Command{
 				Name:      "rule-rm",
 				Usage:     "Delete a rule from the policy",
 				ArgsUsage: "[policy] [rule id]",
-				Flags:     []cli.Flag{tenantFlag},
+				Flags:     []cli.Flag{tenantFlag, dryRunFlag},
 				Action:    deleteRule,
 			},
 			{
@@ -354,15 +552,32 @@ var Commands = []cli.Command{
 						Name:  "protocol, l",
 						Usage: "Protocol (e.g., tcp, udp, icmp)",
 					},
-					cli.IntFlag{
+					cli.StringFlag{
 						Name:  "port, P",
-						Usage: "Port",
+						Usage: "Port, or list of ports and port ranges (e.g., 80,443,8000-8100)",
+					},
+					cli.IntFlag{
+						Name:  "src-port",
+						Usage: "Source port",
+					},
+					cli.StringFlag{
+						Name:  "tcp-flags",
+						Usage: "TCP flags to match (syn, ack, syn,ack, syn,!ack or !syn,ack)",
+					},
+					cli.StringFlag{
+						Name:  "icmp-type",
+						Usage: "ICMP type name or number (e.g., echo-request, 8)",
+					},
+					cli.StringFlag{
+						Name:  "icmp-code",
+						Usage: "ICMP code (requires icmp-type)",
 					},
 					cli.StringFlag{
 						Name:  "action, j",
 						Usage: "Action to take (allow or deny)",
 						Value: "allow",
 					},
+					dryRunFlag,
 				},
 				Action: addRule,
 			},
@@ -671,7 +886,7 @@ var Commands = []cli.Command{
 					},
 					cli.StringSliceFlag{
 						Name:  "selector,l",
-						Usage: "service selector .Usage: --selector=key1=value1 --selector=key2=value2",
+						Usage: "service selector. Usage: --selector=key1=value1 --selector=\"key2 in (value2,value3)\" --selector=\"key3 notin (value4)\" --selector=\"key4 exists\" --selector=\"key5 !exists\"",
 					},
 					cli.StringSliceFlag{
 						Name:  "port,p",
@@ -681,6 +896,46 @@ var Commands = []cli.Command{
 						Name:  "preferred-ip,ip",
 						Usage: "preferred ip address",
 					},
+					cli.StringFlag{
+						Name:  "health-check",
+						Usage: "provider health check (tcp or http)",
+					},
+					cli.IntFlag{
+						Name:  "health-check-port",
+						Usage: "provider health check port, defaults to the first tcp provider port",
+					},
+					cli.StringFlag{
+						Name:  "health-check-path",
+						Usage: "http health check path",
+					},
+					cli.IntFlag{
+						Name:  "health-check-interval",
+						Usage: "health check interval in seconds (default 10)",
+					},
+					cli.IntFlag{
+						Name:  "health-check-timeout",
+						Usage: "health check timeout in seconds (default 2)",
+					},
+					cli.IntFlag{
+						Name:  "healthy-threshold",
+						Usage: "passed checks to put a provider back (default 2)",
+					},
+					cli.IntFlag{
+						Name:  "unhealthy-threshold",
+						Usage: "failed checks to take a provider out (default 3)",
+					},
+					cli.StringFlag{
+						Name:  "session-affinity",
+						Usage: "session affinity of service clients (None or ClientIP)",
+					},
+					cli.IntFlag{
+						Name:  "session-affinity-timeout",
+						Usage: "ClientIP session affinity timeout in seconds (default 10800)",
+					},
+					cli.StringFlag{
+						Name:  "weight-label",
+						Usage: "provider label with the provider weight (0-100, default 1)",
+					},
 				},
 				Action: createServiceLB,
 			},
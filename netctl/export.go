@@ -0,0 +1,61 @@
+package netctl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/codegangsta/cli"
+)
+
+func exportURL(ctx *cli.Context) string {
+	return fmt.Sprintf("%s/api/v1/export/", baseURL(ctx))
+}
+
+func importURL(ctx *cli.Context) string {
+	url := fmt.Sprintf("%s/api/v1/import/", baseURL(ctx))
+	if ctx.Bool("keep-allocations") {
+		url += "?keepAllocations=true"
+	}
+	return url
+}
+
+func exportConfig(ctx *cli.Context) {
+	if len(ctx.Args()) != 0 {
+		errExit(ctx, exitHelp, "More arguments than required", true)
+	}
+
+	var config json.RawMessage
+	errCheck(ctx, getObject(ctx, exportURL(ctx), &config))
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, config, "", "  "); err != nil {
+		errExit(ctx, exitInvalid, err.Error(), false)
+	}
+	out.WriteString("\n")
+
+	fileName := ctx.String("file")
+	if fileName == "" {
+		os.Stdout.Write(out.Bytes())
+		return
+	}
+
+	if err := ioutil.WriteFile(fileName, out.Bytes(), 0644); err != nil {
+		errExit(ctx, exitIO, err.Error(), false)
+	}
+}
+
+func importConfig(ctx *cli.Context) {
+	config := readConfigFile(ctx)
+
+	var result applyResult
+	errCheck(ctx, postObject(ctx, importURL(ctx), config, &result))
+
+	writeChanges(&result, true)
+	if result.Error != "" {
+		errExit(ctx, exitInvalid, fmt.Sprintf("Imported %d of %d objects. Err: %s",
+			result.Applied, len(result.Changes), result.Error), false)
+	}
+}
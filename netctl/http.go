@@ -1,17 +1,24 @@
 package netctl
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"reflect"
 
 	"github.com/codegangsta/cli"
 )
 
 var client = &http.Client{}
 
+// maxUpdateRetries is the number of times a read-modify-write of an object
+// is retried when the object is changed concurrently
+const maxUpdateRetries = 5
+
 func handleBasicError(ctx *cli.Context, err error) {
 	if err != nil {
 		errExit(ctx, exitRequest, err.Error(), false)
@@ -47,3 +54,103 @@ func getObject(ctx *cli.Context, url string, jdata interface{}) error {
 
 	return nil
 }
+
+func postObject(ctx *cli.Context, url string, body []byte, jdata interface{}) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	handleBasicError(ctx, err)
+	defer resp.Body.Close()
+
+	respCheck(resp, ctx)
+
+	content, err := ioutil.ReadAll(resp.Body)
+	handleBasicError(ctx, err)
+
+	handleBasicError(ctx, json.Unmarshal(content, jdata))
+
+	return nil
+}
+
+func objectURL(ctx *cli.Context, objType, key string) string {
+	return fmt.Sprintf("%s/api/v1/%s/%s/", baseURL(ctx), objType, key)
+}
+
+// updateObject reads an object into obj, modifies it and writes it back.
+// The write fails with a conflict if the object was changed since it was
+// read, in which case the update is retried on the latest object.
+func updateObject(ctx *cli.Context, objType, key string, obj interface{}, modify func()) {
+	url := objectURL(ctx, objType, key)
+	for retry := 0; ; retry++ {
+		resp, err := client.Get(url)
+		handleBasicError(ctx, err)
+		respCheck(resp, ctx)
+
+		content, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		handleBasicError(ctx, err)
+
+		// start from an empty object so that nothing is left from a
+		// previous attempt
+		objValue := reflect.ValueOf(obj).Elem()
+		objValue.Set(reflect.Zero(objValue.Type()))
+		handleBasicError(ctx, json.Unmarshal(content, obj))
+		version := resp.Header.Get("ETag")
+
+		modify()
+
+		jdata, err := json.Marshal(obj)
+		handleBasicError(ctx, err)
+
+		req, err := http.NewRequest("POST", url, bytes.NewReader(jdata))
+		handleBasicError(ctx, err)
+		req.Header.Set("Content-Type", "application/json")
+		if version != "" {
+			req.Header.Set("If-Match", version)
+		}
+
+		resp, err = client.Do(req)
+		handleBasicError(ctx, err)
+		if resp.StatusCode == http.StatusConflict && retry < maxUpdateRetries {
+			resp.Body.Close()
+			continue
+		}
+
+		respCheck(resp, ctx)
+		resp.Body.Close()
+		return
+	}
+}
+
+func dryRunURL(ctx *cli.Context, objType, key string) string {
+	return fmt.Sprintf("%s/api/v1/%s/%s/?dryrun=true", baseURL(ctx), objType, key)
+}
+
+// dryRun asks netmaster for the change plan of a create or delete and
+// prints it. A nil obj is sent as a delete.
+func dryRun(ctx *cli.Context, objType, key string, obj interface{}) {
+	method := "DELETE"
+	var body io.Reader
+	if obj != nil {
+		jdata, err := json.Marshal(obj)
+		handleBasicError(ctx, err)
+
+		method = "POST"
+		body = bytes.NewReader(jdata)
+	}
+
+	req, err := http.NewRequest(method, dryRunURL(ctx, objType, key), body)
+	handleBasicError(ctx, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	handleBasicError(ctx, err)
+	defer resp.Body.Close()
+
+	respCheck(resp, ctx)
+
+	content, err := ioutil.ReadAll(resp.Body)
+	handleBasicError(ctx, err)
+
+	var plan bytes.Buffer
+	handleBasicError(ctx, json.Indent(&plan, content, "", "  "))
+	os.Stdout.Write(plan.Bytes())
+}
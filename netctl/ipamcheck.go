@@ -0,0 +1,75 @@
+package netctl
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/codegangsta/cli"
+)
+
+// ipamIssue is an address whose allocation does not match its use
+type ipamIssue struct {
+	Tenant        string   `json:"tenant"`
+	Network       string   `json:"network"`
+	EndpointGroup string   `json:"endpointGroup,omitempty"`
+	ServiceIPPool bool     `json:"serviceIPPool,omitempty"`
+	IPAddress     string   `json:"ipAddress"`
+	Problem       string   `json:"problem"`
+	Owners        []string `json:"owners,omitempty"`
+	Repaired      bool     `json:"repaired"`
+}
+
+func checkIPAM(ctx *cli.Context) {
+	if len(ctx.Args()) > 1 {
+		errExit(ctx, exitHelp, "More arguments than required", true)
+	}
+
+	query := url.Values{}
+	if !ctx.Bool("all") {
+		query.Set("tenant", ctx.String("tenant"))
+	}
+	if len(ctx.Args()) == 1 {
+		query.Set("network", ctx.Args()[0])
+	}
+	checkURL := fmt.Sprintf("%s/api/v1/ipamCheck/?%s", baseURL(ctx), query.Encode())
+
+	issues := []*ipamIssue{}
+	if ctx.Bool("repair") {
+		errCheck(ctx, postObject(ctx, checkURL, []byte{}, &issues))
+	} else {
+		errCheck(ctx, getObject(ctx, checkURL, &issues))
+	}
+
+	if ctx.Bool("json") {
+		dumpJSONList(ctx, issues)
+		return
+	}
+
+	if len(issues) == 0 {
+		fmt.Println("No address allocation issues found")
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	defer writer.Flush()
+	writer.Write([]byte("Tenant\tNetwork\tGroup\tIP Address\tProblem\tRepaired\tUsed By\n"))
+	writer.Write([]byte("------\t-------\t-----\t----------\t-------\t--------\t-------\n"))
+	for _, issue := range issues {
+		group := issue.EndpointGroup
+		if issue.ServiceIPPool {
+			group = "(service ip pool)"
+		}
+		writer.Write([]byte(fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			issue.Tenant,
+			issue.Network,
+			group,
+			issue.IPAddress,
+			issue.Problem,
+			issue.Repaired,
+			strings.Join(issue.Owners, ", "),
+		)))
+	}
+}
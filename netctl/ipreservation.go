@@ -0,0 +1,132 @@
+package netctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/codegangsta/cli"
+)
+
+// ipReservation is a static ip address reservation in a network
+type ipReservation struct {
+	ID            string            `json:"id,omitempty"`
+	Tenant        string            `json:"tenant"`
+	Network       string            `json:"network"`
+	IPAddress     string            `json:"ipAddress"`
+	ContainerName string            `json:"containerName,omitempty"`
+	PodName       string            `json:"podName,omitempty"`
+	PodNamespace  string            `json:"podNamespace,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+func ipReservationURL(ctx *cli.Context) string {
+	return fmt.Sprintf("%s/api/v1/ipReservations/", baseURL(ctx))
+}
+
+// match returns the workload match of a reservation
+func (resv *ipReservation) match() string {
+	switch {
+	case resv.ContainerName != "":
+		return "container=" + resv.ContainerName
+	case resv.PodName != "":
+		if resv.PodNamespace != "" {
+			return "pod=" + resv.PodNamespace + "/" + resv.PodName
+		}
+		return "pod=" + resv.PodName
+	}
+
+	labels := []string{}
+	for key, value := range resv.Labels {
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+	return "labels=" + strings.Join(labels, ",")
+}
+
+func createIPReservation(ctx *cli.Context) {
+	if len(ctx.Args()) != 2 {
+		errExit(ctx, exitHelp, "Network and ip address required", true)
+	}
+
+	resv := &ipReservation{
+		Tenant:        ctx.String("tenant"),
+		Network:       ctx.Args()[0],
+		IPAddress:     ctx.Args()[1],
+		ContainerName: ctx.String("container"),
+		PodName:       ctx.String("pod"),
+		PodNamespace:  ctx.String("namespace"),
+	}
+
+	for _, label := range ctx.StringSlice("label") {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			errExit(ctx, exitInvalid, fmt.Sprintf("Invalid label %q, expecting key=value", label), false)
+		}
+		if resv.Labels == nil {
+			resv.Labels = make(map[string]string)
+		}
+		resv.Labels[kv[0]] = kv[1]
+	}
+
+	jdata, err := json.Marshal(resv)
+	errCheck(ctx, err)
+
+	errCheck(ctx, postObject(ctx, ipReservationURL(ctx), jdata, resv))
+	fmt.Printf("Reserved %s in network %s for %s\n", resv.IPAddress, resv.Network, resv.match())
+}
+
+func deleteIPReservation(ctx *cli.Context) {
+	if len(ctx.Args()) != 2 {
+		errExit(ctx, exitHelp, "Network and ip address required", true)
+	}
+
+	key := strings.Join([]string{ctx.String("tenant"), ctx.Args()[0], ctx.Args()[1]}, ":")
+	req, err := http.NewRequest("DELETE", ipReservationURL(ctx)+url.QueryEscape(key)+"/", nil)
+	errCheck(ctx, err)
+
+	resp, err := client.Do(req)
+	handleBasicError(ctx, err)
+	defer resp.Body.Close()
+	respCheck(resp, ctx)
+}
+
+func listIPReservations(ctx *cli.Context) {
+	if len(ctx.Args()) != 0 {
+		errExit(ctx, exitHelp, "More arguments than required", true)
+	}
+
+	query := url.Values{}
+	if !ctx.Bool("all") {
+		query.Set("tenant", ctx.String("tenant"))
+	}
+	if network := ctx.String("network"); network != "" {
+		query.Set("network", network)
+	}
+
+	resvList := []*ipReservation{}
+	errCheck(ctx, getObject(ctx, ipReservationURL(ctx)+"?"+query.Encode(), &resvList))
+
+	if ctx.Bool("json") {
+		dumpJSONList(ctx, resvList)
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	defer writer.Flush()
+	writer.Write([]byte("Tenant\tNetwork\tIP Address\tMatch\n"))
+	writer.Write([]byte("------\t-------\t----------\t-----\n"))
+	for _, resv := range resvList {
+		writer.Write([]byte(fmt.Sprintf("%v\t%v\t%v\t%v\n",
+			resv.Tenant,
+			resv.Network,
+			resv.IPAddress,
+			resv.match(),
+		)))
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 
@@ -156,7 +157,18 @@ func addRule(ctx *cli.Context) {
 		errExit(ctx, exitHelp, "Unknown direction", false)
 	}
 
-	errCheck(ctx, getClient(ctx).RulePost(&contivClient.Rule{
+	// a single port number goes in port, anything else is a port list
+	var port int
+	var ports string
+	if portStr := ctx.String("port"); portStr != "" {
+		if portNum, err := strconv.Atoi(portStr); err == nil {
+			port = portNum
+		} else {
+			ports = portStr
+		}
+	}
+
+	rule := &contivClient.Rule{
 		TenantName:        ctx.String("tenant"),
 		PolicyName:        ctx.Args()[0],
 		RuleID:            ctx.Args()[1],
@@ -169,9 +181,21 @@ func addRule(ctx *cli.Context) {
 		FromIpAddress:     ctx.String("from-ip-address"),
 		ToIpAddress:       ctx.String("to-ip-address"),
 		Protocol:          ctx.String("protocol"),
-		Port:              ctx.Int("port"),
+		Port:              port,
+		Ports:             ports,
+		SrcPort:           ctx.Int("src-port"),
+		TcpFlags:          ctx.String("tcp-flags"),
+		IcmpType:          ctx.String("icmp-type"),
+		IcmpCode:          ctx.String("icmp-code"),
 		Action:            ctx.String("action"),
-	}))
+	}
+
+	if ctx.Bool("dry-run") {
+		dryRun(ctx, "rules", rule.TenantName+":"+rule.PolicyName+":"+rule.RuleID, rule)
+		return
+	}
+
+	errCheck(ctx, getClient(ctx).RulePost(rule))
 }
 
 func deleteRule(ctx *cli.Context) {
@@ -183,9 +207,42 @@ func deleteRule(ctx *cli.Context) {
 	policy := ctx.Args()[0]
 	ruleID := ctx.Args()[1]
 
+	if ctx.Bool("dry-run") {
+		dryRun(ctx, "rules", tenant+":"+policy+":"+ruleID, nil)
+		return
+	}
+
 	errCheck(ctx, getClient(ctx).RuleDelete(tenant, policy, ruleID))
 }
 
+// rulePorts returns the port list of a rule for display
+func rulePorts(rule *contivClient.Rule) string {
+	if rule.Ports != "" {
+		return rule.Ports
+	}
+
+	return strconv.Itoa(rule.Port)
+}
+
+// ruleMatches returns the additional protocol matches of a rule for display
+func ruleMatches(rule *contivClient.Rule) string {
+	matches := []string{}
+	if rule.SrcPort != 0 {
+		matches = append(matches, fmt.Sprintf("src-port=%d", rule.SrcPort))
+	}
+	if rule.TcpFlags != "" {
+		matches = append(matches, "tcp-flags="+rule.TcpFlags)
+	}
+	if rule.IcmpType != "" {
+		matches = append(matches, "icmp-type="+rule.IcmpType)
+	}
+	if rule.IcmpCode != "" {
+		matches = append(matches, "icmp-code="+rule.IcmpCode)
+	}
+
+	return strings.Join(matches, " ")
+}
+
 func listRules(ctx *cli.Context) {
 	if len(ctx.Args()) != 1 {
 		errExit(ctx, exitHelp, "Policy name required", true)
@@ -236,40 +293,42 @@ func listRules(ctx *cli.Context) {
 		writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
 		defer writer.Flush()
 		writer.Write([]byte("Incoming Rules:\n"))
-		writer.Write([]byte("Rule\tPriority\tFrom EndpointGroup\tFrom Network\tFrom IpAddress\tProtocol\tPort\tAction\n"))
-		writer.Write([]byte("----\t--------\t------------------\t------------\t---------\t--------\t----\t------\n"))
+		writer.Write([]byte("Rule\tPriority\tFrom EndpointGroup\tFrom Network\tFrom IpAddress\tProtocol\tPort\tMatch\tAction\n"))
+		writer.Write([]byte("----\t--------\t------------------\t------------\t---------\t--------\t----\t-----\t------\n"))
 
 		for _, rule := range results {
 			if rule.Direction == "in" {
 				writer.Write([]byte(fmt.Sprintf(
-					"%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+					"%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
 					rule.RuleID,
 					rule.Priority,
 					rule.FromEndpointGroup,
 					rule.FromNetwork,
 					rule.FromIpAddress,
 					rule.Protocol,
-					rule.Port,
+					rulePorts(rule),
+					ruleMatches(rule),
 					rule.Action,
 				)))
 			}
 		}
 
 		writer.Write([]byte("Outgoing Rules:\n"))
-		writer.Write([]byte("Rule\tPriority\tTo EndpointGroup\tTo Network\tTo IpAddress\tProtocol\tPort\tAction\n"))
-		writer.Write([]byte("----\t--------\t----------------\t----------\t---------\t--------\t----\t------\n"))
+		writer.Write([]byte("Rule\tPriority\tTo EndpointGroup\tTo Network\tTo IpAddress\tProtocol\tPort\tMatch\tAction\n"))
+		writer.Write([]byte("----\t--------\t----------------\t----------\t---------\t--------\t----\t-----\t------\n"))
 
 		for _, rule := range results {
 			if rule.Direction == "out" {
 				writer.Write([]byte(fmt.Sprintf(
-					"%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+					"%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
 					rule.RuleID,
 					rule.Priority,
 					rule.ToEndpointGroup,
 					rule.ToNetwork,
 					rule.ToIpAddress,
 					rule.Protocol,
-					rule.Port,
+					rulePorts(rule),
+					ruleMatches(rule),
 					rule.Action,
 				)))
 			}
@@ -434,17 +493,27 @@ func createNetwork(ctx *cli.Context) {
 	pktTag := ctx.Int("pkt-tag")
 	nwType := ctx.String("nw-type")
 
-	errCheck(ctx, getClient(ctx).NetworkPost(&contivClient.Network{
-		TenantName:  tenant,
-		NetworkName: network,
-		Encap:       encap,
-		Subnet:      subnet,
-		Gateway:     gateway,
-		Ipv6Subnet:  subnetv6,
-		Ipv6Gateway: gatewayv6,
-		PktTag:      pktTag,
-		NwType:      nwType,
-	}))
+	nw := &contivClient.Network{
+		TenantName:          tenant,
+		NetworkName:         network,
+		Encap:               encap,
+		Subnet:              subnet,
+		Gateway:             gateway,
+		Ipv6Subnet:          subnetv6,
+		Ipv6Gateway:         gatewayv6,
+		PktTag:              pktTag,
+		NwType:              nwType,
+		AddrQuarantineTime:  ctx.Int("quarantine-time"),
+		AddrQuarantineCount: ctx.Int("quarantine-count"),
+		ServiceIpPool:       ctx.String("service-ip-pool"),
+	}
+
+	if ctx.Bool("dry-run") {
+		dryRun(ctx, "networks", tenant+":"+network, nw)
+		return
+	}
+
+	errCheck(ctx, getClient(ctx).NetworkPost(nw))
 
 	fmt.Printf("Creating network %s:%s\n", tenant, network)
 }
@@ -457,6 +526,11 @@ func deleteNetwork(ctx *cli.Context) {
 	tenant := ctx.String("tenant")
 	network := ctx.Args()[0]
 
+	if ctx.Bool("dry-run") {
+		dryRun(ctx, "networks", tenant+":"+network, nil)
+		return
+	}
+
 	fmt.Printf("Deleting network %s:%s\n", tenant, network)
 
 	errCheck(ctx, getClient(ctx).NetworkDelete(tenant, network))
@@ -546,6 +620,8 @@ func createTenant(ctx *cli.Context) {
 
 	errCheck(ctx, getClient(ctx).TenantPost(&contivClient.Tenant{
 		TenantName: tenant,
+		Vlans:      ctx.String("vlan-range"),
+		Vxlans:     ctx.String("vxlan-range"),
 	}))
 
 	fmt.Printf("Creating tenant: %s\n", tenant)
@@ -641,18 +717,27 @@ func createEndpointGroup(ctx *cli.Context) {
 	group := ctx.Args()[1]
 	netprofile := ctx.String("networkprofile")
 	ipPool := ctx.String("ip-pool")
+	ipv6Pool := ctx.String("ipv6-pool")
 	policies := ctx.StringSlice("policy")
 
 	extContractsGrps := ctx.StringSlice("external-contract")
-	errCheck(ctx, getClient(ctx).EndpointGroupPost(&contivClient.EndpointGroup{
+	epg := &contivClient.EndpointGroup{
 		TenantName:       tenant,
 		NetworkName:      network,
 		GroupName:        group,
 		NetProfile:       netprofile,
 		IpPool:           ipPool,
+		Ipv6Pool:         ipv6Pool,
 		Policies:         policies,
 		ExtContractsGrps: extContractsGrps,
-	}))
+	}
+
+	if ctx.Bool("dry-run") {
+		dryRun(ctx, "endpointGroups", tenant+":"+group, epg)
+		return
+	}
+
+	errCheck(ctx, getClient(ctx).EndpointGroupPost(epg))
 
 	fmt.Printf("Creating EndpointGroup %s:%s\n", tenant, group)
 }
@@ -675,6 +760,107 @@ func inspectEndpointGroup(ctx *cli.Context) {
 	os.Stdout.WriteString("\n")
 }
 
+// addEndpointGroupPolicy attaches a policy to an endpoint group, keeping
+// the policies that are already attached
+func addEndpointGroupPolicy(ctx *cli.Context) {
+	if len(ctx.Args()) != 2 {
+		errExit(ctx, exitHelp, "Group and policy name required", true)
+	}
+
+	tenant := ctx.String("tenant")
+	group := ctx.Args()[0]
+	policy := ctx.Args()[1]
+
+	epg := &contivClient.EndpointGroup{}
+	updateObject(ctx, "endpointGroups", tenant+":"+group, epg, func() {
+		for _, p := range epg.Policies {
+			if p == policy {
+				return
+			}
+		}
+		epg.Policies = append(epg.Policies, policy)
+	})
+
+	fmt.Printf("Added policy %s to EndpointGroup %s:%s\n", policy, tenant, group)
+}
+
+// removeEndpointGroupPolicy detaches a policy from an endpoint group
+func removeEndpointGroupPolicy(ctx *cli.Context) {
+	if len(ctx.Args()) != 2 {
+		errExit(ctx, exitHelp, "Group and policy name required", true)
+	}
+
+	tenant := ctx.String("tenant")
+	group := ctx.Args()[0]
+	policy := ctx.Args()[1]
+
+	epg := &contivClient.EndpointGroup{}
+	updateObject(ctx, "endpointGroups", tenant+":"+group, epg, func() {
+		policies := []string{}
+		for _, p := range epg.Policies {
+			if p != policy {
+				policies = append(policies, p)
+			}
+		}
+		epg.Policies = policies
+	})
+
+	fmt.Printf("Removed policy %s from EndpointGroup %s:%s\n", policy, tenant, group)
+}
+
+// addEndpointGroupPool appends an address range to the ip pool of an
+// endpoint group
+func addEndpointGroupPool(ctx *cli.Context) {
+	if len(ctx.Args()) != 2 {
+		errExit(ctx, exitHelp, "Group name and ip pool required", true)
+	}
+
+	tenant := ctx.String("tenant")
+	group := ctx.Args()[0]
+	pool := ctx.Args()[1]
+
+	epg := &contivClient.EndpointGroup{}
+	updateObject(ctx, "endpointGroups", tenant+":"+group, epg, func() {
+		pools := []string{}
+		for _, p := range strings.Split(epg.IpPool, ",") {
+			if p == pool {
+				return
+			}
+			if p != "" {
+				pools = append(pools, p)
+			}
+		}
+		epg.IpPool = strings.Join(append(pools, pool), ",")
+	})
+
+	fmt.Printf("Added ip pool %s to EndpointGroup %s:%s\n", pool, tenant, group)
+}
+
+// removeEndpointGroupPool removes an address range from the ip pool of an
+// endpoint group
+func removeEndpointGroupPool(ctx *cli.Context) {
+	if len(ctx.Args()) != 2 {
+		errExit(ctx, exitHelp, "Group name and ip pool required", true)
+	}
+
+	tenant := ctx.String("tenant")
+	group := ctx.Args()[0]
+	pool := ctx.Args()[1]
+
+	epg := &contivClient.EndpointGroup{}
+	updateObject(ctx, "endpointGroups", tenant+":"+group, epg, func() {
+		pools := []string{}
+		for _, p := range strings.Split(epg.IpPool, ",") {
+			if p != pool && p != "" {
+				pools = append(pools, p)
+			}
+		}
+		epg.IpPool = strings.Join(pools, ",")
+	})
+
+	fmt.Printf("Removed ip pool %s from EndpointGroup %s:%s\n", pool, tenant, group)
+}
+
 func deleteEndpointGroup(ctx *cli.Context) {
 	if len(ctx.Args()) != 1 {
 		errExit(ctx, exitHelp, "Endpoint name required", true)
@@ -683,6 +869,11 @@ func deleteEndpointGroup(ctx *cli.Context) {
 	tenant := ctx.String("tenant")
 	group := ctx.Args()[0]
 
+	if ctx.Bool("dry-run") {
+		dryRun(ctx, "endpointGroups", tenant+":"+group, nil)
+		return
+	}
+
 	errCheck(ctx, getClient(ctx).EndpointGroupDelete(tenant, group))
 }
 
@@ -894,30 +1085,28 @@ func setGlobal(ctx *cli.Context) {
 	arpMode := ctx.String("arp-mode")
 	ps := ctx.String("private-subnet")
 
-	global, err := getClient(ctx).GlobalGet("global")
-	errCheck(ctx, err)
-
-	if fabMode != "" {
-		global.NetworkInfraType = fabMode
-	}
-	if vlans != "" {
-		global.Vlans = vlans
-	}
-
-	if vxlans != "" {
-		global.Vxlans = vxlans
-	}
-	if fwdMode != "" {
-		global.FwdMode = fwdMode
-	}
-	if arpMode != "" {
-		global.ArpMode = arpMode
-	}
-	if ps != "" {
-		global.PvtSubnet = ps
-	}
+	global := &contivClient.Global{}
+	updateObject(ctx, "globals", "global", global, func() {
+		if fabMode != "" {
+			global.NetworkInfraType = fabMode
+		}
+		if vlans != "" {
+			global.Vlans = vlans
+		}
 
-	errCheck(ctx, getClient(ctx).GlobalPost(global))
+		if vxlans != "" {
+			global.Vxlans = vxlans
+		}
+		if fwdMode != "" {
+			global.FwdMode = fwdMode
+		}
+		if arpMode != "" {
+			global.ArpMode = arpMode
+		}
+		if ps != "" {
+			global.PvtSubnet = ps
+		}
+	})
 }
 
 func setAciGw(ctx *cli.Context) {
@@ -1163,10 +1352,20 @@ func createServiceLB(ctx *cli.Context) {
 	ports := ctx.StringSlice("port")
 	ipAddress := ctx.String("preferred-ip")
 	service := &contivClient.ServiceLB{
-		ServiceName: serviceName,
-		TenantName:  tenantName,
-		NetworkName: serviceSubnet,
-		IpAddress:   ipAddress,
+		ServiceName:            serviceName,
+		TenantName:             tenantName,
+		NetworkName:            serviceSubnet,
+		IpAddress:              ipAddress,
+		HealthCheck:            ctx.String("health-check"),
+		HealthCheckPort:        ctx.Int("health-check-port"),
+		HealthCheckPath:        ctx.String("health-check-path"),
+		HealthCheckInterval:    ctx.Int("health-check-interval"),
+		HealthCheckTimeout:     ctx.Int("health-check-timeout"),
+		HealthyThreshold:       ctx.Int("healthy-threshold"),
+		UnhealthyThreshold:     ctx.Int("unhealthy-threshold"),
+		SessionAffinity:        ctx.String("session-affinity"),
+		SessionAffinityTimeout: ctx.Int("session-affinity-timeout"),
+		WeightLabel:            ctx.String("weight-label"),
 	}
 	service.Selectors = append(service.Selectors, selectors...)
 	service.Ports = append(service.Ports, ports...)
@@ -158,6 +158,15 @@ func (d *MasterDaemon) agentDiscoveryLoop() {
 			var res bool
 			log.Infof("Unregister node %+v", nodeInfo)
 			d.ofnetMaster.UnRegisterNode(&nodeInfo, &res)
+
+			// return the free addresses delegated to the host
+			if stateDriver, err := utils.GetStateDriver(); err == nil {
+				err = master.ReleaseHostAddrBlocks(stateDriver, agentEv.ServiceInfo.Hostname)
+				if err != nil {
+					log.Errorf("Error releasing address blocks of host %s. Err: %v",
+						agentEv.ServiceInfo.Hostname, err)
+				}
+			}
 		}
 
 		// Dont process next peer event for another 100ms
@@ -172,9 +181,11 @@ func (d *MasterDaemon) registerRoutes(router *mux.Router) {
 
 	s.HandleFunc("/plugin/allocAddress", makeHTTPHandler(master.AllocAddressHandler))
 	s.HandleFunc("/plugin/releaseAddress", makeHTTPHandler(master.ReleaseAddressHandler))
+	s.HandleFunc("/plugin/allocAddrBlock", makeHTTPHandler(master.AllocAddrBlockHandler))
 	s.HandleFunc("/plugin/createEndpoint", makeHTTPHandler(master.CreateEndpointHandler))
 	s.HandleFunc("/plugin/deleteEndpoint", makeHTTPHandler(master.DeleteEndpointHandler))
 	s.HandleFunc("/plugin/updateEndpoint", makeHTTPHandler(master.UpdateEndpointHandler))
+	s.HandleFunc("/plugin/updateProviderHealth", makeHTTPHandler(master.UpdateProviderHealthHandler))
 
 	s = router.Methods("Get").Subrouter()
 
@@ -244,7 +255,7 @@ func (d *MasterDaemon) runLeader() {
 	d.registerRoutes(router)
 
 	// Create HTTP server and listener
-	server := &http.Server{Handler: router}
+	server := &http.Server{Handler: objApi.ObjectHandler(router)}
 	server.SetKeepAlivesEnabled(false)
 	listener, err := net.Listen("tcp", d.ListenURL)
 	if nil != err {
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
@@ -268,6 +269,38 @@ func DeleteDockNet(tenantName, networkName, serviceName string) error {
 	return err
 }
 
+// GetDockNetAddresses returns the addresses of the containers attached to a
+// docker network, keyed by address
+func GetDockNetAddresses(tenantName, networkName, serviceName string) (map[string]string, error) {
+	// Trim default tenant name
+	docknetName := GetDocknetName(tenantName, networkName, serviceName)
+
+	// connect to docker
+	defaultHeaders := map[string]string{"User-Agent": "engine-api-cli-1.0"}
+	docker, err := client.NewClient("unix:///var/run/docker.sock", "v1.23", nil, defaultHeaders)
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return nil, errors.New("Unable to connect to docker")
+	}
+
+	nw, err := docker.NetworkInspect(context.Background(), docknetName)
+	if err != nil {
+		log.Errorf("Error inspecting docker network %s. Err: %v", docknetName, err)
+		return nil, err
+	}
+
+	addrs := make(map[string]string)
+	for _, ep := range nw.Containers {
+		for _, addr := range []string{ep.IPv4Address, ep.IPv6Address} {
+			if addr != "" {
+				addrs[strings.Split(addr, "/")[0]] = ep.Name
+			}
+		}
+	}
+
+	return addrs, nil
+}
+
 // FindDocknetByUUID find the docknet by UUID
 func FindDocknetByUUID(dnetID string) (*DnetOperState, error) {
 	// Get the state driver
@@ -33,11 +33,10 @@ import (
 )
 
 const (
-	cfgGlobalPrefix     = mastercfg.StateConfigPath + "global/"
-	cfgGlobalPath       = cfgGlobalPrefix + "global"
-	operGlobalPrefix    = mastercfg.StateOperPath + "global/"
-	operGlobalPath      = operGlobalPrefix + "global"
-	vxlanLocalVlanRange = "1-4094"
+	cfgGlobalPrefix  = mastercfg.StateConfigPath + "global/"
+	cfgGlobalPath    = cfgGlobalPrefix + "global"
+	operGlobalPrefix = mastercfg.StateOperPath + "global/"
+	operGlobalPath   = operGlobalPrefix + "global"
 )
 
 //GlobalMutex used to syncronize global configuration changes
@@ -173,12 +172,7 @@ func (gc *Cfg) initVXLANBitset(vxlans string) (*resources.AutoVXLANCfgResource,
 		vxlanRsrcCfg.VXLANs.Set(uint(vxlan) - freeVXLANsStart)
 	}
 
-	// Initialize local vlan bitset
-	vxlanRsrcCfg.LocalVLANs, err = gc.initVLANBitset(vxlanLocalVlanRange)
 	vxlanRsrcCfg.FreeVXLANsStart = freeVXLANsStart
-	if err != nil {
-		return nil, err
-	}
 
 	return vxlanRsrcCfg, nil
 }
@@ -195,12 +189,19 @@ func (gc *Cfg) GetVxlansInUse() (uint, string) {
 	return ra.GetResourceList("global", resources.AutoVXLANResource)
 }
 
-// AllocVXLAN allocates a new vxlan; ids for both the vxlan and vlan are returned.
-func (gc *Cfg) AllocVXLAN(reqVxlan uint) (vxlan uint, localVLAN uint, err error) {
+// AllocVXLAN allocates a new vxlan. The local vlan the vxlan is mapped to is
+// allocated by each host that has endpoints in the network.
+func (gc *Cfg) AllocVXLAN(reqVxlan uint) (uint, error) {
+	return gc.AllocVXLANIn(reqVxlan, nil)
+}
+
+// AllocVXLANIn allocates a new vxlan from the allowed vxlans, a nil allowed
+// doesn't restrict the allocation.
+func (gc *Cfg) AllocVXLANIn(reqVxlan uint, allowed *bitset.BitSet) (uint, error) {
 
 	tempRm, err := resources.GetStateResourceManager()
 	if err != nil {
-		return 0, 0, err
+		return 0, err
 	}
 	ra := core.ResourceManager(tempRm)
 
@@ -208,30 +209,104 @@ func (gc *Cfg) AllocVXLAN(reqVxlan uint) (vxlan uint, localVLAN uint, err error)
 	g.StateDriver = gc.StateDriver
 	err = g.Read("")
 	if err != nil {
-		return 0, 0, err
+		return 0, err
 	}
 
 	if reqVxlan != 0 && reqVxlan <= g.FreeVXLANsStart {
-		return 0, 0, errors.New("Requested vxlan is out of range")
+		return 0, errors.New("Requested vxlan is out of range")
 	}
 
 	if (reqVxlan != 0) && (reqVxlan >= g.FreeVXLANsStart) {
 		reqVxlan = reqVxlan - g.FreeVXLANsStart
 	}
 
-	pair, err1 := ra.AllocateResourceVal("global", resources.AutoVXLANResource, reqVxlan)
-	if err1 != nil {
-		return 0, 0, err1
+	req := resources.TagRequest{Tag: reqVxlan, Allowed: vxlanBitsetIndex(allowed, g.FreeVXLANsStart)}
+	vxlan, err := ra.AllocateResourceVal("global", resources.AutoVXLANResource, req)
+	if err != nil {
+		return 0, err
+	}
+
+	return vxlan.(uint) + g.FreeVXLANsStart, nil
+}
+
+// PeekVXLAN returns the vxlan AllocVXLAN would allocate without allocating
+// it.
+func (gc *Cfg) PeekVXLAN(reqVxlan uint) (uint, error) {
+	return gc.PeekVXLANIn(reqVxlan, nil)
+}
+
+// PeekVXLANIn returns the vxlan AllocVXLANIn would allocate without
+// allocating it.
+func (gc *Cfg) PeekVXLANIn(reqVxlan uint, allowed *bitset.BitSet) (uint, error) {
+	g := &Oper{}
+	g.StateDriver = gc.StateDriver
+	err := g.Read("")
+	if err != nil {
+		return 0, err
+	}
+
+	if reqVxlan != 0 && reqVxlan <= g.FreeVXLANsStart {
+		return 0, errors.New("Requested vxlan is out of range")
+	}
+
+	if (reqVxlan != 0) && (reqVxlan >= g.FreeVXLANsStart) {
+		reqVxlan = reqVxlan - g.FreeVXLANsStart
+	}
+
+	oper := &resources.AutoVXLANOperResource{}
+	oper.StateDriver = gc.StateDriver
+	err = oper.Read("global")
+	if err != nil {
+		return 0, err
+	}
+
+	vxlan, err := oper.NextVXLANIn(reqVxlan, vxlanBitsetIndex(allowed, g.FreeVXLANsStart))
+	if err != nil {
+		return 0, err
+	}
+
+	return vxlan + g.FreeVXLANsStart, nil
+}
+
+// vxlanBitsetIndex converts a bitset of vxlans to a bitset indexed like the
+// vxlan resource, which starts after freeVXLANsStart
+func vxlanBitsetIndex(vxlans *bitset.BitSet, freeVXLANsStart uint) *bitset.BitSet {
+	if vxlans == nil {
+		return nil
 	}
 
-	vxlan = pair.(resources.VXLANVLANPair).VXLAN + g.FreeVXLANsStart
-	localVLAN = pair.(resources.VXLANVLANPair).VLAN
+	allowed := bitset.New(0)
+	for vxlan, found := vxlans.NextSet(freeVXLANsStart + 1); found; vxlan, found = vxlans.NextSet(vxlan + 1) {
+		allowed.Set(vxlan - freeVXLANsStart)
+	}
 
-	return
+	return allowed
+}
+
+// GetVxlansUsage returns the number and list of the allowed vxlans in use,
+// and the number of allowed vxlans that are free
+func (gc *Cfg) GetVxlansUsage(allowed *bitset.BitSet) (uint, string, uint) {
+	g := &Oper{}
+	g.StateDriver = gc.StateDriver
+	if err := g.Read(""); err != nil {
+		log.Errorf("error reading global oper state: %s", err)
+		return 0, "", 0
+	}
+
+	oper := &resources.AutoVXLANOperResource{}
+	oper.StateDriver = gc.StateDriver
+	if err := oper.Read("global"); err != nil {
+		log.Errorf("error reading the vxlan resource: %s", err)
+		return 0, "", 0
+	}
+
+	allowed = vxlanBitsetIndex(allowed, g.FreeVXLANsStart)
+	numInUse, inUse := resources.ListTags(allowed.Difference(oper.FreeVXLANs), g.FreeVXLANsStart)
+	return numInUse, inUse, allowed.IntersectionCardinality(oper.FreeVXLANs)
 }
 
 // FreeVXLAN returns a VXLAN id to the pool.
-func (gc *Cfg) FreeVXLAN(vxlan uint, localVLAN uint) error {
+func (gc *Cfg) FreeVXLAN(vxlan uint) error {
 	tempRm, err := resources.GetStateResourceManager()
 	if err != nil {
 		return err
@@ -246,9 +321,7 @@ func (gc *Cfg) FreeVXLAN(vxlan uint, localVLAN uint) error {
 	}
 
 	return ra.DeallocateResourceVal("global", resources.AutoVXLANResource,
-		resources.VXLANVLANPair{
-			VXLAN: vxlan - g.FreeVXLANsStart,
-			VLAN:  localVLAN})
+		vxlan-g.FreeVXLANsStart)
 }
 
 func clearReservedVLANs(vlanBitset *bitset.BitSet) {
@@ -289,13 +362,20 @@ func (gc *Cfg) GetVlansInUse() (uint, string) {
 
 // AllocVLAN allocates a new VLAN resource. Returns an ID.
 func (gc *Cfg) AllocVLAN(reqVlan uint) (uint, error) {
+	return gc.AllocVLANIn(reqVlan, nil)
+}
+
+// AllocVLANIn allocates a new VLAN from the allowed vlans, a nil allowed
+// doesn't restrict the allocation.
+func (gc *Cfg) AllocVLANIn(reqVlan uint, allowed *bitset.BitSet) (uint, error) {
 	tempRm, err := resources.GetStateResourceManager()
 	if err != nil {
 		return 0, err
 	}
 	ra := core.ResourceManager(tempRm)
 
-	vlan, err := ra.AllocateResourceVal("global", resources.AutoVLANResource, reqVlan)
+	req := resources.TagRequest{Tag: reqVlan, Allowed: allowed}
+	vlan, err := ra.AllocateResourceVal("global", resources.AutoVLANResource, req)
 	if err != nil {
 		log.Errorf("alloc vlan failed: %q", err)
 		return 0, err
@@ -304,6 +384,38 @@ func (gc *Cfg) AllocVLAN(reqVlan uint) (uint, error) {
 	return vlan.(uint), err
 }
 
+// PeekVLAN returns the vlan AllocVLAN would allocate without allocating it.
+func (gc *Cfg) PeekVLAN(reqVlan uint) (uint, error) {
+	return gc.PeekVLANIn(reqVlan, nil)
+}
+
+// PeekVLANIn returns the vlan AllocVLANIn would allocate without allocating
+// it.
+func (gc *Cfg) PeekVLANIn(reqVlan uint, allowed *bitset.BitSet) (uint, error) {
+	oper := &resources.AutoVLANOperResource{}
+	oper.StateDriver = gc.StateDriver
+	err := oper.Read("global")
+	if err != nil {
+		return 0, err
+	}
+
+	return oper.NextVLANIn(reqVlan, allowed)
+}
+
+// GetVlansUsage returns the number and list of the allowed vlans in use, and
+// the number of allowed vlans that are free
+func (gc *Cfg) GetVlansUsage(allowed *bitset.BitSet) (uint, string, uint) {
+	oper := &resources.AutoVLANOperResource{}
+	oper.StateDriver = gc.StateDriver
+	if err := oper.Read("global"); err != nil {
+		log.Errorf("error reading the vlan resource: %s", err)
+		return 0, "", 0
+	}
+
+	numInUse, inUse := resources.ListTags(allowed.Difference(oper.FreeVLANs), 0)
+	return numInUse, inUse, allowed.IntersectionCardinality(oper.FreeVLANs)
+}
+
 // FreeVLAN releases a VLAN for a given ID.
 func (gc *Cfg) FreeVLAN(vlan uint) error {
 	tempRm, err := resources.GetStateResourceManager()
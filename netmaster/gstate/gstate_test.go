@@ -98,7 +98,7 @@ func TestGlobalConfigAutoVXLAN(t *testing.T) {
                 "DefaultNetType"    : "vxlan"
             }
         }`)
-	var vxlan, localVLAN uint
+	var vxlan uint
 
 	gc, err := Parse(cfgData)
 	if err != nil {
@@ -122,21 +122,17 @@ func TestGlobalConfigAutoVXLAN(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error '%s' processing config %v \n", err, gc)
 	}
-	vxlan, localVLAN, err = gc.AllocVXLAN(uint(0))
+	vxlan, err = gc.AllocVXLAN(uint(0))
 	if err != nil {
 		t.Fatalf("error - allocating vxlan - %s \n", err)
 	}
 	if vxlan == 0 {
 		t.Fatalf("error - invalid vxlan allocated %d \n", vxlan)
 	}
-	if localVLAN == 0 {
-		t.Fatalf("error - invalid vlan allocated %d \n", localVLAN)
-	}
 
-	err = gc.FreeVXLAN(vxlan, localVLAN)
+	err = gc.FreeVXLAN(vxlan)
 	if err != nil {
-		t.Fatalf("error freeing allocated vxlan %d localvlan %d - err '%s' \n",
-			vxlan, localVLAN, err)
+		t.Fatalf("error freeing allocated vxlan %d - err '%s' \n", vxlan, err)
 	}
 }
 
@@ -155,7 +151,7 @@ func TestGlobalConfigDefaultVXLANWithVLANs(t *testing.T) {
                 "DefaultNetType"    : "vxlan"
             }
         }`)
-	var vlan, localVLAN, vxlan uint
+	var vlan, vxlan uint
 
 	gc, err := Parse(cfgData)
 	if err != nil {
@@ -187,26 +183,73 @@ func TestGlobalConfigDefaultVXLANWithVLANs(t *testing.T) {
 		t.Fatalf("error - expecting vlan %d but allocated %d \n", 100, vlan)
 	}
 
-	vxlan, localVLAN, err = gc.AllocVXLAN(uint(0))
+	vxlan, err = gc.AllocVXLAN(uint(0))
 	if err != nil {
 		t.Fatalf("error - allocating vxlan - %s \n", err)
 	}
 	if vxlan != 10000 {
 		t.Fatalf("error - expecting vlan %d but allocated %d \n", 10000, vxlan)
 	}
-	if localVLAN == 0 {
-		t.Fatalf("error - invalid vlan allocated %d \n", localVLAN)
-	}
 
 	err = gc.FreeVLAN(vlan)
 	if err != nil {
 		t.Fatalf("error freeing allocated vlan %d - err '%s' \n", vlan, err)
 	}
 
-	err = gc.FreeVXLAN(vxlan, localVLAN)
+	err = gc.FreeVXLAN(vxlan)
+	if err != nil {
+		t.Fatalf("error freeing allocated vxlan %d - err '%s' \n", vxlan, err)
+	}
+}
+
+func TestGlobalConfigMoreThan4KVXLANs(t *testing.T) {
+	cfgData := []byte(`
+        {
+            "Tenant"  : "default",
+            "Auto" : {
+                "SubnetPool"        : "11.5.0.0",
+                "SubnetLen"         : 16,
+                "AllocSubnetLen"    : 24,
+                "VLANs"             : "1-10",
+                "VXLANs"            : "10001-15000"
+            },
+            "Deploy" : {
+                "DefaultNetType"    : "vxlan"
+            }
+        }`)
+
+	gc, err := Parse(cfgData)
+	if err != nil {
+		t.Fatalf("error '%s' parsing config '%s' \n", err, cfgData)
+	}
+
+	gstateSD.Init(nil)
+	defer func() { gstateSD.Deinit() }()
+	gc.StateDriver = gstateSD
+	_, err = resources.NewStateResourceManager(gstateSD)
+	if err != nil {
+		t.Fatalf("Failed to instantiate resource manager. Error: %s", err)
+	}
+	defer func() { resources.ReleaseStateResourceManager() }()
+
+	err = gc.Process("vxlan")
 	if err != nil {
-		t.Fatalf("error freeing allocated vxlan %d localvlan %d - err '%s' \n",
-			vxlan, localVLAN, err)
+		t.Fatalf("error '%s' processing config %v \n", err, gc)
+	}
+
+	// vxlans don't take a local vlan in the global resources
+	for i := uint(0); i < 5000; i++ {
+		vxlan, err := gc.AllocVXLAN(uint(0))
+		if err != nil {
+			t.Fatalf("error - allocating vxlan %d - %s \n", i+1, err)
+		}
+		if vxlan != 10001+i {
+			t.Fatalf("error - expecting vxlan %d but allocated %d \n", 10001+i, vxlan)
+		}
+	}
+
+	if _, err := gc.AllocVXLAN(uint(0)); err == nil {
+		t.Fatalf("vxlan allocation succeeded with all vxlans in use")
 	}
 }
 
@@ -51,6 +51,15 @@ type ConfigNetwork struct {
 	IPv6Gateway    string
 	Vrf            string
 
+	// released addresses are held back from allocation for a number of
+	// seconds and until a number of other addresses were released
+	AddrQuarantineTime  int
+	AddrQuarantineCount int
+
+	// service lb addresses are allocated from the ranges of the service
+	// ip pool, when the network has one
+	ServiceIPPool string
+
 	// eps associated with the network
 	Endpoints []ConfigEP
 }
@@ -73,14 +82,66 @@ type ConfigBgp struct {
 	Neighbor   string
 }
 
+// Set-based selector operators
+const (
+	SelectorOpIn        = "in"
+	SelectorOpNotIn     = "notin"
+	SelectorOpExists    = "exists"
+	SelectorOpNotExists = "!exists"
+)
+
+// SelectorExpr is a set-based service selector, Values are used by the in
+// and notin operators
+type SelectorExpr struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// Provider health check types
+const (
+	HealthCheckTCP  = "tcp"
+	HealthCheckHTTP = "http"
+)
+
+// ConfigHealthCheck is the provider health check of a service, an empty Type
+// disables it. Interval and Timeout are in seconds.
+type ConfigHealthCheck struct {
+	Type               string
+	Port               int
+	Path               string
+	Interval           int
+	Timeout            int
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// Service session affinity types
+const (
+	SessionAffinityNone     = "None"
+	SessionAffinityClientIP = "ClientIP"
+)
+
+// ConfigLBPolicy is how clients of a service are spread over its providers.
+// With ClientIP affinity a client sticks to its provider for AffinityTimeout
+// seconds, the weight of a provider is read from its WeightLabel label.
+type ConfigLBPolicy struct {
+	SessionAffinity string
+	AffinityTimeout int
+	WeightLabel     string
+}
+
 //ConfigServiceLB keeps servicelb specific configs
 type ConfigServiceLB struct {
-	ServiceName string
-	Tenant      string
-	Selectors   map[string]string
-	Network     string
-	Ports       []string
-	IPAddress   string
+	ServiceName   string
+	Tenant        string
+	Selectors     map[string]string // key=value selectors
+	SelectorExprs []SelectorExpr    // set-based selectors
+	Network       string
+	Ports         []string
+	IPAddress     string
+	HealthCheck   ConfigHealthCheck
+	LBPolicy      ConfigLBPolicy
 }
 
 // Config is the top level configuration
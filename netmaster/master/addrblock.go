@@ -0,0 +1,679 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+	"github.com/contiv/netplugin/utils/netutils"
+	"github.com/jainvipin/bitset"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Hosts allocate IPv4 addresses for their containers from address blocks
+// delegated by netmaster, so that containers can be started while netmaster
+// is down or failing over. A block holds addresses of a network, or of the
+// ip pool of an epg, that are marked as allocated in the network or epg
+// allocator. The host moves addresses from the free to the allocated list of
+// its block with conditional writes of the block state, and asks netmaster
+// for more addresses when the block runs low. Released addresses go back to
+// the free list of their block. The free addresses of a host are returned
+// to the allocators when the host is removed, or when another host runs out
+// of addresses. Addresses of networks with an address quarantine are not
+// delegated. Endpoints with a block address are created by the host too.
+
+const (
+	// AddrBlockSize is the number of addresses delegated to a host at once
+	AddrBlockSize = 16
+	// AddrBlockLowWater is the number of free addresses in a block below
+	// which a host asks for more addresses
+	AddrBlockLowWater = 4
+)
+
+// AddrBlockRequest is the request of a host for more addresses in its block
+type AddrBlockRequest struct {
+	Host      string // Host label of the netplugin
+	NetworkID string // Network id, nw:epg.tenant for an epg, as in AddressAllocRequest
+}
+
+// AddrBlockResponse is the response to an address block request
+type AddrBlockResponse struct {
+	Addresses []string // Addresses added to the block
+}
+
+// ErrAddrsNotDelegated is returned for networks whose addresses are not
+// delegated to hosts
+var ErrAddrsNotDelegated = errors.New("addresses are not delegated to hosts")
+
+// ErrAddrBlockEmpty is returned when the address block of a host has no
+// free addresses
+var ErrAddrBlockEmpty = errors.New("address block has no free addresses")
+
+// addrBlockScope returns the network of an address request, and the epg for
+// epgs with an IPv4 pool. Addresses of epgs without a pool are allocated
+// from the network.
+func addrBlockScope(stateDriver core.StateDriver, allocID string) (*mastercfg.CfgNetworkState,
+	*mastercfg.EndpointGroupState, error) {
+	networkID, epgName := getNwAndEpgFromAddrReq(allocID)
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		log.Errorf("network %s is not operational", networkID)
+		return nil, nil, err
+	}
+
+	if epgName == "" {
+		return nwCfg, nil, nil
+	}
+
+	epgCfg := &mastercfg.EndpointGroupState{}
+	epgCfg.StateDriver = stateDriver
+	if err := epgCfg.Read(epgName); err != nil {
+		log.Errorf("failed to read epg %s, %s", epgName, err)
+		return nil, nil, err
+	}
+	if epgCfg.IPPool == "" {
+		return nwCfg, nil, nil
+	}
+
+	return nwCfg, epgCfg, nil
+}
+
+// addrBlockEPGKey returns the epg key of the blocks of an epg pool, an
+// empty string for the blocks of a network
+func addrBlockEPGKey(epgCfg *mastercfg.EndpointGroupState) string {
+	if epgCfg == nil {
+		return ""
+	}
+	return epgCfg.ID
+}
+
+// readAddrBlocks reads the address blocks matching a filter
+func readAddrBlocks(stateDriver core.StateDriver,
+	match func(*mastercfg.CfgAddrBlockState) bool) ([]*mastercfg.CfgAddrBlockState, error) {
+	readBlock := &mastercfg.CfgAddrBlockState{}
+	readBlock.StateDriver = stateDriver
+	blkStates, err := readBlock.ReadAll()
+	if err != nil && !strings.Contains(err.Error(), "Key not found") {
+		log.Errorf("Error reading address blocks. Err: %v", err)
+		return nil, err
+	}
+
+	blocks := []*mastercfg.CfgAddrBlockState{}
+	for _, state := range blkStates {
+		blkCfg := state.(*mastercfg.CfgAddrBlockState)
+		if match(blkCfg) {
+			blkCfg.StateDriver = stateDriver
+			blocks = append(blocks, blkCfg)
+		}
+	}
+	sort.Sort(addrBlockList(blocks))
+
+	return blocks, nil
+}
+
+// addrBlockList sorts address blocks by their id
+type addrBlockList []*mastercfg.CfgAddrBlockState
+
+func (l addrBlockList) Len() int           { return len(l) }
+func (l addrBlockList) Less(i, j int) bool { return l[i].ID < l[j].ID }
+func (l addrBlockList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// updateAddrBlock applies an update to an address block like
+// core.UpdateState, the block is created if it does not exist
+func updateAddrBlock(blkCfg *mastercfg.CfgAddrBlockState, id string, update func() error) error {
+	err := core.UpdateState(blkCfg, id, update)
+	if err == nil || !strings.Contains(err.Error(), "Key not found") {
+		return err
+	}
+
+	*blkCfg = mastercfg.CfgAddrBlockState{CommonState: blkCfg.CommonState}
+	blkCfg.ID = id
+	if err := update(); err != nil {
+		return err
+	}
+	err = blkCfg.WriteIfRevision(0)
+	if core.IsRevisionMismatch(err) {
+		// the block was created in the meantime
+		return core.UpdateState(blkCfg, id, update)
+	}
+
+	return err
+}
+
+// markAddrs marks up to count free addresses in the allocator of a network
+// or epg pool and returns them
+func markAddrs(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState, count int) ([]string, error) {
+	var addrs []string
+	mark := func(allocMap *bitset.BitSet, next func() (uint, bool)) error {
+		addrs = nil
+		for len(addrs) < count {
+			ipAddrValue, found := next()
+			if !found {
+				break
+			}
+			ipAddress, err := netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddrValue)
+			if err != nil {
+				log.Errorf("error acquiring subnet ip. Error: %s", err)
+				return err
+			}
+			allocMap.Set(ipAddrValue)
+			addrs = append(addrs, ipAddress)
+		}
+		return nil
+	}
+
+	if epgCfg != nil {
+		err := core.UpdateState(epgCfg, epgCfg.ID, func() error {
+			return mark(&epgCfg.EPGIPAllocMap, func() (uint, bool) {
+				return netutils.NextClearInPools(epgCfg.EPGIPAllocMap, epgCfg.IPPool,
+					nwCfg.SubnetIP, nwCfg.SubnetLen)
+			})
+		})
+		if err != nil {
+			log.Errorf("error updating epg config. Error: %s", err)
+			return nil, err
+		}
+		return addrs, nil
+	}
+
+	err := core.UpdateState(nwCfg, nwCfg.ID, func() error {
+		return mark(&nwCfg.IPAllocMap, func() (uint, bool) {
+			return netutils.NextClear(nwCfg.IPAllocMap, 0, nwCfg.SubnetLen)
+		})
+	})
+	if err != nil {
+		log.Errorf("error updating nw config. Error: %s", err)
+		return nil, err
+	}
+
+	return addrs, nil
+}
+
+// unmarkAddrs clears addresses in the allocator of a network or epg pool
+func unmarkAddrs(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState, addrs []string) error {
+	if epgCfg != nil {
+		return core.UpdateState(epgCfg, epgCfg.ID, func() error {
+			for _, ipAddress := range addrs {
+				if err := unmarkAddress(nwCfg, &epgCfg.EPGIPAllocMap, &epgCfg.EPGIPv6Alloc, ipAddress); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return core.UpdateState(nwCfg, nwCfg.ID, func() error {
+		for _, ipAddress := range addrs {
+			if err := unmarkAddress(nwCfg, &nwCfg.IPAllocMap, &nwCfg.IPv6Alloc, ipAddress); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// delegateAddrBlock allocates up to count addresses of a network or epg pool
+// and adds them to the free addresses of the block of a host
+func delegateAddrBlock(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState,
+	host string, count int) ([]string, error) {
+	addrs, err := markAddrs(nwCfg, epgCfg, count)
+	if err != nil || len(addrs) == 0 {
+		return nil, err
+	}
+
+	blkCfg := &mastercfg.CfgAddrBlockState{}
+	blkCfg.StateDriver = nwCfg.StateDriver
+	epgKey := addrBlockEPGKey(epgCfg)
+	err = updateAddrBlock(blkCfg, mastercfg.GetAddrBlockKey(host, nwCfg.ID, epgKey), func() error {
+		blkCfg.Host = host
+		blkCfg.NetworkID = nwCfg.ID
+		blkCfg.EndpointGroup = epgKey
+		blkCfg.Free = append(blkCfg.Free, addrs...)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error updating address block of host %s in %s. Err: %v", host, nwCfg.ID, err)
+		if err := unmarkAddrs(nwCfg, epgCfg, addrs); err != nil {
+			log.Errorf("Error freeing addresses %v of %s. Err: %v", addrs, nwCfg.ID, err)
+		}
+		return nil, err
+	}
+
+	return addrs, nil
+}
+
+// reclaimAddrBlocks takes the free addresses of the matching address blocks
+// and clears them in the network and epg allocators
+func reclaimAddrBlocks(stateDriver core.StateDriver, match func(*mastercfg.CfgAddrBlockState) bool) error {
+	blocks, err := readAddrBlocks(stateDriver, match)
+	if err != nil {
+		return err
+	}
+
+	for _, blkCfg := range blocks {
+		var free []string
+		err := core.UpdateState(blkCfg, blkCfg.ID, func() error {
+			free = blkCfg.Free
+			blkCfg.Free = nil
+			return nil
+		})
+		if err != nil {
+			log.Errorf("Error updating address block %s. Err: %v", blkCfg.ID, err)
+			return err
+		}
+		if len(free) == 0 {
+			continue
+		}
+
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = stateDriver
+		if err := nwCfg.Read(blkCfg.NetworkID); err != nil {
+			log.Errorf("Error reading network %s. Err: %v", blkCfg.NetworkID, err)
+			return err
+		}
+		var epgCfg *mastercfg.EndpointGroupState
+		if blkCfg.EndpointGroup != "" {
+			epgCfg = &mastercfg.EndpointGroupState{}
+			epgCfg.StateDriver = stateDriver
+			epgCfg.ID = blkCfg.EndpointGroup
+		}
+
+		if err := unmarkAddrs(nwCfg, epgCfg, free); err != nil {
+			log.Errorf("Error freeing addresses %v of block %s. Err: %v", free, blkCfg.ID, err)
+			return err
+		}
+		log.Infof("Reclaimed %d addresses of block %s", len(free), blkCfg.ID)
+	}
+
+	return nil
+}
+
+// deleteAddrBlocks removes the matching address blocks, the addresses are
+// freed along with the network or epg they belong to
+func deleteAddrBlocks(stateDriver core.StateDriver, match func(*mastercfg.CfgAddrBlockState) bool) error {
+	blocks, err := readAddrBlocks(stateDriver, match)
+	if err != nil {
+		return err
+	}
+
+	for _, blkCfg := range blocks {
+		if err := blkCfg.Clear(); err != nil {
+			log.Errorf("Error deleting address block %s. Err: %v", blkCfg.ID, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseBlockAddress returns an address allocated from the block of a host
+// to the free addresses of the block. It returns false if the address is
+// not in an address block.
+func releaseBlockAddress(stateDriver core.StateDriver, networkID, ipAddress string) (bool, error) {
+	if stateDriver == nil || netutils.IsIPv6(ipAddress) {
+		return false, nil
+	}
+
+	blocks, err := readAddrBlocks(stateDriver, func(blkCfg *mastercfg.CfgAddrBlockState) bool {
+		return blkCfg.NetworkID == networkID &&
+			(findAddr(blkCfg.Allocated, ipAddress) >= 0 || findAddr(blkCfg.Free, ipAddress) >= 0)
+	})
+	if err != nil || len(blocks) == 0 {
+		return false, err
+	}
+
+	blkCfg := blocks[0]
+	err = core.UpdateState(blkCfg, blkCfg.ID, func() error {
+		// an address that is already free was released before
+		if idx := findAddr(blkCfg.Allocated, ipAddress); idx >= 0 {
+			blkCfg.Allocated = append(blkCfg.Allocated[:idx], blkCfg.Allocated[idx+1:]...)
+			blkCfg.Free = append(blkCfg.Free, ipAddress)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error updating address block %s. Err: %v", blkCfg.ID, err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// findAddr returns the index of an address in a list, or -1
+func findAddr(addrs []string, ipAddress string) int {
+	for idx, addr := range addrs {
+		if addr == ipAddress {
+			return idx
+		}
+	}
+	return -1
+}
+
+// freeBlockAddrs returns the free addresses of the blocks of a network, or
+// of an epg pool
+func freeBlockAddrs(nwCfg *mastercfg.CfgNetworkState, epgKey string) []string {
+	if nwCfg.StateDriver == nil {
+		return nil
+	}
+
+	blocks, err := readAddrBlocks(nwCfg.StateDriver, func(blkCfg *mastercfg.CfgAddrBlockState) bool {
+		return blkCfg.NetworkID == nwCfg.ID && blkCfg.EndpointGroup == epgKey
+	})
+	if err != nil {
+		return nil
+	}
+
+	free := []string{}
+	for _, blkCfg := range blocks {
+		free = append(free, blkCfg.Free...)
+	}
+	return free
+}
+
+// clearFreeBlockAddrs clears the free addresses of the blocks of a network,
+// or of an epg pool, in an alloc map
+func clearFreeBlockAddrs(nwCfg *mastercfg.CfgNetworkState, epgKey string, allocMap *bitset.BitSet) {
+	for _, ipAddress := range freeBlockAddrs(nwCfg, epgKey) {
+		ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddress)
+		if err == nil {
+			allocMap.Clear(ipAddrValue)
+		}
+	}
+}
+
+// NetworkAllocatedCount returns the number of addresses allocated in a
+// network, including the addresses allocated by hosts from their blocks
+func NetworkAllocatedCount(nwCfg *mastercfg.CfgNetworkState) int {
+	count := nwCfg.EpAddrCount
+	if nwCfg.StateDriver == nil {
+		return count
+	}
+
+	blocks, err := readAddrBlocks(nwCfg.StateDriver, func(blkCfg *mastercfg.CfgAddrBlockState) bool {
+		return blkCfg.NetworkID == nwCfg.ID
+	})
+	if err != nil {
+		return count
+	}
+	for _, blkCfg := range blocks {
+		count += len(blkCfg.Allocated)
+	}
+
+	return count
+}
+
+// AllocAddrBlockHandler adds addresses of a network or epg pool to the
+// address block of a host. When the network or pool is exhausted, the free
+// addresses of the blocks of other hosts are reclaimed first.
+func AllocAddrBlockHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	var blockReq AddrBlockRequest
+
+	// Get object from the request
+	err := json.NewDecoder(r.Body).Decode(&blockReq)
+	if err != nil {
+		log.Errorf("Error decoding AllocAddrBlockHandler. Err %v", err)
+		return nil, err
+	}
+
+	log.Infof("Received AddrBlockRequest: %+v", blockReq)
+
+	if blockReq.Host == "" {
+		return nil, core.Errorf("address block request without a host")
+	}
+
+	// Take a global lock for address allocation
+	addrMutex.Lock()
+	defer addrMutex.Unlock()
+
+	// Get hold of the state driver
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	nwCfg, epgCfg, err := addrBlockScope(stateDriver, blockReq.NetworkID)
+	if err != nil {
+		return nil, err
+	}
+	if nwCfg.SubnetIP == "" {
+		return nil, core.Errorf("network %s has no IPv4 subnet", nwCfg.ID)
+	}
+	if quarantineEnabled(nwCfg) {
+		return nil, core.Errorf("addresses of network %s are quarantined, they are not delegated to hosts", nwCfg.ID)
+	}
+
+	addrs, err := delegateAddrBlock(nwCfg, epgCfg, blockReq.Host, AddrBlockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		epgKey := addrBlockEPGKey(epgCfg)
+		err = reclaimAddrBlocks(stateDriver, func(blkCfg *mastercfg.CfgAddrBlockState) bool {
+			return blkCfg.Host != blockReq.Host && blkCfg.NetworkID == nwCfg.ID && blkCfg.EndpointGroup == epgKey
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// hand out small blocks once the addresses run short
+		addrs, err = delegateAddrBlock(nwCfg, epgCfg, blockReq.Host, AddrBlockLowWater)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(addrs) == 0 {
+		if epgCfg != nil {
+			return nil, core.Errorf("address exhaustion in pool %s", epgCfg.IPPool)
+		}
+		return nil, core.Errorf("address exhaustion in subnet %s/%d", nwCfg.SubnetIP, nwCfg.SubnetLen)
+	}
+
+	log.Infof("Delegated addresses %v of %s to host %s", addrs, blockReq.NetworkID, blockReq.Host)
+
+	return AddrBlockResponse{Addresses: addrs}, nil
+}
+
+// AllocBlockAddress allocates an address from the address block of a host,
+// without netmaster. It returns the address with the subnet length and the
+// number of free addresses left in the block. An empty address is returned
+// when the block has no free addresses, ErrAddrsNotDelegated when addresses
+// of the network are not delegated.
+func AllocBlockAddress(stateDriver core.StateDriver, host, allocID string) (string, int, error) {
+	nwCfg, epgCfg, err := addrBlockScope(stateDriver, allocID)
+	if err != nil {
+		return "", 0, err
+	}
+	if nwCfg.SubnetIP == "" || quarantineEnabled(nwCfg) {
+		return "", 0, ErrAddrsNotDelegated
+	}
+
+	var ipAddress string
+	free := 0
+	blkCfg := &mastercfg.CfgAddrBlockState{}
+	blkCfg.StateDriver = stateDriver
+	blockID := mastercfg.GetAddrBlockKey(host, nwCfg.ID, addrBlockEPGKey(epgCfg))
+	err = core.UpdateState(blkCfg, blockID, func() error {
+		if len(blkCfg.Free) == 0 {
+			return ErrAddrBlockEmpty
+		}
+		ipAddress = blkCfg.Free[0]
+		blkCfg.Free = blkCfg.Free[1:]
+		blkCfg.Allocated = append(blkCfg.Allocated, ipAddress)
+		free = len(blkCfg.Free)
+		return nil
+	})
+	if err == ErrAddrBlockEmpty || (err != nil && strings.Contains(err.Error(), "Key not found")) {
+		return "", 0, nil
+	}
+	if err != nil {
+		log.Errorf("Error allocating address from block %s. Err: %v", blockID, err)
+		return "", 0, err
+	}
+
+	return ipAddress + "/" + fmt.Sprintf("%d", nwCfg.SubnetLen), free, nil
+}
+
+// EndpointAllocID returns the id of the address allocations of an endpoint
+// request, nw:epg.tenant for an endpoint in an epg, as in AddressAllocRequest
+func EndpointAllocID(epReq *CreateEndpointRequest) string {
+	if epReq.ServiceName != "" {
+		return epReq.NetworkName + ":" + epReq.ServiceName + "." + epReq.TenantName
+	}
+	return epReq.NetworkName + "." + epReq.TenantName
+}
+
+// CreateBlockEndpoint creates the state of an endpoint on a host without
+// netmaster, with an address from the address block of the host. A
+// requested address must be allocated from the block of the host. It
+// returns ErrAddrsNotDelegated for endpoints that are created by netmaster,
+// in networks without delegated addresses, with IPv6 addresses or with
+// addresses reserved for workloads, and ErrAddrBlockEmpty when the block of
+// the host has no free addresses.
+func CreateBlockEndpoint(stateDriver core.StateDriver, host string,
+	epReq *CreateEndpointRequest) (*mastercfg.CfgEndpointState, error) {
+	ep := &epReq.ConfigEP
+	nwCfg, poolCfg, err := addrBlockScope(stateDriver, EndpointAllocID(epReq))
+	if err != nil {
+		return nil, err
+	}
+	if nwCfg.SubnetIP == "" || quarantineEnabled(nwCfg) || nwCfg.IPv6Subnet != "" ||
+		(ep.IPAddress == "" && len(nwCfg.ReservedIPs) > 0) {
+		return nil, ErrAddrsNotDelegated
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = stateDriver
+	epCfg.ID = getEpName(nwCfg.ID, ep)
+	if err := epCfg.Read(epCfg.ID); err == nil {
+		return epCfg, nil
+	}
+
+	epCfg.NetID = nwCfg.ID
+	epCfg.EndpointID = ep.Container
+	epCfg.HomingHost = ep.Host
+	epCfg.ServiceName = ep.ServiceName
+	epCfg.EPCommonName = epReq.EPCommonName
+
+	if ep.IPAddress != "" {
+		// the address was allocated from the block before, and is
+		// released by the allocator
+		blkCfg := &mastercfg.CfgAddrBlockState{}
+		blkCfg.StateDriver = stateDriver
+		err = blkCfg.Read(mastercfg.GetAddrBlockKey(host, nwCfg.ID, addrBlockEPGKey(poolCfg)))
+		if err != nil || findAddr(blkCfg.Allocated, ep.IPAddress) < 0 {
+			return nil, ErrAddrsNotDelegated
+		}
+		epCfg.IPAddress = ep.IPAddress
+	} else {
+		var ipAddress string
+		ipAddress, _, err = AllocBlockAddress(stateDriver, host, EndpointAllocID(epReq))
+		if err != nil {
+			return nil, err
+		}
+		if ipAddress == "" {
+			return nil, ErrAddrBlockEmpty
+		}
+		epCfg.IPAddress = strings.Split(ipAddress, "/")[0]
+
+		// cleanup relies on var err being used for all error checking
+		defer func() {
+			if err != nil {
+				log.Infof("Freeing %s on error", epCfg.IPAddress)
+				releaseBlockAddress(stateDriver, nwCfg.ID, epCfg.IPAddress)
+			}
+		}()
+	}
+	epCfg.MacAddress = epMacAddress(epCfg.IPAddress)
+
+	// Set endpoint group
+	// Skip for infra nw
+	if nwCfg.NwType != "infra" {
+		epCfg.EndpointGroupKey = mastercfg.GetEndpointGroupKey(ep.ServiceName, nwCfg.Tenant)
+		epCfg.EndpointGroupID, err = mastercfg.GetEndpointGroupID(stateDriver, ep.ServiceName, nwCfg.Tenant)
+		if err != nil {
+			log.Errorf("Error getting endpoint group ID for %s.%s. Err: %v", ep.ServiceName, nwCfg.ID, err)
+			return nil, err
+		}
+
+		if epCfg.EndpointGroupKey != "" {
+			epgCfg := &mastercfg.EndpointGroupState{}
+			epgCfg.StateDriver = stateDriver
+			err = core.UpdateState(epgCfg, epCfg.EndpointGroupKey, func() error {
+				epgCfg.EpCount++
+				return nil
+			})
+			if err != nil {
+				log.Errorf("Error saving epg state of %s. Err: %v", epCfg.EndpointGroupKey, err)
+				return nil, err
+			}
+		}
+	}
+
+	err = nwCfg.IncrEpCount()
+	if err != nil {
+		log.Errorf("Error incrementing ep count. Err: %v", err)
+		return nil, err
+	}
+
+	err = epCfg.Write()
+	if err != nil {
+		log.Errorf("error writing ep config. Error: %s", err)
+		return nil, err
+	}
+
+	log.Infof("Created endpoint %s with address %s of the block of host %s", epCfg.ID, epCfg.IPAddress, host)
+
+	return epCfg, nil
+}
+
+// AddrBlockFreeCount returns the number of free addresses in the address
+// block of a host
+func AddrBlockFreeCount(stateDriver core.StateDriver, host, allocID string) int {
+	nwCfg, epgCfg, err := addrBlockScope(stateDriver, allocID)
+	if err != nil {
+		return 0
+	}
+
+	blkCfg := &mastercfg.CfgAddrBlockState{}
+	blkCfg.StateDriver = stateDriver
+	if err := blkCfg.Read(mastercfg.GetAddrBlockKey(host, nwCfg.ID, addrBlockEPGKey(epgCfg))); err != nil {
+		return 0
+	}
+
+	return len(blkCfg.Free)
+}
+
+// ReleaseHostAddrBlocks returns the free addresses delegated to a host to
+// the network and epg allocators. Addresses the host allocated stay in its
+// blocks until they are released.
+func ReleaseHostAddrBlocks(stateDriver core.StateDriver, host string) error {
+	return reclaimAddrBlocks(stateDriver, func(blkCfg *mastercfg.CfgAddrBlockState) bool {
+		return blkCfg.Host == host
+	})
+}
@@ -30,11 +30,21 @@ import (
 	"github.com/contiv/netplugin/utils/netutils"
 )
 
+// WorkloadInfo identifies the container or pod an address is allocated
+// for. It is matched against the ip reservations of the network.
+type WorkloadInfo struct {
+	ContainerName string            // container name
+	PodName       string            // pod name
+	PodNamespace  string            // pod namespace
+	Labels        map[string]string // container or pod labels
+}
+
 // AddressAllocRequest is the address request from netplugin
 type AddressAllocRequest struct {
-	NetworkID            string // Unique identifier for the network
-	AddressPool          string // Address pool from which to allocate the address
-	PreferredIPv4Address string // Preferred address
+	NetworkID            string       // Unique identifier for the network
+	AddressPool          string       // Address pool from which to allocate the address
+	PreferredIPv4Address string       // Preferred address
+	Workload             WorkloadInfo // Workload the address is allocated for
 }
 
 // AddressAllocResponse is the response from netmaster
@@ -51,12 +61,14 @@ type AddressReleaseRequest struct {
 
 // CreateEndpointRequest has the endpoint create request from netplugin
 type CreateEndpointRequest struct {
-	TenantName   string          // tenant name
-	NetworkName  string          // network name
-	ServiceName  string          // service name
-	EndpointID   string          // Unique identifier for the endpoint
-	EPCommonName string          // Common name for the endpoint
-	ConfigEP     intent.ConfigEP // Endpoint configuration
+	TenantName     string          // tenant name
+	NetworkName    string          // network name
+	ServiceName    string          // service name
+	EndpointID     string          // Unique identifier for the endpoint
+	EPCommonName   string          // Common name for the endpoint
+	ConfigEP       intent.ConfigEP // Endpoint configuration
+	Workload       WorkloadInfo    // Workload the endpoint is created for
+	WorkloadAtJoin bool            // Workload is only known when the endpoint joins it
 }
 
 // CreateEndpointResponse has the endpoint create response from netmaster
@@ -73,6 +85,15 @@ type DeleteEndpointRequest struct {
 	IPv4Address string // Allocated IPv4 address for the endpoint
 }
 
+// JoinEndpointRequest identifies the workload of an endpoint created
+// without knowing its workload
+type JoinEndpointRequest struct {
+	TenantName  string       // tenant name
+	NetworkName string       // network name
+	EndpointID  string       // Unique identifier for the endpoint
+	Workload    WorkloadInfo // Workload the endpoint joins
+}
+
 //UpdateEndpointRequest has the update endpoint request from netplugin
 type UpdateEndpointRequest struct {
 	IPAddress    string            // provider IP
@@ -90,6 +111,20 @@ type UpdateEndpointResponse struct {
 	IPAddress string // provider IP
 }
 
+// ProviderHealthRequest is a provider health change from the netplugin
+// checking the provider
+type ProviderHealthRequest struct {
+	ServiceName string // service name
+	Tenant      string // tenant of the service
+	IPAddress   string // provider IP
+	Healthy     bool   // provider passed its health check
+}
+
+// ProviderHealthResponse is the response to a provider health change
+type ProviderHealthResponse struct {
+	Healthy bool // provider health in the service
+}
+
 // DeleteEndpointResponse is the delete endpoint response from netmaster
 type DeleteEndpointResponse struct {
 	EndpointConfig mastercfg.CfgEndpointState // Endpoint config
@@ -203,10 +238,21 @@ func AllocAddressHandler(w http.ResponseWriter, r *http.Request, vars map[string
 		return nil, err
 	}
 
-	// Alloc addresses
-	addr, err := networkAllocAddress(nwCfg, epgCfg, allocReq.PreferredIPv4Address, netutils.IsIPv6(allocReq.AddressPool))
+	// Alloc addresses, the address reserved for the workload is preferred
+	reqAddr := allocReq.PreferredIPv4Address
+	if reqAddr == "" {
+		reqAddr, err = claimReservedAddress(stateDriver, nwCfg, &allocReq.Workload, isIPv6)
+		if err != nil {
+			log.Errorf("Failed to allocate reserved address. Err: %v", err)
+			return nil, err
+		}
+	}
+	addr, err := networkAllocAddress(nwCfg, epgCfg, reqAddr, isIPv6)
 	if err != nil {
 		log.Errorf("Failed to allocate address. Err: %v", err)
+		if reqAddr != allocReq.PreferredIPv4Address {
+			networkReleaseAddress(nwCfg, epgCfg, reqAddr)
+		}
 		return nil, err
 	}
 
@@ -462,34 +508,26 @@ func UpdateEndpointHandler(w http.ResponseWriter, r *http.Request, vars map[stri
 		mastercfg.ProviderDb[providerDbID] = provider
 
 		for serviceID, service := range mastercfg.ServiceLBDb {
-			count := 0
-			if service.Tenant == epUpdReq.Tenant {
-				for key, value := range epUpdReq.Labels {
-					if val := service.Selectors[key]; val == value {
-						count++
-					}
-
-					if count == len(service.Selectors) {
-						//Container corresponds to the service since it
-						//matches all service Selectors
-						mastercfg.ProviderDb[providerDbID].Services =
-							append(mastercfg.ProviderDb[providerDbID].Services, serviceID)
-							//Update ServiceDB
-						mastercfg.ServiceLBDb[serviceID].Providers[providerID] = provider
-
-						serviceLbState := &mastercfg.CfgServiceLBState{}
-						serviceLbState.StateDriver = stateDriver
-						err = serviceLbState.Read(serviceID)
-						if err != nil {
-							mastercfg.SvcMutex.Unlock()
-							return nil, err
-						}
-						serviceLbState.Providers[providerID] = provider
-						serviceLbState.Write()
-						SvcProviderUpdate(serviceID, false)
-						break
-					}
+			if providerMatches(provider, service) {
+				//Container corresponds to the service since it
+				//matches all service Selectors
+				mastercfg.ProviderDb[providerDbID].Services =
+					append(mastercfg.ProviderDb[providerDbID].Services, serviceID)
+				//Update ServiceDB, a started provider is healthy until checked
+				mastercfg.ServiceLBDb[serviceID].Providers[providerID] = provider
+				delete(mastercfg.ServiceLBDb[serviceID].Unhealthy, providerID)
+
+				serviceLbState := &mastercfg.CfgServiceLBState{}
+				serviceLbState.StateDriver = stateDriver
+				err = serviceLbState.Read(serviceID)
+				if err != nil {
+					mastercfg.SvcMutex.Unlock()
+					return nil, err
 				}
+				serviceLbState.Providers[providerID] = provider
+				serviceLbState.Unhealthy = service.Unhealthy
+				serviceLbState.Write()
+				SvcProviderUpdate(serviceID, false)
 			}
 		}
 		mastercfg.SvcMutex.Unlock()
@@ -520,6 +558,7 @@ func UpdateEndpointHandler(w http.ResponseWriter, r *http.Request, vars map[stri
 			}
 			if service.Providers[providerID] != nil {
 				delete(service.Providers, providerID)
+				delete(service.Unhealthy, providerID)
 
 				serviceLbState := &mastercfg.CfgServiceLBState{}
 				serviceLbState.StateDriver = stateDriver
@@ -529,6 +568,7 @@ func UpdateEndpointHandler(w http.ResponseWriter, r *http.Request, vars map[stri
 					return nil, err
 				}
 				delete(serviceLbState.Providers, providerID)
+				serviceLbState.Unhealthy = service.Unhealthy
 				serviceLbState.Write()
 				delete(mastercfg.ProviderDb, providerDbID)
 				SvcProviderUpdate(serviceID, false)
@@ -544,3 +584,32 @@ func UpdateEndpointHandler(w http.ResponseWriter, r *http.Request, vars map[stri
 	}
 	return epUpdResp, nil
 }
+
+// UpdateProviderHealthHandler handles provider health changes from netplugin
+func UpdateProviderHealthHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	var healthReq ProviderHealthRequest
+
+	// Get object from the request
+	err := json.NewDecoder(r.Body).Decode(&healthReq)
+	if err != nil {
+		log.Errorf("Error decoding ProviderHealthRequest. Err %v", err)
+		return nil, err
+	}
+
+	log.Infof("Received ProviderHealthRequest {%+v}", healthReq)
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	serviceID := GetServiceID(healthReq.ServiceName, healthReq.Tenant)
+	providerID := getProviderID(&mastercfg.Provider{IPAddress: healthReq.IPAddress, Tenant: healthReq.Tenant})
+	err = SetProviderHealth(stateDriver, serviceID, providerID, healthReq.Healthy)
+	if err != nil {
+		log.Errorf("Error updating health of provider %s of service %s. Err: %v", providerID, serviceID, err)
+		return nil, err
+	}
+
+	return &ProviderHealthResponse{Healthy: healthReq.Healthy}, nil
+}
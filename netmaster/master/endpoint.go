@@ -69,17 +69,14 @@ func allocSetEpAddress(ep *intent.ConfigEP, epCfg *mastercfg.CfgEndpointState,
 
 	epCfg.IPAddress = ipAddress
 
-	// Set mac address which is derived from IP address
-	ipAddr := net.ParseIP(ipAddress)
-	macAddr := fmt.Sprintf("02:02:%02x:%02x:%02x:%02x", ipAddr[12], ipAddr[13], ipAddr[14], ipAddr[15])
-
-	epCfg.MacAddress = macAddr
+	epCfg.MacAddress = epMacAddress(ipAddress)
 
 	if nwCfg.IPv6Subnet != "" {
 		var ipv6Address string
-		ipv6Address, err = networkAllocAddress(nwCfg, nil, ep.IPv6Address, true)
+		ipv6Address, err = networkAllocAddress(nwCfg, epgCfg, ep.IPv6Address, true)
 		if err != nil {
 			log.Errorf("Error allocating IP address. Err: %v", err)
+			networkReleaseAddress(nwCfg, epgCfg, ipAddress)
 			return
 		}
 		epCfg.IPv6Address = ipv6Address
@@ -88,12 +85,22 @@ func allocSetEpAddress(ep *intent.ConfigEP, epCfg *mastercfg.CfgEndpointState,
 	return
 }
 
+// epMacAddress returns the mac address of an endpoint, which is derived
+// from its IP address
+func epMacAddress(ipAddress string) string {
+	ipAddr := net.ParseIP(ipAddress)
+	return fmt.Sprintf("02:02:%02x:%02x:%02x:%02x", ipAddr[12], ipAddr[13], ipAddr[14], ipAddr[15])
+}
+
 // freeAddrOnErr deferred function that cleans up on error
 func freeAddrOnErr(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState,
-	ipAddress string, pErr *error) {
+	epCfg *mastercfg.CfgEndpointState, pErr *error) {
 	if *pErr != nil {
-		log.Infof("Freeing %s on error", ipAddress)
-		networkReleaseAddress(nwCfg, epgCfg, ipAddress)
+		log.Infof("Freeing %s on error", epCfg.IPAddress)
+		networkReleaseAddress(nwCfg, epgCfg, epCfg.IPAddress)
+		if epCfg.IPv6Address != "" {
+			networkReleaseAddress(nwCfg, epgCfg, epCfg.IPv6Address)
+		}
 	}
 }
 
@@ -128,15 +135,22 @@ func CreateEndpoint(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkSta
 		}
 	}
 
-	// Allocate addresses
+	// Allocate addresses, the addresses reserved for the workload are
+	// preferred
+	claimed, err := claimEndpointAddresses(stateDriver, nwCfg, ep, epReq)
+	if err != nil {
+		log.Errorf("error allocating reserved IP. Error: %s", err)
+		return nil, err
+	}
 	err = allocSetEpAddress(ep, epCfg, nwCfg, epgCfg)
 	if err != nil {
 		log.Errorf("error allocating and/or reserving IP. Error: %s", err)
+		releaseClaimedAddresses(nwCfg, claimed)
 		return nil, err
 	}
 
 	// cleanup relies on var err being used for all error checking
-	defer freeAddrOnErr(nwCfg, epgCfg, epCfg.IPAddress, &err)
+	defer freeAddrOnErr(nwCfg, epgCfg, epCfg, &err)
 
 	// Set endpoint group
 	// Skip for infra nw
@@ -157,9 +171,10 @@ func CreateEndpoint(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkSta
 				return nil, err
 			}
 
-			epgCfg.EpCount++
-
-			err = epgCfg.Write()
+			err = core.UpdateState(epgCfg, epgCfg.ID, func() error {
+				epgCfg.EpCount++
+				return nil
+			})
 			if err != nil {
 				log.Errorf("Error saving epg state: %+v", epgCfg)
 				return nil, err
@@ -182,6 +197,36 @@ func CreateEndpoint(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkSta
 	return epCfg, nil
 }
 
+// JoinEndpoint checks the addresses of an endpoint created without knowing
+// its workload against the ip reservations of the workload. It only reads
+// the state, so it is also called by netplugin.
+func JoinEndpoint(stateDriver core.StateDriver, joinReq *JoinEndpointRequest) (*mastercfg.CfgEndpointState, error) {
+	netID := joinReq.NetworkName + "." + joinReq.TenantName
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	err := nwCfg.Read(netID)
+	if err != nil {
+		log.Errorf("network %s is not operational", netID)
+		return nil, err
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = stateDriver
+	err = epCfg.Read(getEpName(netID, &intent.ConfigEP{Container: joinReq.EndpointID}))
+	if err != nil {
+		log.Errorf("Error reading endpoint %s. Err: %v", joinReq.EndpointID, err)
+		return nil, err
+	}
+
+	err = checkJoinReservations(stateDriver, nwCfg, epCfg, &joinReq.Workload)
+	if err != nil {
+		log.Errorf("Endpoint %s can not join workload %+v. Err: %v", epCfg.ID, joinReq.Workload, err)
+		return nil, err
+	}
+
+	return epCfg, nil
+}
+
 // CreateEndpoints creates the endpoints for a given tenant.
 func CreateEndpoints(stateDriver core.StateDriver, tenant *intent.ConfigTenant) error {
 	err := validateEndpointConfig(stateDriver, tenant)
@@ -209,12 +254,6 @@ func CreateEndpoints(stateDriver core.StateDriver, tenant *intent.ConfigTenant)
 				return err
 			}
 		}
-
-		err = nwCfg.Write()
-		if err != nil {
-			log.Errorf("error writing nw config. Error: %s", err)
-			return err
-		}
 	}
 
 	return err
@@ -252,29 +291,28 @@ func DeleteEndpointID(stateDriver core.StateDriver, epID string) (*mastercfg.Cfg
 		if err != nil {
 			log.Errorf("Error releasing endpoint state for: %s. Err: %v", epCfg.IPAddress, err)
 		}
+		if epCfg.IPv6Address != "" {
+			err = networkReleaseAddress(nwCfg, epgCfg, epCfg.IPv6Address)
+			if err != nil {
+				log.Errorf("Error releasing endpoint state for: %s. Err: %v", epCfg.IPv6Address, err)
+			}
+		}
 
 		if epCfg.EndpointGroupKey != "" {
 			epgCfg := &mastercfg.EndpointGroupState{}
 			epgCfg.StateDriver = stateDriver
-			err = epgCfg.Read(epCfg.EndpointGroupKey)
-			if err != nil {
-				log.Errorf("Error reading EPG for endpoint: %+v", epCfg)
-			}
-
-			epgCfg.EpCount--
-
 			// write updated epg state
-			err = epgCfg.Write()
+			err = core.UpdateState(epgCfg, epCfg.EndpointGroupKey, func() error {
+				epgCfg.EpCount--
+				return nil
+			})
 			if err != nil {
 				log.Errorf("error writing epg config. Error: %s", err)
 			}
 		}
 
 		// decrement ep count
-		nwCfg.EpCount--
-
-		// write modified nw state
-		err = nwCfg.Write()
+		err = nwCfg.DecrEpCount()
 		if err != nil {
 			log.Errorf("error writing nw config. Error: %s", err)
 		}
@@ -28,7 +28,6 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/contiv/netplugin/utils/netutils"
-	"strings"
 )
 
 const maxEpgID = 65535
@@ -36,8 +35,90 @@ const maxEpgID = 65535
 // FIXME: hack to allocate unique endpoint group ids
 var globalEpgID = 1
 
+// validateEpgPool checks that the ranges of an epg ip pool are within the
+// network and free. Ranges of the current ip pool of the epg are free.
+func validateEpgPool(nwCfg *mastercfg.CfgNetworkState, oldIPPool, ipPool string) error {
+	if len(ipPool) == 0 {
+		return nil
+	}
+
+	if netutils.IsIPv6(ipPool) == true {
+		return fmt.Errorf("ipv6 address pool is not supported for Endpoint Groups")
+	}
+
+	for _, pool := range netutils.GetIPPools(ipPool) {
+		if err := netutils.ValidateNetworkRangeParams(pool, nwCfg.SubnetLen); err != nil {
+			return fmt.Errorf("invalid ip-pool %s", pool)
+		}
+
+		if _, _, err := netutils.GetIPPoolHostRange(pool, nwCfg.SubnetIP, nwCfg.SubnetLen); err != nil {
+			return fmt.Errorf("bad ip-pool %s, EPG ip-pool must be a subset of network %s/%d", pool, nwCfg.SubnetIP,
+				nwCfg.SubnetLen)
+		}
+	}
+
+	_, err := reservedPoolMap(nwCfg, oldIPPool, ipPool)
+	return err
+}
+
+// validateEpgIPv6Pool checks that an epg ipv6 pool is within the ipv6
+// subnet of the network and free
+func validateEpgIPv6Pool(nwCfg *mastercfg.CfgNetworkState, ipv6Pool string) error {
+	if len(ipv6Pool) == 0 {
+		return nil
+	}
+
+	if nwCfg.IPv6Subnet == "" {
+		return fmt.Errorf("ipv6-pool %s requires an ipv6 subnet in network %s", ipv6Pool, nwCfg.NetworkName)
+	}
+
+	if _, _, err := netutils.ParseIPv6Range(ipv6Pool); err != nil {
+		return fmt.Errorf("invalid ipv6-pool %s", ipv6Pool)
+	}
+
+	if !netutils.IsIPv6RangeContained(ipv6Pool, nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen) {
+		return fmt.Errorf("bad ipv6-pool %s, EPG ipv6-pool must be a subset of network %s/%d", ipv6Pool,
+			nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen)
+	}
+
+	if err := initIPv6Alloc(nwCfg); err != nil {
+		return err
+	}
+	if !nwCfg.IPv6Alloc.IsRangeFree(ipv6Pool) {
+		return fmt.Errorf("ipv6-pool %s overlaps with addresses in use in network %s", ipv6Pool, nwCfg.NetworkName)
+	}
+
+	return nil
+}
+
+// PreviewEndpointGroup runs the validation of CreateEndpointGroup without
+// creating the endpoint group
+func PreviewEndpointGroup(tenantName, networkName, ipPool, ipv6Pool string) error {
+	// Get the state driver
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return err
+	}
+
+	// read the network config
+	networkID := networkName + "." + tenantName
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	err = nwCfg.Read(networkID)
+	if err != nil {
+		log.Errorf("Could not find network %s. Err: %v", networkID, err)
+		return err
+	}
+
+	if err := validateEpgPool(nwCfg, "", ipPool); err != nil {
+		return err
+	}
+
+	return validateEpgIPv6Pool(nwCfg, ipv6Pool)
+}
+
 // CreateEndpointGroup handles creation of endpoint group
-func CreateEndpointGroup(tenantName, networkName, ipPool, groupName string) error {
+func CreateEndpointGroup(tenantName, networkName, ipPool, ipv6Pool, groupName string) (err error) {
 	var epgID int
 
 	// Get the state driver
@@ -68,32 +149,15 @@ func CreateEndpointGroup(tenantName, networkName, ipPool, groupName string) erro
 	}
 
 	// check epg range is with in network
-	if len(ipPool) > 0 {
-		if netutils.IsIPv6(ipPool) == true {
-			return fmt.Errorf("ipv6 address pool is not supported for Endpoint Groups")
-		}
-
-		if err = netutils.ValidateNetworkRangeParams(ipPool, nwCfg.SubnetLen); err != nil {
-			return fmt.Errorf("invalid ip-pool %s", ipPool)
-		}
-
-		addrRangeList := strings.Split(ipPool, "-")
-		if _, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, addrRangeList[0]); err != nil {
-			return fmt.Errorf("bad ip-pool %s, EPG ip-pool must be a subset of network %s/%d", ipPool, nwCfg.SubnetIP,
-				nwCfg.SubnetLen)
-		}
-		if _, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, addrRangeList[1]); err != nil {
-			return fmt.Errorf("bad ip-pool %s, EPG ip-pool must be a subset of network %s/%d", ipPool, nwCfg.SubnetIP,
-				nwCfg.SubnetLen)
-		}
-
-		if err := netutils.TestIPAddrRange(&nwCfg.IPAllocMap, ipPool, nwCfg.SubnetIP,
-			nwCfg.SubnetLen); err != nil {
-			return err
-		}
+	if err = validateEpgPool(nwCfg, "", ipPool); err != nil {
+		return err
+	}
+	if err = validateEpgIPv6Pool(nwCfg, ipv6Pool); err != nil {
+		return err
 	}
 
 	// params for docker network
+	dockNetCreated := false
 	if GetClusterMode() == "docker" {
 		// Create each EPG as a docker network
 		err = docknet.CreateDockNet(tenantName, networkName, groupName, nwCfg)
@@ -101,6 +165,7 @@ func CreateEndpointGroup(tenantName, networkName, ipPool, groupName string) erro
 			log.Errorf("Error creating docker network for group %s.%s. Err: %v", networkName, groupName, err)
 			return err
 		}
+		dockNetCreated = true
 	}
 	// assign unique endpoint group ids
 	// FIXME: This is a hack. need to add a epgID resource
@@ -124,6 +189,7 @@ func CreateEndpointGroup(tenantName, networkName, ipPool, groupName string) erro
 		TenantName:      tenantName,
 		NetworkName:     networkName,
 		IPPool:          ipPool,
+		IPv6Pool:        ipv6Pool,
 		EndpointGroupID: epgID,
 		PktTagType:      nwCfg.PktTagType,
 		PktTag:          nwCfg.PktTag,
@@ -134,6 +200,32 @@ func CreateEndpointGroup(tenantName, networkName, ipPool, groupName string) erro
 	epgCfg.ID = mastercfg.GetEndpointGroupKey(groupName, tenantName)
 	log.Debugf("##Create EpGroup %v network %v tagtype %v", groupName, networkName, nwCfg.PktTagType)
 
+	// release what was allocated for the group if it can't be created
+	vlanAllocated, poolsReserved := false, false
+	defer func() {
+		if err == nil {
+			return
+		}
+		if poolsReserved {
+			rErr := core.UpdateState(nwCfg, nwCfg.ID, func() error {
+				return releaseEPGPools(nwCfg, ipPool, ipv6Pool)
+			})
+			if rErr != nil {
+				log.Errorf("error releasing ip pools of epg %s. Error: %v", groupName, rErr)
+			}
+		}
+		if vlanAllocated {
+			if rErr := gCfg.FreeVLAN(uint(epgCfg.PktTag)); rErr != nil {
+				log.Errorf("error freeing vlan %d of epg %s. Error: %v", epgCfg.PktTag, groupName, rErr)
+			}
+		}
+		if dockNetCreated {
+			if rErr := docknet.DeleteDockNet(tenantName, networkName, groupName); rErr != nil {
+				log.Errorf("Error deleting docker network for group %s.%s. Err: %v", networkName, groupName, rErr)
+			}
+		}
+	}()
+
 	// if aci mode allocate per-epg vlan. otherwise, stick to per-network vlan
 	aciMode, rErr := IsAciConfigured()
 	if rErr != nil {
@@ -147,28 +239,71 @@ func CreateEndpointGroup(tenantName, networkName, ipPool, groupName string) erro
 			return errors.New("Network type must be VLAN for ACI mode")
 		}
 
-		pktTag, err := gCfg.AllocVLAN(0)
+		allowed, err := tenantAllowedTags(stateDriver, &gCfg, tenantName, "vlan")
+		if err != nil {
+			return err
+		}
+		pktTag, err := gCfg.AllocVLANIn(0, allowed)
 		if err != nil {
 			return err
 		}
 		epgCfg.PktTag = int(pktTag)
+		vlanAllocated = true
 		log.Debugf("ACI -- Allocated vlan %v for epg %v", pktTag, groupName)
 
 	}
 
 	if len(ipPool) > 0 {
-		// mark range as used
-		netutils.SetIPAddrRange(&nwCfg.IPAllocMap, ipPool, nwCfg.SubnetIP, nwCfg.SubnetLen)
+		if err := initPoolAllocMap(nwCfg, &epgCfg.EPGIPAllocMap, ipPool); err != nil {
+			return err
+		}
+	}
+	if len(ipv6Pool) > 0 {
+		if err := epgCfg.EPGIPv6Alloc.InitRange(ipv6Pool); err != nil {
+			return err
+		}
+	}
 
-		if err := nwCfg.Write(); err != nil {
-			return fmt.Errorf("updating epg ipaddress in network failed: %s", err)
+	if len(ipPool) > 0 || len(ipv6Pool) > 0 {
+		// reserve the pools in the network, the ipv6 pool is checked first
+		// so that no range is marked when it is in use
+		err = core.UpdateState(nwCfg, nwCfg.ID, func() error {
+			if len(ipv6Pool) > 0 {
+				if err := initIPv6Alloc(nwCfg); err != nil {
+					return err
+				}
+				if !nwCfg.IPv6Alloc.IsRangeFree(ipv6Pool) {
+					return core.Errorf("ipv6-pool %s overlaps with addresses in use", ipv6Pool)
+				}
+				if err := nwCfg.IPv6Alloc.ReserveRange(ipv6Pool); err != nil {
+					return err
+				}
+			}
+			return setPoolRanges(nwCfg, &nwCfg.IPAllocMap, ipPool, true)
+		})
+		if err != nil {
+			return fmt.Errorf("updating epg address pools in network failed: %s", err)
 		}
-		netutils.InitSubnetBitset(&epgCfg.EPGIPAllocMap, nwCfg.SubnetLen)
-		netutils.SetBitsOutsideRange(&epgCfg.EPGIPAllocMap, ipPool, nwCfg.SubnetLen)
+		poolsReserved = true
 	}
+
 	return epgCfg.Write()
 }
 
+// releaseEPGPools releases the ip pools of an endpoint group in a network
+func releaseEPGPools(nwCfg *mastercfg.CfgNetworkState, ipPool, ipv6Pool string) error {
+	if err := setPoolRanges(nwCfg, &nwCfg.IPAllocMap, ipPool, false); err != nil {
+		return err
+	}
+	if len(ipv6Pool) == 0 {
+		return nil
+	}
+	if err := initIPv6Alloc(nwCfg); err != nil {
+		return err
+	}
+	return nwCfg.IPv6Alloc.ReleaseRange(ipv6Pool)
+}
+
 // DeleteEndpointGroup handles endpoint group deletes
 func DeleteEndpointGroup(tenantName, groupName string) error {
 	// Get the state driver
@@ -228,12 +363,26 @@ func DeleteEndpointGroup(tenantName, groupName string) error {
 
 	// mark it as unused
 	if len(epgCfg.IPPool) > 0 {
-		netutils.ClearIPAddrRange(&nwCfg.IPAllocMap, epgCfg.IPPool, nwCfg.SubnetIP, nwCfg.SubnetLen)
-		if err = nwCfg.Write(); err != nil {
+		err = core.UpdateState(nwCfg, nwCfg.ID, func() error {
+			return setPoolRanges(nwCfg, &nwCfg.IPAllocMap, epgCfg.IPPool, false)
+		})
+		if err != nil {
 			log.Errorf("error writing nw config after releasing subnet. Error: %v", err)
 			return err
 		}
 	}
+	if len(epgCfg.IPv6Pool) > 0 {
+		err = core.UpdateState(nwCfg, nwCfg.ID, func() error {
+			if err := initIPv6Alloc(nwCfg); err != nil {
+				return err
+			}
+			return nwCfg.IPv6Alloc.ReleaseRange(epgCfg.IPv6Pool)
+		})
+		if err != nil {
+			log.Errorf("error writing nw config after releasing ipv6 pool. Error: %v", err)
+			return err
+		}
+	}
 
 	// Delete endpoint group
 	err = epgCfg.Clear()
@@ -241,6 +390,12 @@ func DeleteEndpointGroup(tenantName, groupName string) error {
 		log.Errorf("error writing epGroup config. Error: %v", err)
 		return err
 	}
+	err = deleteAddrBlocks(stateDriver, func(blkCfg *mastercfg.CfgAddrBlockState) bool {
+		return blkCfg.EndpointGroup == epgKey
+	})
+	if err != nil {
+		return err
+	}
 
 	if GetClusterMode() == "docker" {
 		return docknet.DeleteDockNet(epgCfg.TenantName, epgCfg.NetworkName, epgCfg.GroupName)
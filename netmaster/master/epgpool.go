@@ -0,0 +1,228 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+	"github.com/contiv/netplugin/utils/netutils"
+	"github.com/jainvipin/bitset"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The ip pool of an epg is an ordered, comma separated list of address
+// ranges, e.g. 10.1.1.10-10.1.1.20,10.1.1.100-10.1.1.120. The ranges are
+// reserved in the network allocator and the epg allocator has all addresses
+// outside the ranges marked. Addresses are allocated from the first range
+// with free addresses. Ranges can be added and removed while the epg has
+// endpoints, as long as no address of a removed range is in use.
+
+// updateEPGPool changes the ip pool of an epg. The addresses allocated in the
+// epg must be within the new pool, quarantined addresses of removed ranges
+// are dropped.
+func updateEPGPool(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState, ipPool string) error {
+	usedMap := unquarantinedMap(nwCfg, epgCfg.Quarantine, &epgCfg.EPGIPAllocMap)
+	if err := netutils.ClearBitsOutsidePools(usedMap, epgCfg.IPPool, nwCfg.SubnetIP, nwCfg.SubnetLen); err != nil {
+		return err
+	}
+
+	allocMap := &bitset.BitSet{}
+	if err := initPoolAllocMap(nwCfg, allocMap, ipPool); err != nil {
+		return err
+	}
+
+	maxHosts := uint(1 << (32 - nwCfg.SubnetLen))
+	for idx, found := usedMap.NextSet(0); found && idx < maxHosts; idx, found = usedMap.NextSet(idx + 1) {
+		if allocMap.Test(idx) {
+			ipAddress, _ := netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, idx)
+			return core.Errorf("address %s of epg %s is in use and not in ip pool %s",
+				ipAddress, epgCfg.GroupName, ipPool)
+		}
+		allocMap.Set(idx)
+	}
+
+	quarantine := []mastercfg.QuarantinedAddr{}
+	for _, addr := range epgCfg.Quarantine {
+		if netutils.IsIPv6(addr.IPAddress) {
+			quarantine = append(quarantine, addr)
+			continue
+		}
+
+		idx, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, addr.IPAddress)
+		if err != nil || allocMap.Test(idx) {
+			continue
+		}
+		allocMap.Set(idx)
+		quarantine = append(quarantine, addr)
+	}
+	if len(quarantine) == 0 {
+		quarantine = nil
+	}
+
+	epgCfg.EPGIPAllocMap = *allocMap
+	epgCfg.IPPool = ipPool
+	epgCfg.Quarantine = quarantine
+
+	return nil
+}
+
+// readEPGPoolState reads the epg and network state for an ip pool update
+func readEPGPoolState(tenantName, groupName string) (*mastercfg.EndpointGroupState, *mastercfg.CfgNetworkState, error) {
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	epgCfg := &mastercfg.EndpointGroupState{}
+	epgCfg.StateDriver = stateDriver
+	epgKey := mastercfg.GetEndpointGroupKey(groupName, tenantName)
+	if err := epgCfg.Read(epgKey); err != nil {
+		log.Errorf("error reading EPG key %s. Error: %s", epgKey, err)
+		return nil, nil, err
+	}
+
+	networkID := epgCfg.NetworkName + "." + epgCfg.TenantName
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		log.Errorf("Could not find network %s. Err: %v", networkID, err)
+		return nil, nil, err
+	}
+
+	return epgCfg, nwCfg, nil
+}
+
+// PreviewEndpointGroupPool runs the validation of UpdateEndpointGroupPool
+// without changing the ip pool
+func PreviewEndpointGroupPool(tenantName, groupName, ipPool string) error {
+	epgCfg, nwCfg, err := readEPGPoolState(tenantName, groupName)
+	if err != nil {
+		return err
+	}
+
+	if err := validateEpgPool(nwCfg, epgCfg.IPPool, ipPool); err != nil {
+		return err
+	}
+
+	return updateEPGPool(nwCfg, epgCfg, ipPool)
+}
+
+// UpdateEndpointGroupPool changes the ip pool of an epg. Ranges can be added
+// and removed while the epg has endpoints, a range can only be removed if
+// none of its addresses is in use. The new ranges are reserved in the
+// network before the epg allocates from them, and the removed ranges are
+// released once the epg no longer does.
+func UpdateEndpointGroupPool(tenantName, groupName, ipPool string) error {
+	// Take a global lock for address allocation
+	addrMutex.Lock()
+	defer addrMutex.Unlock()
+
+	epgCfg, nwCfg, err := readEPGPoolState(tenantName, groupName)
+	if err != nil {
+		return err
+	}
+	if epgCfg.IPPool == ipPool {
+		return nil
+	}
+
+	if err := validateEpgPool(nwCfg, epgCfg.IPPool, ipPool); err != nil {
+		return err
+	}
+
+	// reserve the new ranges, the old ones stay reserved
+	oldIPPool := epgCfg.IPPool
+	err = core.UpdateState(nwCfg, nwCfg.ID, func() error {
+		allocMap, err := reservedPoolMap(nwCfg, oldIPPool, ipPool)
+		if err != nil {
+			return err
+		}
+		if err := setPoolRanges(nwCfg, allocMap, oldIPPool, true); err != nil {
+			return err
+		}
+		nwCfg.IPAllocMap = *allocMap
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error reserving ip pool %s of epg %s in network %s. Err: %v",
+			ipPool, epgCfg.ID, nwCfg.ID, err)
+		return err
+	}
+
+	err = core.UpdateState(epgCfg, epgCfg.ID, func() error {
+		if epgCfg.IPPool != oldIPPool {
+			return core.Errorf("ip pool of epg %s was changed concurrently", groupName)
+		}
+		return updateEPGPool(nwCfg, epgCfg, ipPool)
+	})
+	if err != nil {
+		log.Errorf("Error updating ip pool of epg %s. Err: %v", epgCfg.ID, err)
+
+		// release the new ranges, the epg did not allocate from them
+		rbErr := core.UpdateState(nwCfg, nwCfg.ID, func() error {
+			return movePoolRanges(nwCfg, ipPool, oldIPPool)
+		})
+		if rbErr != nil {
+			log.Errorf("Error releasing ip pool %s of epg %s in network %s. Err: %v",
+				ipPool, epgCfg.ID, nwCfg.ID, rbErr)
+		}
+		return err
+	}
+
+	// release the removed ranges, they stay reserved if this fails
+	err = core.UpdateState(nwCfg, nwCfg.ID, func() error {
+		return movePoolRanges(nwCfg, oldIPPool, ipPool)
+	})
+	if err != nil {
+		log.Errorf("Error releasing ip pool %s of epg %s in network %s. Err: %v",
+			oldIPPool, epgCfg.ID, nwCfg.ID, err)
+	}
+
+	log.Infof("Changed ip pool of epg %s from %q to %q", epgCfg.ID, oldIPPool, ipPool)
+	return nil
+}
+
+// ListEPGPoolUsage returns the number of allocated and available addresses
+// of each range of an epg ip pool
+func ListEPGPoolUsage(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState) []string {
+	usedMap := unquarantinedMap(nwCfg, epgCfg.Quarantine, &epgCfg.EPGIPAllocMap)
+	_, availMap := activeQuarantine(nwCfg, epgCfg.Quarantine, &epgCfg.EPGIPAllocMap)
+
+	usage := []string{}
+	for _, pool := range netutils.GetIPPools(epgCfg.IPPool) {
+		hostMin, hostMax, err := netutils.GetIPPoolHostRange(pool, nwCfg.SubnetIP, nwCfg.SubnetLen)
+		if err != nil {
+			log.Errorf("Error parsing ip pool %s of epg %s. Err: %v", pool, epgCfg.ID, err)
+			continue
+		}
+
+		allocated, available := 0, 0
+		for idx := hostMin; idx <= hostMax; idx++ {
+			if usedMap.Test(idx) {
+				allocated++
+			}
+			if !availMap.Test(idx) {
+				available++
+			}
+		}
+		usage = append(usage, fmt.Sprintf("%s: %d allocated, %d available", pool, allocated, available))
+	}
+
+	return usage
+}
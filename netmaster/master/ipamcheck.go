@@ -0,0 +1,627 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/docknet"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils/netutils"
+	"github.com/jainvipin/bitset"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// IPAMLeaked is an address that is allocated but not used
+	IPAMLeaked = "leaked"
+	// IPAMDoubleAllocated is an address used by more than one endpoint or
+	// service
+	IPAMDoubleAllocated = "double-allocated"
+	// IPAMUnallocated is an address that is used but not allocated, it
+	// can be handed out again
+	IPAMUnallocated = "unallocated"
+
+	// maxIPv6Leaks limits the leaked addresses reported for an IPv6 allocator
+	maxIPv6Leaks = 1024
+)
+
+// IPAMIssue is an address whose allocation does not match its use
+type IPAMIssue struct {
+	Tenant        string   `json:"tenant"`
+	Network       string   `json:"network"`
+	EndpointGroup string   `json:"endpointGroup,omitempty"`
+	ServiceIPPool bool     `json:"serviceIPPool,omitempty"`
+	IPAddress     string   `json:"ipAddress"`
+	Problem       string   `json:"problem"`
+	Owners        []string `json:"owners,omitempty"`
+	Repaired      bool     `json:"repaired"`
+}
+
+// ipamUse is an address used in a network
+type ipamUse struct {
+	ipAddress string
+	// epg the address is allocated from, nil for the network
+	epgCfg *mastercfg.EndpointGroupState
+	// the address is allocated from the service ip pool of the network
+	servicePool bool
+	// endpoints and services using the address
+	owners []string
+	// docker containers attached with the address
+	containers []string
+}
+
+// ipamAllocator is the address allocator of a network, of the pools of an
+// epg or of the service ip pool of a network
+type ipamAllocator struct {
+	epgCfg      *mastercfg.EndpointGroupState
+	servicePool bool
+	allocMap    *bitset.BitSet
+	ipv6Alloc   *netutils.IPv6Allocator
+	quarantine  *[]mastercfg.QuarantinedAddr
+}
+
+func networkAllocator(nwCfg *mastercfg.CfgNetworkState) *ipamAllocator {
+	return &ipamAllocator{
+		allocMap:   &nwCfg.IPAllocMap,
+		ipv6Alloc:  &nwCfg.IPv6Alloc,
+		quarantine: &nwCfg.Quarantine,
+	}
+}
+
+// serviceAllocator returns the allocator of the service ip pool of a
+// network, service addresses are IPv4 only and not quarantined
+func serviceAllocator(nwCfg *mastercfg.CfgNetworkState) *ipamAllocator {
+	return &ipamAllocator{
+		servicePool: true,
+		allocMap:    &nwCfg.ServiceIPAllocMap,
+		ipv6Alloc:   &netutils.IPv6Allocator{},
+		quarantine:  &[]mastercfg.QuarantinedAddr{},
+	}
+}
+
+func epgAllocator(epgCfg *mastercfg.EndpointGroupState) *ipamAllocator {
+	return &ipamAllocator{
+		epgCfg:     epgCfg,
+		allocMap:   &epgCfg.EPGIPAllocMap,
+		ipv6Alloc:  &epgCfg.EPGIPv6Alloc,
+		quarantine: &epgCfg.Quarantine,
+	}
+}
+
+// isMarked checks if an address is marked in an allocator
+func (a *ipamAllocator) isMarked(nwCfg *mastercfg.CfgNetworkState, ipAddress string) bool {
+	if netutils.IsIPv6(ipAddress) {
+		return a.ipv6Alloc.IsAllocated(ipAddress)
+	}
+
+	ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddress)
+	return err == nil && a.allocMap.Test(ipAddrValue)
+}
+
+// isAllocated checks if an address is allocated, quarantined addresses are
+// marked but will be handed out again
+func (a *ipamAllocator) isAllocated(nwCfg *mastercfg.CfgNetworkState, ipAddress string) bool {
+	return a.isMarked(nwCfg, ipAddress) && findQuarantined(*a.quarantine, ipAddress) < 0
+}
+
+// mark marks an address in an allocator
+func (a *ipamAllocator) mark(nwCfg *mastercfg.CfgNetworkState, ipAddress string) error {
+	if netutils.IsIPv6(ipAddress) {
+		return a.ipv6Alloc.Reserve(ipAddress)
+	}
+
+	ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddress)
+	if err != nil {
+		return err
+	}
+	a.allocMap.Set(ipAddrValue)
+
+	return nil
+}
+
+// unusedAddresses returns the addresses marked in an allocator that are not
+// in use. Addresses that are allocated by design, like gateways, epg and
+// service ip pools, ip reservations and quarantined addresses, are not
+// included.
+func (a *ipamAllocator) unusedAddresses(nwCfg *mastercfg.CfgNetworkState,
+	epgs []*mastercfg.EndpointGroupState, uses map[string]*ipamUse) []string {
+	allocMap := &bitset.BitSet{}
+	ipv6Alloc := a.ipv6Alloc.Clone()
+
+	if a.epgCfg == nil {
+		if nwCfg.SubnetIP != "" {
+			allocMap = a.allocMap.Clone()
+			netutils.ClearReservedEntries(allocMap, nwCfg.SubnetLen)
+			netutils.ClearBitsOutsideRange(allocMap, nwCfg.IPAddrRange, nwCfg.SubnetLen)
+			if nwCfg.Gateway != "" {
+				unmarkAddress(nwCfg, allocMap, ipv6Alloc, nwCfg.Gateway)
+			}
+		}
+		if nwCfg.IPv6Gateway != "" {
+			ipv6Alloc.Release(nwCfg.IPv6Gateway)
+		}
+
+		if nwCfg.SubnetIP != "" {
+			setPoolRanges(nwCfg, allocMap, nwCfg.ServiceIPPool, false)
+		}
+		for _, epgCfg := range epgs {
+			if nwCfg.SubnetIP != "" {
+				setPoolRanges(nwCfg, allocMap, epgCfg.IPPool, false)
+			}
+			if epgCfg.IPv6Pool != "" {
+				ipv6Alloc.ReleaseRange(epgCfg.IPv6Pool)
+			}
+		}
+		for ipAddress := range nwCfg.ReservedIPs {
+			unmarkAddress(nwCfg, allocMap, ipv6Alloc, ipAddress)
+		}
+	} else if a.epgCfg.IPPool != "" {
+		allocMap = a.allocMap.Clone()
+		netutils.ClearReservedEntries(allocMap, nwCfg.SubnetLen)
+		netutils.ClearBitsOutsidePools(allocMap, a.epgCfg.IPPool, nwCfg.SubnetIP, nwCfg.SubnetLen)
+	}
+	if a.servicePool {
+		allocMap = a.allocMap.Clone()
+		netutils.ClearReservedEntries(allocMap, nwCfg.SubnetLen)
+		netutils.ClearBitsOutsidePools(allocMap, nwCfg.ServiceIPPool, nwCfg.SubnetIP, nwCfg.SubnetLen)
+	}
+
+	for _, addr := range *a.quarantine {
+		unmarkAddress(nwCfg, allocMap, ipv6Alloc, addr.IPAddress)
+	}
+	for _, use := range uses {
+		if use.epgCfg == a.epgCfg && use.servicePool == a.servicePool {
+			unmarkAddress(nwCfg, allocMap, ipv6Alloc, use.ipAddress)
+		}
+	}
+
+	unused := []string{}
+	maxHosts := uint(1 << (32 - nwCfg.SubnetLen))
+	for idx, found := allocMap.NextSet(0); found && idx < maxHosts; idx, found = allocMap.NextSet(idx + 1) {
+		ipAddress, err := netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, idx)
+		if err == nil {
+			unused = append(unused, ipAddress)
+		}
+	}
+
+	return append(unused, ipv6Alloc.List(maxIPv6Leaks)...)
+}
+
+// networkAddressUses returns the addresses used in a network by endpoints,
+// service lbs and, in docker mode, by the containers attached to the docker
+// networks
+func networkAddressUses(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState,
+	epgs []*mastercfg.EndpointGroupState) (map[string]*ipamUse, error) {
+	epgMap := make(map[string]*mastercfg.EndpointGroupState)
+	for _, epgCfg := range epgs {
+		epgMap[epgCfg.ID] = epgCfg
+	}
+
+	uses := make(map[string]*ipamUse)
+	addUse := func(ipAddress, epgKey string) *ipamUse {
+		key := reservedIPKey(ipAddress)
+		use, found := uses[key]
+		if !found {
+			use = &ipamUse{ipAddress: key}
+			// see networkAllocAddress and serviceAllocAddress on where
+			// addresses are allocated from
+			epgCfg := epgMap[epgKey]
+			if epgPool(epgCfg, netutils.IsIPv6(key)) != "" && !isReservedIP(nwCfg, key) {
+				use.epgCfg = epgCfg
+			} else if inServiceIPPool(nwCfg, key) {
+				use.servicePool = true
+			}
+			uses[key] = use
+		}
+		return use
+	}
+
+	readEp := &mastercfg.CfgEndpointState{}
+	readEp.StateDriver = stateDriver
+	epCfgs, err := readEp.ReadAll()
+	if err != nil && !strings.Contains(err.Error(), "Key not found") {
+		log.Errorf("Error reading endpoints. Err: %v", err)
+		return nil, err
+	}
+	for _, state := range epCfgs {
+		ep := state.(*mastercfg.CfgEndpointState)
+		if ep.NetID != nwCfg.ID {
+			continue
+		}
+		for _, ipAddress := range []string{ep.IPAddress, ep.IPv6Address} {
+			if ipAddress != "" {
+				use := addUse(ipAddress, ep.EndpointGroupKey)
+				use.owners = append(use.owners, "endpoint "+ep.ID)
+			}
+		}
+	}
+
+	readSvc := &mastercfg.CfgServiceLBState{}
+	readSvc.StateDriver = stateDriver
+	svcCfgs, err := readSvc.ReadAll()
+	if err != nil && !strings.Contains(err.Error(), "Key not found") {
+		log.Errorf("Error reading service lbs. Err: %v", err)
+		return nil, err
+	}
+	for _, state := range svcCfgs {
+		svc := state.(*mastercfg.CfgServiceLBState)
+		if svc.Network+"."+svc.Tenant == nwCfg.ID && svc.IPAddress != "" {
+			use := addUse(svc.IPAddress, "")
+			use.owners = append(use.owners, "service "+svc.ServiceName)
+		}
+	}
+
+	// addresses handed to docker are not owned by an endpoint until the
+	// endpoint is created
+	aci, _ := IsAciConfigured()
+	if GetClusterMode() == "docker" && !aci && nwCfg.NwType != "infra" {
+		epgKeys := map[string]string{"": ""}
+		for _, epgCfg := range epgs {
+			epgKeys[epgCfg.GroupName] = epgCfg.ID
+		}
+		for groupName, epgKey := range epgKeys {
+			addrs, err := docknet.GetDockNetAddresses(nwCfg.Tenant, nwCfg.NetworkName, groupName)
+			if err != nil {
+				log.Warnf("Unable to read addresses of docker network %s/%s. Err: %v",
+					nwCfg.ID, groupName, err)
+				continue
+			}
+			for ipAddress, container := range addrs {
+				use := addUse(ipAddress, epgKey)
+				use.containers = append(use.containers, "container "+container)
+			}
+		}
+	}
+
+	return uses, nil
+}
+
+// checkNetworkIPAM cross-checks the allocators of a network and its epgs
+// against the addresses in use
+func checkNetworkIPAM(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState) ([]*IPAMIssue, error) {
+	if err := initIPv6Alloc(nwCfg); err != nil {
+		return nil, err
+	}
+
+	epgs := networkEPGs(stateDriver, nwCfg)
+	uses, err := networkAddressUses(stateDriver, nwCfg, epgs)
+	if err != nil {
+		return nil, err
+	}
+
+	newIssue := func(allocator *ipamAllocator, ipAddress, problem string) *IPAMIssue {
+		issue := &IPAMIssue{
+			Tenant:        nwCfg.Tenant,
+			Network:       nwCfg.NetworkName,
+			ServiceIPPool: allocator.servicePool,
+			IPAddress:     ipAddress,
+			Problem:       problem,
+		}
+		if allocator.epgCfg != nil {
+			issue.EndpointGroup = allocator.epgCfg.GroupName
+		}
+		return issue
+	}
+
+	allocators := map[*mastercfg.EndpointGroupState]*ipamAllocator{nil: networkAllocator(nwCfg)}
+	for _, epgCfg := range epgs {
+		if epgCfg.IPPool != "" || epgCfg.IPv6Pool != "" {
+			allocators[epgCfg] = epgAllocator(epgCfg)
+		}
+	}
+	svcAllocator := serviceAllocator(nwCfg)
+
+	issues := []*IPAMIssue{}
+	for _, use := range uses {
+		allocator := allocators[use.epgCfg]
+		if use.servicePool {
+			allocator = svcAllocator
+		}
+
+		owners := append(append([]string{}, use.owners...), use.containers...)
+		sort.Strings(owners)
+		if len(use.owners) > 1 {
+			issue := newIssue(allocator, use.ipAddress, IPAMDoubleAllocated)
+			issue.Owners = owners
+			issues = append(issues, issue)
+		}
+		if !allocator.isAllocated(nwCfg, use.ipAddress) {
+			issue := newIssue(allocator, use.ipAddress, IPAMUnallocated)
+			issue.Owners = owners
+			issues = append(issues, issue)
+		}
+	}
+
+	// free addresses of host address blocks are allocated by design
+	for epgCfg, allocator := range allocators {
+		free := freeBlockAddrs(nwCfg, addrBlockEPGKey(epgCfg))
+		for _, ipAddress := range allocator.unusedAddresses(nwCfg, epgs, uses) {
+			if findAddr(free, ipAddress) < 0 {
+				issues = append(issues, newIssue(allocator, ipAddress, IPAMLeaked))
+			}
+		}
+	}
+	if nwCfg.ServiceIPPool != "" {
+		for _, ipAddress := range svcAllocator.unusedAddresses(nwCfg, epgs, uses) {
+			issues = append(issues, newIssue(svcAllocator, ipAddress, IPAMLeaked))
+		}
+	}
+
+	return issues, nil
+}
+
+// ownedAddresses returns the addresses of a network used by endpoints,
+// service lbs and docker containers, and with blocks also the addresses in
+// the address blocks of hosts
+func ownedAddresses(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState, blocks bool) (map[string]bool, error) {
+	uses, err := networkAddressUses(stateDriver, nwCfg, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make(map[string]bool)
+	for key := range uses {
+		owned[key] = true
+	}
+	if !blocks {
+		return owned, nil
+	}
+
+	blkCfgs, err := readAddrBlocks(stateDriver, func(blkCfg *mastercfg.CfgAddrBlockState) bool {
+		return blkCfg.NetworkID == nwCfg.ID
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, blkCfg := range blkCfgs {
+		for _, ipAddress := range append(append([]string{}, blkCfg.Free...), blkCfg.Allocated...) {
+			owned[reservedIPKey(ipAddress)] = true
+		}
+	}
+
+	return owned, nil
+}
+
+// releaseLeakedBlockAddress returns a leaked address allocated from the
+// block of a host to the free addresses of the block, unless an endpoint or
+// service took the address since the check. It returns false if the address
+// is not in an address block.
+func releaseLeakedBlockAddress(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState, issue *IPAMIssue) (bool, error) {
+	if netutils.IsIPv6(issue.IPAddress) {
+		return false, nil
+	}
+
+	blocks, err := readAddrBlocks(stateDriver, func(blkCfg *mastercfg.CfgAddrBlockState) bool {
+		return blkCfg.NetworkID == nwCfg.ID &&
+			(findAddr(blkCfg.Allocated, issue.IPAddress) >= 0 || findAddr(blkCfg.Free, issue.IPAddress) >= 0)
+	})
+	if err != nil || len(blocks) == 0 {
+		return false, err
+	}
+
+	blkCfg := blocks[0]
+	err = core.UpdateState(blkCfg, blkCfg.ID, func() error {
+		// an address that is already free was released before
+		idx := findAddr(blkCfg.Allocated, issue.IPAddress)
+		issue.Repaired = idx < 0
+		if idx < 0 {
+			return nil
+		}
+
+		owned, err := ownedAddresses(stateDriver, nwCfg, false)
+		if err != nil || owned[reservedIPKey(issue.IPAddress)] {
+			return err
+		}
+		blkCfg.Allocated = append(blkCfg.Allocated[:idx], blkCfg.Allocated[idx+1:]...)
+		blkCfg.Free = append(blkCfg.Free, issue.IPAddress)
+		issue.Repaired = true
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error updating address block %s. Err: %v", blkCfg.ID, err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// repairNetworkIPAM frees the leaked addresses of a network and marks the
+// unallocated addresses in use as allocated. The network and epg states are
+// re-read before they are changed, and only addresses that are still in the
+// state found by the check are changed. Leaked addresses are only freed if
+// no endpoint, service or address block took them since the check.
+func repairNetworkIPAM(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState, issues []*IPAMIssue) error {
+	epgIssues := make(map[string][]*IPAMIssue)
+	svcIssues := []*IPAMIssue{}
+	for _, issue := range issues {
+		if issue.Problem == IPAMDoubleAllocated {
+			continue
+		}
+		if issue.ServiceIPPool {
+			svcIssues = append(svcIssues, issue)
+			continue
+		}
+
+		// leaked addresses allocated by a host go back to its address block
+		if issue.Problem == IPAMLeaked {
+			inBlock, err := releaseLeakedBlockAddress(stateDriver, nwCfg, issue)
+			if err != nil {
+				return err
+			}
+			if inBlock {
+				continue
+			}
+		}
+		epgIssues[issue.EndpointGroup] = append(epgIssues[issue.EndpointGroup], issue)
+	}
+
+	repair := func(allocator *ipamAllocator, issues []*IPAMIssue) (int, error) {
+		owned, err := ownedAddresses(stateDriver, nwCfg, true)
+		if err != nil {
+			return 0, err
+		}
+
+		addrCount := 0
+		for _, issue := range issues {
+			issue.Repaired = false
+			switch {
+			case issue.Problem == IPAMLeaked && owned[reservedIPKey(issue.IPAddress)]:
+				continue
+			case issue.Problem == IPAMLeaked && allocator.isAllocated(nwCfg, issue.IPAddress):
+				if err := unmarkAddress(nwCfg, allocator.allocMap, allocator.ipv6Alloc, issue.IPAddress); err != nil {
+					return 0, err
+				}
+				addrCount--
+			case issue.Problem == IPAMUnallocated && !allocator.isAllocated(nwCfg, issue.IPAddress):
+				removeQuarantined(allocator.quarantine, issue.IPAddress)
+				if err := allocator.mark(nwCfg, issue.IPAddress); err != nil {
+					return 0, err
+				}
+				addrCount++
+			default:
+				continue
+			}
+			issue.Repaired = true
+		}
+		return addrCount, nil
+	}
+
+	addrCount := 0
+	for groupName, issues := range epgIssues {
+		if groupName == "" {
+			continue
+		}
+
+		epgCfg := &mastercfg.EndpointGroupState{}
+		epgCfg.StateDriver = stateDriver
+		epgID := mastercfg.GetEndpointGroupKey(groupName, nwCfg.Tenant)
+		count := 0
+		err := core.UpdateState(epgCfg, epgID, func() error {
+			var err error
+			count, err = repair(epgAllocator(epgCfg), issues)
+			return err
+		})
+		if err != nil {
+			log.Errorf("Error repairing address allocations of epg %s. Err: %v", epgID, err)
+			return err
+		}
+		addrCount += count
+	}
+
+	err := core.UpdateState(nwCfg, nwCfg.ID, func() error {
+		count, err := repair(networkAllocator(nwCfg), epgIssues[""])
+		if err != nil {
+			return err
+		}
+		// service ip pool addresses are not counted
+		if _, err := repair(serviceAllocator(nwCfg), svcIssues); err != nil {
+			return err
+		}
+		nwCfg.EpAddrCount += addrCount + count
+		if nwCfg.EpAddrCount < 0 {
+			nwCfg.EpAddrCount = 0
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error repairing address allocations of network %s. Err: %v", nwCfg.ID, err)
+		return err
+	}
+
+	return nil
+}
+
+// ipamNetworkList sorts networks by their id
+type ipamNetworkList []*mastercfg.CfgNetworkState
+
+func (l ipamNetworkList) Len() int           { return len(l) }
+func (l ipamNetworkList) Less(i, j int) bool { return l[i].ID < l[j].ID }
+func (l ipamNetworkList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// ipamIssueList sorts the issues of a network by endpoint group, address
+// and problem
+type ipamIssueList []*IPAMIssue
+
+func (l ipamIssueList) Len() int      { return len(l) }
+func (l ipamIssueList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l ipamIssueList) Less(i, j int) bool {
+	if l[i].EndpointGroup != l[j].EndpointGroup {
+		return l[i].EndpointGroup < l[j].EndpointGroup
+	}
+	cmp := bytes.Compare(net.ParseIP(l[i].IPAddress), net.ParseIP(l[j].IPAddress))
+	if cmp != 0 {
+		return cmp < 0
+	}
+	return l[i].Problem < l[j].Problem
+}
+
+// CheckIPAM cross-checks the address allocators of the networks, service ip
+// pools and epgs of a tenant, or of a single network, against the addresses
+// used by endpoints, service lbs and docker containers. It reports leaked, double
+// allocated and unallocated addresses. With repair, leaked addresses are
+// freed and unallocated addresses are marked as allocated, double
+// allocations are only reported. An empty tenant checks all networks. The
+// check holds the address allocation lock, so that addresses being
+// allocated are not taken for leaked addresses.
+func CheckIPAM(stateDriver core.StateDriver, tenant, network string, repair bool) ([]*IPAMIssue, error) {
+	addrMutex.Lock()
+	defer addrMutex.Unlock()
+
+	readNet := &mastercfg.CfgNetworkState{}
+	readNet.StateDriver = stateDriver
+	nwStates, err := readNet.ReadAll()
+	if err != nil && !strings.Contains(err.Error(), "Key not found") {
+		log.Errorf("Error reading networks. Err: %v", err)
+		return nil, err
+	}
+
+	nwList := []*mastercfg.CfgNetworkState{}
+	for _, state := range nwStates {
+		nwCfg := state.(*mastercfg.CfgNetworkState)
+		if (tenant == "" || nwCfg.Tenant == tenant) && (network == "" || nwCfg.NetworkName == network) {
+			nwList = append(nwList, nwCfg)
+		}
+	}
+	if network != "" && len(nwList) == 0 {
+		return nil, core.Errorf("network %s not found in tenant %s", network, tenant)
+	}
+	sort.Sort(ipamNetworkList(nwList))
+
+	issues := []*IPAMIssue{}
+	for _, nwCfg := range nwList {
+		nwIssues, err := checkNetworkIPAM(stateDriver, nwCfg)
+		if err != nil {
+			return nil, err
+		}
+
+		if repair && len(nwIssues) > 0 {
+			if err := repairNetworkIPAM(stateDriver, nwCfg, nwIssues); err != nil {
+				return nil, err
+			}
+		}
+
+		sort.Sort(ipamIssueList(nwIssues))
+		issues = append(issues, nwIssues...)
+	}
+
+	return issues, nil
+}
@@ -0,0 +1,471 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils/netutils"
+)
+
+// validateIPReservation checks the address and the workload match of a
+// reservation
+func validateIPReservation(resv *mastercfg.CfgIPReservationState) error {
+	if resv.Tenant == "" || resv.Network == "" {
+		return core.Errorf("tenant and network are required for ip reservation")
+	}
+
+	if net.ParseIP(resv.IPAddress) == nil {
+		return core.Errorf("invalid ip address %q in ip reservation", resv.IPAddress)
+	}
+
+	matches := 0
+	if resv.ContainerName != "" {
+		matches++
+	}
+	if resv.PodName != "" {
+		matches++
+	}
+	if len(resv.Labels) > 0 {
+		matches++
+	}
+	if matches != 1 {
+		return core.Errorf("ip reservation must match on one of container name, pod name or labels")
+	}
+	if resv.PodNamespace != "" && resv.PodName == "" {
+		return core.Errorf("pod namespace of ip reservation requires a pod name")
+	}
+
+	return nil
+}
+
+// reservedIPKey returns the key of an address in the reserved addresses of
+// a network
+func reservedIPKey(ipAddress string) string {
+	if ip := net.ParseIP(ipAddress); ip != nil {
+		return ip.String()
+	}
+	return ipAddress
+}
+
+// isReservedIP checks if an address is reserved in the network
+func isReservedIP(nwCfg *mastercfg.CfgNetworkState, ipAddress string) bool {
+	_, found := nwCfg.ReservedIPs[reservedIPKey(ipAddress)]
+	return found
+}
+
+// reserveNetworkAddress marks a free address of the network as reserved
+func reserveNetworkAddress(nwCfg *mastercfg.CfgNetworkState, ipAddress string) error {
+	switch {
+	case removeQuarantined(&nwCfg.Quarantine, ipAddress):
+		// quarantined addresses are still marked in the allocators, the
+		// reservation takes them over
+	case netutils.IsIPv6(ipAddress):
+		if nwCfg.IPv6Subnet == "" {
+			return core.Errorf("network %s has no ipv6 subnet", nwCfg.ID)
+		}
+		if err := initIPv6Alloc(nwCfg); err != nil {
+			return err
+		}
+		if nwCfg.IPv6Alloc.IsAllocated(ipAddress) {
+			return core.Errorf("address %s is in use in network %s", ipAddress, nwCfg.ID)
+		}
+		if err := nwCfg.IPv6Alloc.Reserve(ipAddress); err != nil {
+			return err
+		}
+	default:
+		if nwCfg.SubnetIP == "" {
+			return core.Errorf("network %s has no subnet", nwCfg.ID)
+		}
+		ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddress)
+		if err != nil {
+			return err
+		}
+		// the gateway, epg pools and addresses outside the network range
+		// are marked as allocated as well
+		if nwCfg.IPAllocMap.Test(ipAddrValue) {
+			return core.Errorf("address %s is in use in network %s", ipAddress, nwCfg.ID)
+		}
+		nwCfg.IPAllocMap.Set(ipAddrValue)
+	}
+
+	if nwCfg.ReservedIPs == nil {
+		nwCfg.ReservedIPs = make(map[string]bool)
+	}
+	nwCfg.ReservedIPs[ipAddress] = false
+
+	return nil
+}
+
+// unreserveNetworkAddress frees a reserved address of the network
+func unreserveNetworkAddress(nwCfg *mastercfg.CfgNetworkState, ipAddress string) error {
+	claimed, found := nwCfg.ReservedIPs[ipAddress]
+	if !found {
+		return nil
+	}
+	if claimed {
+		return core.Errorf("reserved address %s is in use in network %s", ipAddress, nwCfg.ID)
+	}
+
+	delete(nwCfg.ReservedIPs, ipAddress)
+	if netutils.IsIPv6(ipAddress) {
+		if err := initIPv6Alloc(nwCfg); err != nil {
+			return err
+		}
+		nwCfg.IPv6Alloc.Release(ipAddress)
+		return nil
+	}
+
+	ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddress)
+	if err != nil {
+		return err
+	}
+	nwCfg.IPAllocMap.Clear(ipAddrValue)
+
+	return nil
+}
+
+// CreateIPReservation reserves an address of a network for the workload
+// matching the reservation. Changing the workload match of an existing
+// reservation keeps the address allocated to its current endpoint.
+func CreateIPReservation(stateDriver core.StateDriver, resv *mastercfg.CfgIPReservationState) error {
+	if err := validateIPReservation(resv); err != nil {
+		return err
+	}
+
+	resv.IPAddress = reservedIPKey(resv.IPAddress)
+	resv.ID = mastercfg.GetIPReservationKey(resv.Tenant, resv.Network, resv.IPAddress)
+	resv.StateDriver = stateDriver
+
+	curResv := &mastercfg.CfgIPReservationState{}
+	curResv.StateDriver = stateDriver
+	if err := curResv.Read(resv.ID); err == nil {
+		return resv.Write()
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	networkID := resv.Network + "." + resv.Tenant
+	if err := nwCfg.Read(networkID); err != nil {
+		log.Errorf("Error reading network %s for ip reservation. Err: %v", networkID, err)
+		return core.Errorf("network %s not found", networkID)
+	}
+
+	err := core.UpdateState(nwCfg, nwCfg.ID, func() error {
+		return reserveNetworkAddress(nwCfg, resv.IPAddress)
+	})
+	if err != nil {
+		log.Errorf("Error reserving address %s in network %s. Err: %v", resv.IPAddress, networkID, err)
+		return err
+	}
+
+	if err := resv.Write(); err != nil {
+		log.Errorf("Error writing ip reservation %s. Err: %v", resv.ID, err)
+		core.UpdateState(nwCfg, nwCfg.ID, func() error {
+			return unreserveNetworkAddress(nwCfg, resv.IPAddress)
+		})
+		return err
+	}
+
+	return nil
+}
+
+// DeleteIPReservation deletes a reservation and frees its address. A
+// reservation can't be deleted while its address is in use.
+func DeleteIPReservation(stateDriver core.StateDriver, key string) error {
+	resv := &mastercfg.CfgIPReservationState{}
+	resv.StateDriver = stateDriver
+	if err := resv.Read(key); err != nil {
+		return core.Errorf("ip reservation %s not found", key)
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	networkID := resv.Network + "." + resv.Tenant
+	if err := nwCfg.Read(networkID); err == nil {
+		err = core.UpdateState(nwCfg, nwCfg.ID, func() error {
+			return unreserveNetworkAddress(nwCfg, resv.IPAddress)
+		})
+		if err != nil {
+			log.Errorf("Error freeing address %s in network %s. Err: %v", resv.IPAddress, networkID, err)
+			return err
+		}
+	}
+
+	return resv.Clear()
+}
+
+// reservationList sorts reservations by their id
+type reservationList []*mastercfg.CfgIPReservationState
+
+func (l reservationList) Len() int           { return len(l) }
+func (l reservationList) Less(i, j int) bool { return l[i].ID < l[j].ID }
+func (l reservationList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// addressList sorts ip addresses in address order
+type addressList []string
+
+func (l addressList) Len() int { return len(l) }
+func (l addressList) Less(i, j int) bool {
+	return bytes.Compare(net.ParseIP(l[i]), net.ParseIP(l[j])) < 0
+}
+func (l addressList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+
+// ListIPReservations returns the reservations of a tenant and network,
+// sorted by key. Empty filters select all reservations.
+func ListIPReservations(stateDriver core.StateDriver, tenant, network string) ([]*mastercfg.CfgIPReservationState, error) {
+	readResv := &mastercfg.CfgIPReservationState{}
+	readResv.StateDriver = stateDriver
+	resvStates, err := readResv.ReadAll()
+	if err != nil && !strings.Contains(err.Error(), "Key not found") {
+		log.Errorf("Error reading ip reservations. Err: %v", err)
+		return nil, err
+	}
+
+	resvList := []*mastercfg.CfgIPReservationState{}
+	for _, state := range resvStates {
+		resv := state.(*mastercfg.CfgIPReservationState)
+		if (tenant == "" || resv.Tenant == tenant) && (network == "" || resv.Network == network) {
+			resvList = append(resvList, resv)
+		}
+	}
+	sort.Sort(reservationList(resvList))
+
+	return resvList, nil
+}
+
+// reservationMatches checks if a workload matches a reservation
+func reservationMatches(resv *mastercfg.CfgIPReservationState, workload *WorkloadInfo) bool {
+	switch {
+	case resv.ContainerName != "":
+		// docker reports container names with a leading slash
+		return resv.ContainerName == strings.TrimPrefix(workload.ContainerName, "/")
+	case resv.PodName != "":
+		return resv.PodName == workload.PodName &&
+			(resv.PodNamespace == "" || resv.PodNamespace == workload.PodNamespace)
+	case len(resv.Labels) > 0:
+		for key, value := range resv.Labels {
+			if wlValue, found := workload.Labels[key]; !found || wlValue != value {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// claimReservedAddress allocates the lowest free address reserved for a
+// workload in the network. It returns an empty address if no address is
+// reserved for the workload, and an error if all of them are in use.
+func claimReservedAddress(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState,
+	workload *WorkloadInfo, isIPv6 bool) (string, error) {
+	if len(nwCfg.ReservedIPs) == 0 {
+		return "", nil
+	}
+
+	resvList, err := ListIPReservations(stateDriver, nwCfg.Tenant, nwCfg.NetworkName)
+	if err != nil {
+		return "", err
+	}
+
+	reservedAddrs := []string{}
+	for _, resv := range resvList {
+		if netutils.IsIPv6(resv.IPAddress) == isIPv6 && reservationMatches(resv, workload) {
+			reservedAddrs = append(reservedAddrs, resv.IPAddress)
+		}
+	}
+	if len(reservedAddrs) == 0 {
+		return "", nil
+	}
+	sort.Sort(addressList(reservedAddrs))
+
+	ipAddress := ""
+	err = core.UpdateState(nwCfg, nwCfg.ID, func() error {
+		for _, addr := range reservedAddrs {
+			if claimed, found := nwCfg.ReservedIPs[addr]; found && !claimed {
+				nwCfg.ReservedIPs[addr] = true
+				nwCfg.EpAddrCount++
+				ipAddress = addr
+				return nil
+			}
+		}
+		return core.Errorf("addresses %s reserved for the workload are in use",
+			strings.Join(reservedAddrs, ", "))
+	})
+	if err != nil {
+		log.Errorf("Error allocating reserved address in network %s. Err: %v", nwCfg.ID, err)
+		return "", err
+	}
+
+	log.Infof("Allocated reserved address %s in network %s", ipAddress, nwCfg.ID)
+	return ipAddress, nil
+}
+
+// claimRequestedAddress allocates a reserved address requested explicitly
+// by its workload. Workloads that are only known when their endpoint joins
+// can claim any reserved address, which is verified by checkJoinReservations.
+// It returns false if the address is not reserved or not claimed.
+func claimRequestedAddress(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState,
+	ipAddress string, workload *WorkloadInfo, atJoin bool) (bool, error) {
+	if !isReservedIP(nwCfg, ipAddress) {
+		return false, nil
+	}
+
+	if !atJoin {
+		resv := &mastercfg.CfgIPReservationState{}
+		resv.StateDriver = stateDriver
+		err := resv.Read(mastercfg.GetIPReservationKey(nwCfg.Tenant, nwCfg.NetworkName, reservedIPKey(ipAddress)))
+		if err != nil {
+			log.Errorf("Error reading ip reservation of %s. Err: %v", ipAddress, err)
+			return false, err
+		}
+		if !reservationMatches(resv, workload) {
+			return false, nil
+		}
+	}
+
+	claimed := false
+	err := core.UpdateState(nwCfg, nwCfg.ID, func() error {
+		inUse, found := nwCfg.ReservedIPs[reservedIPKey(ipAddress)]
+		if !found {
+			return nil
+		}
+		if inUse {
+			return core.Errorf("reserved address %s is in use in network %s", ipAddress, nwCfg.ID)
+		}
+		nwCfg.ReservedIPs[reservedIPKey(ipAddress)] = true
+		nwCfg.EpAddrCount++
+		claimed = true
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Error allocating reserved address in network %s. Err: %v", nwCfg.ID, err)
+		return false, err
+	}
+
+	if claimed {
+		log.Infof("Allocated requested reserved address %s in network %s", ipAddress, nwCfg.ID)
+	}
+	return claimed, nil
+}
+
+// claimEndpointAddresses sets the addresses of an endpoint to the addresses
+// reserved for its workload, or claims the reserved addresses it requests
+// explicitly. The claimed addresses are returned so they can be released on
+// error.
+func claimEndpointAddresses(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState,
+	ep *intent.ConfigEP, epReq *CreateEndpointRequest) ([]string, error) {
+	claimed := []string{}
+
+	for _, isIPv6 := range []bool{false, true} {
+		addr := &ep.IPAddress
+		if isIPv6 {
+			if nwCfg.IPv6Subnet == "" {
+				break
+			}
+			addr = &ep.IPv6Address
+		}
+
+		if *addr != "" {
+			found, err := claimRequestedAddress(stateDriver, nwCfg, *addr, &epReq.Workload, epReq.WorkloadAtJoin)
+			if err != nil {
+				releaseClaimedAddresses(nwCfg, claimed)
+				return nil, err
+			}
+			if found {
+				claimed = append(claimed, *addr)
+			}
+			continue
+		}
+
+		ipAddress, err := claimReservedAddress(stateDriver, nwCfg, &epReq.Workload, isIPv6)
+		if err != nil {
+			releaseClaimedAddresses(nwCfg, claimed)
+			return nil, err
+		}
+		if ipAddress != "" {
+			*addr = ipAddress
+			claimed = append(claimed, ipAddress)
+		}
+	}
+
+	return claimed, nil
+}
+
+// checkJoinReservations verifies the addresses of an endpoint against the ip
+// reservations once its workload is known. A reserved address of the
+// endpoint must be reserved for the workload, and a workload with reserved
+// addresses must use one of them.
+func checkJoinReservations(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState,
+	epCfg *mastercfg.CfgEndpointState, workload *WorkloadInfo) error {
+	if len(nwCfg.ReservedIPs) == 0 {
+		return nil
+	}
+
+	resvList, err := ListIPReservations(stateDriver, nwCfg.Tenant, nwCfg.NetworkName)
+	if err != nil {
+		return err
+	}
+
+	for _, ipAddress := range []string{epCfg.IPAddress, epCfg.IPv6Address} {
+		if ipAddress == "" {
+			continue
+		}
+
+		isIPv6 := netutils.IsIPv6(ipAddress)
+		reservedAddrs := []string{}
+		for _, resv := range resvList {
+			if netutils.IsIPv6(resv.IPAddress) != isIPv6 {
+				continue
+			}
+			if reservedIPKey(resv.IPAddress) == reservedIPKey(ipAddress) {
+				if !reservationMatches(resv, workload) {
+					return core.Errorf("address %s is reserved for another workload", ipAddress)
+				}
+				reservedAddrs = nil
+				break
+			}
+			if reservationMatches(resv, workload) {
+				reservedAddrs = append(reservedAddrs, resv.IPAddress)
+			}
+		}
+		if len(reservedAddrs) > 0 {
+			sort.Sort(addressList(reservedAddrs))
+			return core.Errorf("addresses %s are reserved for the workload and must be requested explicitly",
+				strings.Join(reservedAddrs, ", "))
+		}
+	}
+
+	return nil
+}
+
+// releaseClaimedAddresses releases reserved addresses claimed for a
+// workload, keeping them reserved
+func releaseClaimedAddresses(nwCfg *mastercfg.CfgNetworkState, claimed []string) {
+	for _, ipAddress := range claimed {
+		if err := networkReleaseAddress(nwCfg, nil, ipAddress); err != nil {
+			log.Errorf("Error releasing reserved address %s. Err: %v", ipAddress, err)
+		}
+	}
+}
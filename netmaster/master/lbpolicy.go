@@ -0,0 +1,103 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"strconv"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The lb policy of a service is kept in the service state and handed to the
+// service proxies with the service spec. Provider weights come from a label
+// of the providers, a provider with weight 0 only gets clients when all
+// providers of the service have weight 0.
+
+const (
+	defaultAffinityTimeout = 10800
+	defaultProviderWeight  = 1
+	maxProviderWeight      = 100
+)
+
+// validateLBPolicy checks the lb policy of a service and fills in the
+// affinity timeout if it isn't set. None affinity is stored as no affinity.
+func validateLBPolicy(lbPolicy *intent.ConfigLBPolicy) error {
+	switch lbPolicy.SessionAffinity {
+	case "", intent.SessionAffinityNone:
+		if lbPolicy.AffinityTimeout != 0 {
+			return core.Errorf("session affinity timeout is only supported with %s session affinity",
+				intent.SessionAffinityClientIP)
+		}
+		lbPolicy.SessionAffinity = ""
+	case intent.SessionAffinityClientIP:
+		if lbPolicy.AffinityTimeout == 0 {
+			lbPolicy.AffinityTimeout = defaultAffinityTimeout
+		}
+	default:
+		return core.Errorf("invalid session affinity %s", lbPolicy.SessionAffinity)
+	}
+
+	return nil
+}
+
+// updateServiceLBPolicy changes the lb policy of a service
+func updateServiceLBPolicy(stateDriver core.StateDriver, serviceID string, lbPolicy intent.ConfigLBPolicy) error {
+	mastercfg.SvcMutex.Lock()
+	defer mastercfg.SvcMutex.Unlock()
+
+	service := mastercfg.ServiceLBDb[serviceID]
+	if service == nil {
+		return core.Errorf("service %s not found", serviceID)
+	}
+
+	serviceLbState := &mastercfg.CfgServiceLBState{}
+	serviceLbState.StateDriver = stateDriver
+	if err := serviceLbState.Read(serviceID); err != nil {
+		log.Errorf("Error reading service lb config for service %s. Err: %v", serviceID, err)
+		return err
+	}
+
+	service.LBPolicy = lbPolicy
+	serviceLbState.LBPolicy = lbPolicy
+
+	return serviceLbState.Write()
+}
+
+// ProviderWeight returns the weight of a provider from its weightLabel label,
+// providers without the label or with an invalid weight get the default
+func ProviderWeight(provider *mastercfg.Provider, weightLabel string) int {
+	if weightLabel == "" {
+		return defaultProviderWeight
+	}
+
+	value, found := provider.Labels[weightLabel]
+	if !found {
+		return defaultProviderWeight
+	}
+
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight < 0 || weight > maxProviderWeight {
+		log.Warnf("Invalid weight %q of provider %s, using weight %d", value, provider.IPAddress,
+			defaultProviderWeight)
+		return defaultProviderWeight
+	}
+
+	return weight
+}
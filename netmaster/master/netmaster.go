@@ -130,6 +130,9 @@ func CreateGlobal(stateDriver core.StateDriver, gc *intent.ConfigGlobal) error {
 		if err != nil {
 			return err
 		}
+		if err := checkTenantTagRanges(stateDriver, gc.VXLANs, "vxlan"); err != nil {
+			return err
+		}
 		gCfg.Auto.VXLANs = gc.VXLANs
 		gcfgUpdateList = append(gcfgUpdateList, "vxlan")
 	}
@@ -219,6 +222,9 @@ func UpdateGlobal(stateDriver core.StateDriver, gc *intent.ConfigGlobal) error {
 		if err != nil {
 			return err
 		}
+		if err := checkTenantTagRanges(stateDriver, gc.VLANs, "vlan"); err != nil {
+			return err
+		}
 		gCfg.Auto.VLANs = gc.VLANs
 		gcfgUpdateList = append(gcfgUpdateList, "vlan")
 	}
@@ -232,6 +238,9 @@ func UpdateGlobal(stateDriver core.StateDriver, gc *intent.ConfigGlobal) error {
 		if err != nil {
 			return err
 		}
+		if err := checkTenantTagRanges(stateDriver, gc.VXLANs, "vxlan"); err != nil {
+			return err
+		}
 		gCfg.Auto.VXLANs = gc.VXLANs
 		gcfgUpdateList = append(gcfgUpdateList, "vxlan")
 	}
@@ -321,12 +330,57 @@ func DeleteGlobal(stateDriver core.StateDriver) error {
 
 // CreateTenant sets the tenant's state according to the passed ConfigTenant.
 func CreateTenant(stateDriver core.StateDriver, tenant *intent.ConfigTenant) error {
-	return validateTenantConfig(tenant)
+	err := validateTenantConfig(tenant)
+	if err != nil {
+		return err
+	}
+
+	if tenant.VLANs == "" && tenant.VXLANs == "" {
+		return nil
+	}
+
+	gstate.GlobalMutex.Lock()
+	defer gstate.GlobalMutex.Unlock()
+	gCfg := &gstate.Cfg{}
+	gCfg.StateDriver = stateDriver
+	err = gCfg.Read("")
+	if err != nil {
+		log.Errorf("error reading global cfg state. Error: %s", err)
+		return err
+	}
+
+	err = validateTenantTagRanges(stateDriver, gCfg, tenant)
+	if err != nil {
+		log.Errorf("error validating tag ranges of tenant %s. Error: %s", tenant.Name, err)
+		return err
+	}
+
+	tenantCfg := &mastercfg.CfgTenantState{
+		VLANs:  tenant.VLANs,
+		VXLANs: tenant.VXLANs,
+	}
+	tenantCfg.StateDriver = stateDriver
+	tenantCfg.ID = tenant.Name
+
+	return tenantCfg.Write()
 }
 
 // DeleteTenant deletes a tenant from the state store based on its ConfigTenant.
 func DeleteTenant(stateDriver core.StateDriver, tenant *intent.ConfigTenant) error {
-	return validateTenantConfig(tenant)
+	err := validateTenantConfig(tenant)
+	if err != nil {
+		return err
+	}
+
+	tenantCfg := &mastercfg.CfgTenantState{}
+	tenantCfg.StateDriver = stateDriver
+	err = tenantCfg.Read(tenant.Name)
+	if err == nil {
+		tenantCfg.ID = tenant.Name
+		return tenantCfg.Clear()
+	}
+
+	return core.ErrIfKeyExists(err)
 }
 
 // IsAciConfigured returns true if aci is configured on netmaster.
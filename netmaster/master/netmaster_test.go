@@ -16,9 +16,14 @@ limitations under the License.
 package master
 
 import (
+	"bytes"
 	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -540,6 +545,54 @@ func TestNetworkDeleteWithEPs(t *testing.T) {
 	}
 }
 
+// TestDockerNetworkUpdateWithEPs tests that the subnet and gateway of a
+// docker network with endpoints can't be changed, and that previews of the
+// change fail the same way
+func TestDockerNetworkUpdateWithEPs(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+            "SubnetCIDR"        : "10.1.1.1/24",
+            "Gateway"           : "10.1.1.254",
+            "Endpoints" : [
+            {
+                "Container"     : "myContainer1"
+            }
+            ]
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+	defer func(mode string) { masterRTCfg.clusterMode = mode }(masterRTCfg.clusterMode)
+	if err := SetClusterMode("docker"); err != nil {
+		t.Fatalf("error setting cluster mode. Err: %v", err)
+	}
+
+	network := intent.ConfigNetwork{
+		Name:       "orange",
+		SubnetCIDR: "10.1.1.1/24",
+		Gateway:    "10.1.1.253",
+	}
+	if _, err := PreviewNetworkUpdate(network, fakeDriver, "tenant-one"); err == nil {
+		t.Fatalf("gateway change of a docker network with endpoints passed validation")
+	}
+	if err := UpdateNetwork(network, fakeDriver, "tenant-one"); err == nil {
+		t.Fatalf("changed the gateway of a docker network with endpoints")
+	}
+
+	// other parameters don't need the docker network to be recreated
+	network.Gateway = "10.1.1.254"
+	network.AddrQuarantineCount = 2
+	if _, err := PreviewNetworkUpdate(network, fakeDriver, "tenant-one"); err != nil {
+		t.Fatalf("error validating network update. Err: %v", err)
+	}
+}
+
 func TestGetAllocatedIPs(t *testing.T) {
 	cfgBytes := []byte(`{
     "Tenants" : [{
@@ -716,3 +769,1555 @@ func TestGetNwAndEpgFromAddrReq(t *testing.T) {
 		assertOnTrue(t, e != d.epgName, fmt.Sprintf("epgname mismatch [%s] != [%s]", e, d.epgName))
 	}
 }
+
+func TestIPv6Allocation(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                      : "teaone",
+        "Networks"  : [{
+            "Name"                : "orange",
+            "SubnetCIDR"          : "10.1.1.0/24",
+            "IPv6SubnetCIDR"      : "2016:0617::/120",
+            "IPv6Gateway"         : "2016:0617::1",
+            "Endpoints" : [{
+                "Container"       : "myContainer1"
+            },
+            {
+                "Container"       : "myContainer2"
+            },
+            {
+                "Container"       : "myContainer3"
+            }]
+        }]
+    }]}`)
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	networkID := "orange.teaone"
+	for i, ipv6Addr := range []string{"2016:617::2", "2016:617::3", "2016:617::4"} {
+		epCfg := &mastercfg.CfgEndpointState{}
+		epCfg.StateDriver = fakeDriver
+		epID := getEpName(networkID, &intent.ConfigEP{Container: fmt.Sprintf("myContainer%d", i+1)})
+		if err := epCfg.Read(epID); err != nil {
+			t.Fatalf("unable to locate endpoint: %s", epID)
+		}
+		if epCfg.IPv6Address != ipv6Addr {
+			t.Fatalf("endpoint %s got ipv6 address %s, expected %s", epID, epCfg.IPv6Address, ipv6Addr)
+		}
+	}
+
+	// released addresses can be reused
+	epID := getEpName(networkID, &intent.ConfigEP{Container: "myContainer2"})
+	if _, err := DeleteEndpointID(fakeDriver, epID); err != nil {
+		t.Fatalf("error deleting endpoint, %s", err)
+	}
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		t.Fatalf("unable to locate network: %s", networkID)
+	}
+	if nwCfg.IPv6Alloc.IsAllocated("2016:0617::3") || !nwCfg.IPv6Alloc.IsAllocated("2016:0617::4") {
+		t.Fatalf("unexpected ipv6 allocations %+v", nwCfg.IPv6Alloc.Allocated)
+	}
+
+	// epg pools are reserved in the network and allocated from separately
+	if err := CreateEndpointGroup("teaone", "orange", "", "2016:0617::3-2016:0617::10", "epgA"); err == nil {
+		t.Fatalf("created epg with an ipv6 pool in use")
+	}
+	if err := CreateEndpointGroup("teaone", "orange", "", "2016:0617::10-2016:0617::1f", "epgA"); err != nil {
+		t.Fatalf("error creating epg. Err: %v", err)
+	}
+	epgCfg := &mastercfg.EndpointGroupState{}
+	epgCfg.StateDriver = fakeDriver
+	if err := epgCfg.Read(mastercfg.GetEndpointGroupKey("epgA", "teaone")); err != nil {
+		t.Fatalf("unable to locate epg. Err: %v", err)
+	}
+	if err := nwCfg.Read(networkID); err != nil {
+		t.Fatalf("unable to locate network: %s", networkID)
+	}
+
+	for _, exp := range []struct {
+		epgCfg   *mastercfg.EndpointGroupState
+		ipv6Addr string
+	}{
+		{epgCfg, "2016:617::10"},
+		{nil, "2016:617::3"},
+		{epgCfg, "2016:617::11"},
+		{nil, "2016:617::5"},
+	} {
+		ipv6Addr, err := networkAllocAddress(nwCfg, exp.epgCfg, "", true)
+		if err != nil || ipv6Addr != exp.ipv6Addr {
+			t.Fatalf("allocated ipv6 address %s, expected %s. Err: %v", ipv6Addr, exp.ipv6Addr, err)
+		}
+	}
+
+	if err := networkReleaseAddress(nwCfg, epgCfg, "2016:0617::10"); err != nil {
+		t.Fatalf("error releasing ipv6 address. Err: %v", err)
+	}
+	if epgCfg.EPGIPv6Alloc.IsAllocated("2016:0617::10") || !epgCfg.EPGIPv6Alloc.IsAllocated("2016:0617::11") {
+		t.Fatalf("unexpected epg ipv6 allocations %+v", epgCfg.EPGIPv6Alloc.Allocated)
+	}
+}
+
+func createReservedEP(t *testing.T, nwCfg *mastercfg.CfgNetworkState, container string,
+	workload WorkloadInfo) (*mastercfg.CfgEndpointState, error) {
+	if err := nwCfg.Read(nwCfg.ID); err != nil {
+		t.Fatalf("unable to locate network: %s", nwCfg.ID)
+	}
+
+	epReq := &CreateEndpointRequest{
+		TenantName:  nwCfg.Tenant,
+		NetworkName: nwCfg.NetworkName,
+		EndpointID:  container,
+		ConfigEP:    intent.ConfigEP{Container: container},
+		Workload:    workload,
+	}
+	return CreateEndpoint(fakeDriver, nwCfg, epReq)
+}
+
+// createRequestedEP creates an endpoint requesting an address explicitly
+func createRequestedEP(t *testing.T, nwCfg *mastercfg.CfgNetworkState, container, ipAddress string,
+	workload WorkloadInfo, atJoin bool) (*mastercfg.CfgEndpointState, error) {
+	if err := nwCfg.Read(nwCfg.ID); err != nil {
+		t.Fatalf("unable to locate network: %s", nwCfg.ID)
+	}
+
+	epReq := &CreateEndpointRequest{
+		TenantName:     nwCfg.Tenant,
+		NetworkName:    nwCfg.NetworkName,
+		EndpointID:     container,
+		ConfigEP:       intent.ConfigEP{Container: container, IPAddress: ipAddress},
+		Workload:       workload,
+		WorkloadAtJoin: atJoin,
+	}
+	return CreateEndpoint(fakeDriver, nwCfg, epReq)
+}
+
+// joinEP checks the addresses of an endpoint against the reservations of
+// the workload it joins
+func joinEP(nwCfg *mastercfg.CfgNetworkState, container string, workload WorkloadInfo) error {
+	_, err := JoinEndpoint(fakeDriver, &JoinEndpointRequest{
+		TenantName:  nwCfg.Tenant,
+		NetworkName: nwCfg.NetworkName,
+		EndpointID:  container,
+		Workload:    workload,
+	})
+	return err
+}
+
+// allocAddress allocates an address of network orange.teaone for a workload
+// through the address allocation handler, an empty address on errors
+func allocAddress(t *testing.T, workload WorkloadInfo) string {
+	reqBytes, err := json.Marshal(AddressAllocRequest{
+		NetworkID:   "orange.teaone",
+		AddressPool: "10.1.1.0/24",
+		Workload:    workload,
+	})
+	if err != nil {
+		t.Fatalf("error encoding address request. Err: %v", err)
+	}
+	req := httptest.NewRequest("POST", "/plugin/allocAddress", bytes.NewReader(reqBytes))
+	resp, err := AllocAddressHandler(httptest.NewRecorder(), req, nil)
+	if err != nil {
+		return ""
+	}
+	return resp.(AddressAllocResponse).IPv4Address
+}
+
+func TestIPReservations(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                      : "teaone",
+        "Networks"  : [{
+            "Name"                : "orange",
+            "SubnetCIDR"          : "10.1.1.0/24",
+            "Gateway"             : "10.1.1.254",
+            "IPv6SubnetCIDR"      : "2016:0617::/120"
+        }]
+    }]}`)
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	nwCfg.ID = "orange.teaone"
+
+	for _, resv := range []*mastercfg.CfgIPReservationState{
+		{Tenant: "teaone", Network: "orange", IPAddress: "10.1.1.1", ContainerName: "db"},
+		{Tenant: "teaone", Network: "orange", IPAddress: "10.1.1.2", Labels: map[string]string{"app": "web"}},
+		{Tenant: "teaone", Network: "orange", IPAddress: "2016:0617::10", PodName: "db", PodNamespace: "prod"},
+	} {
+		if err := CreateIPReservation(fakeDriver, resv); err != nil {
+			t.Fatalf("error creating ip reservation %+v. Err: %v", resv, err)
+		}
+	}
+
+	// addresses in use, outside the network, or without a single match
+	// can't be reserved
+	for _, resv := range []*mastercfg.CfgIPReservationState{
+		{Tenant: "teaone", Network: "orange", IPAddress: "10.1.1.254", ContainerName: "gw"},
+		{Tenant: "teaone", Network: "orange", IPAddress: "10.1.2.1", ContainerName: "web"},
+		{Tenant: "teaone", Network: "blue", IPAddress: "10.1.1.3", ContainerName: "web"},
+		{Tenant: "teaone", Network: "orange", IPAddress: "10.1.1.3"},
+		{Tenant: "teaone", Network: "orange", IPAddress: "10.1.1.3", ContainerName: "web", PodName: "web"},
+		{Tenant: "teaone", Network: "orange", IPAddress: "10.1.1.3", PodNamespace: "prod"},
+		{Tenant: "teaone", Network: "orange", IPAddress: "10.1.1.3", PodName: "web", Labels: map[string]string{"app": "web"}},
+	} {
+		if err := CreateIPReservation(fakeDriver, resv); err == nil {
+			t.Fatalf("created invalid ip reservation %+v", resv)
+		}
+	}
+
+	// reserved addresses are only allocated to their workload
+	for _, exp := range []struct {
+		container string
+		workload  WorkloadInfo
+		ipAddr    string
+		ipv6Addr  string
+	}{
+		{"c1", WorkloadInfo{ContainerName: "other"}, "10.1.1.3", "2016:617::1"},
+		{"c2", WorkloadInfo{ContainerName: "/db"}, "10.1.1.1", "2016:617::2"},
+		{"c3", WorkloadInfo{PodName: "db", PodNamespace: "prod"}, "10.1.1.4", "2016:617::10"},
+		{"c4", WorkloadInfo{Labels: map[string]string{"app": "web", "tier": "1"}}, "10.1.1.2", "2016:617::3"},
+	} {
+		epCfg, err := createReservedEP(t, nwCfg, exp.container, exp.workload)
+		if err != nil {
+			t.Fatalf("error creating endpoint %s. Err: %v", exp.container, err)
+		}
+		if epCfg.IPAddress != exp.ipAddr || epCfg.IPv6Address != exp.ipv6Addr {
+			t.Fatalf("endpoint %s got addresses %s/%s, expected %s/%s", exp.container,
+				epCfg.IPAddress, epCfg.IPv6Address, exp.ipAddr, exp.ipv6Addr)
+		}
+	}
+
+	// a reserved address is not shared
+	if _, err := createReservedEP(t, nwCfg, "c5", WorkloadInfo{ContainerName: "db"}); err == nil {
+		t.Fatalf("allocated reserved address in use")
+	}
+	if err := DeleteIPReservation(fakeDriver, "teaone:orange:10.1.1.1"); err == nil {
+		t.Fatalf("deleted ip reservation in use")
+	}
+
+	// released addresses stay reserved
+	if _, err := DeleteEndpointID(fakeDriver, getEpName(nwCfg.ID, &intent.ConfigEP{Container: "c2"})); err != nil {
+		t.Fatalf("error deleting endpoint. Err: %v", err)
+	}
+	epCfg, err := createReservedEP(t, nwCfg, "c6", WorkloadInfo{PodName: "web"})
+	if err != nil || epCfg.IPAddress != "10.1.1.5" {
+		t.Fatalf("unexpected endpoint %+v. Err: %v", epCfg, err)
+	}
+	if _, err := networkAllocAddress(nwCfg, nil, "10.1.1.1", false); err == nil {
+		t.Fatalf("allocated reserved address without a reservation match")
+	}
+
+	// address requests of the workload get the reserved address
+	resv := &mastercfg.CfgIPReservationState{Tenant: "teaone", Network: "orange", IPAddress: "10.1.1.20",
+		Labels: map[string]string{"app": "cache"}}
+	if err := CreateIPReservation(fakeDriver, resv); err != nil {
+		t.Fatalf("error creating ip reservation %+v. Err: %v", resv, err)
+	}
+	if addr := allocAddress(t, WorkloadInfo{PodName: "cache"}); addr != "10.1.1.6/24" {
+		t.Fatalf("allocated address %s, expected 10.1.1.6/24", addr)
+	}
+	if addr := allocAddress(t, WorkloadInfo{Labels: map[string]string{"app": "cache"}}); addr != "10.1.1.20/24" {
+		t.Fatalf("allocated address %s, expected 10.1.1.20/24", addr)
+	}
+	if addr := allocAddress(t, WorkloadInfo{Labels: map[string]string{"app": "cache"}}); addr != "" {
+		t.Fatalf("allocated reserved address %s in use", addr)
+	}
+	if err := networkReleaseAddress(nwCfg, nil, "10.1.1.20"); err != nil {
+		t.Fatalf("error releasing address. Err: %v", err)
+	}
+	if err := DeleteIPReservation(fakeDriver, "teaone:orange:10.1.1.20"); err != nil {
+		t.Fatalf("error deleting ip reservation. Err: %v", err)
+	}
+
+	// requested reserved addresses are claimed by their workload, or by
+	// workloads that are only known when their endpoint joins
+	resv = &mastercfg.CfgIPReservationState{Tenant: "teaone", Network: "orange", IPAddress: "10.1.1.21",
+		ContainerName: "app"}
+	if err := CreateIPReservation(fakeDriver, resv); err != nil {
+		t.Fatalf("error creating ip reservation %+v. Err: %v", resv, err)
+	}
+	if _, err := createRequestedEP(t, nwCfg, "c8", "10.1.1.21", WorkloadInfo{ContainerName: "other"}, false); err == nil {
+		t.Fatalf("allocated requested reserved address without a reservation match")
+	}
+	epCfg, err = createRequestedEP(t, nwCfg, "c8", "10.1.1.21", WorkloadInfo{ContainerName: "app"}, false)
+	if err != nil || epCfg.IPAddress != "10.1.1.21" {
+		t.Fatalf("unexpected endpoint %+v. Err: %v", epCfg, err)
+	}
+	if _, err := createRequestedEP(t, nwCfg, "c9", "10.1.1.21", WorkloadInfo{}, true); err == nil {
+		t.Fatalf("allocated requested reserved address in use")
+	}
+	if _, err := DeleteEndpointID(fakeDriver, epCfg.ID); err != nil {
+		t.Fatalf("error deleting endpoint. Err: %v", err)
+	}
+	epCfg, err = createRequestedEP(t, nwCfg, "c9", "10.1.1.21", WorkloadInfo{}, true)
+	if err != nil || epCfg.IPAddress != "10.1.1.21" {
+		t.Fatalf("unexpected endpoint %+v. Err: %v", epCfg, err)
+	}
+	if err := joinEP(nwCfg, "c9", WorkloadInfo{ContainerName: "other"}); err == nil {
+		t.Fatalf("joined endpoint with an address reserved for another workload")
+	}
+	if err := joinEP(nwCfg, "c9", WorkloadInfo{ContainerName: "/app"}); err != nil {
+		t.Fatalf("error joining endpoint. Err: %v", err)
+	}
+	if _, err := DeleteEndpointID(fakeDriver, epCfg.ID); err != nil {
+		t.Fatalf("error deleting endpoint. Err: %v", err)
+	}
+
+	// workloads with reservations must use their reserved addresses
+	epCfg, err = createRequestedEP(t, nwCfg, "c10", "", WorkloadInfo{}, true)
+	if err != nil || isReservedIP(nwCfg, epCfg.IPAddress) {
+		t.Fatalf("unexpected endpoint %+v. Err: %v", epCfg, err)
+	}
+	if err := joinEP(nwCfg, "c10", WorkloadInfo{ContainerName: "app"}); err == nil {
+		t.Fatalf("joined endpoint without the address reserved for the workload")
+	}
+	if err := joinEP(nwCfg, "c10", WorkloadInfo{ContainerName: "other"}); err != nil {
+		t.Fatalf("error joining endpoint. Err: %v", err)
+	}
+	if _, err := DeleteEndpointID(fakeDriver, epCfg.ID); err != nil {
+		t.Fatalf("error deleting endpoint. Err: %v", err)
+	}
+	if err := DeleteIPReservation(fakeDriver, "teaone:orange:10.1.1.21"); err != nil {
+		t.Fatalf("error deleting ip reservation. Err: %v", err)
+	}
+
+	// addresses of deleted reservations are free
+	if err := DeleteNetworkID(fakeDriver, nwCfg.ID); err == nil {
+		t.Fatalf("deleted network with ip reservations")
+	}
+	if err := DeleteIPReservation(fakeDriver, "teaone:orange:10.1.1.1"); err != nil {
+		t.Fatalf("error deleting ip reservation. Err: %v", err)
+	}
+	epCfg, err = createReservedEP(t, nwCfg, "c7", WorkloadInfo{ContainerName: "db"})
+	if err != nil || epCfg.IPAddress != "10.1.1.1" {
+		t.Fatalf("unexpected endpoint %+v. Err: %v", epCfg, err)
+	}
+
+	resvList, err := ListIPReservations(fakeDriver, "teaone", "orange")
+	if err != nil || len(resvList) != 2 {
+		t.Fatalf("unexpected ip reservations %+v. Err: %v", resvList, err)
+	}
+}
+
+// ageQuarantine moves the release time of the quarantined network addresses
+// back by a duration
+func ageQuarantine(t *testing.T, nwCfg *mastercfg.CfgNetworkState, age time.Duration) {
+	if err := nwCfg.Read(nwCfg.ID); err != nil {
+		t.Fatalf("unable to locate network: %s", nwCfg.ID)
+	}
+	for i := range nwCfg.Quarantine {
+		nwCfg.Quarantine[i].ReleasedAt = nwCfg.Quarantine[i].ReleasedAt.Add(-age)
+	}
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network. Err: %v", err)
+	}
+}
+
+func TestAddressQuarantine(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                      : "teaone",
+        "Networks"  : [{
+            "Name"                : "orange",
+            "SubnetCIDR"          : "10.1.1.0/29",
+            "Gateway"             : "10.1.1.6",
+            "IPv6SubnetCIDR"      : "2016:0617::/120",
+            "AddrQuarantineTime"  : 60
+        }]
+    }]}`)
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read("orange.teaone"); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+
+	allocAddrs := func(exp ...string) {
+		for _, expAddr := range exp {
+			ipAddr, err := networkAllocAddress(nwCfg, nil, "", strings.Contains(expAddr, ":"))
+			if err != nil || ipAddr != expAddr {
+				t.Fatalf("allocated address %s, expected %s. Err: %v", ipAddr, expAddr, err)
+			}
+		}
+	}
+	releaseAddrs := func(addrs ...string) {
+		for _, ipAddr := range addrs {
+			if err := networkReleaseAddress(nwCfg, nil, ipAddr); err != nil {
+				t.Fatalf("error releasing address %s. Err: %v", ipAddr, err)
+			}
+		}
+	}
+
+	// released addresses are held back for the quarantine time
+	allocAddrs("10.1.1.1", "10.1.1.2", "2016:617::1")
+	releaseAddrs("10.1.1.1", "2016:617::1", "10.1.1.1")
+	allocAddrs("10.1.1.3", "2016:617::2")
+	if nwCfg.EpAddrCount != 3 {
+		t.Fatalf("unexpected address count %d", nwCfg.EpAddrCount)
+	}
+	if quarantined := ListQuarantinedIPs(nwCfg); quarantined != "10.1.1.1, 2016:617::1" {
+		t.Fatalf("unexpected quarantined addresses %q", quarantined)
+	}
+	if available := ListAvailableIPs(nwCfg); available != "10.1.1.4-10.1.1.5" {
+		t.Fatalf("unexpected available addresses %q", available)
+	}
+	if allocated := ListAllocatedIPs(nwCfg); allocated != "10.1.1.2-10.1.1.3, 10.1.1.6" {
+		t.Fatalf("unexpected allocated addresses %q", allocated)
+	}
+
+	ageQuarantine(t, nwCfg, time.Minute)
+	if available := ListAvailableIPs(nwCfg); available != "10.1.1.1, 10.1.1.4-10.1.1.5" {
+		t.Fatalf("unexpected available addresses %q", available)
+	}
+	allocAddrs("10.1.1.1", "2016:617::1")
+	if len(nwCfg.Quarantine) != 0 {
+		t.Fatalf("unexpected quarantine %+v", nwCfg.Quarantine)
+	}
+
+	// with a quarantine count, addresses are held back until enough other
+	// addresses were released
+	network := intent.ConfigNetwork{
+		Name:                "orange",
+		SubnetCIDR:          "10.1.1.0/29",
+		Gateway:             "10.1.1.6",
+		IPv6SubnetCIDR:      "2016:0617::/120",
+		AddrQuarantineCount: 2,
+	}
+	if err := UpdateNetwork(network, fakeDriver, "teaone"); err != nil {
+		t.Fatalf("error updating network. Err: %v", err)
+	}
+	if err := nwCfg.Read(nwCfg.ID); err != nil {
+		t.Fatalf("unable to locate network: %s", nwCfg.ID)
+	}
+	releaseAddrs("10.1.1.2", "10.1.1.3")
+	allocAddrs("10.1.1.4")
+	releaseAddrs("10.1.1.1")
+	allocAddrs("10.1.1.2")
+	if quarantined := ListQuarantinedIPs(nwCfg); quarantined != "10.1.1.3, 10.1.1.1" {
+		t.Fatalf("unexpected quarantined addresses %q", quarantined)
+	}
+
+	// requested addresses are handed out, and quarantined addresses are
+	// used when the subnet is exhausted
+	if ipAddr, err := networkAllocAddress(nwCfg, nil, "10.1.1.3", false); err != nil || ipAddr != "10.1.1.3" {
+		t.Fatalf("error allocating requested address. Err: %v", err)
+	}
+	allocAddrs("10.1.1.5", "10.1.1.1")
+	if _, err := networkAllocAddress(nwCfg, nil, "", false); err == nil {
+		t.Fatalf("allocated address from an exhausted subnet")
+	}
+}
+
+func checkIPAMIssues(t *testing.T, repair bool, expIssues ...string) {
+	issues, err := CheckIPAM(fakeDriver, "teaone", "orange", repair)
+	if err != nil {
+		t.Fatalf("error checking ipam. Err: %v", err)
+	}
+
+	found := []string{}
+	for _, issue := range issues {
+		group := issue.EndpointGroup
+		if issue.ServiceIPPool {
+			group = "service"
+		}
+		found = append(found, fmt.Sprintf("%s/%s/%s/%v", group, issue.IPAddress,
+			issue.Problem, issue.Repaired))
+	}
+	if fmt.Sprint(found) != fmt.Sprint(expIssues) {
+		t.Fatalf("found ipam issues %v, expected %v", found, expIssues)
+	}
+}
+
+func TestIPAMCheck(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                      : "teaone",
+        "Networks"  : [{
+            "Name"                : "orange",
+            "SubnetCIDR"          : "10.1.1.0/24",
+            "Gateway"             : "10.1.1.254",
+            "IPv6SubnetCIDR"      : "2016:0617::/120"
+        }]
+    }]}`)
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+	if err := CreateEndpointGroup("teaone", "orange", "10.1.1.10-10.1.1.20", "", "epgA"); err != nil {
+		t.Fatalf("error creating epg. Err: %v", err)
+	}
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	nwCfg.ID = "orange.teaone"
+	epgCfg := &mastercfg.EndpointGroupState{}
+	epgCfg.StateDriver = fakeDriver
+	if err := epgCfg.Read(mastercfg.GetEndpointGroupKey("epgA", "teaone")); err != nil {
+		t.Fatalf("unable to locate epg. Err: %v", err)
+	}
+
+	for _, container := range []string{"c1", "c2"} {
+		if _, err := createReservedEP(t, nwCfg, container, WorkloadInfo{}); err != nil {
+			t.Fatalf("error creating endpoint %s. Err: %v", container, err)
+		}
+	}
+	checkIPAMIssues(t, false)
+
+	// addresses without endpoints leak, released addresses of endpoints
+	// are unallocated and addresses used twice are double allocated
+	if _, err := networkAllocAddress(nwCfg, nil, "", false); err != nil {
+		t.Fatalf("error allocating address. Err: %v", err)
+	}
+	if _, err := networkAllocAddress(nwCfg, epgCfg, "", false); err != nil {
+		t.Fatalf("error allocating address. Err: %v", err)
+	}
+	if err := networkReleaseAddress(nwCfg, nil, "2016:617::2"); err != nil {
+		t.Fatalf("error releasing address. Err: %v", err)
+	}
+	svcState := &mastercfg.CfgServiceLBState{
+		ServiceName: "svc",
+		Tenant:      "teaone",
+		Network:     "orange",
+		IPAddress:   "10.1.1.1",
+	}
+	svcState.ID = GetServiceID("svc", "teaone")
+	svcState.StateDriver = fakeDriver
+	if err := svcState.Write(); err != nil {
+		t.Fatalf("error writing service lb. Err: %v", err)
+	}
+
+	checkIPAMIssues(t, false, "/10.1.1.1/double-allocated/false", "/10.1.1.3/leaked/false",
+		"/2016:617::2/unallocated/false", "epgA/10.1.1.10/leaked/false")
+	checkIPAMIssues(t, true, "/10.1.1.1/double-allocated/false", "/10.1.1.3/leaked/true",
+		"/2016:617::2/unallocated/true", "epgA/10.1.1.10/leaked/true")
+	checkIPAMIssues(t, false, "/10.1.1.1/double-allocated/false")
+
+	if err := nwCfg.Read(nwCfg.ID); err != nil {
+		t.Fatalf("unable to locate network: %s", nwCfg.ID)
+	}
+	if allocated := ListAllocatedIPs(nwCfg); allocated != "10.1.1.1-10.1.1.2, 10.1.1.10-10.1.1.20, 10.1.1.254" {
+		t.Fatalf("unexpected allocated addresses %q", allocated)
+	}
+	if !nwCfg.IPv6Alloc.IsAllocated("2016:617::2") || nwCfg.EpAddrCount != 4 {
+		t.Fatalf("unexpected network state %+v", nwCfg)
+	}
+
+	// an address taken by an endpoint after the check is not freed
+	if _, err := networkAllocAddress(nwCfg, nil, "10.1.1.3", false); err != nil {
+		t.Fatalf("error allocating address. Err: %v", err)
+	}
+	issues, err := CheckIPAM(fakeDriver, "teaone", "orange", false)
+	if err != nil || len(issues) != 2 || issues[1].IPAddress != "10.1.1.3" || issues[1].Problem != IPAMLeaked {
+		t.Fatalf("unexpected ipam issues %+v. Err: %v", issues, err)
+	}
+	epCfg := &mastercfg.CfgEndpointState{NetID: nwCfg.ID, IPAddress: "10.1.1.3"}
+	epCfg.ID = getEpName(nwCfg.ID, &intent.ConfigEP{Container: "c3"})
+	epCfg.StateDriver = fakeDriver
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("error writing endpoint. Err: %v", err)
+	}
+	if err := repairNetworkIPAM(fakeDriver, nwCfg, issues); err != nil || issues[1].Repaired {
+		t.Fatalf("repaired address in use %+v. Err: %v", issues[1], err)
+	}
+	checkIPAMIssues(t, false, "/10.1.1.1/double-allocated/false")
+}
+
+func TestEPGMultiplePools(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                      : "teaone",
+        "Networks"  : [{
+            "Name"                : "orange",
+            "SubnetCIDR"          : "10.1.1.0/24",
+            "Gateway"             : "10.1.1.254"
+        }]
+    }]}`)
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+	if err := CreateEndpointGroup("teaone", "orange", "10.1.1.30-10.1.1.35,10.1.1.34-10.1.1.40", "", "epgA"); err == nil {
+		t.Fatalf("created epg with overlapping ip pools")
+	}
+	if err := CreateEndpointGroup("teaone", "orange", "10.1.1.20-10.1.1.21,10.1.1.10", "", "epgA"); err != nil {
+		t.Fatalf("error creating epg. Err: %v", err)
+	}
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read("orange.teaone"); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	epgCfg := &mastercfg.EndpointGroupState{}
+	epgCfg.StateDriver = fakeDriver
+	epgKey := mastercfg.GetEndpointGroupKey("epgA", "teaone")
+	if err := epgCfg.Read(epgKey); err != nil {
+		t.Fatalf("unable to locate epg. Err: %v", err)
+	}
+
+	// pools are used in order
+	for _, expAddr := range []string{"10.1.1.20", "10.1.1.21", "10.1.1.10"} {
+		ipAddress, err := networkAllocAddress(nwCfg, epgCfg, "", false)
+		if err != nil || ipAddress != expAddr {
+			t.Fatalf("allocated address %s, expected %s. Err: %v", ipAddress, expAddr, err)
+		}
+	}
+	if ipAddress, err := networkAllocAddress(nwCfg, epgCfg, "", false); err == nil {
+		t.Fatalf("allocated address %s from an exhausted pool", ipAddress)
+	}
+	if _, err := networkAllocAddress(nwCfg, nil, "", false); err != nil {
+		t.Fatalf("error allocating network address. Err: %v", err)
+	}
+
+	// ranges can be added while the epg has endpoints, but not over
+	// addresses in use in the network
+	if err := UpdateEndpointGroupPool("teaone", "epgA", "10.1.1.20-10.1.1.21,10.1.1.10,10.1.1.1-10.1.1.2"); err == nil {
+		t.Fatalf("added an ip pool range with addresses in use")
+	}
+	if err := UpdateEndpointGroupPool("teaone", "epgA", "10.1.1.20-10.1.1.21,10.1.1.10,10.1.1.30-10.1.1.31"); err != nil {
+		t.Fatalf("error adding ip pool range. Err: %v", err)
+	}
+	if ipAddress, err := networkAllocAddress(nwCfg, epgCfg, "", false); err != nil || ipAddress != "10.1.1.30" {
+		t.Fatalf("allocated address %s, expected 10.1.1.30. Err: %v", ipAddress, err)
+	}
+	if usage := strings.Join(ListEPGPoolUsage(nwCfg, epgCfg), "; "); usage != "10.1.1.20-10.1.1.21: 2 allocated, 0 available; "+
+		"10.1.1.10-10.1.1.10: 1 allocated, 0 available; 10.1.1.30-10.1.1.31: 1 allocated, 1 available" {
+		t.Fatalf("unexpected pool usage %q", usage)
+	}
+
+	// ranges can only be removed when their addresses are not in use
+	if err := PreviewEndpointGroupPool("teaone", "epgA", "10.1.1.20-10.1.1.21,10.1.1.30-10.1.1.31"); err == nil {
+		t.Fatalf("removal of an ip pool range in use passed validation")
+	}
+	if err := UpdateEndpointGroupPool("teaone", "epgA", "10.1.1.20-10.1.1.21,10.1.1.30-10.1.1.31"); err == nil {
+		t.Fatalf("removed an ip pool range in use")
+	}
+
+	// ranges reserved for a failed update are released
+	if err := UpdateEndpointGroupPool("teaone", "epgA", "10.1.1.20-10.1.1.21,10.1.1.30-10.1.1.31,10.1.1.40"); err == nil {
+		t.Fatalf("removed an ip pool range in use")
+	}
+	if err := nwCfg.Read(nwCfg.ID); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	if allocated := ListAllocatedIPs(nwCfg); allocated != "10.1.1.1, 10.1.1.10, 10.1.1.20-10.1.1.21, 10.1.1.30-10.1.1.31, 10.1.1.254" {
+		t.Fatalf("unexpected network allocated addresses %q", allocated)
+	}
+	if err := networkReleaseAddress(nwCfg, epgCfg, "10.1.1.10"); err != nil {
+		t.Fatalf("error releasing address. Err: %v", err)
+	}
+	if err := UpdateEndpointGroupPool("teaone", "epgA", "10.1.1.20-10.1.1.21,10.1.1.30-10.1.1.31"); err != nil {
+		t.Fatalf("error removing ip pool range. Err: %v", err)
+	}
+
+	if err := nwCfg.Read(nwCfg.ID); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	if err := epgCfg.Read(epgKey); err != nil {
+		t.Fatalf("unable to locate epg. Err: %v", err)
+	}
+	if allocated := ListAllocatedIPs(nwCfg); allocated != "10.1.1.1, 10.1.1.20-10.1.1.21, 10.1.1.30-10.1.1.31, 10.1.1.254" {
+		t.Fatalf("unexpected network allocated addresses %q", allocated)
+	}
+	if allocated := ListEPGAllocatedIPs(nwCfg, epgCfg); allocated != "10.1.1.20-10.1.1.21, 10.1.1.30" {
+		t.Fatalf("unexpected epg allocated addresses %q", allocated)
+	}
+	if available := ListEPGAvailableIPs(nwCfg, epgCfg); available != "10.1.1.31" {
+		t.Fatalf("unexpected epg available addresses %q", available)
+	}
+
+	// epg addresses the network doesn't count go back to the epg pool
+	savedNw := *nwCfg
+	if err := nwCfg.Clear(); err != nil {
+		t.Fatalf("error clearing network. Err: %v", err)
+	}
+	if ipAddress, err := networkAllocAddress(nwCfg, epgCfg, "", false); err == nil {
+		t.Fatalf("allocated address %s without a network", ipAddress)
+	}
+	*nwCfg = savedNw
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network. Err: %v", err)
+	}
+	if err := epgCfg.Read(epgKey); err != nil {
+		t.Fatalf("unable to locate epg. Err: %v", err)
+	}
+	if available := ListEPGAvailableIPs(nwCfg, epgCfg); available != "10.1.1.31" {
+		t.Fatalf("unexpected epg available addresses %q after a failed allocation", available)
+	}
+
+	if err := DeleteEndpointGroup("teaone", "epgA"); err != nil {
+		t.Fatalf("error deleting epg. Err: %v", err)
+	}
+	if err := nwCfg.Read(nwCfg.ID); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	if allocated := ListAllocatedIPs(nwCfg); allocated != "10.1.1.1, 10.1.1.254" {
+		t.Fatalf("unexpected network allocated addresses %q", allocated)
+	}
+}
+
+func requestAddrBlock(t *testing.T, host, networkID string) []string {
+	reqBytes, err := json.Marshal(AddrBlockRequest{Host: host, NetworkID: networkID})
+	if err != nil {
+		t.Fatalf("error encoding address block request. Err: %v", err)
+	}
+	req := httptest.NewRequest("POST", "/plugin/allocAddrBlock", bytes.NewReader(reqBytes))
+	resp, err := AllocAddrBlockHandler(httptest.NewRecorder(), req, nil)
+	if err != nil {
+		return nil
+	}
+	return resp.(AddrBlockResponse).Addresses
+}
+
+func TestAddrBlocks(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                      : "teaone",
+        "Networks"  : [{
+            "Name"                : "orange",
+            "SubnetCIDR"          : "10.1.1.0/28",
+            "Gateway"             : "10.1.1.14"
+        }]
+    }]}`)
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+	if err := CreateEndpointGroup("teaone", "orange", "10.1.1.8-10.1.1.10", "", "epgA"); err != nil {
+		t.Fatalf("error creating epg. Err: %v", err)
+	}
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	readNetwork := func() {
+		if err := nwCfg.Read("orange.teaone"); err != nil {
+			t.Fatalf("unable to locate network. Err: %v", err)
+		}
+	}
+
+	// hosts allocate from their blocks, released addresses go back to the
+	// block of the host
+	if addrs := requestAddrBlock(t, "host1", "orange.teaone"); len(addrs) != 10 || addrs[0] != "10.1.1.1" {
+		t.Fatalf("unexpected address block %v", addrs)
+	}
+	if addrs := requestAddrBlock(t, "host1", "orange:epgA.teaone"); strings.Join(addrs, ",") != "10.1.1.8,10.1.1.9,10.1.1.10" {
+		t.Fatalf("unexpected epg address block %v", addrs)
+	}
+	ipAddress, free, err := AllocBlockAddress(fakeDriver, "host1", "orange.teaone")
+	if err != nil || ipAddress != "10.1.1.1/28" || free != 9 {
+		t.Fatalf("allocated %s with %d free addresses, expected 10.1.1.1/28. Err: %v", ipAddress, free, err)
+	}
+	if ipAddress, _, err := AllocBlockAddress(fakeDriver, "host1", "orange:epgA.teaone"); err != nil || ipAddress != "10.1.1.8/28" {
+		t.Fatalf("allocated %s, expected 10.1.1.8/28. Err: %v", ipAddress, err)
+	}
+	if ipAddress, _, err := AllocBlockAddress(fakeDriver, "host2", "orange.teaone"); err != nil || ipAddress != "" {
+		t.Fatalf("allocated %s from a host without a block. Err: %v", ipAddress, err)
+	}
+
+	readNetwork()
+	if count := NetworkAllocatedCount(nwCfg); count != 2 {
+		t.Fatalf("unexpected allocated address count %d", count)
+	}
+	if allocated := ListAllocatedIPs(nwCfg); allocated != "10.1.1.1, 10.1.1.8-10.1.1.10, 10.1.1.14" {
+		t.Fatalf("unexpected network allocated addresses %q", allocated)
+	}
+	if issues, err := CheckIPAM(fakeDriver, "teaone", "", false); err != nil || len(issues) != 2 {
+		t.Fatalf("unexpected ipam issues %+v. Err: %v", issues, err)
+	}
+
+	if err := networkReleaseAddress(nwCfg, nil, "10.1.1.1"); err != nil {
+		t.Fatalf("error releasing address. Err: %v", err)
+	}
+	readNetwork()
+	if count := NetworkAllocatedCount(nwCfg); count != 1 {
+		t.Fatalf("unexpected allocated address count %d", count)
+	}
+	if allocated := ListAllocatedIPs(nwCfg); allocated != "10.1.1.8-10.1.1.10, 10.1.1.14" {
+		t.Fatalf("unexpected network allocated addresses %q", allocated)
+	}
+
+	// free addresses of other hosts are reclaimed when the network runs out
+	if addrs := requestAddrBlock(t, "host2", "orange.teaone"); len(addrs) != AddrBlockLowWater {
+		t.Fatalf("unexpected address block %v", addrs)
+	}
+	if ipAddress, _, err := AllocBlockAddress(fakeDriver, "host2", "orange.teaone"); err != nil || ipAddress == "" {
+		t.Fatalf("error allocating address. Err: %v", err)
+	}
+	if ipAddress, _, err := AllocBlockAddress(fakeDriver, "host1", "orange.teaone"); err != nil || ipAddress != "" {
+		t.Fatalf("allocated %s from a reclaimed block. Err: %v", ipAddress, err)
+	}
+
+	// removed hosts return their free addresses
+	if err := ReleaseHostAddrBlocks(fakeDriver, "host2"); err != nil {
+		t.Fatalf("error releasing address blocks. Err: %v", err)
+	}
+	readNetwork()
+	if count := NetworkAllocatedCount(nwCfg); count != 2 {
+		t.Fatalf("unexpected allocated address count %d", count)
+	}
+	if available := ListAvailableIPs(nwCfg); available != "10.1.1.2-10.1.1.7, 10.1.1.11-10.1.1.13" {
+		t.Fatalf("unexpected available addresses %q", available)
+	}
+
+	// addresses of quarantined networks are not delegated
+	nwCfg.AddrQuarantineTime = 60
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network. Err: %v", err)
+	}
+	if _, _, err := AllocBlockAddress(fakeDriver, "host2", "orange.teaone"); err != ErrAddrsNotDelegated {
+		t.Fatalf("allocated address from a quarantined network. Err: %v", err)
+	}
+	if addrs := requestAddrBlock(t, "host1", "orange.teaone"); addrs != nil {
+		t.Fatalf("delegated addresses %v of a quarantined network", addrs)
+	}
+}
+
+func TestBlockEndpoints(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                      : "teaone",
+        "Networks"  : [{
+            "Name"                : "orange",
+            "SubnetCIDR"          : "10.1.1.0/28",
+            "Gateway"             : "10.1.1.14"
+        }]
+    }]}`)
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+	if err := CreateEndpointGroup("teaone", "orange", "10.1.1.8-10.1.1.10", "", "epgA"); err != nil {
+		t.Fatalf("error creating epg. Err: %v", err)
+	}
+	epReq := func(container, ipAddress string) *CreateEndpointRequest {
+		return &CreateEndpointRequest{
+			TenantName:  "teaone",
+			NetworkName: "orange",
+			ServiceName: "epgA",
+			ConfigEP: intent.ConfigEP{
+				Container:   container,
+				Host:        "host1",
+				IPAddress:   ipAddress,
+				ServiceName: "epgA",
+			},
+		}
+	}
+
+	// endpoints are created by netmaster without a block
+	if _, err := CreateBlockEndpoint(fakeDriver, "host1", epReq("c1", "")); err != ErrAddrBlockEmpty {
+		t.Fatalf("created endpoint without an address block. Err: %v", err)
+	}
+	requestAddrBlock(t, "host1", "orange:epgA.teaone")
+
+	// hosts create endpoints with the addresses of their block
+	epCfg, err := CreateBlockEndpoint(fakeDriver, "host1", epReq("c1", ""))
+	if err != nil {
+		t.Fatalf("error creating endpoint. Err: %v", err)
+	}
+	if epCfg.IPAddress != "10.1.1.8" || epCfg.MacAddress != "02:02:0a:01:01:08" ||
+		epCfg.EndpointGroupKey != "epgA:teaone" || epCfg.HomingHost != "host1" {
+		t.Fatalf("unexpected endpoint state %+v", epCfg)
+	}
+	if epCfg, err := CreateBlockEndpoint(fakeDriver, "host1", epReq("c1", "")); err != nil || epCfg.IPAddress != "10.1.1.8" {
+		t.Fatalf("endpoint was created again %+v. Err: %v", epCfg, err)
+	}
+
+	// requested addresses must be allocated from the block of the host
+	if _, err := CreateBlockEndpoint(fakeDriver, "host1", epReq("c2", "10.1.1.9")); err != ErrAddrsNotDelegated {
+		t.Fatalf("created endpoint with an address not allocated from the block. Err: %v", err)
+	}
+	if _, _, err := AllocBlockAddress(fakeDriver, "host1", "orange:epgA.teaone"); err != nil {
+		t.Fatalf("error allocating address. Err: %v", err)
+	}
+	if epCfg, err := CreateBlockEndpoint(fakeDriver, "host1", epReq("c2", "10.1.1.9")); err != nil || epCfg.IPAddress != "10.1.1.9" {
+		t.Fatalf("unexpected endpoint state %+v. Err: %v", epCfg, err)
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read("orange.teaone"); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	epgCfg := &mastercfg.EndpointGroupState{}
+	epgCfg.StateDriver = fakeDriver
+	if err := epgCfg.Read("epgA:teaone"); err != nil {
+		t.Fatalf("unable to locate epg. Err: %v", err)
+	}
+	if nwCfg.EpCount != 2 || epgCfg.EpCount != 2 {
+		t.Fatalf("unexpected endpoint counts %d, %d", nwCfg.EpCount, epgCfg.EpCount)
+	}
+
+	// deleted endpoints return their address to the block
+	if _, err := DeleteEndpointID(fakeDriver, epCfg.ID); err != nil {
+		t.Fatalf("error deleting endpoint. Err: %v", err)
+	}
+	if free := AddrBlockFreeCount(fakeDriver, "host1", "orange:epgA.teaone"); free != 2 {
+		t.Fatalf("unexpected free addresses %d in the block", free)
+	}
+
+	// networks with reserved addresses are left to netmaster
+	nwCfg.ReservedIPs = map[string]bool{"10.1.1.5": false}
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("error writing network. Err: %v", err)
+	}
+	if _, err := CreateBlockEndpoint(fakeDriver, "host1", epReq("c3", "")); err != ErrAddrsNotDelegated {
+		t.Fatalf("created endpoint in a network with reserved addresses. Err: %v", err)
+	}
+}
+
+func TestServiceIPPool(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                      : "teaone",
+        "Networks"  : [{
+            "Name"                : "orange",
+            "SubnetCIDR"          : "10.1.1.0/24",
+            "Gateway"             : "10.1.1.254",
+            "ServiceIPPool"       : "10.1.1.200-10.1.1.201,10.1.1.210"
+        }]
+    }]}`)
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read("orange.teaone"); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+
+	// epg pools can not overlap the service ip pool
+	if err := CreateEndpointGroup("teaone", "orange", "10.1.1.195-10.1.1.200", "", "epgA"); err == nil {
+		t.Fatalf("created epg with an ip pool overlapping the service ip pool")
+	}
+
+	// services get addresses of the pool, endpoints get the others
+	for _, expAddr := range []string{"10.1.1.200", "10.1.1.201", "10.1.1.210"} {
+		if ipAddress, err := serviceAllocAddress(nwCfg, ""); err != nil || ipAddress != expAddr {
+			t.Fatalf("allocated service address %s, expected %s. Err: %v", ipAddress, expAddr, err)
+		}
+	}
+	if ipAddress, err := serviceAllocAddress(nwCfg, ""); err == nil {
+		t.Fatalf("allocated service address %s from an exhausted pool", ipAddress)
+	}
+	if ipAddress, err := serviceAllocAddress(nwCfg, "10.1.1.50"); err != nil || ipAddress != "10.1.1.50" {
+		t.Fatalf("allocated service address %s, expected 10.1.1.50. Err: %v", ipAddress, err)
+	}
+	if ipAddress, err := networkAllocAddress(nwCfg, nil, "", false); err != nil || ipAddress != "10.1.1.1" {
+		t.Fatalf("allocated address %s, expected 10.1.1.1. Err: %v", ipAddress, err)
+	}
+
+	if err := serviceReleaseAddress(nwCfg, "10.1.1.201"); err != nil {
+		t.Fatalf("error releasing service address. Err: %v", err)
+	}
+	if err := nwCfg.Read(nwCfg.ID); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	if allocated := ListServiceAllocatedIPs(nwCfg); allocated != "10.1.1.200, 10.1.1.210" {
+		t.Fatalf("unexpected allocated service addresses %q", allocated)
+	}
+	if available := ListServiceAvailableIPs(nwCfg); available != "10.1.1.201" {
+		t.Fatalf("unexpected available service addresses %q", available)
+	}
+	checkIPAMIssues(t, false, "/10.1.1.1/leaked/false", "/10.1.1.50/leaked/false",
+		"service/10.1.1.200/leaked/false", "service/10.1.1.210/leaked/false")
+
+	// the pool can only change to ranges that hold the service addresses
+	network := intent.ConfigNetwork{
+		Name:          "orange",
+		SubnetCIDR:    "10.1.1.0/24",
+		Gateway:       "10.1.1.254",
+		ServiceIPPool: "10.1.1.200-10.1.1.209",
+	}
+	if err := UpdateNetwork(network, fakeDriver, "teaone"); err == nil {
+		t.Fatalf("removed a service ip pool range in use")
+	}
+	network.ServiceIPPool = "10.1.1.1-10.1.1.2,10.1.1.200-10.1.1.210"
+	if err := UpdateNetwork(network, fakeDriver, "teaone"); err == nil {
+		t.Fatalf("added a service ip pool range with addresses in use")
+	}
+	network.ServiceIPPool = "10.1.1.200-10.1.1.220"
+	if err := UpdateNetwork(network, fakeDriver, "teaone"); err != nil {
+		t.Fatalf("error updating service ip pool. Err: %v", err)
+	}
+	if err := nwCfg.Read(nwCfg.ID); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	if allocated := ListServiceAllocatedIPs(nwCfg); allocated != "10.1.1.200, 10.1.1.210" {
+		t.Fatalf("unexpected allocated service addresses %q", allocated)
+	}
+	if available := ListAvailableIPs(nwCfg); available != "10.1.1.2-10.1.1.49, 10.1.1.51-10.1.1.199, 10.1.1.221-10.1.1.253" {
+		t.Fatalf("unexpected available addresses %q", available)
+	}
+
+	// service addresses in the pool are checked against the pool allocator
+	svcState := &mastercfg.CfgServiceLBState{
+		ServiceName: "svc",
+		Tenant:      "teaone",
+		Network:     "orange",
+		IPAddress:   "10.1.1.201",
+	}
+	svcState.ID = GetServiceID("svc", "teaone")
+	svcState.StateDriver = fakeDriver
+	if err := svcState.Write(); err != nil {
+		t.Fatalf("error writing service lb. Err: %v", err)
+	}
+	checkIPAMIssues(t, true, "/10.1.1.1/leaked/true", "/10.1.1.50/leaked/true", "service/10.1.1.200/leaked/true",
+		"service/10.1.1.201/unallocated/true", "service/10.1.1.210/leaked/true")
+	checkIPAMIssues(t, false)
+	if err := nwCfg.Read(nwCfg.ID); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	if allocated := ListServiceAllocatedIPs(nwCfg); allocated != "10.1.1.201" {
+		t.Fatalf("unexpected allocated service addresses %q", allocated)
+	}
+}
+
+func TestNetworkUpdateRestore(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                      : "teaone",
+        "Networks"  : [{
+            "Name"                : "orange",
+            "SubnetCIDR"          : "10.1.1.0/25",
+            "Gateway"             : "10.1.1.1"
+        }]
+    }]}`)
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+	if err := CreateEndpointGroup("teaone", "orange", "10.1.1.10-10.1.1.11", "", "epgA"); err != nil {
+		t.Fatalf("error creating epg. Err: %v", err)
+	}
+	prevCfg := &mastercfg.CfgNetworkState{}
+	prevCfg.StateDriver = fakeDriver
+	if err := prevCfg.Read("orange.teaone"); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+
+	network := intent.ConfigNetwork{
+		Name:       "orange",
+		SubnetCIDR: "10.1.1.0/24",
+		Gateway:    "10.1.1.254",
+	}
+	if err := UpdateNetwork(network, fakeDriver, "teaone"); err != nil {
+		t.Fatalf("error updating network. Err: %v", err)
+	}
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read("orange.teaone"); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	epgCfg := &mastercfg.EndpointGroupState{}
+	epgCfg.StateDriver = fakeDriver
+	if err := epgCfg.Read(mastercfg.GetEndpointGroupKey("epgA", "teaone")); err != nil {
+		t.Fatalf("unable to locate epg. Err: %v", err)
+	}
+
+	// addresses allocated after the update are kept by the rollback
+	if ipAddress, err := networkAllocAddress(nwCfg, epgCfg, "", false); err != nil || ipAddress != "10.1.1.10" {
+		t.Fatalf("allocated address %s, expected 10.1.1.10. Err: %v", ipAddress, err)
+	}
+	if ipAddress, err := networkAllocAddress(nwCfg, nil, "", false); err != nil || ipAddress != "10.1.1.1" {
+		t.Fatalf("allocated address %s, expected 10.1.1.1. Err: %v", ipAddress, err)
+	}
+	restoreNetworkUpdate(prevCfg, nwCfg, nil, nil, false)
+	if err := nwCfg.Read(nwCfg.ID); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	if nwCfg.SubnetLen != 24 || nwCfg.Gateway != "10.1.1.254" {
+		t.Fatalf("restored network with the old gateway in use, subnet len %d gateway %s",
+			nwCfg.SubnetLen, nwCfg.Gateway)
+	}
+
+	if err := networkReleaseAddress(nwCfg, nil, "10.1.1.1"); err != nil {
+		t.Fatalf("error releasing address. Err: %v", err)
+	}
+	restoreNetworkUpdate(prevCfg, nwCfg, nil, []*mastercfg.EndpointGroupState{epgCfg}, false)
+	if err := nwCfg.Read(nwCfg.ID); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	if nwCfg.SubnetLen != 25 || nwCfg.Gateway != "10.1.1.1" {
+		t.Fatalf("unexpected restored subnet len %d gateway %s", nwCfg.SubnetLen, nwCfg.Gateway)
+	}
+	if allocated := ListEPGAllocatedIPs(nwCfg, epgCfg); allocated != "10.1.1.10" {
+		t.Fatalf("unexpected allocated epg addresses %q", allocated)
+	}
+	if available := ListAvailableIPs(nwCfg); available != "10.1.1.2-10.1.1.9, 10.1.1.12-10.1.1.126" {
+		t.Fatalf("unexpected available addresses %q", available)
+	}
+}
+
+func TestTenantTagRanges(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                      : "teaone",
+        "VLANs"                     : "100-101",
+        "VXLANs"                    : "5001-5002",
+        "Networks"  : [{
+            "Name"                : "orange",
+            "PktTagType"          : "vlan",
+            "SubnetCIDR"          : "10.1.1.0/24",
+            "Gateway"             : "10.1.1.254"
+        },
+        {
+            "Name"                : "purple",
+            "PktTagType"          : "vxlan",
+            "SubnetCIDR"          : "10.1.2.0/24",
+            "Gateway"             : "10.1.2.254"
+        }]
+    },
+    {
+        "Name"                      : "teatwo",
+        "Networks"  : [{
+            "Name"                : "green",
+            "PktTagType"          : "vlan",
+            "SubnetCIDR"          : "10.1.3.0/24",
+            "Gateway"             : "10.1.3.254"
+        }]
+    }]}`)
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	// networks of a tenant with a range allocate from it, other networks
+	// allocate outside of the tenant ranges
+	for networkID, expTag := range map[string]int{"orange.teaone": 100, "purple.teaone": 5001, "green.teatwo": 1} {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = fakeDriver
+		if err := nwCfg.Read(networkID); err != nil {
+			t.Fatalf("unable to locate network %s. Err: %v", networkID, err)
+		}
+		if tag := pktTagOf(nwCfg.PktTagType, nwCfg.PktTag, nwCfg.ExtPktTag); tag != uint(expTag) {
+			t.Fatalf("network %s has pkt tag %d, expected %d", networkID, tag, expTag)
+		}
+	}
+
+	// tenant ranges must be within the global ranges, can't overlap and
+	// can't include tags of networks of other tenants
+	for _, tenant := range []intent.ConfigTenant{
+		{Name: "teathree", VLANs: "101-110"},
+		{Name: "teathree", VXLANs: "9000-10001"},
+		{Name: "teathree", VLANs: "1-5"},
+	} {
+		if err := CreateTenant(fakeDriver, &tenant); err == nil {
+			t.Fatalf("created tenant with ranges %+v", tenant)
+		}
+	}
+	if err := CreateTenant(fakeDriver, &intent.ConfigTenant{Name: "teathree", VLANs: "102-110"}); err != nil {
+		t.Fatalf("error creating tenant. Err: %v", err)
+	}
+	if err := checkTenantTagRanges(fakeDriver, "1-105", "vlan"); err == nil {
+		t.Fatalf("global vlan range excludes a tenant vlan range")
+	}
+
+	if _, err := resources.NewStateResourceManager(fakeDriver); err != nil {
+		t.Fatalf("state store initialization failed. Error: %s", err)
+	}
+	defer func() { resources.ReleaseStateResourceManager() }()
+
+	network := intent.ConfigNetwork{Name: "red", PktTagType: "vlan", PktTag: 200, SubnetCIDR: "10.1.4.0/24"}
+	if err := CreateNetwork(network, fakeDriver, "teaone"); err == nil {
+		t.Fatalf("allocated a vlan outside of the tenant range")
+	}
+	network.PktTag = 101
+	if err := CreateNetwork(network, fakeDriver, "teatwo"); err == nil {
+		t.Fatalf("allocated a vlan of another tenant range")
+	}
+	network.PktTag = 0
+	if err := CreateNetwork(network, fakeDriver, "teaone"); err != nil {
+		t.Fatalf("error creating network. Err: %v", err)
+	}
+	network.Name = "yellow"
+	network.SubnetCIDR = "10.1.5.0/24"
+	if err := CreateNetwork(network, fakeDriver, "teaone"); err == nil ||
+		!strings.Contains(err.Error(), "no vlans available in allowed range") {
+		t.Fatalf("allocated a vlan from an exhausted tenant range. Err: %v", err)
+	}
+
+	usage, err := GetTenantTagUsage(fakeDriver, "teaone", "vlan")
+	if err != nil || usage == nil || usage.InUse != "100-101" || usage.NumInUse != 2 || usage.NumFree != 0 {
+		t.Fatalf("unexpected vlan usage %+v. Err: %v", usage, err)
+	}
+	usage, err = GetTenantTagUsage(fakeDriver, "teaone", "vxlan")
+	if err != nil || usage == nil || usage.InUse != "5001" || usage.NumInUse != 1 || usage.NumFree != 1 {
+		t.Fatalf("unexpected vxlan usage %+v. Err: %v", usage, err)
+	}
+	if usage, err = GetTenantTagUsage(fakeDriver, "teatwo", "vlan"); err != nil || usage != nil {
+		t.Fatalf("unexpected vlan usage %+v of tenant without range. Err: %v", usage, err)
+	}
+
+	if err := DeleteTenant(fakeDriver, &intent.ConfigTenant{Name: "teathree"}); err != nil {
+		t.Fatalf("error deleting tenant. Err: %v", err)
+	}
+	if err := CreateTenant(fakeDriver, &intent.ConfigTenant{Name: "teafour", VLANs: "105-106"}); err != nil {
+		t.Fatalf("error creating tenant in a released range. Err: %v", err)
+	}
+}
+
+func TestServiceLBSelectors(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	providers := map[string]map[string]string{
+		"20.1.1.1": {"app": "redis", "tier": "db"},
+		"20.1.1.2": {"app": "redis", "tier": "cache"},
+		"20.1.1.3": {"app": "web"},
+	}
+	for ipAddress, labels := range providers {
+		mastercfg.ProviderDb[ipAddress] = &mastercfg.Provider{
+			IPAddress:   ipAddress,
+			Tenant:      "default",
+			ContainerID: ipAddress,
+			Labels:      labels,
+		}
+	}
+	defer func() {
+		mastercfg.ProviderDb = make(map[string]*mastercfg.Provider)
+		mastercfg.ServiceLBDb = make(map[string]*mastercfg.ServiceLBInfo)
+	}()
+
+	// key=value selectors are a subset match
+	service := &mastercfg.ServiceLBInfo{Tenant: "default", Selectors: map[string]string{"app": "redis"}}
+	for ipAddress, expMatch := range map[string]bool{"20.1.1.1": true, "20.1.1.2": true, "20.1.1.3": false} {
+		if providerMatches(mastercfg.ProviderDb[ipAddress], service) != expMatch {
+			t.Fatalf("provider %s match of selector app=redis is not %v", ipAddress, expMatch)
+		}
+	}
+	service.Tenant = "blue"
+	if providerMatches(mastercfg.ProviderDb["20.1.1.1"], service) {
+		t.Fatalf("provider matched a service of another tenant")
+	}
+
+	for _, test := range []struct {
+		expr    intent.SelectorExpr
+		matches []string
+	}{
+		{intent.SelectorExpr{Key: "tier", Operator: intent.SelectorOpIn, Values: []string{"db", "web"}}, []string{"20.1.1.1"}},
+		{intent.SelectorExpr{Key: "tier", Operator: intent.SelectorOpNotIn, Values: []string{"db"}}, []string{"20.1.1.2", "20.1.1.3"}},
+		{intent.SelectorExpr{Key: "tier", Operator: intent.SelectorOpExists}, []string{"20.1.1.1", "20.1.1.2"}},
+		{intent.SelectorExpr{Key: "tier", Operator: intent.SelectorOpNotExists}, []string{"20.1.1.3"}},
+		{intent.SelectorExpr{Key: "tier", Operator: "like"}, []string{}},
+	} {
+		for ipAddress, labels := range providers {
+			expMatch := false
+			for _, match := range test.matches {
+				expMatch = expMatch || match == ipAddress
+			}
+			if selectorExprMatches(test.expr, labels) != expMatch {
+				t.Fatalf("provider %s match of selector %+v is not %v", ipAddress, test.expr, expMatch)
+			}
+		}
+	}
+
+	// selector changes add and remove the matching providers
+	serviceID := GetServiceID("redis", "default")
+	mastercfg.ServiceLBDb[serviceID] = &mastercfg.ServiceLBInfo{
+		ServiceName: "redis",
+		Tenant:      "default",
+		Selectors:   map[string]string{"app": "redis"},
+		Providers:   make(map[string]*mastercfg.Provider),
+	}
+	serviceLbState := &mastercfg.CfgServiceLBState{ServiceName: "redis", Tenant: "default"}
+	serviceLbState.StateDriver = fakeDriver
+	serviceLbState.ID = serviceID
+	if err := serviceLbState.Write(); err != nil {
+		t.Fatalf("error writing service state. Err: %v", err)
+	}
+
+	verifyProviders := func(expProviders ...string) {
+		serviceLbState := &mastercfg.CfgServiceLBState{}
+		serviceLbState.StateDriver = fakeDriver
+		if len(mastercfg.ServiceLBDb[serviceID].Providers) != len(expProviders) {
+			t.Fatalf("service has providers %v, expected %v", mastercfg.ServiceLBDb[serviceID].Providers, expProviders)
+		}
+		if err := serviceLbState.Read(serviceID); err != nil || len(serviceLbState.Providers) != len(expProviders) {
+			t.Fatalf("service state has providers %v, expected %v. Err: %v", serviceLbState.Providers, expProviders, err)
+		}
+		for _, ipAddress := range expProviders {
+			providerID := getProviderID(mastercfg.ProviderDb[ipAddress])
+			if mastercfg.ServiceLBDb[serviceID].Providers[providerID] == nil || serviceLbState.Providers[providerID] == nil {
+				t.Fatalf("provider %s is not a provider of the service", ipAddress)
+			}
+		}
+		for ipAddress, provider := range mastercfg.ProviderDb {
+			_, isProvider := mastercfg.ServiceLBDb[serviceID].Providers[getProviderID(provider)]
+			if isProvider != (len(provider.Services) == 1) {
+				t.Fatalf("provider %s has services %v", ipAddress, provider.Services)
+			}
+		}
+	}
+
+	serviceLbCfg := &intent.ConfigServiceLB{
+		ServiceName: "redis",
+		Tenant:      "default",
+		Selectors:   map[string]string{"app": "redis"},
+	}
+	if err := updateServiceLBSelectors(fakeDriver, serviceID, serviceLbCfg); err != nil {
+		t.Fatalf("error updating service selectors. Err: %v", err)
+	}
+	verifyProviders("20.1.1.1", "20.1.1.2")
+
+	serviceLbCfg.SelectorExprs = []intent.SelectorExpr{{Key: "tier", Operator: intent.SelectorOpIn, Values: []string{"db"}}}
+	if err := updateServiceLBSelectors(fakeDriver, serviceID, serviceLbCfg); err != nil {
+		t.Fatalf("error updating service selectors. Err: %v", err)
+	}
+	verifyProviders("20.1.1.1")
+
+	serviceLbCfg.Selectors = map[string]string{}
+	serviceLbCfg.SelectorExprs = []intent.SelectorExpr{{Key: "tier", Operator: intent.SelectorOpNotIn, Values: []string{"db"}}}
+	if err := updateServiceLBSelectors(fakeDriver, serviceID, serviceLbCfg); err != nil {
+		t.Fatalf("error updating service selectors. Err: %v", err)
+	}
+	verifyProviders("20.1.1.2", "20.1.1.3")
+}
+
+func TestServiceLBHealthCheck(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                      : "teaone",
+        "Networks"  : [{
+            "Name"                : "orange",
+            "SubnetCIDR"          : "10.1.1.0/24",
+            "Gateway"             : "10.1.1.254"
+        }]
+    }]}`)
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	for _, ipAddress := range []string{"10.1.1.1", "10.1.1.2"} {
+		mastercfg.ProviderDb[ipAddress] = &mastercfg.Provider{
+			IPAddress:   ipAddress,
+			Tenant:      "teaone",
+			ContainerID: ipAddress,
+			Labels:      map[string]string{"app": "web"},
+		}
+	}
+	defer func() {
+		mastercfg.ProviderDb = make(map[string]*mastercfg.Provider)
+		mastercfg.ServiceLBDb = make(map[string]*mastercfg.ServiceLBInfo)
+	}()
+
+	// unset parameters get defaults, the port defaults to the first tcp
+	// provider port
+	healthCheck := intent.ConfigHealthCheck{Type: intent.HealthCheckHTTP}
+	if err := validateHealthCheck(&healthCheck, []string{"53:5353:UDP", "80:8080:TCP"}); err != nil {
+		t.Fatalf("error validating health check. Err: %v", err)
+	}
+	expHealthCheck := intent.ConfigHealthCheck{
+		Type:               intent.HealthCheckHTTP,
+		Port:               8080,
+		Path:               "/",
+		Interval:           defaultHealthCheckInterval,
+		Timeout:            defaultHealthCheckTimeout,
+		HealthyThreshold:   defaultHealthyThreshold,
+		UnhealthyThreshold: defaultUnhealthyThreshold,
+	}
+	if healthCheck != expHealthCheck {
+		t.Fatalf("health check is %+v, expected %+v", healthCheck, expHealthCheck)
+	}
+	for _, healthCheck := range []intent.ConfigHealthCheck{
+		{Type: "udp"},
+		{Type: intent.HealthCheckTCP, Path: "/"},
+		{Type: intent.HealthCheckHTTP, Path: "health"},
+		{Type: intent.HealthCheckTCP, Interval: 5, Timeout: 10},
+	} {
+		if err := validateHealthCheck(&healthCheck, []string{"80:8080:TCP"}); err == nil {
+			t.Fatalf("invalid health check %+v was accepted", healthCheck)
+		}
+	}
+	healthCheck = intent.ConfigHealthCheck{Type: intent.HealthCheckTCP}
+	if err := validateHealthCheck(&healthCheck, []string{"53:5353:UDP"}); err == nil {
+		t.Fatalf("health check without a port was accepted")
+	}
+
+	serviceLbCfg := &intent.ConfigServiceLB{
+		ServiceName: "web",
+		Tenant:      "teaone",
+		Network:     "orange",
+		Ports:       []string{"80:8080:TCP"},
+		Selectors:   map[string]string{"app": "web"},
+		HealthCheck: intent.ConfigHealthCheck{Type: intent.HealthCheckTCP},
+	}
+	if err := CreateServiceLB(fakeDriver, serviceLbCfg); err != nil {
+		t.Fatalf("error creating service. Err: %v", err)
+	}
+
+	serviceID := GetServiceID("web", "teaone")
+	service := mastercfg.ServiceLBDb[serviceID]
+	verifyProviders := func(expProviders ...string) {
+		svcProvider := &mastercfg.SvcProvider{}
+		svcProvider.StateDriver = fakeDriver
+		if err := svcProvider.Read(serviceID); err != nil {
+			t.Fatalf("error reading service providers. Err: %v", err)
+		}
+		sort.Strings(svcProvider.Providers)
+		if !reflect.DeepEqual(svcProvider.Providers, expProviders) {
+			t.Fatalf("service providers are %v, expected %v", svcProvider.Providers, expProviders)
+		}
+
+		serviceLbState := &mastercfg.CfgServiceLBState{}
+		serviceLbState.StateDriver = fakeDriver
+		if err := serviceLbState.Read(serviceID); err != nil {
+			t.Fatalf("error reading service state. Err: %v", err)
+		}
+		if len(serviceLbState.Providers) != 2 || len(serviceLbState.Unhealthy) != 2-len(expProviders) {
+			t.Fatalf("service state has providers %v, unhealthy %v", serviceLbState.Providers, serviceLbState.Unhealthy)
+		}
+	}
+	verifyProviders("10.1.1.1", "10.1.1.2")
+
+	// unhealthy providers are taken out and put back when healthy
+	if err := SetProviderHealth(fakeDriver, serviceID, "10.1.1.1:teaone", false); err != nil {
+		t.Fatalf("error setting provider health. Err: %v", err)
+	}
+	verifyProviders("10.1.1.2")
+	if status := ProviderHealthStatus(service, "10.1.1.1:teaone"); status != ProviderUnhealthy {
+		t.Fatalf("provider health status is %q, expected %q", status, ProviderUnhealthy)
+	}
+	if status := ProviderHealthStatus(service, "10.1.1.2:teaone"); status != ProviderHealthy {
+		t.Fatalf("provider health status is %q, expected %q", status, ProviderHealthy)
+	}
+	if err := SetProviderHealth(fakeDriver, serviceID, "10.1.1.9:teaone", false); err != nil {
+		t.Fatalf("error ignoring health of an unknown provider. Err: %v", err)
+	}
+	if err := SetProviderHealth(fakeDriver, serviceID, "10.1.1.1:teaone", true); err != nil {
+		t.Fatalf("error setting provider health. Err: %v", err)
+	}
+	verifyProviders("10.1.1.1", "10.1.1.2")
+
+	// removing the health check puts the providers back
+	if err := SetProviderHealth(fakeDriver, serviceID, "10.1.1.2:teaone", false); err != nil {
+		t.Fatalf("error setting provider health. Err: %v", err)
+	}
+	verifyProviders("10.1.1.1")
+	serviceLbCfg.HealthCheck = intent.ConfigHealthCheck{}
+	if err := CreateServiceLB(fakeDriver, serviceLbCfg); err != nil {
+		t.Fatalf("error updating service. Err: %v", err)
+	}
+	if mastercfg.ServiceLBDb[serviceID] != service {
+		t.Fatalf("service was recreated on a health check change")
+	}
+	verifyProviders("10.1.1.1", "10.1.1.2")
+	if status := ProviderHealthStatus(service, "10.1.1.2:teaone"); status != "" {
+		t.Fatalf("provider health status is %q without a health check", status)
+	}
+}
+
+func TestServiceLBPolicy(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                      : "teaone",
+        "Networks"  : [{
+            "Name"                : "orange",
+            "SubnetCIDR"          : "10.1.1.0/24",
+            "Gateway"             : "10.1.1.254"
+        }]
+    }]}`)
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	mastercfg.ProviderDb["10.1.1.1"] = &mastercfg.Provider{
+		IPAddress:   "10.1.1.1",
+		Tenant:      "teaone",
+		ContainerID: "10.1.1.1",
+		Labels:      map[string]string{"app": "web", "weight": "9"},
+	}
+	defer func() {
+		mastercfg.ProviderDb = make(map[string]*mastercfg.Provider)
+		mastercfg.ServiceLBDb = make(map[string]*mastercfg.ServiceLBInfo)
+	}()
+
+	// ClientIP affinity gets the default timeout, none affinity is stored
+	// as no affinity
+	lbPolicy := intent.ConfigLBPolicy{SessionAffinity: intent.SessionAffinityClientIP}
+	if err := validateLBPolicy(&lbPolicy); err != nil {
+		t.Fatalf("error validating lb policy. Err: %v", err)
+	}
+	if lbPolicy.AffinityTimeout != defaultAffinityTimeout {
+		t.Fatalf("affinity timeout is %d, expected %d", lbPolicy.AffinityTimeout, defaultAffinityTimeout)
+	}
+	lbPolicy = intent.ConfigLBPolicy{SessionAffinity: intent.SessionAffinityNone}
+	if err := validateLBPolicy(&lbPolicy); err != nil || lbPolicy.SessionAffinity != "" {
+		t.Fatalf("none affinity was validated to %+v. Err: %v", lbPolicy, err)
+	}
+	for _, lbPolicy := range []intent.ConfigLBPolicy{
+		{SessionAffinity: "Cookie"},
+		{SessionAffinity: intent.SessionAffinityNone, AffinityTimeout: 60},
+		{AffinityTimeout: 60},
+	} {
+		if err := validateLBPolicy(&lbPolicy); err == nil {
+			t.Fatalf("invalid lb policy %+v was accepted", lbPolicy)
+		}
+	}
+
+	// providers without a valid weight get the default weight
+	provider := &mastercfg.Provider{IPAddress: "10.1.1.2", Labels: map[string]string{
+		"weight": "0", "bad": "heavy", "big": "101"}}
+	for label, expWeight := range map[string]int{
+		"":        defaultProviderWeight,
+		"weight":  0,
+		"missing": defaultProviderWeight,
+		"bad":     defaultProviderWeight,
+		"big":     defaultProviderWeight,
+	} {
+		if weight := ProviderWeight(provider, label); weight != expWeight {
+			t.Fatalf("weight for label %q is %d, expected %d", label, weight, expWeight)
+		}
+	}
+
+	serviceLbCfg := &intent.ConfigServiceLB{
+		ServiceName: "web",
+		Tenant:      "teaone",
+		Network:     "orange",
+		Ports:       []string{"80:8080:TCP"},
+		Selectors:   map[string]string{"app": "web"},
+		LBPolicy: intent.ConfigLBPolicy{
+			SessionAffinity: intent.SessionAffinityClientIP,
+			AffinityTimeout: 600,
+			WeightLabel:     "weight",
+		},
+	}
+	if err := CreateServiceLB(fakeDriver, serviceLbCfg); err != nil {
+		t.Fatalf("error creating service. Err: %v", err)
+	}
+
+	serviceID := GetServiceID("web", "teaone")
+	service := mastercfg.ServiceLBDb[serviceID]
+	verifyLBPolicy := func(expLBPolicy intent.ConfigLBPolicy) {
+		serviceLbState := &mastercfg.CfgServiceLBState{}
+		serviceLbState.StateDriver = fakeDriver
+		if err := serviceLbState.Read(serviceID); err != nil {
+			t.Fatalf("error reading service state. Err: %v", err)
+		}
+		if serviceLbState.LBPolicy != expLBPolicy || service.LBPolicy != expLBPolicy {
+			t.Fatalf("service lb policy is %+v, expected %+v", serviceLbState.LBPolicy, expLBPolicy)
+		}
+		if len(serviceLbState.Providers) != 1 {
+			t.Fatalf("service state has providers %v", serviceLbState.Providers)
+		}
+	}
+	verifyLBPolicy(serviceLbCfg.LBPolicy)
+
+	// lb policy changes update the service in place
+	serviceLbCfg.LBPolicy = intent.ConfigLBPolicy{WeightLabel: "weight"}
+	if err := CreateServiceLB(fakeDriver, serviceLbCfg); err != nil {
+		t.Fatalf("error updating service. Err: %v", err)
+	}
+	if mastercfg.ServiceLBDb[serviceID] != service {
+		t.Fatalf("service was recreated on an lb policy change")
+	}
+	verifyLBPolicy(intent.ConfigLBPolicy{WeightLabel: "weight"})
+}
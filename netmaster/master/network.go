@@ -16,6 +16,7 @@ limitations under the License.
 package master
 
 import (
+	"fmt"
 	"net"
 	"strings"
 
@@ -25,6 +26,7 @@ import (
 	"github.com/contiv/netplugin/netmaster/intent"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/utils/netutils"
+	"github.com/jainvipin/bitset"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -71,6 +73,86 @@ func validateNetworkConfig(tenant *intent.ConfigTenant) error {
 	return err
 }
 
+// newNetworkState validates network intent and builds the network state
+// without allocating any pkt tags
+func newNetworkState(network intent.ConfigNetwork, stateDriver core.StateDriver, tenantName string) (*mastercfg.CfgNetworkState, error) {
+	subnetIP, subnetLen, _ := netutils.ParseCIDR(network.SubnetCIDR)
+	err := netutils.ValidateNetworkRangeParams(subnetIP, subnetLen)
+	if err != nil {
+		return nil, err
+	}
+
+	ipv6Subnet, ipv6SubnetLen, _ := netutils.ParseCIDR(network.IPv6SubnetCIDR)
+
+	err = validateQuarantine(network.AddrQuarantineTime, network.AddrQuarantineCount)
+	if err != nil {
+		return nil, err
+	}
+
+	// construct network state
+	nwCfg := &mastercfg.CfgNetworkState{
+		Tenant:              tenantName,
+		NetworkName:         network.Name,
+		NwType:              network.NwType,
+		PktTagType:          network.PktTagType,
+		SubnetIP:            subnetIP,
+		SubnetLen:           subnetLen,
+		IPv6Subnet:          ipv6Subnet,
+		IPv6SubnetLen:       ipv6SubnetLen,
+		AddrQuarantineTime:  network.AddrQuarantineTime,
+		AddrQuarantineCount: network.AddrQuarantineCount,
+	}
+
+	nwCfg.ID = network.Name + "." + tenantName
+	nwCfg.StateDriver = stateDriver
+
+	netutils.InitSubnetBitset(&nwCfg.IPAllocMap, nwCfg.SubnetLen)
+	subnetAddr := netutils.GetSubnetAddr(nwCfg.SubnetIP, nwCfg.SubnetLen)
+	nwCfg.SubnetIP = subnetAddr
+	nwCfg.IPAddrRange = netutils.GetIPAddrRange(subnetIP, subnetLen)
+
+	if network.Gateway != "" {
+		nwCfg.Gateway = network.Gateway
+
+		// Reserve gateway IP address if gateway is specified
+		ipAddrValue, err := netutils.GetIPNumber(subnetAddr, nwCfg.SubnetLen, 32, nwCfg.Gateway)
+		if err != nil {
+			log.Errorf("Error parsing gateway address %s. Err: %v", nwCfg.Gateway, err)
+			return nil, err
+		}
+		nwCfg.IPAllocMap.Set(ipAddrValue)
+	}
+
+	if strings.Contains(subnetIP, "-") {
+		netutils.SetBitsOutsideRange(&nwCfg.IPAllocMap, subnetIP, subnetLen)
+	}
+
+	if err := updateServiceIPPool(nwCfg, network.ServiceIPPool); err != nil {
+		return nil, err
+	}
+
+	if nwCfg.IPv6Subnet != "" {
+		err = nwCfg.IPv6Alloc.InitSubnet(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen)
+		if err != nil {
+			log.Errorf("Error initializing IPv6 allocator. Err: %v", err)
+			return nil, err
+		}
+	}
+
+	if network.IPv6Gateway != "" {
+		nwCfg.IPv6Gateway = network.IPv6Gateway
+
+		// Reserve gateway IPv6 address if gateway is specified
+		err = nwCfg.IPv6Alloc.Reserve(nwCfg.IPv6Gateway)
+		if err != nil {
+			log.Errorf("Error parsing gateway address %s. Err: %v", nwCfg.IPv6Gateway, err)
+			return nil, err
+		}
+	}
+
+	return nwCfg, nil
+}
+
 // CreateNetwork creates a network from intent
 func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, tenantName string) error {
 	var extPktTag, pktTag uint
@@ -93,106 +175,650 @@ func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, t
 		// TODO: check if parameters changed and apply an update if needed
 		return nil
 	}
-
-	subnetIP, subnetLen, _ := netutils.ParseCIDR(network.SubnetCIDR)
-	err = netutils.ValidateNetworkRangeParams(subnetIP, subnetLen)
+
+	nwCfg, err = newNetworkState(network, stateDriver, tenantName)
+	if err != nil {
+		return err
+	}
+
+	// Allocate pkt tags, from the tenant range if it has one
+	reqPktTag := uint(network.PktTag)
+	if nwCfg.PktTagType == "vlan" || nwCfg.PktTagType == "vxlan" {
+		allowed, err := tenantAllowedTags(stateDriver, &gCfg, tenantName, nwCfg.PktTagType)
+		if err != nil {
+			return err
+		}
+
+		if nwCfg.PktTagType == "vlan" {
+			pktTag, err = gCfg.AllocVLANIn(reqPktTag, allowed)
+		} else {
+			extPktTag, err = gCfg.AllocVXLANIn(reqPktTag, allowed)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	nwCfg.ExtPktTag = int(extPktTag)
+	nwCfg.PktTag = int(pktTag)
+
+	err = nwCfg.Write()
+	if err != nil {
+		return err
+	}
+
+	// Skip docker and service container configs for infra nw
+	if network.NwType == "infra" {
+		return nil
+	}
+
+	aci, _ := IsAciConfigured()
+	if aci {
+		// Skip docker network creation for ACI fabric mode.
+		return nil
+	}
+
+	if GetClusterMode() == "docker" {
+		// Create the network in docker
+		err = docknet.CreateDockNet(tenantName, network.Name, "", nwCfg)
+		if err != nil {
+			log.Errorf("Error creating network %s in docker. Err: %v", nwCfg.ID, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PreviewNetwork runs the validation of CreateNetwork and returns the
+// network state it would write, including the pkt tags it would allocate.
+// Nothing is written to the state store.
+func PreviewNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, tenantName string) (*mastercfg.CfgNetworkState, error) {
+	var extPktTag, pktTag uint
+
+	gstate.GlobalMutex.Lock()
+	defer gstate.GlobalMutex.Unlock()
+	gCfg := gstate.Cfg{}
+	gCfg.StateDriver = stateDriver
+	err := gCfg.Read("")
+	if err != nil {
+		log.Errorf("error reading tenant cfg state. Error: %s", err)
+		return nil, err
+	}
+
+	nwCfg, err := newNetworkState(network, stateDriver, tenantName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the pkt tags that would be allocated
+	reqPktTag := uint(network.PktTag)
+	if nwCfg.PktTagType == "vlan" || nwCfg.PktTagType == "vxlan" {
+		allowed, err := tenantAllowedTags(stateDriver, &gCfg, tenantName, nwCfg.PktTagType)
+		if err != nil {
+			return nil, err
+		}
+
+		if nwCfg.PktTagType == "vlan" {
+			pktTag, err = gCfg.PeekVLANIn(reqPktTag, allowed)
+		} else {
+			extPktTag, err = gCfg.PeekVXLANIn(reqPktTag, allowed)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nwCfg.ExtPktTag = int(extPktTag)
+	nwCfg.PktTag = int(pktTag)
+
+	return nwCfg, nil
+}
+
+// networkEPGs returns all endpoint groups associated with a network
+func networkEPGs(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState) []*mastercfg.EndpointGroupState {
+	epgList := []*mastercfg.EndpointGroupState{}
+
+	readEpg := &mastercfg.EndpointGroupState{}
+	readEpg.StateDriver = stateDriver
+	epgCfgs, err := readEpg.ReadAll()
+	if err == nil {
+		for _, epgCfg := range epgCfgs {
+			epg := epgCfg.(*mastercfg.EndpointGroupState)
+			if epg.TenantName == nwCfg.Tenant && epg.NetworkName == nwCfg.NetworkName {
+				epgList = append(epgList, epg)
+			}
+		}
+	}
+
+	return epgList
+}
+
+// updateNetworkSubnet expands the IPv4 subnet of a network. The new subnet
+// must contain the existing address range so that all allocated addresses
+// remain valid. The pools of the endpoint groups are moved by updateEPGSubnet.
+func updateNetworkSubnet(nwCfg *mastercfg.CfgNetworkState, subnetCIDR string) error {
+	if subnetCIDR == "" {
+		return core.Errorf("subnet can not be removed from network %s", nwCfg.ID)
+	}
+
+	subnetIP, subnetLen, err := netutils.ParseCIDR(subnetCIDR)
+	if err != nil {
+		return err
+	}
+	err = netutils.ValidateNetworkRangeParams(subnetIP, subnetLen)
+	if err != nil {
+		return err
+	}
+
+	subnetAddr := netutils.GetSubnetAddr(subnetIP, subnetLen)
+	ipAddrRange := netutils.GetIPAddrRange(subnetIP, subnetLen)
+	if subnetAddr == nwCfg.SubnetIP && subnetLen == nwCfg.SubnetLen && ipAddrRange == nwCfg.IPAddrRange {
+		return nil
+	}
+
+	if !netutils.IsIPAddrRangeContained(nwCfg.IPAddrRange, ipAddrRange) {
+		return core.Errorf("subnet %s does not contain existing address range %s of network %s",
+			subnetCIDR, nwCfg.IPAddrRange, nwCfg.ID)
+	}
+
+	return moveNetworkSubnet(nwCfg, subnetAddr, subnetLen, ipAddrRange)
+}
+
+// moveNetworkSubnet moves the allocated addresses of a network to a new
+// subnet and address range. It fails if an allocated address is not in the
+// new address range.
+func moveNetworkSubnet(nwCfg *mastercfg.CfgNetworkState, subnetAddr string, subnetLen uint,
+	ipAddrRange string) error {
+	if subnetAddr == nwCfg.SubnetIP && subnetLen == nwCfg.SubnetLen && ipAddrRange == nwCfg.IPAddrRange {
+		return nil
+	}
+
+	usedMap := nwCfg.IPAllocMap.Clone()
+	netutils.ClearReservedEntries(usedMap, nwCfg.SubnetLen)
+	netutils.ClearBitsOutsideRange(usedMap, nwCfg.IPAddrRange, nwCfg.SubnetLen)
+	maxHosts := uint(1 << (32 - nwCfg.SubnetLen))
+	for idx, found := usedMap.NextSet(0); found && idx < maxHosts; idx, found = usedMap.NextSet(idx + 1) {
+		ipAddress, _ := netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, idx)
+		if !netutils.IsIPAddrRangeContained(ipAddress+"-"+ipAddress, ipAddrRange) {
+			return core.Errorf("address %s of network %s is in use and not in address range %s",
+				ipAddress, nwCfg.ID, ipAddrRange)
+		}
+	}
+
+	// move allocated addresses to the new subnet
+	allocMap, err := netutils.RebaseIPAllocMap(&nwCfg.IPAllocMap, nwCfg.IPAddrRange,
+		nwCfg.SubnetIP, nwCfg.SubnetLen, subnetAddr, subnetLen)
+	if err != nil {
+		log.Errorf("Error moving allocated addresses of network %s. Err: %v", nwCfg.ID, err)
+		return err
+	}
+	netutils.InitSubnetBitset(&allocMap, subnetLen)
+	netutils.SetBitsOutsideRange(&allocMap, ipAddrRange, subnetLen)
+
+	if nwCfg.ServiceIPPool != "" {
+		svcMap, err := rebasePoolAllocMap(nwCfg, &nwCfg.ServiceIPAllocMap, nwCfg.ServiceIPPool, subnetAddr, subnetLen)
+		if err != nil {
+			log.Errorf("Error moving service addresses of network %s. Err: %v", nwCfg.ID, err)
+			return err
+		}
+		nwCfg.ServiceIPAllocMap = svcMap
+	}
+
+	nwCfg.IPAllocMap = allocMap
+	nwCfg.SubnetIP = subnetAddr
+	nwCfg.SubnetLen = subnetLen
+	nwCfg.IPAddrRange = ipAddrRange
+
+	return nil
+}
+
+// updateEPGSubnet moves the addresses allocated in the ip pool of an
+// endpoint group from the subnet of prevCfg to the subnet of nwCfg
+func updateEPGSubnet(epgCfg *mastercfg.EndpointGroupState, prevCfg, nwCfg *mastercfg.CfgNetworkState) error {
+	if len(epgCfg.IPPool) == 0 {
+		return nil
+	}
+
+	epgMap, err := rebasePoolAllocMap(prevCfg, &epgCfg.EPGIPAllocMap, epgCfg.IPPool, nwCfg.SubnetIP, nwCfg.SubnetLen)
+	if err != nil {
+		log.Errorf("Error moving allocated addresses of epg %s. Err: %v", epgCfg.GroupName, err)
+		return err
+	}
+	epgCfg.EPGIPAllocMap = epgMap
+
+	return nil
+}
+
+// rebasePoolAllocMap moves the addresses allocated in the ranges of an ip
+// pool to an alloc map of a new subnet
+func rebasePoolAllocMap(nwCfg *mastercfg.CfgNetworkState, allocMap *bitset.BitSet, ipPool string,
+	subnetAddr string, subnetLen uint) (bitset.BitSet, error) {
+	usedMap := allocMap.Clone()
+	err := netutils.ClearBitsOutsidePools(usedMap, ipPool, nwCfg.SubnetIP, nwCfg.SubnetLen)
+	if err != nil {
+		return bitset.BitSet{}, err
+	}
+
+	poolMap, err := netutils.RebaseIPAllocMap(usedMap, nwCfg.IPAddrRange,
+		nwCfg.SubnetIP, nwCfg.SubnetLen, subnetAddr, subnetLen)
+	if err != nil {
+		return bitset.BitSet{}, err
+	}
+	netutils.InitSubnetBitset(&poolMap, subnetLen)
+	err = netutils.SetBitsOutsidePools(&poolMap, ipPool, subnetAddr, subnetLen)
+
+	return poolMap, err
+}
+
+// updateNetworkGateway changes the IPv4 gateway of a network
+func updateNetworkGateway(nwCfg *mastercfg.CfgNetworkState, epList []*mastercfg.CfgEndpointState,
+	gateway string) error {
+	if gateway == nwCfg.Gateway {
+		return nil
+	}
+
+	if gateway != "" {
+		if net.ParseIP(gateway) == nil || netutils.IsIPv6(gateway) {
+			return core.Errorf("invalid gateway %s", gateway)
+		}
+		if !netutils.IsIPAddrRangeContained(gateway+"-"+gateway, nwCfg.IPAddrRange) {
+			return core.Errorf("gateway %s is not in network range %s", gateway, nwCfg.IPAddrRange)
+		}
+		for _, ep := range epList {
+			if ep.IPAddress == gateway {
+				return core.Errorf("gateway %s is already in use by endpoint %s", gateway, ep.EndpointID)
+			}
+		}
+	}
+
+	if nwCfg.Gateway != "" {
+		ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, nwCfg.Gateway)
+		if err != nil {
+			log.Errorf("Error parsing gateway address %s. Err: %v", nwCfg.Gateway, err)
+			return err
+		}
+		nwCfg.IPAllocMap.Clear(ipAddrValue)
+	}
+
+	if gateway != "" {
+		ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, gateway)
+		if err != nil {
+			log.Errorf("Error parsing gateway address %s. Err: %v", gateway, err)
+			return err
+		}
+		if nwCfg.IPAllocMap.Test(ipAddrValue) {
+			return core.Errorf("gateway %s is already in use", gateway)
+		}
+		nwCfg.IPAllocMap.Set(ipAddrValue)
+	}
+
+	nwCfg.Gateway = gateway
+
+	return nil
+}
+
+// updateNetworkIPv6 changes the IPv6 subnet and gateway of a network. The
+// IPv6 subnet can only be changed while no IPv6 addresses are allocated.
+func updateNetworkIPv6(nwCfg *mastercfg.CfgNetworkState, epList []*mastercfg.CfgEndpointState,
+	ipv6SubnetCIDR, ipv6Gateway string) error {
+	ipv6Subnet, ipv6SubnetLen, _ := netutils.ParseCIDR(ipv6SubnetCIDR)
+	if ipv6Subnet == nwCfg.IPv6Subnet && ipv6SubnetLen == nwCfg.IPv6SubnetLen &&
+		ipv6Gateway == nwCfg.IPv6Gateway {
+		return nil
+	}
+
+	if ipv6Gateway != "" && ipv6Subnet == "" {
+		return core.Errorf("IPv6 gateway %s requires an IPv6 subnet", ipv6Gateway)
+	}
+
+	if err := initIPv6Alloc(nwCfg); err != nil {
+		return err
+	}
+
+	// release the existing gateway before looking at allocations
+	if nwCfg.IPv6Gateway != "" {
+		nwCfg.IPv6Alloc.Release(nwCfg.IPv6Gateway)
+	}
+
+	if ipv6Subnet != nwCfg.IPv6Subnet || ipv6SubnetLen != nwCfg.IPv6SubnetLen {
+		if !nwCfg.IPv6Alloc.IsEmpty() {
+			return core.Errorf("IPv6 subnet of network %s can not be changed while IPv6 addresses are allocated",
+				nwCfg.ID)
+		}
+		nwCfg.IPv6Subnet = ipv6Subnet
+		nwCfg.IPv6SubnetLen = ipv6SubnetLen
+		nwCfg.IPv6Alloc = netutils.IPv6Allocator{}
+		if ipv6Subnet != "" {
+			if err := nwCfg.IPv6Alloc.InitSubnet(ipv6Subnet, ipv6SubnetLen); err != nil {
+				return err
+			}
+		}
+	}
+
+	if ipv6Gateway != "" {
+		for _, ep := range epList {
+			if ep.IPv6Address == ipv6Gateway {
+				return core.Errorf("gateway %s is already in use by endpoint %s", ipv6Gateway, ep.EndpointID)
+			}
+		}
+
+		if nwCfg.IPv6Alloc.IsAllocated(ipv6Gateway) {
+			return core.Errorf("gateway %s is already in use", ipv6Gateway)
+		}
+		err := nwCfg.IPv6Alloc.Reserve(ipv6Gateway)
+		if err != nil {
+			log.Errorf("Error parsing gateway address %s. Err: %v", ipv6Gateway, err)
+			return err
+		}
+	}
+
+	nwCfg.IPv6Gateway = ipv6Gateway
+
+	return nil
+}
+
+// recreateDockNets recreates the docker networks of a network and its
+// endpoint groups, docker does not allow changing ipam config in place.
+func recreateDockNets(nwCfg *mastercfg.CfgNetworkState, epgList []*mastercfg.EndpointGroupState) error {
+	names := []string{""}
+	for _, epgCfg := range epgList {
+		names = append(names, epgCfg.GroupName)
+	}
+
+	for _, name := range names {
+		err := docknet.DeleteDockNet(nwCfg.Tenant, nwCfg.NetworkName, name)
+		if err != nil {
+			log.Errorf("Error deleting docker network %s/%s. Err: %v", nwCfg.ID, name, err)
+			return err
+		}
+
+		err = docknet.CreateDockNet(nwCfg.Tenant, nwCfg.NetworkName, name, nwCfg)
+		if err != nil {
+			log.Errorf("Error creating docker network %s/%s. Err: %v", nwCfg.ID, name, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkDockNetUpdate returns whether the docker networks of a changed network
+// have to be recreated, and fails if they can't be. Docker doesn't remove
+// networks that containers are attached to, so they can only be recreated
+// while the network has no endpoints.
+func checkDockNetUpdate(nwCfg *mastercfg.CfgNetworkState) (bool, error) {
+	aci, _ := IsAciConfigured()
+	if nwCfg.NwType == "infra" || GetClusterMode() != "docker" || aci {
+		return false, nil
+	}
+
+	if hasActiveEndpoints(nwCfg) {
+		return false, core.Errorf("docker network %s has active endpoints and can not be updated", nwCfg.ID)
+	}
+
+	return true, nil
+}
+
+// networkEndpoints returns all endpoints of a network
+func networkEndpoints(stateDriver core.StateDriver, networkID string) []*mastercfg.CfgEndpointState {
+	epList := []*mastercfg.CfgEndpointState{}
+
+	readEp := &mastercfg.CfgEndpointState{}
+	readEp.StateDriver = stateDriver
+	epCfgs, err := readEp.ReadAll()
+	if err == nil {
+		for _, epCfg := range epCfgs {
+			ep := epCfg.(*mastercfg.CfgEndpointState)
+			if ep.NetID == networkID {
+				epList = append(epList, ep)
+			}
+		}
+	}
+
+	return epList
+}
+
+// updateNetworkState applies network intent to the existing network state
+// in memory. It returns the updated state, the endpoint groups of the network
+// and whether anything changed.
+func updateNetworkState(network intent.ConfigNetwork, stateDriver core.StateDriver,
+	tenantName string) (*mastercfg.CfgNetworkState, []*mastercfg.EndpointGroupState, bool, error) {
+	networkID := network.Name + "." + tenantName
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	err := nwCfg.Read(networkID)
+	if err != nil {
+		log.Errorf("network %s is not operational", networkID)
+		return nil, nil, false, err
+	}
+
+	prevCfg := *nwCfg
+
+	epList := networkEndpoints(stateDriver, networkID)
+	epgList := networkEPGs(stateDriver, nwCfg)
+
+	err = updateNetworkSubnet(nwCfg, network.SubnetCIDR)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	err = updateNetworkGateway(nwCfg, epList, network.Gateway)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	err = updateNetworkIPv6(nwCfg, epList, network.IPv6SubnetCIDR, network.IPv6Gateway)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	changed := nwCfg.SubnetIP != prevCfg.SubnetIP || nwCfg.SubnetLen != prevCfg.SubnetLen ||
+		nwCfg.IPAddrRange != prevCfg.IPAddrRange || nwCfg.Gateway != prevCfg.Gateway ||
+		nwCfg.IPv6Subnet != prevCfg.IPv6Subnet || nwCfg.IPv6SubnetLen != prevCfg.IPv6SubnetLen ||
+		nwCfg.IPv6Gateway != prevCfg.IPv6Gateway
+
+	return nwCfg, epgList, changed, nil
+}
+
+// updateNetworkQuarantine changes the address quarantine of a network and
+// returns whether it changed. Quarantined addresses are expired with the new
+// settings on the next allocation.
+func updateNetworkQuarantine(nwCfg *mastercfg.CfgNetworkState, network intent.ConfigNetwork) (bool, error) {
+	err := validateQuarantine(network.AddrQuarantineTime, network.AddrQuarantineCount)
+	if err != nil {
+		return false, err
+	}
+
+	if nwCfg.AddrQuarantineTime == network.AddrQuarantineTime &&
+		nwCfg.AddrQuarantineCount == network.AddrQuarantineCount {
+		return false, nil
+	}
+
+	nwCfg.AddrQuarantineTime = network.AddrQuarantineTime
+	nwCfg.AddrQuarantineCount = network.AddrQuarantineCount
+
+	return true, nil
+}
+
+// applyNetworkUpdate applies network intent to a network state
+func applyNetworkUpdate(nwCfg *mastercfg.CfgNetworkState, epList []*mastercfg.CfgEndpointState,
+	network intent.ConfigNetwork) error {
+	if err := updateNetworkSubnet(nwCfg, network.SubnetCIDR); err != nil {
+		return err
+	}
+	if err := updateNetworkGateway(nwCfg, epList, network.Gateway); err != nil {
+		return err
+	}
+	if err := updateNetworkIPv6(nwCfg, epList, network.IPv6SubnetCIDR, network.IPv6Gateway); err != nil {
+		return err
+	}
+	if _, err := updateNetworkQuarantine(nwCfg, network); err != nil {
+		return err
+	}
+	_, err := updateNetworkServiceIPPool(nwCfg, network)
+	return err
+}
+
+// revertNetworkUpdate changes the fields of a network state that an update
+// changed back to their values in prevCfg
+func revertNetworkUpdate(nwCfg, prevCfg *mastercfg.CfgNetworkState) error {
+	if err := updateServiceIPPool(nwCfg, prevCfg.ServiceIPPool); err != nil {
+		return err
+	}
+	nwCfg.AddrQuarantineTime = prevCfg.AddrQuarantineTime
+	nwCfg.AddrQuarantineCount = prevCfg.AddrQuarantineCount
+
+	ipv6SubnetCIDR := ""
+	if prevCfg.IPv6Subnet != "" {
+		ipv6SubnetCIDR = fmt.Sprintf("%s/%d", prevCfg.IPv6Subnet, prevCfg.IPv6SubnetLen)
+	}
+	if err := updateNetworkIPv6(nwCfg, nil, ipv6SubnetCIDR, prevCfg.IPv6Gateway); err != nil {
+		return err
+	}
+	if err := updateNetworkGateway(nwCfg, nil, prevCfg.Gateway); err != nil {
+		return err
+	}
+
+	return moveNetworkSubnet(nwCfg, prevCfg.SubnetIP, prevCfg.SubnetLen, prevCfg.IPAddrRange)
+}
+
+// UpdateNetwork updates the parameters of an existing network in place.
+// Gateways and the IPv6 subnet can be changed as long as they don't conflict
+// with allocated addresses, the IPv4 subnet can only be expanded. In docker
+// mode, the subnet and gateways of a network can only be changed while it
+// has no endpoints, because its docker networks have to be recreated.
+func UpdateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, tenantName string) error {
+	gstate.GlobalMutex.Lock()
+	defer gstate.GlobalMutex.Unlock()
+
+	nwCfg, epgList, changed, err := updateNetworkState(network, stateDriver, tenantName)
+	if err != nil {
+		return err
+	}
+	quarantineChanged, err := updateNetworkQuarantine(nwCfg, network)
+	if err != nil {
+		return err
+	}
+	servicePoolChanged, err := updateNetworkServiceIPPool(nwCfg, network)
 	if err != nil {
 		return err
 	}
-
-	ipv6Subnet, ipv6SubnetLen, _ := netutils.ParseCIDR(network.IPv6SubnetCIDR)
-
-	// construct and update network state
-	nwCfg = &mastercfg.CfgNetworkState{
-		Tenant:        tenantName,
-		NetworkName:   network.Name,
-		NwType:        network.NwType,
-		PktTagType:    network.PktTagType,
-		SubnetIP:      subnetIP,
-		SubnetLen:     subnetLen,
-		IPv6Subnet:    ipv6Subnet,
-		IPv6SubnetLen: ipv6SubnetLen,
+	if !changed && !quarantineChanged && !servicePoolChanged {
+		return nil
 	}
 
-	nwCfg.ID = networkID
-	nwCfg.StateDriver = stateDriver
-
-	netutils.InitSubnetBitset(&nwCfg.IPAllocMap, nwCfg.SubnetLen)
-	subnetAddr := netutils.GetSubnetAddr(nwCfg.SubnetIP, nwCfg.SubnetLen)
-	nwCfg.SubnetIP = subnetAddr
-	nwCfg.IPAddrRange = netutils.GetIPAddrRange(subnetIP, subnetLen)
-
-	if network.Gateway != "" {
-		nwCfg.Gateway = network.Gateway
+	// keep the settings to roll back to
+	prevCfg := &mastercfg.CfgNetworkState{}
+	prevCfg.StateDriver = stateDriver
+	if err := prevCfg.Read(nwCfg.ID); err != nil {
+		log.Errorf("network %s is not operational", nwCfg.ID)
+		return err
+	}
 
-		// Reserve gateway IP address if gateway is specified
-		ipAddrValue, err := netutils.GetIPNumber(subnetAddr, nwCfg.SubnetLen, 32, nwCfg.Gateway)
+	recreate := false
+	if changed {
+		recreate, err = checkDockNetUpdate(nwCfg)
 		if err != nil {
-			log.Errorf("Error parsing gateway address %s. Err: %v", nwCfg.Gateway, err)
 			return err
 		}
-		nwCfg.IPAllocMap.Set(ipAddrValue)
+	}
+	if recreate {
+		err = recreateDockNets(nwCfg, epgList)
+		if err != nil {
+			restoreNetworkUpdate(prevCfg, nil, epgList, nil, recreate)
+			return err
+		}
 	}
 
-	if strings.Contains(subnetIP, "-") {
-		netutils.SetBitsOutsideRange(&nwCfg.IPAllocMap, subnetIP, subnetLen)
+	// addresses are allocated without the global mutex, so the update is
+	// applied again on the latest state. agents pick up the new gateway and
+	// subnet through the network watch
+	epList := networkEndpoints(stateDriver, nwCfg.ID)
+	err = core.UpdateState(nwCfg, nwCfg.ID, func() error {
+		return applyNetworkUpdate(nwCfg, epList, network)
+	})
+	if err != nil {
+		log.Errorf("error updating nw config. Error: %s", err)
+		restoreNetworkUpdate(prevCfg, nil, epgList, nil, recreate)
+		return err
 	}
 
-	if network.IPv6Gateway != "" {
-		nwCfg.IPv6Gateway = network.IPv6Gateway
+	if nwCfg.SubnetIP == prevCfg.SubnetIP && nwCfg.SubnetLen == prevCfg.SubnetLen {
+		return nil
+	}
 
-		// Reserve gateway IPv6 address if gateway is specified
-		hostID, err := netutils.GetIPv6HostID(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, nwCfg.IPv6Gateway)
+	updated := []*mastercfg.EndpointGroupState{}
+	for _, epgCfg := range epgList {
+		if len(epgCfg.IPPool) == 0 {
+			continue
+		}
+		err = core.UpdateState(epgCfg, epgCfg.ID, func() error {
+			return updateEPGSubnet(epgCfg, prevCfg, nwCfg)
+		})
 		if err != nil {
-			log.Errorf("Error parsing gateway address %s. Err: %v", nwCfg.IPv6Gateway, err)
+			log.Errorf("error updating epg config. Error: %s", err)
+			restoreNetworkUpdate(prevCfg, nwCfg, epgList, updated, recreate)
 			return err
 		}
-		netutils.ReserveIPv6HostID(hostID, &nwCfg.IPv6AllocMap)
+		updated = append(updated, epgCfg)
 	}
 
-	// Allocate pkt tags
-	reqPktTag := uint(network.PktTag)
-	if nwCfg.PktTagType == "vlan" {
-		pktTag, err = gCfg.AllocVLAN(reqPktTag)
+	return nil
+}
+
+// restoreNetworkUpdate rolls back a network update that failed. The updated
+// endpoint groups and the network, when given, are reverted on their latest
+// state so that addresses allocated in the meantime are kept.
+func restoreNetworkUpdate(prevCfg, nwCfg *mastercfg.CfgNetworkState, epgList []*mastercfg.EndpointGroupState,
+	updated []*mastercfg.EndpointGroupState, recreated bool) {
+	for _, epgCfg := range updated {
+		err := core.UpdateState(epgCfg, epgCfg.ID, func() error {
+			return updateEPGSubnet(epgCfg, nwCfg, prevCfg)
+		})
 		if err != nil {
-			return err
+			log.Errorf("error restoring epg config %s. Error: %s", epgCfg.ID, err)
 		}
-	} else if nwCfg.PktTagType == "vxlan" {
-		extPktTag, pktTag, err = gCfg.AllocVXLAN(reqPktTag)
+	}
+
+	if nwCfg != nil {
+		err := core.UpdateState(nwCfg, nwCfg.ID, func() error {
+			return revertNetworkUpdate(nwCfg, prevCfg)
+		})
 		if err != nil {
-			return err
+			log.Errorf("error restoring nw config %s. Error: %s", nwCfg.ID, err)
 		}
 	}
 
-	nwCfg.ExtPktTag = int(extPktTag)
-	nwCfg.PktTag = int(pktTag)
-
-	err = nwCfg.Write()
-	if err != nil {
-		return err
+	if recreated {
+		if err := recreateDockNets(prevCfg, epgList); err != nil {
+			log.Errorf("error restoring docker networks of %s. Error: %s", prevCfg.ID, err)
+		}
 	}
+}
 
-	// Skip docker and service container configs for infra nw
-	if network.NwType == "infra" {
-		return nil
-	}
+// PreviewNetworkUpdate runs the validation of UpdateNetwork and returns the
+// network state it would write. Nothing is written to the state store.
+func PreviewNetworkUpdate(network intent.ConfigNetwork, stateDriver core.StateDriver, tenantName string) (*mastercfg.CfgNetworkState, error) {
+	gstate.GlobalMutex.Lock()
+	defer gstate.GlobalMutex.Unlock()
 
-	aci, _ := IsAciConfigured()
-	if aci {
-		// Skip docker network creation for ACI fabric mode.
-		return nil
+	nwCfg, _, changed, err := updateNetworkState(network, stateDriver, tenantName)
+	if err != nil {
+		return nil, err
 	}
-
-	if GetClusterMode() == "docker" {
-		// Create the network in docker
-		err = docknet.CreateDockNet(tenantName, network.Name, "", nwCfg)
-		if err != nil {
-			log.Errorf("Error creating network %s in docker. Err: %v", nwCfg.ID, err)
-			return err
+	if changed {
+		if _, err = checkDockNetUpdate(nwCfg); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	if _, err = updateNetworkQuarantine(nwCfg, network); err != nil {
+		return nil, err
+	}
+
+	_, err = updateNetworkServiceIPPool(nwCfg, network)
+	return nwCfg, err
 }
 
 // CreateNetworks creates the necessary virtual networks for the tenant
@@ -223,8 +849,8 @@ func freeNetworkResources(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetw
 			return err
 		}
 	} else if nwCfg.PktTagType == "vxlan" {
-		log.Infof("freeing vlan %d vxlan %d", nwCfg.PktTag, nwCfg.ExtPktTag)
-		err = gCfg.FreeVXLAN(uint(nwCfg.ExtPktTag), uint(nwCfg.PktTag))
+		log.Infof("freeing vxlan %d", nwCfg.ExtPktTag)
+		err = gCfg.FreeVXLAN(uint(nwCfg.ExtPktTag))
 		if err != nil {
 			return err
 		}
@@ -256,6 +882,9 @@ func DeleteNetworkID(stateDriver core.StateDriver, netID string) error {
 		if hasActiveEndpoints(nwCfg) {
 			return core.Errorf("Error: Network has active endpoints")
 		}
+		if len(nwCfg.ReservedIPs) > 0 {
+			return core.Errorf("Error: Network has ip reservations")
+		}
 
 		if GetClusterMode() == "docker" && aci == false {
 			// Delete the docker network
@@ -294,7 +923,9 @@ func DeleteNetworkID(stateDriver core.StateDriver, netID string) error {
 		return err
 	}
 
-	return err
+	return deleteAddrBlocks(stateDriver, func(blkCfg *mastercfg.CfgAddrBlockState) bool {
+		return blkCfg.NetworkID == netID
+	})
 }
 
 // DeleteNetworks removes all the virtual networks for a given tenant.
@@ -325,74 +956,141 @@ func DeleteNetworks(stateDriver core.StateDriver, tenant *intent.ConfigTenant) e
 	return err
 }
 
-// ListAllocatedIPs returns a string of allocated IPs in a network
+// ListAllocatedIPs returns a string of allocated IPs in a network,
+// quarantined addresses and free addresses of host blocks are not included
 func ListAllocatedIPs(nwCfg *mastercfg.CfgNetworkState) string {
-	return netutils.ListAllocatedIPs(nwCfg.IPAllocMap, nwCfg.IPAddrRange, nwCfg.SubnetIP, nwCfg.SubnetLen)
+	usedMap := unquarantinedMap(nwCfg, nwCfg.Quarantine, &nwCfg.IPAllocMap)
+	clearFreeBlockAddrs(nwCfg, "", usedMap)
+	return netutils.ListAllocatedIPs(*usedMap, nwCfg.IPAddrRange, nwCfg.SubnetIP, nwCfg.SubnetLen)
 }
 
-// ListAvailableIPs returns a string of available IPs in a network
+// ListAvailableIPs returns a string of available IPs in a network,
+// addresses that are still quarantined are not available
 func ListAvailableIPs(nwCfg *mastercfg.CfgNetworkState) string {
-	return netutils.ListAvailableIPs(nwCfg.IPAllocMap, nwCfg.SubnetIP, nwCfg.SubnetLen)
+	_, availMap := activeQuarantine(nwCfg, nwCfg.Quarantine, &nwCfg.IPAllocMap)
+	return netutils.ListAvailableIPs(*availMap, nwCfg.SubnetIP, nwCfg.SubnetLen)
 }
 
-// Allocate an address from the network
+// Allocate an address from the network. Addresses are allocated with
+// conditional writes of the network and epg state, so that concurrent
+// allocations never hand out the same address.
 func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState,
 	reqAddr string, isIPv6 bool) (string, error) {
 	var ipAddress string
+
+	// reserved addresses are always allocated from the network
+	if epgPool(epgCfg, isIPv6) == "" || isReservedIP(nwCfg, reqAddr) {
+		err := core.UpdateState(nwCfg, nwCfg.ID, func() error {
+			var err error
+			ipAddress, err = allocNetworkAddress(nwCfg, reqAddr, isIPv6)
+			return err
+		})
+		if err != nil {
+			log.Errorf("error updating nw config. Error: %s", err)
+			return "", err
+		}
+
+		return ipAddress, nil
+	}
+
+	// allocate from epg pool
+	err := core.UpdateState(epgCfg, epgCfg.ID, func() error {
+		var err error
+		ipAddress, err = allocEPGAddress(nwCfg, epgCfg, reqAddr, isIPv6)
+		return err
+	})
+	if err != nil {
+		log.Errorf("error updating epg config. Error: %s", err)
+		return "", err
+	}
+
+	// a failed update leaves nwCfg cleared
+	subnetIP, subnetLen := nwCfg.SubnetIP, nwCfg.SubnetLen
+	err = core.UpdateState(nwCfg, nwCfg.ID, func() error {
+		// see allocNetworkAddress on when the address count is incremented
+		if reqAddr == "" {
+			nwCfg.EpAddrCount++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("error updating nw config. Error: %s", err)
+		// give the address back to the epg pool, the network doesn't count it
+		if ipAddress != "" {
+			if rerr := clearEPGAddress(epgCfg, subnetIP, subnetLen, ipAddress); rerr != nil {
+				log.Errorf("error releasing %s in epg %s. Error: %s", ipAddress, epgCfg.ID, rerr)
+			}
+		}
+		return "", err
+	}
+
+	return ipAddress, nil
+}
+
+// clearEPGAddress clears an address in the ip pool of an epg without
+// quarantining it
+func clearEPGAddress(epgCfg *mastercfg.EndpointGroupState, subnetIP string, subnetLen uint, ipAddress string) error {
+	return core.UpdateState(epgCfg, epgCfg.ID, func() error {
+		if netutils.IsIPv6(ipAddress) {
+			epgCfg.EPGIPv6Alloc.Release(ipAddress)
+			return nil
+		}
+
+		ipAddrValue, err := netutils.GetIPNumber(subnetIP, subnetLen, 32, ipAddress)
+		if err != nil {
+			return err
+		}
+		epgCfg.EPGIPAllocMap.Clear(ipAddrValue)
+		return nil
+	})
+}
+
+// allocNetworkAddress allocates an address from the network subnet
+func allocNetworkAddress(nwCfg *mastercfg.CfgNetworkState, reqAddr string, isIPv6 bool) (string, error) {
+	var ipAddress string
 	var ipAddrValue uint
 	var found bool
 	var err error
-	var hostID string
 
 	// alloc address
 	if reqAddr == "" {
+		if err = expireNetworkQuarantine(nwCfg); err != nil {
+			return "", err
+		}
+
 		if isIPv6 {
-			// Get the next available IPv6 address
-			hostID, err = netutils.GetNextIPv6HostID(nwCfg.IPv6LastHost, nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, nwCfg.IPv6AllocMap)
-			if err != nil {
-				log.Errorf("create eps: error allocating ip. Error: %s", err)
+			// Get the lowest available IPv6 address
+			if err = initIPv6Alloc(nwCfg); err != nil {
 				return "", err
 			}
-			ipAddress, err = netutils.GetSubnetIPv6(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, hostID)
+			ipAddress, err = nwCfg.IPv6Alloc.Allocate()
+			if err != nil && releaseOldestQuarantined(nwCfg, &nwCfg.Quarantine,
+				&nwCfg.IPAllocMap, &nwCfg.IPv6Alloc, isIPv6) {
+				ipAddress, err = nwCfg.IPv6Alloc.Allocate()
+			}
 			if err != nil {
-				log.Errorf("create eps: error acquiring subnet ip. Error: %s", err)
+				log.Errorf("create eps: error allocating ip. Error: %s", err)
 				return "", err
 			}
-			nwCfg.IPv6LastHost = hostID
-			netutils.ReserveIPv6HostID(hostID, &nwCfg.IPv6AllocMap)
 		} else {
-			if epgCfg != nil && len(epgCfg.IPPool) > 0 { // allocate from epg network
-				log.Infof("allocating ip address from epg pool %s", epgCfg.IPPool)
-				ipAddrValue, found = netutils.NextClear(epgCfg.EPGIPAllocMap, 0, nwCfg.SubnetLen)
-				if !found {
-					log.Errorf("auto allocation failed - address exhaustion in pool %s",
-						epgCfg.IPPool)
-					err = core.Errorf("auto allocation failed - address exhaustion in pool %s",
-						epgCfg.IPPool)
-					return "", err
-				}
-				ipAddress, err = netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddrValue)
-				if err != nil {
-					log.Errorf("create eps: error acquiring subnet ip. Error: %s", err)
-					return "", err
-				}
-				epgCfg.EPGIPAllocMap.Set(ipAddrValue)
-			} else {
+			ipAddrValue, found = netutils.NextClear(nwCfg.IPAllocMap, 0, nwCfg.SubnetLen)
+			if !found && releaseOldestQuarantined(nwCfg, &nwCfg.Quarantine,
+				&nwCfg.IPAllocMap, &nwCfg.IPv6Alloc, isIPv6) {
 				ipAddrValue, found = netutils.NextClear(nwCfg.IPAllocMap, 0, nwCfg.SubnetLen)
-				if !found {
-					log.Errorf("auto allocation failed - address exhaustion in subnet %s/%d",
-						nwCfg.SubnetIP, nwCfg.SubnetLen)
-					err = core.Errorf("auto allocation failed - address exhaustion in subnet %s/%d",
-						nwCfg.SubnetIP, nwCfg.SubnetLen)
-					return "", err
-				}
-				ipAddress, err = netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddrValue)
-				if err != nil {
-					log.Errorf("create eps: error acquiring subnet ip. Error: %s", err)
-					return "", err
-				}
-				nwCfg.IPAllocMap.Set(ipAddrValue)
 			}
+			if !found {
+				log.Errorf("auto allocation failed - address exhaustion in subnet %s/%d",
+					nwCfg.SubnetIP, nwCfg.SubnetLen)
+				err = core.Errorf("auto allocation failed - address exhaustion in subnet %s/%d",
+					nwCfg.SubnetIP, nwCfg.SubnetLen)
+				return "", err
+			}
+			ipAddress, err = netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddrValue)
+			if err != nil {
+				log.Errorf("create eps: error acquiring subnet ip. Error: %s", err)
+				return "", err
+			}
+			nwCfg.IPAllocMap.Set(ipAddrValue)
 		}
 
 		// Docker, Mesos issue a Alloc Address first, followed by a CreateEndpoint
@@ -403,117 +1101,290 @@ func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.End
 		// allocateAddress had allocated in the earlier call.
 		nwCfg.EpAddrCount++
 
+	} else if claimed, found := nwCfg.ReservedIPs[reservedIPKey(reqAddr)]; found {
+		// reserved addresses are allocated to their workload by
+		// claimReservedAddress before they are requested
+		if !claimed {
+			return "", core.Errorf("address %s is reserved in network %s", reqAddr, nwCfg.ID)
+		}
+		ipAddress = reqAddr
+
 	} else if reqAddr != "" && nwCfg.SubnetIP != "" {
+		// a requested address is handed out even if it is quarantined
+		removeQuarantined(&nwCfg.Quarantine, reqAddr)
+
 		if isIPv6 {
-			hostID, err = netutils.GetIPv6HostID(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, reqAddr)
+			if err = initIPv6Alloc(nwCfg); err != nil {
+				return "", err
+			}
+			err = nwCfg.IPv6Alloc.Reserve(reqAddr)
 			if err != nil {
-				log.Errorf("create eps: error getting host id from hostIP %s Subnet %s/%d. Error: %s",
+				log.Errorf("create eps: error reserving %s in Subnet %s/%d. Error: %s",
 					reqAddr, nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, err)
 				return "", err
 			}
-			netutils.ReserveIPv6HostID(hostID, &nwCfg.IPv6AllocMap)
 		} else {
-
-			if epgCfg != nil && len(epgCfg.IPPool) > 0 { // allocate from epg network
-				ipAddrValue, err = netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, reqAddr)
-				if err != nil {
-					log.Errorf("create eps: error getting host id from hostIP %s pool %s. Error: %s",
-						reqAddr, epgCfg.IPPool, err)
-					return "", err
-				}
-				epgCfg.EPGIPAllocMap.Set(ipAddrValue)
-			} else {
-				ipAddrValue, err = netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, reqAddr)
-				if err != nil {
-					log.Errorf("create eps: error getting host id from hostIP %s Subnet %s/%d. Error: %s",
-						reqAddr, nwCfg.SubnetIP, nwCfg.SubnetLen, err)
-					return "", err
-				}
-				nwCfg.IPAllocMap.Set(ipAddrValue)
+			ipAddrValue, err = netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, reqAddr)
+			if err != nil {
+				log.Errorf("create eps: error getting host id from hostIP %s Subnet %s/%d. Error: %s",
+					reqAddr, nwCfg.SubnetIP, nwCfg.SubnetLen, err)
+				return "", err
 			}
+			nwCfg.IPAllocMap.Set(ipAddrValue)
 		}
 
 		ipAddress = reqAddr
 	}
 
-	if epgCfg != nil && len(epgCfg.IPPool) > 0 {
-		err = epgCfg.Write()
+	return ipAddress, nil
+}
+
+// epgPool returns the ip pool of an epg for an address family, or an empty
+// string if addresses are allocated from the network
+func epgPool(epgCfg *mastercfg.EndpointGroupState, isIPv6 bool) string {
+	if epgCfg == nil {
+		return ""
+	}
+	if isIPv6 {
+		return epgCfg.IPv6Pool
+	}
+	return epgCfg.IPPool
+}
+
+// allocEPGAddress allocates an address from the ip pool of an epg
+func allocEPGAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState,
+	reqAddr string, isIPv6 bool) (string, error) {
+	if reqAddr == "" {
+		if err := expireEPGQuarantine(nwCfg, epgCfg); err != nil {
+			return "", err
+		}
+	} else {
+		// a requested address is handed out even if it is quarantined
+		removeQuarantined(&epgCfg.Quarantine, reqAddr)
+	}
+
+	if isIPv6 {
+		if reqAddr == "" {
+			log.Infof("allocating ip address from epg pool %s", epgCfg.IPv6Pool)
+			ipAddress, err := epgCfg.EPGIPv6Alloc.Allocate()
+			if err != nil && releaseOldestQuarantined(nwCfg, &epgCfg.Quarantine,
+				&epgCfg.EPGIPAllocMap, &epgCfg.EPGIPv6Alloc, isIPv6) {
+				ipAddress, err = epgCfg.EPGIPv6Alloc.Allocate()
+			}
+			if err != nil {
+				log.Errorf("auto allocation failed in pool %s. Error: %s", epgCfg.IPv6Pool, err)
+				return "", err
+			}
+			return ipAddress, nil
+		}
+
+		err := epgCfg.EPGIPv6Alloc.Reserve(reqAddr)
+		if err != nil {
+			log.Errorf("create eps: error reserving %s in pool %s. Error: %s",
+				reqAddr, epgCfg.IPv6Pool, err)
+			return "", err
+		}
+		return reqAddr, nil
+	}
+
+	if reqAddr == "" {
+		log.Infof("allocating ip address from epg pool %s", epgCfg.IPPool)
+		ipAddrValue, found := netutils.NextClearInPools(epgCfg.EPGIPAllocMap, epgCfg.IPPool,
+			nwCfg.SubnetIP, nwCfg.SubnetLen)
+		if !found && releaseOldestQuarantined(nwCfg, &epgCfg.Quarantine,
+			&epgCfg.EPGIPAllocMap, &epgCfg.EPGIPv6Alloc, isIPv6) {
+			ipAddrValue, found = netutils.NextClearInPools(epgCfg.EPGIPAllocMap, epgCfg.IPPool,
+				nwCfg.SubnetIP, nwCfg.SubnetLen)
+		}
+		if !found {
+			log.Errorf("auto allocation failed - address exhaustion in pool %s",
+				epgCfg.IPPool)
+			return "", core.Errorf("auto allocation failed - address exhaustion in pool %s",
+				epgCfg.IPPool)
+		}
+		ipAddress, err := netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddrValue)
 		if err != nil {
-			log.Errorf("error writing epg config. Error: %s", err)
+			log.Errorf("create eps: error acquiring subnet ip. Error: %s", err)
 			return "", err
 		}
+		epgCfg.EPGIPAllocMap.Set(ipAddrValue)
+
+		return ipAddress, nil
 	}
 
-	err = nwCfg.Write()
+	if nwCfg.SubnetIP == "" {
+		return "", nil
+	}
+
+	ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, reqAddr)
 	if err != nil {
-		log.Errorf("error writing nw config. Error: %s", err)
+		log.Errorf("create eps: error getting host id from hostIP %s pool %s. Error: %s",
+			reqAddr, epgCfg.IPPool, err)
 		return "", err
 	}
+	epgCfg.EPGIPAllocMap.Set(ipAddrValue)
 
-	return ipAddress, nil
+	return reqAddr, nil
 }
 
 // networkReleaseAddress release the ip address
 func networkReleaseAddress(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState, ipAddress string) error {
+	// addresses allocated by a host go back to its address block
+	if released, err := releaseBlockAddress(nwCfg.StateDriver, nwCfg.ID, ipAddress); err != nil || released {
+		return err
+	}
+
 	isIPv6 := netutils.IsIPv6(ipAddress)
-	if isIPv6 {
-		hostID, err := netutils.GetIPv6HostID(nwCfg.SubnetIP, nwCfg.SubnetLen, ipAddress)
+	if epgPool(epgCfg, isIPv6) == "" || isReservedIP(nwCfg, ipAddress) {
+		err := core.UpdateState(nwCfg, nwCfg.ID, func() error {
+			return releaseNetworkAddress(nwCfg, ipAddress)
+		})
 		if err != nil {
-			log.Errorf("error getting host id from hostIP %s Subnet %s/%d. Error: %s",
-				ipAddress, nwCfg.SubnetIP, nwCfg.SubnetLen, err)
+			log.Errorf("error updating nw config. Error: %s", err)
 			return err
 		}
-		// networkReleaseAddress is called from multiple places
-		// Make sure we decrement the EpCount only if the IPAddress
-		// was not already freed earlier
-		if _, found := nwCfg.IPv6AllocMap[hostID]; found {
-			nwCfg.EpAddrCount--
+
+		return nil
+	}
+
+	log.Infof("releasing epg ip: %s", ipAddress)
+	released := false
+	err := core.UpdateState(epgCfg, epgCfg.ID, func() error {
+		if findQuarantined(epgCfg.Quarantine, ipAddress) >= 0 {
+			released = false
+			return nil
 		}
-		delete(nwCfg.IPv6AllocMap, hostID)
-	} else {
-		if epgCfg != nil && len(epgCfg.IPPool) > 0 {
-			log.Infof("releasing epg ip: %s", ipAddress)
+
+		if isIPv6 {
+			if quarantineEnabled(nwCfg) {
+				released = epgCfg.EPGIPv6Alloc.IsAllocated(ipAddress)
+			} else {
+				released = epgCfg.EPGIPv6Alloc.Release(ipAddress)
+			}
+		} else {
 			ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddress)
 			if err != nil {
 				log.Errorf("error getting host id from hostIP %s pool %s. Error: %s",
 					ipAddress, epgCfg.IPPool, err)
 				return err
 			}
-			// networkReleaseAddress is called from multiple places
-			// Make sure we decrement the EpCount only if the IPAddress
-			// was not already freed earlier
-			if epgCfg.EPGIPAllocMap.Test(ipAddrValue) {
-				nwCfg.EpAddrCount--
-			}
-			epgCfg.EPGIPAllocMap.Clear(ipAddrValue)
-			if err := epgCfg.Write(); err != nil {
-				log.Errorf("error writing epg config. Error: %s", err)
-				return err
+			released = epgCfg.EPGIPAllocMap.Test(ipAddrValue)
+			if !quarantineEnabled(nwCfg) {
+				epgCfg.EPGIPAllocMap.Clear(ipAddrValue)
 			}
+		}
 
-		} else {
-			ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddress)
-			if err != nil {
-				log.Errorf("error getting host id from hostIP %s Subnet %s/%d. Error: %s",
-					ipAddress, nwCfg.SubnetIP, nwCfg.SubnetLen, err)
-				return err
-			}
-			// networkReleaseAddress is called from multiple places
-			// Make sure we decrement the EpCount only if the IPAddress
-			// was not already freed earlier
-			if nwCfg.IPAllocMap.Test(ipAddrValue) {
+		// quarantined addresses stay marked in the epg pool
+		if released && quarantineEnabled(nwCfg) {
+			epgCfg.Quarantine = addQuarantined(epgCfg.Quarantine, ipAddress)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("error updating epg config. Error: %s", err)
+		return err
+	}
+
+	err = core.UpdateState(nwCfg, nwCfg.ID, func() error {
+		// networkReleaseAddress is called from multiple places
+		// Make sure we decrement the EpCount only if the IPAddress
+		// was not already freed earlier
+		if released {
+			nwCfg.EpAddrCount--
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("error updating nw config. Error: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// releaseNetworkAddress releases an address of the network subnet
+func releaseNetworkAddress(nwCfg *mastercfg.CfgNetworkState, ipAddress string) error {
+	if claimed, found := nwCfg.ReservedIPs[reservedIPKey(ipAddress)]; found {
+		// reserved addresses stay allocated until the reservation is deleted
+		if claimed {
+			nwCfg.ReservedIPs[reservedIPKey(ipAddress)] = false
+			nwCfg.EpAddrCount--
+		}
+		return nil
+	}
+
+	if findQuarantined(nwCfg.Quarantine, ipAddress) >= 0 {
+		return nil
+	}
+
+	if netutils.IsIPv6(ipAddress) {
+		if err := initIPv6Alloc(nwCfg); err != nil {
+			return err
+		}
+		if quarantineEnabled(nwCfg) {
+			// quarantined addresses stay marked in the allocator
+			if nwCfg.IPv6Alloc.IsAllocated(ipAddress) {
+				nwCfg.Quarantine = addQuarantined(nwCfg.Quarantine, ipAddress)
 				nwCfg.EpAddrCount--
 			}
-			nwCfg.IPAllocMap.Clear(ipAddrValue)
+			return nil
+		}
+		// networkReleaseAddress is called from multiple places
+		// Make sure we decrement the EpCount only if the IPAddress
+		// was not already freed earlier
+		if nwCfg.IPv6Alloc.Release(ipAddress) {
+			nwCfg.EpAddrCount--
 		}
+		return nil
 	}
 
-	err := nwCfg.Write()
+	ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddress)
 	if err != nil {
-		log.Errorf("error writing nw config. Error: %s", err)
+		log.Errorf("error getting host id from hostIP %s Subnet %s/%d. Error: %s",
+			ipAddress, nwCfg.SubnetIP, nwCfg.SubnetLen, err)
+		return err
+	}
+	// networkReleaseAddress is called from multiple places
+	// Make sure we decrement the EpCount only if the IPAddress
+	// was not already freed earlier
+	if nwCfg.IPAllocMap.Test(ipAddrValue) {
+		nwCfg.EpAddrCount--
+		if quarantineEnabled(nwCfg) {
+			// quarantined addresses stay marked in the allocator
+			nwCfg.Quarantine = addQuarantined(nwCfg.Quarantine, ipAddress)
+			return nil
+		}
+	}
+	nwCfg.IPAllocMap.Clear(ipAddrValue)
+
+	return nil
+}
+
+// initIPv6Alloc sets up the IPv6 allocator of a network created by an older
+// version, which kept the allocated IPv6 host ids in IPv6AllocMap
+func initIPv6Alloc(nwCfg *mastercfg.CfgNetworkState) error {
+	if nwCfg.IPv6Subnet == "" || nwCfg.IPv6Alloc.First != nil {
+		return nil
+	}
+
+	err := nwCfg.IPv6Alloc.InitSubnet(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen)
+	if err != nil {
+		log.Errorf("Error initializing IPv6 allocator of network %s. Err: %v", nwCfg.ID, err)
 		return err
 	}
 
+	for hostID := range nwCfg.IPv6AllocMap {
+		ipAddress, err := netutils.GetSubnetIPv6(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, hostID)
+		if err == nil {
+			err = nwCfg.IPv6Alloc.Reserve(ipAddress)
+		}
+		if err != nil {
+			log.Errorf("Error moving IPv6 host id %s of network %s. Err: %v", hostID, nwCfg.ID, err)
+			return err
+		}
+	}
+	nwCfg.IPv6AllocMap = nil
+
 	return nil
 }
 
@@ -149,6 +149,24 @@ func PolicyAddRule(policy *contivModel.Policy, rule *contivModel.Rule) error {
 	return nil
 }
 
+// PolicyRuleIDs returns the ids of the ofnet rules installed for a rule
+// in all endpoint groups the policy is attached to
+func PolicyRuleIDs(policy *contivModel.Policy, rule *contivModel.Rule) []string {
+	ruleIDs := []string{}
+	for epgKey := range policy.LinkSets.EndpointGroups {
+		gp := mastercfg.FindEpgPolicy(epgKey + ":" + policy.Key)
+		if gp == nil || gp.RuleMaps[rule.Key] == nil {
+			continue
+		}
+
+		for ruleID := range gp.RuleMaps[rule.Key].OfnetRules {
+			ruleIDs = append(ruleIDs, ruleID)
+		}
+	}
+
+	return ruleIDs
+}
+
 // PolicyDelRule removes a rule from existing policy
 func PolicyDelRule(policy *contivModel.Policy, rule *contivModel.Rule) error {
 	// Dont install policies in ACI mode
@@ -183,3 +201,73 @@ func PolicyDelRule(policy *contivModel.Policy, rule *contivModel.Rule) error {
 
 	return nil
 }
+
+// PolicyUpdateRule replaces a rule in existing policy. If the rule can't be
+// replaced in every associated endpoint group, the groups already updated
+// get their original rule back.
+func PolicyUpdateRule(policy *contivModel.Policy, rule *contivModel.Rule) error {
+	// Dont install policies in ACI mode
+	if !isPolicyEnabled() {
+		return nil
+	}
+
+	// Find all the epg policies before updating any of them
+	gps := []*mastercfg.EpgPolicy{}
+	for epgKey := range policy.LinkSets.EndpointGroups {
+		gpKey := epgKey + ":" + policy.Key
+
+		gp := mastercfg.FindEpgPolicy(gpKey)
+		if gp == nil {
+			log.Errorf("Failed to find the epg policy %s", gpKey)
+			return core.Errorf("epg policy not found")
+		}
+		if gp.RuleMaps[rule.Key] == nil {
+			log.Errorf("Failed to find the rule %s in epg policy %s", rule.Key, gpKey)
+			return core.Errorf("Rule does not exists")
+		}
+
+		gps = append(gps, gp)
+	}
+
+	// original rule of each epg policy updated so far
+	updated := make(map[*mastercfg.EpgPolicy]*contivModel.Rule)
+	for _, gp := range gps {
+		oldRule := gp.RuleMaps[rule.Key].Rule
+
+		// update the Rule
+		err := gp.UpdateRule(rule)
+		if err != nil {
+			log.Errorf("Error updating the rule %s in epg policy %s. Err: %v", rule.Key, gp.EpgPolicyKey, err)
+			restorePolicyRules(updated)
+			return err
+		}
+		updated[gp] = oldRule
+
+		// Save the policy state
+		err = gp.Write()
+		if err != nil {
+			log.Errorf("Error writing policy %s to state store. Err: %v", gp.EpgPolicyKey, err)
+			restorePolicyRules(updated)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restorePolicyRules puts the original rules back in epg policies after a
+// failed rule update
+func restorePolicyRules(updated map[*mastercfg.EpgPolicy]*contivModel.Rule) {
+	for gp, oldRule := range updated {
+		err := gp.UpdateRule(oldRule)
+		if err != nil {
+			log.Errorf("Error restoring the rule %s in epg policy %s. Err: %v", oldRule.Key, gp.EpgPolicyKey, err)
+			continue
+		}
+
+		err = gp.Write()
+		if err != nil {
+			log.Errorf("Error writing policy %s to state store. Err: %v", gp.EpgPolicyKey, err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils/netutils"
+	"github.com/jainvipin/bitset"
+)
+
+// Address pools, like the ip pool of an epg and the service ip pool of a
+// network, are comma separated lists of address ranges within the network
+// subnet. The ranges of a pool are reserved in the network alloc map, and
+// the alloc map of a pool has all addresses outside its ranges marked.
+
+// initPoolAllocMap initializes the alloc map of an address pool
+func initPoolAllocMap(nwCfg *mastercfg.CfgNetworkState, allocMap *bitset.BitSet, ipPool string) error {
+	netutils.InitSubnetBitset(allocMap, nwCfg.SubnetLen)
+	return netutils.SetBitsOutsidePools(allocMap, ipPool, nwCfg.SubnetIP, nwCfg.SubnetLen)
+}
+
+// setPoolRanges marks or clears the ranges of an address pool in a network
+// alloc map
+func setPoolRanges(nwCfg *mastercfg.CfgNetworkState, allocMap *bitset.BitSet, ipPool string, used bool) error {
+	for _, pool := range netutils.GetIPPools(ipPool) {
+		var err error
+		if used {
+			err = netutils.SetIPAddrRange(allocMap, pool, nwCfg.SubnetIP, nwCfg.SubnetLen)
+		} else {
+			err = netutils.ClearIPAddrRange(allocMap, pool, nwCfg.SubnetIP, nwCfg.SubnetLen)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reservedPoolMap returns the network alloc map with the ranges of the old
+// address pool moved to the ranges of the new one. Addresses that are only
+// in the new ranges must be free in the network.
+func reservedPoolMap(nwCfg *mastercfg.CfgNetworkState, oldIPPool, ipPool string) (*bitset.BitSet, error) {
+	allocMap := nwCfg.IPAllocMap.Clone()
+	if err := setPoolRanges(nwCfg, allocMap, oldIPPool, false); err != nil {
+		return nil, err
+	}
+
+	for _, pool := range netutils.GetIPPools(ipPool) {
+		if err := netutils.TestIPAddrRange(allocMap, pool, nwCfg.SubnetIP, nwCfg.SubnetLen); err != nil {
+			return nil, err
+		}
+		// mark the range, the ranges of a pool can not overlap
+		if err := netutils.SetIPAddrRange(allocMap, pool, nwCfg.SubnetIP, nwCfg.SubnetLen); err != nil {
+			return nil, err
+		}
+	}
+
+	return allocMap, nil
+}
+
+// movePoolRanges clears the ranges of the old address pool in the network
+// alloc map and marks the ranges of the new one
+func movePoolRanges(nwCfg *mastercfg.CfgNetworkState, oldIPPool, ipPool string) error {
+	allocMap := nwCfg.IPAllocMap.Clone()
+	if err := setPoolRanges(nwCfg, allocMap, oldIPPool, false); err != nil {
+		return err
+	}
+	if err := setPoolRanges(nwCfg, allocMap, ipPool, true); err != nil {
+		return err
+	}
+	nwCfg.IPAllocMap = *allocMap
+	return nil
+}
@@ -38,7 +38,11 @@ func SvcProviderUpdate(serviceID string, isDelete bool) error {
 		return svcProvider.Clear()
 	}
 
-	for _, provider := range mastercfg.ServiceLBDb[serviceID].Providers {
+	for providerID, provider := range mastercfg.ServiceLBDb[serviceID].Providers {
+		//leave out the providers taken out by the health check
+		if mastercfg.ServiceLBDb[serviceID].Unhealthy[providerID] {
+			continue
+		}
 		providerList = append(providerList, provider.IPAddress)
 	}
 
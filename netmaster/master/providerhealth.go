@@ -0,0 +1,201 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The providers of a service with a health check are checked by the
+// netplugin on their host, which reports health changes to the netmaster. An
+// unhealthy provider stays a provider of the service but is left out of the
+// provider list sent to the netplugins until it is reported healthy again.
+// Unhealthy providers are kept in the service state so that netplugins and a
+// restarted netmaster know them.
+
+const (
+	defaultHealthCheckInterval = 10
+	defaultHealthCheckTimeout  = 2
+	defaultHealthyThreshold    = 2
+	defaultUnhealthyThreshold  = 3
+)
+
+// Provider health status in service inspect
+const (
+	ProviderHealthy   = "healthy"
+	ProviderUnhealthy = "unhealthy"
+)
+
+// validateHealthCheck checks the health check of a service and fills in the
+// parameters that aren't set. The default port is the provider port of the
+// first tcp port of the service.
+func validateHealthCheck(healthCheck *intent.ConfigHealthCheck, ports []string) error {
+	switch healthCheck.Type {
+	case "":
+		*healthCheck = intent.ConfigHealthCheck{}
+		return nil
+	case intent.HealthCheckTCP:
+		if healthCheck.Path != "" {
+			return core.Errorf("health check path is only supported by http health checks")
+		}
+	case intent.HealthCheckHTTP:
+		if healthCheck.Path == "" {
+			healthCheck.Path = "/"
+		}
+		if !strings.HasPrefix(healthCheck.Path, "/") {
+			return core.Errorf("invalid health check path %s", healthCheck.Path)
+		}
+	default:
+		return core.Errorf("invalid health check type %s", healthCheck.Type)
+	}
+
+	if healthCheck.Port == 0 {
+		for _, port := range ports {
+			portInfo := strings.Split(port, ":")
+			if len(portInfo) == 3 && strings.ToLower(portInfo[2]) == "tcp" {
+				healthCheck.Port, _ = strconv.Atoi(portInfo[1])
+				break
+			}
+		}
+		if healthCheck.Port == 0 {
+			return core.Errorf("health check port is required for services without tcp ports")
+		}
+	}
+
+	if healthCheck.Interval == 0 {
+		healthCheck.Interval = defaultHealthCheckInterval
+	}
+	if healthCheck.Timeout == 0 {
+		healthCheck.Timeout = defaultHealthCheckTimeout
+		if healthCheck.Timeout > healthCheck.Interval {
+			healthCheck.Timeout = healthCheck.Interval
+		}
+	}
+	if healthCheck.Timeout > healthCheck.Interval {
+		return core.Errorf("health check timeout %ds is longer than the interval %ds", healthCheck.Timeout,
+			healthCheck.Interval)
+	}
+	if healthCheck.HealthyThreshold == 0 {
+		healthCheck.HealthyThreshold = defaultHealthyThreshold
+	}
+	if healthCheck.UnhealthyThreshold == 0 {
+		healthCheck.UnhealthyThreshold = defaultUnhealthyThreshold
+	}
+
+	return nil
+}
+
+// updateServiceLBHealthCheck changes the health check of a service, the
+// providers are put back if the health check is removed
+func updateServiceLBHealthCheck(stateDriver core.StateDriver, serviceID string, healthCheck intent.ConfigHealthCheck) error {
+	mastercfg.SvcMutex.Lock()
+	defer mastercfg.SvcMutex.Unlock()
+
+	service := mastercfg.ServiceLBDb[serviceID]
+	if service == nil {
+		return core.Errorf("service %s not found", serviceID)
+	}
+
+	serviceLbState := &mastercfg.CfgServiceLBState{}
+	serviceLbState.StateDriver = stateDriver
+	if err := serviceLbState.Read(serviceID); err != nil {
+		log.Errorf("Error reading service lb config for service %s. Err: %v", serviceID, err)
+		return err
+	}
+
+	providersChanged := false
+	service.HealthCheck = healthCheck
+	if healthCheck.Type == "" && len(service.Unhealthy) > 0 {
+		service.Unhealthy = nil
+		providersChanged = true
+	}
+	serviceLbState.HealthCheck = service.HealthCheck
+	serviceLbState.Unhealthy = service.Unhealthy
+
+	if err := serviceLbState.Write(); err != nil {
+		return err
+	}
+
+	if providersChanged {
+		return SvcProviderUpdate(serviceID, false)
+	}
+
+	return nil
+}
+
+// SetProviderHealth takes an unhealthy provider out of a service or puts a
+// healthy one back. Reports for providers that are no longer providers of
+// the service are ignored.
+func SetProviderHealth(stateDriver core.StateDriver, serviceID, providerID string, healthy bool) error {
+	mastercfg.SvcMutex.Lock()
+	defer mastercfg.SvcMutex.Unlock()
+
+	service := mastercfg.ServiceLBDb[serviceID]
+	if service == nil {
+		return core.Errorf("service %s not found", serviceID)
+	}
+	if service.Providers[providerID] == nil || service.HealthCheck.Type == "" {
+		log.Infof("Ignoring health of provider %s of service %s", providerID, serviceID)
+		return nil
+	}
+	if healthy != service.Unhealthy[providerID] {
+		return nil
+	}
+
+	serviceLbState := &mastercfg.CfgServiceLBState{}
+	serviceLbState.StateDriver = stateDriver
+	if err := serviceLbState.Read(serviceID); err != nil {
+		log.Errorf("Error reading service lb config for service %s. Err: %v", serviceID, err)
+		return err
+	}
+
+	if healthy {
+		log.Infof("Provider %s of service %s is healthy, putting it back", providerID, serviceID)
+		delete(service.Unhealthy, providerID)
+	} else {
+		log.Infof("Provider %s of service %s is unhealthy, taking it out", providerID, serviceID)
+		if service.Unhealthy == nil {
+			service.Unhealthy = make(map[string]bool)
+		}
+		service.Unhealthy[providerID] = true
+	}
+	serviceLbState.Unhealthy = service.Unhealthy
+
+	if err := serviceLbState.Write(); err != nil {
+		return err
+	}
+
+	return SvcProviderUpdate(serviceID, false)
+}
+
+// ProviderHealthStatus returns the health status of a provider of a service,
+// empty if the service has no health check
+func ProviderHealthStatus(service *mastercfg.ServiceLBInfo, providerID string) string {
+	if service.HealthCheck.Type == "" {
+		return ""
+	}
+	if service.Unhealthy[providerID] {
+		return ProviderUnhealthy
+	}
+	return ProviderHealthy
+}
@@ -0,0 +1,258 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils/netutils"
+	"github.com/jainvipin/bitset"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Released addresses are not handed out again right away, peers may still
+// have stale arp entries, dns records or connection state for them. When a
+// network has an address quarantine, released addresses stay marked in the
+// address allocators and are appended to the quarantine list of the network,
+// or of the epg for addresses of an epg pool. Addresses leave the quarantine
+// once they were held back for AddrQuarantineTime seconds and at least
+// AddrQuarantineCount addresses were released after them. The quarantine is
+// expired lazily when addresses are allocated.
+
+// validateQuarantine checks the address quarantine config of a network
+func validateQuarantine(quarantineTime, quarantineCount int) error {
+	if quarantineTime < 0 {
+		return core.Errorf("invalid address quarantine time %d", quarantineTime)
+	}
+	if quarantineCount < 0 {
+		return core.Errorf("invalid address quarantine count %d", quarantineCount)
+	}
+
+	return nil
+}
+
+// quarantineEnabled returns whether released addresses of a network are
+// held back from allocation
+func quarantineEnabled(nwCfg *mastercfg.CfgNetworkState) bool {
+	return nwCfg.AddrQuarantineTime > 0 || nwCfg.AddrQuarantineCount > 0
+}
+
+// isQuarantineExpired returns whether the i-th address of a quarantine list
+// served its quarantine
+func isQuarantineExpired(nwCfg *mastercfg.CfgNetworkState, quarantine []mastercfg.QuarantinedAddr,
+	i int, now time.Time) bool {
+	quarantineTime := time.Duration(nwCfg.AddrQuarantineTime) * time.Second
+	if now.Sub(quarantine[i].ReleasedAt) < quarantineTime {
+		return false
+	}
+
+	return len(quarantine)-1-i >= nwCfg.AddrQuarantineCount
+}
+
+// findQuarantined returns the index of an address in a quarantine list, or -1
+func findQuarantined(quarantine []mastercfg.QuarantinedAddr, ipAddress string) int {
+	ip := net.ParseIP(ipAddress)
+	for i, addr := range quarantine {
+		if ip.Equal(net.ParseIP(addr.IPAddress)) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// addQuarantined appends a released address to a quarantine list
+func addQuarantined(quarantine []mastercfg.QuarantinedAddr, ipAddress string) []mastercfg.QuarantinedAddr {
+	return append(quarantine, mastercfg.QuarantinedAddr{
+		IPAddress:  ipAddress,
+		ReleasedAt: time.Now(),
+	})
+}
+
+// removeQuarantined removes an address from a quarantine list and returns
+// whether it was quarantined. The address stays marked in the allocators.
+func removeQuarantined(quarantine *[]mastercfg.QuarantinedAddr, ipAddress string) bool {
+	i := findQuarantined(*quarantine, ipAddress)
+	if i < 0 {
+		return false
+	}
+
+	*quarantine = append((*quarantine)[:i], (*quarantine)[i+1:]...)
+	return true
+}
+
+// unmarkAddress clears an address in the allocators of a network or epg
+func unmarkAddress(nwCfg *mastercfg.CfgNetworkState, allocMap *bitset.BitSet,
+	ipv6Alloc *netutils.IPv6Allocator, ipAddress string) error {
+	if netutils.IsIPv6(ipAddress) {
+		ipv6Alloc.Release(ipAddress)
+		return nil
+	}
+
+	ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddress)
+	if err != nil {
+		log.Errorf("error getting host id from hostIP %s Subnet %s/%d. Error: %s",
+			ipAddress, nwCfg.SubnetIP, nwCfg.SubnetLen, err)
+		return err
+	}
+	allocMap.Clear(ipAddrValue)
+
+	return nil
+}
+
+// expireQuarantine releases the addresses of a quarantine list that served
+// their quarantine
+func expireQuarantine(nwCfg *mastercfg.CfgNetworkState, quarantine *[]mastercfg.QuarantinedAddr,
+	allocMap *bitset.BitSet, ipv6Alloc *netutils.IPv6Allocator) error {
+	now := time.Now()
+	kept := []mastercfg.QuarantinedAddr{}
+	for i, addr := range *quarantine {
+		if !isQuarantineExpired(nwCfg, *quarantine, i, now) {
+			kept = append(kept, addr)
+			continue
+		}
+
+		if err := unmarkAddress(nwCfg, allocMap, ipv6Alloc, addr.IPAddress); err != nil {
+			return err
+		}
+	}
+
+	if len(kept) == 0 {
+		kept = nil
+	}
+	*quarantine = kept
+
+	return nil
+}
+
+// releaseOldestQuarantined releases the address of an address family that
+// is quarantined the longest. It is used when the pool is exhausted, an
+// address that was held back is better than a failed allocation.
+func releaseOldestQuarantined(nwCfg *mastercfg.CfgNetworkState, quarantine *[]mastercfg.QuarantinedAddr,
+	allocMap *bitset.BitSet, ipv6Alloc *netutils.IPv6Allocator, isIPv6 bool) bool {
+	for _, addr := range *quarantine {
+		if netutils.IsIPv6(addr.IPAddress) != isIPv6 {
+			continue
+		}
+
+		if unmarkAddress(nwCfg, allocMap, ipv6Alloc, addr.IPAddress) != nil {
+			return false
+		}
+		log.Infof("address pool exhausted, releasing quarantined address %s", addr.IPAddress)
+		return removeQuarantined(quarantine, addr.IPAddress)
+	}
+
+	return false
+}
+
+// expireNetworkQuarantine releases the network addresses that served their
+// quarantine
+func expireNetworkQuarantine(nwCfg *mastercfg.CfgNetworkState) error {
+	if len(nwCfg.Quarantine) == 0 {
+		return nil
+	}
+	if err := initIPv6Alloc(nwCfg); err != nil {
+		return err
+	}
+
+	return expireQuarantine(nwCfg, &nwCfg.Quarantine, &nwCfg.IPAllocMap, &nwCfg.IPv6Alloc)
+}
+
+// expireEPGQuarantine releases the epg pool addresses that served their
+// quarantine
+func expireEPGQuarantine(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState) error {
+	if len(epgCfg.Quarantine) == 0 {
+		return nil
+	}
+
+	return expireQuarantine(nwCfg, &epgCfg.Quarantine, &epgCfg.EPGIPAllocMap, &epgCfg.EPGIPv6Alloc)
+}
+
+// activeQuarantine returns the addresses of a quarantine list that are
+// still held back, and the IPv4 alloc map without the addresses that served
+// their quarantine
+func activeQuarantine(nwCfg *mastercfg.CfgNetworkState, quarantine []mastercfg.QuarantinedAddr,
+	allocMap *bitset.BitSet) ([]string, *bitset.BitSet) {
+	now := time.Now()
+	active := []string{}
+	availMap := allocMap.Clone()
+	for i, addr := range quarantine {
+		if !isQuarantineExpired(nwCfg, quarantine, i, now) {
+			active = append(active, addr.IPAddress)
+			continue
+		}
+
+		if !netutils.IsIPv6(addr.IPAddress) {
+			ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, addr.IPAddress)
+			if err == nil {
+				availMap.Clear(ipAddrValue)
+			}
+		}
+	}
+
+	return active, availMap
+}
+
+// unquarantinedMap returns an IPv4 alloc map without the quarantined addresses
+func unquarantinedMap(nwCfg *mastercfg.CfgNetworkState, quarantine []mastercfg.QuarantinedAddr,
+	allocMap *bitset.BitSet) *bitset.BitSet {
+	usedMap := allocMap.Clone()
+	for _, addr := range quarantine {
+		if netutils.IsIPv6(addr.IPAddress) {
+			continue
+		}
+		ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, addr.IPAddress)
+		if err == nil {
+			usedMap.Clear(ipAddrValue)
+		}
+	}
+
+	return usedMap
+}
+
+// ListQuarantinedIPs returns a string of the quarantined IPs in a network
+func ListQuarantinedIPs(nwCfg *mastercfg.CfgNetworkState) string {
+	active, _ := activeQuarantine(nwCfg, nwCfg.Quarantine, &nwCfg.IPAllocMap)
+	return strings.Join(active, ", ")
+}
+
+// ListEPGAllocatedIPs returns a string of allocated IPs in an epg pool
+func ListEPGAllocatedIPs(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState) string {
+	usedMap := unquarantinedMap(nwCfg, epgCfg.Quarantine, &epgCfg.EPGIPAllocMap)
+	if err := netutils.ClearBitsOutsidePools(usedMap, epgCfg.IPPool, nwCfg.SubnetIP, nwCfg.SubnetLen); err != nil {
+		log.Errorf("Error parsing ip pool %s of epg %s. Err: %v", epgCfg.IPPool, epgCfg.ID, err)
+		return ""
+	}
+	clearFreeBlockAddrs(nwCfg, epgCfg.ID, usedMap)
+	return netutils.ListAllocatedIPs(*usedMap, nwCfg.IPAddrRange, nwCfg.SubnetIP, nwCfg.SubnetLen)
+}
+
+// ListEPGAvailableIPs returns a string of available IPs in an epg pool
+func ListEPGAvailableIPs(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState) string {
+	_, availMap := activeQuarantine(nwCfg, epgCfg.Quarantine, &epgCfg.EPGIPAllocMap)
+	return netutils.ListAvailableIPs(*availMap, nwCfg.SubnetIP, nwCfg.SubnetLen)
+}
+
+// ListEPGQuarantinedIPs returns a string of the quarantined IPs in an epg pool
+func ListEPGQuarantinedIPs(nwCfg *mastercfg.CfgNetworkState, epgCfg *mastercfg.EndpointGroupState) string {
+	active, _ := activeQuarantine(nwCfg, epgCfg.Quarantine, &epgCfg.EPGIPAllocMap)
+	return strings.Join(active, ", ")
+}
@@ -34,6 +34,13 @@ func CreateServiceLB(stateDriver core.StateDriver, serviceLbCfg *intent.ConfigSe
 
 	log.Infof("Recevied Create Service Load Balancer config {%v}", serviceLbCfg)
 
+	if err := validateHealthCheck(&serviceLbCfg.HealthCheck, serviceLbCfg.Ports); err != nil {
+		return err
+	}
+	if err := validateLBPolicy(&serviceLbCfg.LBPolicy); err != nil {
+		return err
+	}
+
 	//Check if service already exists.
 	svcID := GetServiceID(serviceLbCfg.ServiceName, serviceLbCfg.Tenant)
 
@@ -44,9 +51,31 @@ func CreateServiceLB(stateDriver core.StateDriver, serviceLbCfg *intent.ConfigSe
 	if oldServiceInfo != nil {
 		//ServiceInfo Exists
 		if reflect.DeepEqual(oldServiceInfo.Ports, serviceLbCfg.Ports) &&
-			reflect.DeepEqual(oldServiceInfo.Selectors, serviceLbCfg.Selectors) &&
 			serviceLbCfg.Tenant == oldServiceInfo.Tenant {
-			return nil
+			selectorsChanged := !reflect.DeepEqual(oldServiceInfo.Selectors, serviceLbCfg.Selectors) ||
+				!reflect.DeepEqual(oldServiceInfo.SelectorExprs, serviceLbCfg.SelectorExprs)
+			healthCheckChanged := oldServiceInfo.HealthCheck != serviceLbCfg.HealthCheck
+			lbPolicyChanged := oldServiceInfo.LBPolicy != serviceLbCfg.LBPolicy
+			if !selectorsChanged && !healthCheckChanged && !lbPolicyChanged {
+				return nil
+			}
+			// only the selectors, the health check or the lb policy
+			// changed, update the service in place
+			if serviceLbCfg.Network == oldServiceInfo.Network {
+				if lbPolicyChanged {
+					err := updateServiceLBPolicy(stateDriver, svcID, serviceLbCfg.LBPolicy)
+					if err != nil || (!healthCheckChanged && !selectorsChanged) {
+						return err
+					}
+				}
+				if healthCheckChanged {
+					err := updateServiceLBHealthCheck(stateDriver, svcID, serviceLbCfg.HealthCheck)
+					if err != nil || !selectorsChanged {
+						return err
+					}
+				}
+				return updateServiceLBSelectors(stateDriver, svcID, serviceLbCfg)
+			}
 		}
 		serviceIP = oldServiceInfo.IPAddress
 		DeleteServiceLB(stateDriver, oldServiceInfo.ServiceName, oldServiceInfo.Tenant)
@@ -65,6 +94,9 @@ func CreateServiceLB(stateDriver core.StateDriver, serviceLbCfg *intent.ConfigSe
 	for k, v := range serviceLbCfg.Selectors {
 		serviceLbState.Selectors[k] = v
 	}
+	serviceLbState.SelectorExprs = copySelectorExprs(serviceLbCfg.SelectorExprs)
+	serviceLbState.HealthCheck = serviceLbCfg.HealthCheck
+	serviceLbState.LBPolicy = serviceLbCfg.LBPolicy
 
 	// find the network from network id
 	networkID := serviceLbState.Network + "." + serviceLbState.Tenant
@@ -76,15 +108,19 @@ func CreateServiceLB(stateDriver core.StateDriver, serviceLbCfg *intent.ConfigSe
 		return err
 	}
 
-	// Alloc addresses
-	addr, err := networkAllocAddress(nwCfg, nil, serviceIP, false)
+	// Alloc addresses, the service state is written under the address
+	// lock so that an ipam check does not find the address unused
+	addrMutex.Lock()
+	addr, err := serviceAllocAddress(nwCfg, serviceIP)
 	if err != nil {
+		addrMutex.Unlock()
 		log.Errorf("Failed to allocate address. Err: %v", err)
 		return err
 	}
 	serviceLbState.IPAddress = addr
 	mastercfg.SvcMutex.Lock()
 	err = serviceLbState.Write()
+	addrMutex.Unlock()
 
 	if err != nil {
 		mastercfg.SvcMutex.Unlock()
@@ -98,6 +134,8 @@ func CreateServiceLB(stateDriver core.StateDriver, serviceLbCfg *intent.ConfigSe
 		Tenant:      serviceLbState.Tenant,
 		ServiceName: serviceLbState.ServiceName,
 		Network:     serviceLbState.Network,
+		HealthCheck: serviceLbState.HealthCheck,
+		LBPolicy:    serviceLbState.LBPolicy,
 	}
 	mastercfg.ServiceLBDb[serviceID].Ports = append(mastercfg.ServiceLBDb[serviceID].Ports, serviceLbState.Ports...)
 	mastercfg.ServiceLBDb[serviceID].Selectors = make(map[string]string)
@@ -106,11 +144,12 @@ func CreateServiceLB(stateDriver core.StateDriver, serviceLbCfg *intent.ConfigSe
 	for k, v := range serviceLbCfg.Selectors {
 		mastercfg.ServiceLBDb[serviceID].Selectors[k] = v
 	}
+	mastercfg.ServiceLBDb[serviceID].SelectorExprs = copySelectorExprs(serviceLbCfg.SelectorExprs)
 
 	//Check for containers in the tenant matching service selectors
 	for _, providerInfo := range mastercfg.ProviderDb {
 		if providerInfo.Tenant == serviceLbState.Tenant {
-			if eq := reflect.DeepEqual(providerInfo.Labels, mastercfg.ServiceLBDb[serviceID].Selectors); eq {
+			if providerMatches(providerInfo, mastercfg.ServiceLBDb[serviceID]) {
 				//provider matches service selectors
 				providerID := getProviderID(providerInfo)
 				providerDbID := getProviderDbID(providerInfo)
@@ -143,6 +182,70 @@ func CreateServiceLB(stateDriver core.StateDriver, serviceLbCfg *intent.ConfigSe
 	return nil
 }
 
+// updateServiceLBSelectors changes the selectors of a service, only the
+// providers whose match changed are added to or removed from the service
+func updateServiceLBSelectors(stateDriver core.StateDriver, serviceID string, serviceLbCfg *intent.ConfigServiceLB) error {
+	mastercfg.SvcMutex.Lock()
+	defer mastercfg.SvcMutex.Unlock()
+
+	service := mastercfg.ServiceLBDb[serviceID]
+	if service == nil {
+		return core.Errorf("service %s not found", serviceID)
+	}
+
+	serviceLbState := &mastercfg.CfgServiceLBState{}
+	serviceLbState.StateDriver = stateDriver
+	if err := serviceLbState.Read(serviceID); err != nil {
+		log.Errorf("Error reading service lb config for service %s. Err: %v", serviceID, err)
+		return err
+	}
+	if serviceLbState.Providers == nil {
+		serviceLbState.Providers = make(map[string]*mastercfg.Provider)
+	}
+
+	service.Selectors = make(map[string]string)
+	for k, v := range serviceLbCfg.Selectors {
+		service.Selectors[k] = v
+	}
+	service.SelectorExprs = copySelectorExprs(serviceLbCfg.SelectorExprs)
+	serviceLbState.Selectors = service.Selectors
+	serviceLbState.SelectorExprs = service.SelectorExprs
+	serviceLbState.Unhealthy = service.Unhealthy
+
+	providersChanged := false
+	for _, providerInfo := range mastercfg.ProviderDb {
+		providerID := getProviderID(providerInfo)
+		_, isProvider := service.Providers[providerID]
+		if providerMatches(providerInfo, service) == isProvider {
+			continue
+		}
+
+		if isProvider {
+			log.Infof("Removing provider %s from service %s", providerID, serviceID)
+			providerInfo.Services = removeServiceID(providerInfo.Services, serviceID)
+			delete(service.Providers, providerID)
+			delete(service.Unhealthy, providerID)
+			delete(serviceLbState.Providers, providerID)
+		} else {
+			log.Infof("Adding provider %s to service %s", providerID, serviceID)
+			providerInfo.Services = append(providerInfo.Services, serviceID)
+			service.Providers[providerID] = providerInfo
+			serviceLbState.Providers[providerID] = providerInfo
+		}
+		providersChanged = true
+	}
+
+	if err := serviceLbState.Write(); err != nil {
+		return err
+	}
+
+	if providersChanged {
+		return SvcProviderUpdate(serviceID, false)
+	}
+
+	return nil
+}
+
 //DeleteServiceLB deletes from etcd state
 func DeleteServiceLB(stateDriver core.StateDriver, serviceName string, tenantName string) error {
 
@@ -169,7 +272,7 @@ func DeleteServiceLB(stateDriver core.StateDriver, serviceName string, tenantNam
 		log.Errorf("network %s is not operational. Service object deletion failed", networkID)
 		return err
 	}
-	err = networkReleaseAddress(nwCfg, nil, serviceLBState.IPAddress)
+	err = serviceReleaseAddress(nwCfg, serviceLBState.IPAddress)
 	if err != nil {
 		log.Errorf("Network release address  failed %s", err)
 	}
@@ -231,6 +334,9 @@ func RestoreServiceProviderLBDb() {
 				Tenant:      svcLB.Tenant,
 				ServiceName: svcLB.ServiceName,
 				Network:     svcLB.Network,
+				HealthCheck: svcLB.HealthCheck,
+				Unhealthy:   svcLB.Unhealthy,
+				LBPolicy:    svcLB.LBPolicy,
 			}
 			mastercfg.ServiceLBDb[serviceID].Ports = append(mastercfg.ServiceLBDb[serviceID].Ports, svcLB.Ports...)
 
@@ -240,6 +346,7 @@ func RestoreServiceProviderLBDb() {
 			for k, v := range svcLB.Selectors {
 				mastercfg.ServiceLBDb[serviceID].Selectors[k] = v
 			}
+			mastercfg.ServiceLBDb[serviceID].SelectorExprs = copySelectorExprs(svcLB.SelectorExprs)
 
 			for providerID, providerInfo := range svcLB.Providers {
 				mastercfg.ServiceLBDb[serviceID].Providers[providerID] = providerInfo
@@ -278,6 +385,77 @@ func RestoreServiceProviderLBDb() {
 	}
 }
 
+// providerMatches checks if a provider is in the tenant of a service and its
+// labels match all the service selectors. A provider can have labels the
+// selectors don't mention.
+func providerMatches(provider *mastercfg.Provider, service *mastercfg.ServiceLBInfo) bool {
+	if provider.Tenant != service.Tenant {
+		return false
+	}
+
+	for key, value := range service.Selectors {
+		if label, ok := provider.Labels[key]; !ok || label != value {
+			return false
+		}
+	}
+
+	for _, expr := range service.SelectorExprs {
+		if !selectorExprMatches(expr, provider.Labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// selectorExprMatches checks if labels match a set-based selector
+func selectorExprMatches(expr intent.SelectorExpr, labels map[string]string) bool {
+	label, ok := labels[expr.Key]
+
+	switch expr.Operator {
+	case intent.SelectorOpIn:
+		return ok && isSelectorValue(expr.Values, label)
+	case intent.SelectorOpNotIn:
+		return !ok || !isSelectorValue(expr.Values, label)
+	case intent.SelectorOpExists:
+		return ok
+	case intent.SelectorOpNotExists:
+		return !ok
+	}
+
+	return false
+}
+
+func isSelectorValue(values []string, label string) bool {
+	for _, value := range values {
+		if value == label {
+			return true
+		}
+	}
+	return false
+}
+
+// copySelectorExprs returns a copy of set-based selectors, nil if there are
+// none
+func copySelectorExprs(exprs []intent.SelectorExpr) []intent.SelectorExpr {
+	var exprsCopy []intent.SelectorExpr
+	for _, expr := range exprs {
+		expr.Values = append([]string(nil), expr.Values...)
+		exprsCopy = append(exprsCopy, expr)
+	}
+	return exprsCopy
+}
+
+// removeServiceID removes a service from the services of a provider
+func removeServiceID(services []string, serviceID string) []string {
+	for i, service := range services {
+		if service == serviceID {
+			return append(services[:i], services[i+1:]...)
+		}
+	}
+	return services
+}
+
 //GetServiceID returns service id for etcd lookup
 func GetServiceID(servicename string, tenantname string) string {
 	return servicename + ":" + tenantname
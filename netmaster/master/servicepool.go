@@ -0,0 +1,234 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils/netutils"
+	"github.com/jainvipin/bitset"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Service lb addresses are allocated from the service ip pool of their
+// network, when it has one, so that they don't compete with endpoints for
+// addresses and stay within a known range. The pool is a comma separated
+// list of address ranges, like an epg ip pool. Its ranges are reserved in
+// the network allocator, ServiceIPAllocMap has the addresses outside the
+// ranges marked. Requested service addresses outside the pool are allocated
+// from the network.
+
+// validateServiceIPPool checks that the ranges of a service ip pool are
+// within the network and free. Ranges of the current service ip pool of the
+// network are free.
+func validateServiceIPPool(nwCfg *mastercfg.CfgNetworkState, ipPool string) error {
+	if ipPool == "" {
+		return nil
+	}
+
+	if netutils.IsIPv6(ipPool) {
+		return core.Errorf("ipv6 service ip pools are not supported")
+	}
+	if nwCfg.SubnetIP == "" {
+		return core.Errorf("network %s has no IPv4 subnet for service ip pool %s", nwCfg.ID, ipPool)
+	}
+
+	for _, pool := range netutils.GetIPPools(ipPool) {
+		if err := netutils.ValidateNetworkRangeParams(pool, nwCfg.SubnetLen); err != nil {
+			return core.Errorf("invalid service ip pool %s", pool)
+		}
+
+		if _, _, err := netutils.GetIPPoolHostRange(pool, nwCfg.SubnetIP, nwCfg.SubnetLen); err != nil {
+			return core.Errorf("bad service ip pool %s, it must be a subset of network %s/%d", pool,
+				nwCfg.SubnetIP, nwCfg.SubnetLen)
+		}
+	}
+
+	// epg pools, gateways and allocated addresses are marked in the network
+	_, err := reservedPoolMap(nwCfg, nwCfg.ServiceIPPool, ipPool)
+	return err
+}
+
+// updateServiceIPPool changes the service ip pool of a network. The service
+// addresses allocated in the current pool must be within the new pool.
+func updateServiceIPPool(nwCfg *mastercfg.CfgNetworkState, ipPool string) error {
+	if ipPool == nwCfg.ServiceIPPool {
+		return nil
+	}
+	if err := validateServiceIPPool(nwCfg, ipPool); err != nil {
+		return err
+	}
+
+	allocMap := &bitset.BitSet{}
+	if ipPool != "" {
+		if err := initPoolAllocMap(nwCfg, allocMap, ipPool); err != nil {
+			return err
+		}
+	}
+
+	if nwCfg.ServiceIPPool != "" {
+		usedMap := nwCfg.ServiceIPAllocMap.Clone()
+		err := netutils.ClearBitsOutsidePools(usedMap, nwCfg.ServiceIPPool, nwCfg.SubnetIP, nwCfg.SubnetLen)
+		if err != nil {
+			return err
+		}
+
+		maxHosts := uint(1 << (32 - nwCfg.SubnetLen))
+		for idx, found := usedMap.NextSet(0); found && idx < maxHosts; idx, found = usedMap.NextSet(idx + 1) {
+			if ipPool == "" || allocMap.Test(idx) {
+				ipAddress, _ := netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, idx)
+				return core.Errorf("service address %s of network %s is in use and not in service ip pool %q",
+					ipAddress, nwCfg.ID, ipPool)
+			}
+			allocMap.Set(idx)
+		}
+	}
+
+	if err := movePoolRanges(nwCfg, nwCfg.ServiceIPPool, ipPool); err != nil {
+		return err
+	}
+	nwCfg.ServiceIPPool = ipPool
+	nwCfg.ServiceIPAllocMap = *allocMap
+
+	return nil
+}
+
+// updateNetworkServiceIPPool changes the service ip pool of a network and
+// returns whether it changed
+func updateNetworkServiceIPPool(nwCfg *mastercfg.CfgNetworkState, network intent.ConfigNetwork) (bool, error) {
+	if nwCfg.ServiceIPPool == network.ServiceIPPool {
+		return false, nil
+	}
+
+	if err := updateServiceIPPool(nwCfg, network.ServiceIPPool); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// inServiceIPPool checks if an address is within the service ip pool of a
+// network
+func inServiceIPPool(nwCfg *mastercfg.CfgNetworkState, ipAddress string) bool {
+	if nwCfg.ServiceIPPool == "" || netutils.IsIPv6(ipAddress) {
+		return false
+	}
+
+	ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddress)
+	if err != nil {
+		return false
+	}
+	for _, pool := range netutils.GetIPPools(nwCfg.ServiceIPPool) {
+		hostMin, hostMax, err := netutils.GetIPPoolHostRange(pool, nwCfg.SubnetIP, nwCfg.SubnetLen)
+		if err == nil && ipAddrValue >= hostMin && ipAddrValue <= hostMax {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serviceAllocAddress allocates the address of a service lb, from the
+// service ip pool of the network when it has one
+func serviceAllocAddress(nwCfg *mastercfg.CfgNetworkState, reqAddr string) (string, error) {
+	if nwCfg.ServiceIPPool == "" || (reqAddr != "" && !inServiceIPPool(nwCfg, reqAddr)) {
+		return networkAllocAddress(nwCfg, nil, reqAddr, false)
+	}
+
+	var ipAddress string
+	err := core.UpdateState(nwCfg, nwCfg.ID, func() error {
+		if nwCfg.ServiceIPPool == "" {
+			return core.Errorf("service ip pool of network %s was removed", nwCfg.ID)
+		}
+
+		if reqAddr != "" {
+			ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, reqAddr)
+			if err != nil {
+				return err
+			}
+			nwCfg.ServiceIPAllocMap.Set(ipAddrValue)
+			ipAddress = reqAddr
+			return nil
+		}
+
+		ipAddrValue, found := netutils.NextClearInPools(nwCfg.ServiceIPAllocMap, nwCfg.ServiceIPPool,
+			nwCfg.SubnetIP, nwCfg.SubnetLen)
+		if !found {
+			return core.Errorf("service address exhaustion in service ip pool %s", nwCfg.ServiceIPPool)
+		}
+		var err error
+		ipAddress, err = netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddrValue)
+		if err != nil {
+			return err
+		}
+		nwCfg.ServiceIPAllocMap.Set(ipAddrValue)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("error allocating service address in network %s. Error: %s", nwCfg.ID, err)
+		return "", err
+	}
+
+	return ipAddress, nil
+}
+
+// serviceReleaseAddress releases the address of a service lb
+func serviceReleaseAddress(nwCfg *mastercfg.CfgNetworkState, ipAddress string) error {
+	if !inServiceIPPool(nwCfg, ipAddress) {
+		return networkReleaseAddress(nwCfg, nil, ipAddress)
+	}
+
+	err := core.UpdateState(nwCfg, nwCfg.ID, func() error {
+		ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddress)
+		if err != nil {
+			return err
+		}
+		nwCfg.ServiceIPAllocMap.Clear(ipAddrValue)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("error releasing service address %s in network %s. Error: %s", ipAddress, nwCfg.ID, err)
+		return err
+	}
+
+	return nil
+}
+
+// ListServiceAllocatedIPs returns a string of the service addresses
+// allocated in the service ip pool of a network
+func ListServiceAllocatedIPs(nwCfg *mastercfg.CfgNetworkState) string {
+	if nwCfg.ServiceIPPool == "" {
+		return ""
+	}
+
+	usedMap := nwCfg.ServiceIPAllocMap.Clone()
+	if err := netutils.ClearBitsOutsidePools(usedMap, nwCfg.ServiceIPPool, nwCfg.SubnetIP, nwCfg.SubnetLen); err != nil {
+		log.Errorf("Error parsing service ip pool %s of network %s. Err: %v", nwCfg.ServiceIPPool, nwCfg.ID, err)
+		return ""
+	}
+	return netutils.ListAllocatedIPs(*usedMap, nwCfg.IPAddrRange, nwCfg.SubnetIP, nwCfg.SubnetLen)
+}
+
+// ListServiceAvailableIPs returns a string of the free addresses in the
+// service ip pool of a network
+func ListServiceAvailableIPs(nwCfg *mastercfg.CfgNetworkState) string {
+	if nwCfg.ServiceIPPool == "" {
+		return ""
+	}
+	return netutils.ListAvailableIPs(nwCfg.ServiceIPAllocMap, nwCfg.SubnetIP, nwCfg.SubnetLen)
+}
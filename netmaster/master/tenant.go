@@ -0,0 +1,299 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/gstate"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils/netutils"
+	"github.com/jainvipin/bitset"
+)
+
+// A tenant can own sub-ranges of the global vlan and vxlan ranges, they are
+// set when the tenant is created. The pkt tags of the networks of the tenant
+// are allocated from its ranges and networks of other tenants can't use tags
+// in them. Tags are still allocated from the global resources, the ranges
+// restrict the tags an allocation can pick.
+
+// TenantTagUsage is the usage of the vlan or vxlan range of a tenant
+type TenantTagUsage struct {
+	NumInUse uint
+	InUse    string
+	NumFree  uint
+}
+
+// tagBitset returns a bitset with the tags of a vlan or vxlan range set
+func tagBitset(ranges, tagType string) (*bitset.BitSet, error) {
+	tagRanges, err := netutils.ParseTagRanges(ranges, tagType)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := bitset.New(0)
+	for _, tagRange := range tagRanges {
+		for tag := tagRange.Min; tag <= tagRange.Max; tag++ {
+			tags.Set(uint(tag))
+		}
+	}
+	tags.Clear(0)
+
+	return tags, nil
+}
+
+// tenantTagRanges returns the vlan or vxlan range of a tenant
+func tenantTagRanges(tenantCfg *mastercfg.CfgTenantState, tagType string) string {
+	if tagType == "vlan" {
+		return tenantCfg.VLANs
+	}
+	return tenantCfg.VXLANs
+}
+
+// globalTagRanges returns the global vlan or vxlan range
+func globalTagRanges(gCfg *gstate.Cfg, tagType string) string {
+	if tagType == "vlan" {
+		return gCfg.Auto.VLANs
+	}
+	return gCfg.Auto.VXLANs
+}
+
+// pktTagOf returns the vlan or vxlan of a network or epg, the vxlan is its
+// external pkt tag
+func pktTagOf(pktTagType string, pktTag, extPktTag int) uint {
+	if pktTagType == "vxlan" {
+		return uint(extPktTag)
+	}
+	return uint(pktTag)
+}
+
+// readTenantStates returns the state of the tenants which own tag ranges
+func readTenantStates(stateDriver core.StateDriver) ([]*mastercfg.CfgTenantState, error) {
+	tenantCfgs := []*mastercfg.CfgTenantState{}
+
+	readTenant := &mastercfg.CfgTenantState{}
+	readTenant.StateDriver = stateDriver
+	states, err := readTenant.ReadAll()
+	if err != nil {
+		if core.ErrIfKeyExists(err) == nil {
+			return tenantCfgs, nil
+		}
+		return nil, err
+	}
+
+	for _, state := range states {
+		tenantCfgs = append(tenantCfgs, state.(*mastercfg.CfgTenantState))
+	}
+
+	return tenantCfgs, nil
+}
+
+// validateTenantTagRanges checks that the tag ranges of a new tenant are
+// within the global ranges, don't overlap the ranges of other tenants and
+// aren't used by networks of other tenants
+func validateTenantTagRanges(stateDriver core.StateDriver, gCfg *gstate.Cfg, tenant *intent.ConfigTenant) error {
+	tenantCfgs, err := readTenantStates(stateDriver)
+	if err != nil {
+		return err
+	}
+
+	for _, tagType := range []string{"vlan", "vxlan"} {
+		ranges := tenant.VLANs
+		if tagType == "vxlan" {
+			ranges = tenant.VXLANs
+		}
+		if ranges == "" {
+			continue
+		}
+
+		tags, err := tagBitset(ranges, tagType)
+		if err != nil {
+			return err
+		}
+
+		globalRanges := globalTagRanges(gCfg, tagType)
+		if globalRanges == "" {
+			return core.Errorf("tenant %s %s range %s needs a global %s range", tenant.Name, tagType, ranges, tagType)
+		}
+		globalTags, err := tagBitset(globalRanges, tagType)
+		if err != nil {
+			return err
+		}
+		if tags.DifferenceCardinality(globalTags) != 0 {
+			return core.Errorf("tenant %s %s range %s is not within the global %s range %s", tenant.Name,
+				tagType, ranges, tagType, globalRanges)
+		}
+
+		for _, tenantCfg := range tenantCfgs {
+			otherRanges := tenantTagRanges(tenantCfg, tagType)
+			if tenantCfg.ID == tenant.Name || otherRanges == "" {
+				continue
+			}
+			otherTags, err := tagBitset(otherRanges, tagType)
+			if err != nil {
+				return err
+			}
+			if tags.IntersectionCardinality(otherTags) != 0 {
+				return core.Errorf("tenant %s %s range %s overlaps %s range %s of tenant %s", tenant.Name,
+					tagType, ranges, tagType, otherRanges, tenantCfg.ID)
+			}
+		}
+
+		readNet := &mastercfg.CfgNetworkState{}
+		readNet.StateDriver = stateDriver
+		nwCfgs, err := readNet.ReadAll()
+		if core.ErrIfKeyExists(err) != nil {
+			return err
+		}
+		for _, state := range nwCfgs {
+			nwCfg := state.(*mastercfg.CfgNetworkState)
+			if nwCfg.Tenant == tenant.Name || nwCfg.PktTagType != tagType {
+				continue
+			}
+			tag := pktTagOf(nwCfg.PktTagType, nwCfg.PktTag, nwCfg.ExtPktTag)
+			if tags.Test(tag) {
+				return core.Errorf("tenant %s %s range %s includes %s %d of network %s", tenant.Name,
+					tagType, ranges, tagType, tag, nwCfg.ID)
+			}
+		}
+
+		readEpg := &mastercfg.EndpointGroupState{}
+		readEpg.StateDriver = stateDriver
+		epgCfgs, err := readEpg.ReadAll()
+		if core.ErrIfKeyExists(err) != nil {
+			return err
+		}
+		for _, state := range epgCfgs {
+			epgCfg := state.(*mastercfg.EndpointGroupState)
+			if epgCfg.TenantName == tenant.Name || epgCfg.PktTagType != tagType {
+				continue
+			}
+			tag := pktTagOf(epgCfg.PktTagType, epgCfg.PktTag, epgCfg.ExtPktTag)
+			if tags.Test(tag) {
+				return core.Errorf("tenant %s %s range %s includes %s %d of endpoint group %s", tenant.Name,
+					tagType, ranges, tagType, tag, epgCfg.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkTenantTagRanges checks that the tag ranges of all tenants are within
+// a new global vlan or vxlan range
+func checkTenantTagRanges(stateDriver core.StateDriver, globalRanges, tagType string) error {
+	tenantCfgs, err := readTenantStates(stateDriver)
+	if err != nil {
+		return err
+	}
+
+	globalTags, err := tagBitset(globalRanges, tagType)
+	if err != nil {
+		return err
+	}
+
+	for _, tenantCfg := range tenantCfgs {
+		ranges := tenantTagRanges(tenantCfg, tagType)
+		if ranges == "" {
+			continue
+		}
+		tags, err := tagBitset(ranges, tagType)
+		if err != nil {
+			return err
+		}
+		if tags.DifferenceCardinality(globalTags) != 0 {
+			return core.Errorf("cannot update the %s range, tenant %s %s range %s is not within it",
+				tagType, tenantCfg.ID, tagType, ranges)
+		}
+	}
+
+	return nil
+}
+
+// tenantAllowedTags returns the vlans or vxlans the networks of a tenant can
+// use, nil if they aren't restricted. A tenant with a range can only use
+// its range, other tenants can use the global range without the ranges of
+// the tenants.
+func tenantAllowedTags(stateDriver core.StateDriver, gCfg *gstate.Cfg, tenantName, tagType string) (*bitset.BitSet, error) {
+	tenantCfgs, err := readTenantStates(stateDriver)
+	if err != nil {
+		return nil, err
+	}
+
+	var ownedTags *bitset.BitSet
+	for _, tenantCfg := range tenantCfgs {
+		ranges := tenantTagRanges(tenantCfg, tagType)
+		if ranges == "" {
+			continue
+		}
+		tags, err := tagBitset(ranges, tagType)
+		if err != nil {
+			return nil, err
+		}
+		if tenantCfg.ID == tenantName {
+			return tags, nil
+		}
+		if ownedTags == nil {
+			ownedTags = bitset.New(0)
+		}
+		ownedTags.InPlaceUnion(tags)
+	}
+
+	if ownedTags == nil {
+		return nil, nil
+	}
+
+	globalTags, err := tagBitset(globalTagRanges(gCfg, tagType), tagType)
+	if err != nil {
+		return nil, err
+	}
+
+	return globalTags.Difference(ownedTags), nil
+}
+
+// GetTenantTagUsage returns the usage of the vlan or vxlan range of a
+// tenant, nil if the tenant has no range of the type
+func GetTenantTagUsage(stateDriver core.StateDriver, tenantName, tagType string) (*TenantTagUsage, error) {
+	tenantCfg := &mastercfg.CfgTenantState{}
+	tenantCfg.StateDriver = stateDriver
+	if err := tenantCfg.Read(tenantName); err != nil {
+		if core.ErrIfKeyExists(err) == nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	ranges := tenantTagRanges(tenantCfg, tagType)
+	if ranges == "" {
+		return nil, nil
+	}
+	tags, err := tagBitset(ranges, tagType)
+	if err != nil {
+		return nil, err
+	}
+
+	gCfg := &gstate.Cfg{}
+	gCfg.StateDriver = stateDriver
+
+	usage := &TenantTagUsage{}
+	if tagType == "vlan" {
+		usage.NumInUse, usage.InUse, usage.NumFree = gCfg.GetVlansUsage(tags)
+	} else {
+		usage.NumInUse, usage.InUse, usage.NumFree = gCfg.GetVxlansUsage(tags)
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,82 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	addrBlockConfigPathPrefix = StateConfigPath + "addrBlocks/"
+	addrBlockConfigPath       = addrBlockConfigPathPrefix + "%s"
+)
+
+// CfgAddrBlockState is a block of addresses of a network, or of the ip pool
+// of an epg, delegated to a host. The addresses are marked as allocated in
+// the network or epg, the host hands them out without netmaster.
+type CfgAddrBlockState struct {
+	core.CommonState
+	Host          string   `json:"host"`
+	NetworkID     string   `json:"networkId"`
+	EndpointGroup string   `json:"endpointGroup,omitempty"` // epg key for blocks of an epg pool
+	Free          []string `json:"free,omitempty"`
+	Allocated     []string `json:"allocated,omitempty"`
+}
+
+// GetAddrBlockKey returns the key of the address block of a host in a
+// network or epg pool
+func GetAddrBlockKey(host, networkID, epgKey string) string {
+	return host + "|" + networkID + "|" + epgKey
+}
+
+// Write the state.
+func (s *CfgAddrBlockState) Write() error {
+	key := fmt.Sprintf(addrBlockConfigPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state for a given identifier.
+func (s *CfgAddrBlockState) Read(id string) error {
+	key := fmt.Sprintf(addrBlockConfigPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadRevision reads the state for a given identifier along with its revision
+func (s *CfgAddrBlockState) ReadRevision(id string) (uint64, error) {
+	*s = CfgAddrBlockState{CommonState: s.CommonState}
+	key := fmt.Sprintf(addrBlockConfigPath, id)
+	return s.StateDriver.ReadStateRevision(key, s, json.Unmarshal)
+}
+
+// WriteIfRevision writes the state if it is still at the given revision
+func (s *CfgAddrBlockState) WriteIfRevision(revision uint64) error {
+	key := fmt.Sprintf(addrBlockConfigPath, s.ID)
+	return s.StateDriver.WriteStateIfRevision(key, s, json.Marshal, revision)
+}
+
+// ReadAll state and return the collection.
+func (s *CfgAddrBlockState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(addrBlockConfigPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the state.
+func (s *CfgAddrBlockState) Clear() error {
+	key := fmt.Sprintf(addrBlockConfigPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
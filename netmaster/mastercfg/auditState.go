@@ -0,0 +1,175 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	auditConfigPathPrefix = StateConfigPath + "audit/"
+	auditConfigPath       = auditConfigPathPrefix + "%s"
+
+	// auditPruneInterval is the number of entries recorded between
+	// enforcing the retention limits
+	auditPruneInterval = 100
+)
+
+// AuditMaxEntries is the maximum number of entries kept in the audit log
+var AuditMaxEntries = 10000
+
+// AuditMaxAge is the maximum age of entries kept in the audit log
+var AuditMaxAge = 30 * 24 * time.Hour
+
+// auditMutex serializes recording audit entries so that their IDs increase
+var auditMutex sync.Mutex
+
+// lastAuditID is the ID of the last recorded audit entry
+var lastAuditID int64
+
+// auditCount is the number of entries recorded since the last prune
+var auditCount int
+
+// CfgAuditEntry is a configuration change recorded in the audit log
+type CfgAuditEntry struct {
+	core.CommonState
+	Timestamp  time.Time       `json:"timestamp"`
+	Client     string          `json:"client"`
+	Tenant     string          `json:"tenant,omitempty"`
+	ObjectType string          `json:"objectType"`
+	ObjectKey  string          `json:"objectKey"`
+	Operation  string          `json:"operation"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+}
+
+// Write the state
+func (s *CfgAuditEntry) Write() error {
+	key := fmt.Sprintf(auditConfigPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state for a given identifier
+func (s *CfgAuditEntry) Read(id string) error {
+	key := fmt.Sprintf(auditConfigPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll state and return the collection.
+func (s *CfgAuditEntry) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(auditConfigPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the state.
+func (s *CfgAuditEntry) Clear() error {
+	key := fmt.Sprintf(auditConfigPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
+
+// RecordAuditEntry appends an entry to the audit log. Entries are keyed by
+// their timestamp in nanoseconds so that they sort in the order recorded.
+func RecordAuditEntry(stateDriver core.StateDriver, entry *CfgAuditEntry) error {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	id := entry.Timestamp.UnixNano()
+	if id <= lastAuditID {
+		id = lastAuditID + 1
+	}
+
+	entry.StateDriver = stateDriver
+	entry.ID = fmt.Sprintf("%019d", id)
+	if err := entry.Write(); err != nil {
+		return err
+	}
+	lastAuditID = id
+
+	auditCount++
+	if auditCount >= auditPruneInterval {
+		auditCount = 0
+		if err := pruneAuditEntries(stateDriver, time.Now()); err != nil {
+			log.Errorf("Error pruning audit log. Err: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// ReadAuditEntries returns the entries of the audit log from oldest to
+// newest
+func ReadAuditEntries(stateDriver core.StateDriver) ([]*CfgAuditEntry, error) {
+	readEntry := &CfgAuditEntry{}
+	readEntry.StateDriver = stateDriver
+	states, err := readEntry.ReadAll()
+	if err != nil {
+		if core.ErrIfKeyExists(err) == nil {
+			return []*CfgAuditEntry{}, nil
+		}
+		return nil, err
+	}
+
+	entries := []*CfgAuditEntry{}
+	for _, state := range states {
+		entries = append(entries, state.(*CfgAuditEntry))
+	}
+	sort.Sort(auditEntryList(entries))
+
+	return entries, nil
+}
+
+// auditEntryList sorts audit entries by their id
+type auditEntryList []*CfgAuditEntry
+
+func (l auditEntryList) Len() int           { return len(l) }
+func (l auditEntryList) Less(i, j int) bool { return l[i].ID < l[j].ID }
+func (l auditEntryList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// PruneAuditEntries removes the entries beyond the retention limits of the
+// audit log
+func PruneAuditEntries(stateDriver core.StateDriver) error {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	return pruneAuditEntries(stateDriver, time.Now())
+}
+
+// pruneAuditEntries removes the oldest entries beyond AuditMaxEntries and
+// the entries older than AuditMaxAge
+func pruneAuditEntries(stateDriver core.StateDriver, now time.Time) error {
+	entries, err := ReadAuditEntries(stateDriver)
+	if err != nil {
+		return err
+	}
+
+	for idx, entry := range entries {
+		if len(entries)-idx <= AuditMaxEntries && now.Sub(entry.Timestamp) <= AuditMaxAge {
+			break
+		}
+
+		if err := entry.Clear(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contiv/netplugin/state"
+)
+
+func TestAuditEntriesRecordPrune(t *testing.T) {
+	stateDriver := &state.FakeStateDriver{}
+	stateDriver.Init(nil)
+
+	now := time.Now()
+	keys := []string{"default:old", "default:p1", "default:p2", "default:p3"}
+	timestamps := []time.Time{now.Add(-2 * AuditMaxAge), now, now, now}
+	for idx, key := range keys {
+		entry := &CfgAuditEntry{
+			Timestamp:  timestamps[idx],
+			Client:     "10.1.1.1",
+			ObjectType: "policys",
+			ObjectKey:  key,
+			Operation:  "create",
+		}
+		if err := RecordAuditEntry(stateDriver, entry); err != nil {
+			t.Fatalf("Error recording audit entry. Err: %v", err)
+		}
+	}
+
+	entries, err := ReadAuditEntries(stateDriver)
+	if err != nil {
+		t.Fatalf("Error reading audit entries. Err: %v", err)
+	}
+	if len(entries) != len(keys) {
+		t.Fatalf("Expected %d audit entries, got %d", len(keys), len(entries))
+	}
+	for idx, entry := range entries {
+		if entry.ObjectKey != keys[idx] {
+			t.Fatalf("Audit entry %d has key %s, expected %s", idx, entry.ObjectKey, keys[idx])
+		}
+	}
+
+	// entries recorded at the same time keep their order
+	if entries[1].ID >= entries[2].ID || entries[2].ID >= entries[3].ID {
+		t.Fatalf("Audit entry IDs are not increasing: %s %s %s", entries[1].ID, entries[2].ID, entries[3].ID)
+	}
+
+	// pruning removes expired entries and the oldest beyond the limit
+	maxEntries := AuditMaxEntries
+	AuditMaxEntries = 2
+	defer func() { AuditMaxEntries = maxEntries }()
+
+	if err := PruneAuditEntries(stateDriver); err != nil {
+		t.Fatalf("Error pruning audit entries. Err: %v", err)
+	}
+
+	entries, err = ReadAuditEntries(stateDriver)
+	if err != nil {
+		t.Fatalf("Error reading audit entries. Err: %v", err)
+	}
+	if len(entries) != 2 || entries[0].ObjectKey != "default:p2" || entries[1].ObjectKey != "default:p3" {
+		t.Fatalf("Unexpected audit entries after pruning: %+v", entries)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"testing"
 
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/internal/testutil"
 )
 
 const (
@@ -28,7 +29,7 @@ const (
 
 type testBgpStateDriver struct{}
 
-var bgpStateDriver = &testBgpStateDriver{}
+var bgpStateDriver = testutil.NoRevisions(&testBgpStateDriver{})
 
 func (d *testBgpStateDriver) Init(instInfo *core.InstanceInfo) error {
 	return core.Errorf("Shouldn't be called!")
@@ -21,6 +21,7 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/utils/netutils"
 	"github.com/jainvipin/bitset"
 )
 
@@ -28,19 +29,24 @@ import (
 // vlans with ovs. The state is stored as Json objects.
 type EndpointGroupState struct {
 	core.CommonState
-	GroupName       string        `json:"groupName"`
-	TenantName      string        `json:"tenantName"`
-	NetworkName     string        `json:"networkName"`
-	EndpointGroupID int           `json:"endpointGroupId"`
-	PktTagType      string        `json:"pktTagType"`
-	PktTag          int           `json:"pktTag"`
-	ExtPktTag       int           `json:"extPktTag"`
-	EpCount         int           `json:"epCount"` // To store endpoint Count
-	DSCP            int           `json:"DSCP"`
-	Bandwidth       string        `json:"Bandwidth"`
-	Burst           int           `json:"Burst"`
-	IPPool          string        `json:"IPPool"`
-	EPGIPAllocMap   bitset.BitSet `json:"epgIpAllocMap"`
+	GroupName       string                 `json:"groupName"`
+	TenantName      string                 `json:"tenantName"`
+	NetworkName     string                 `json:"networkName"`
+	EndpointGroupID int                    `json:"endpointGroupId"`
+	PktTagType      string                 `json:"pktTagType"`
+	PktTag          int                    `json:"pktTag"`
+	ExtPktTag       int                    `json:"extPktTag"`
+	EpCount         int                    `json:"epCount"` // To store endpoint Count
+	DSCP            int                    `json:"DSCP"`
+	Bandwidth       string                 `json:"Bandwidth"`
+	Burst           int                    `json:"Burst"`
+	IPPool          string                 `json:"IPPool"` // comma separated list of ranges
+	EPGIPAllocMap   bitset.BitSet          `json:"epgIpAllocMap"`
+	IPv6Pool        string                 `json:"IPv6Pool"`
+	EPGIPv6Alloc    netutils.IPv6Allocator `json:"epgIpv6Alloc"`
+	// Quarantine holds the released addresses of the epg pools, see
+	// CfgNetworkState
+	Quarantine []QuarantinedAddr `json:"quarantine,omitempty"`
 }
 
 // Write the state.
@@ -55,6 +61,19 @@ func (s *EndpointGroupState) Read(id string) error {
 	return s.StateDriver.ReadState(key, s, json.Unmarshal)
 }
 
+// ReadRevision reads the state for a given identifier along with its revision
+func (s *EndpointGroupState) ReadRevision(id string) (uint64, error) {
+	*s = EndpointGroupState{CommonState: s.CommonState}
+	key := fmt.Sprintf(epGroupConfigPath, id)
+	return s.StateDriver.ReadStateRevision(key, s, json.Unmarshal)
+}
+
+// WriteIfRevision writes the state if it is still at the given revision
+func (s *EndpointGroupState) WriteIfRevision(revision uint64) error {
+	key := fmt.Sprintf(epGroupConfigPath, s.ID)
+	return s.StateDriver.WriteStateIfRevision(key, s, json.Marshal, revision)
+}
+
 // ReadAll state and return the collection.
 func (s *EndpointGroupState) ReadAll() ([]core.State, error) {
 	return s.StateDriver.ReadAllState(epGroupConfigPathPrefix, s, json.Unmarshal)
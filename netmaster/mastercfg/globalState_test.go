@@ -19,6 +19,7 @@ import (
 	"testing"
 
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/internal/testutil"
 )
 
 const (
@@ -27,7 +28,7 @@ const (
 
 type testglobalStateDriver struct{}
 
-var gcStateDriver = &testglobalStateDriver{}
+var gcStateDriver = testutil.NoRevisions(&testglobalStateDriver{})
 
 func (d *testglobalStateDriver) Init(instInfo *core.InstanceInfo) error {
 	return core.Errorf("Shouldn't be called!")
@@ -0,0 +1,70 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	ipReservationConfigPathPrefix = StateConfigPath + "ipReservations/"
+	ipReservationConfigPath       = ipReservationConfigPathPrefix + "%s"
+)
+
+// CfgIPReservationState is a static ip address reservation in a network.
+// The address is only handed out to the workload matching the container
+// name, the pod name and namespace, or the labels of the reservation.
+type CfgIPReservationState struct {
+	core.CommonState
+	Tenant        string            `json:"tenant"`
+	Network       string            `json:"network"`
+	IPAddress     string            `json:"ipAddress"`
+	ContainerName string            `json:"containerName,omitempty"`
+	PodName       string            `json:"podName,omitempty"`
+	PodNamespace  string            `json:"podNamespace,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+// GetIPReservationKey returns the key of the reservation of an address
+func GetIPReservationKey(tenant, network, ipAddress string) string {
+	return tenant + ":" + network + ":" + ipAddress
+}
+
+// Write the state.
+func (s *CfgIPReservationState) Write() error {
+	key := fmt.Sprintf(ipReservationConfigPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state for a given identifier.
+func (s *CfgIPReservationState) Read(id string) error {
+	key := fmt.Sprintf(ipReservationConfigPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll state and return the collection.
+func (s *CfgIPReservationState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(ipReservationConfigPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the state.
+func (s *CfgIPReservationState) Clear() error {
+	key := fmt.Sprintf(ipReservationConfigPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
@@ -18,8 +18,10 @@ package mastercfg
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/utils/netutils"
 	"github.com/jainvipin/bitset"
 )
 
@@ -43,24 +45,49 @@ const (
 // vlans with ovs. The state is stored as Json objects.
 type CfgNetworkState struct {
 	core.CommonState
-	Tenant        string          `json:"tenant"`
-	NetworkName   string          `json:"networkName"`
-	NwType        string          `json:"nwType"`
-	PktTagType    string          `json:"pktTagType"`
-	PktTag        int             `json:"pktTag"`
-	ExtPktTag     int             `json:"extPktTag"`
-	SubnetIP      string          `json:"subnetIP"`
-	SubnetLen     uint            `json:"subnetLen"`
-	Gateway       string          `json:"gateway"`
-	IPAddrRange   string          `json:"ipAddrRange"`
-	EpAddrCount   int             `json:"epAddrCount"`
-	EpCount       int             `json:"epCount"`
-	IPAllocMap    bitset.BitSet   `json:"ipAllocMap"`
-	IPv6Subnet    string          `json:"ipv6SubnetIP"`
-	IPv6SubnetLen uint            `json:"ipv6SubnetLen"`
-	IPv6Gateway   string          `json:"ipv6Gateway"`
-	IPv6AllocMap  map[string]bool `json:"ipv6AllocMap"`
-	IPv6LastHost  string          `json:"ipv6LastHost"`
+	Tenant        string                 `json:"tenant"`
+	NetworkName   string                 `json:"networkName"`
+	NwType        string                 `json:"nwType"`
+	PktTagType    string                 `json:"pktTagType"`
+	PktTag        int                    `json:"pktTag"`
+	ExtPktTag     int                    `json:"extPktTag"`
+	SubnetIP      string                 `json:"subnetIP"`
+	SubnetLen     uint                   `json:"subnetLen"`
+	Gateway       string                 `json:"gateway"`
+	IPAddrRange   string                 `json:"ipAddrRange"`
+	EpAddrCount   int                    `json:"epAddrCount"`
+	EpCount       int                    `json:"epCount"`
+	IPAllocMap    bitset.BitSet          `json:"ipAllocMap"`
+	IPv6Subnet    string                 `json:"ipv6SubnetIP"`
+	IPv6SubnetLen uint                   `json:"ipv6SubnetLen"`
+	IPv6Gateway   string                 `json:"ipv6Gateway"`
+	IPv6Alloc     netutils.IPv6Allocator `json:"ipv6Alloc"`
+	// IPv6AllocMap holds the IPv6 host ids allocated by older versions,
+	// they are moved to IPv6Alloc on the next allocation
+	IPv6AllocMap map[string]bool `json:"ipv6AllocMap,omitempty"`
+	// ReservedIPs holds the addresses of the ip reservations of the network
+	// and whether they are allocated to their workload. Reserved addresses
+	// are always marked in the address allocators.
+	ReservedIPs map[string]bool `json:"reservedIPs,omitempty"`
+	// AddrQuarantineTime and AddrQuarantineCount configure how long released
+	// addresses are held back from allocation: for a number of seconds, and
+	// until that many other addresses were released after them
+	AddrQuarantineTime  int `json:"addrQuarantineTime,omitempty"`
+	AddrQuarantineCount int `json:"addrQuarantineCount,omitempty"`
+	// Quarantine holds the released addresses of the network, oldest first.
+	// Quarantined addresses stay marked in the address allocators.
+	Quarantine []QuarantinedAddr `json:"quarantine,omitempty"`
+	// ServiceIPPool holds the ranges service lb addresses are allocated
+	// from. The ranges are marked in IPAllocMap, ServiceIPAllocMap has the
+	// addresses outside the ranges marked.
+	ServiceIPPool     string        `json:"serviceIPPool,omitempty"`
+	ServiceIPAllocMap bitset.BitSet `json:"serviceIPAllocMap,omitempty"`
+}
+
+// QuarantinedAddr is a released address that is held back from allocation
+type QuarantinedAddr struct {
+	IPAddress  string    `json:"ipAddress"`
+	ReleasedAt time.Time `json:"releasedAt"`
 }
 
 // Write the state.
@@ -75,6 +102,19 @@ func (s *CfgNetworkState) Read(id string) error {
 	return s.StateDriver.ReadState(key, s, json.Unmarshal)
 }
 
+// ReadRevision reads the state for a given identifier along with its revision
+func (s *CfgNetworkState) ReadRevision(id string) (uint64, error) {
+	*s = CfgNetworkState{CommonState: s.CommonState}
+	key := fmt.Sprintf(networkConfigPath, id)
+	return s.StateDriver.ReadStateRevision(key, s, json.Unmarshal)
+}
+
+// WriteIfRevision writes the state if it is still at the given revision
+func (s *CfgNetworkState) WriteIfRevision(revision uint64) error {
+	key := fmt.Sprintf(networkConfigPath, s.ID)
+	return s.StateDriver.WriteStateIfRevision(key, s, json.Marshal, revision)
+}
+
 // ReadAll state and return the collection.
 func (s *CfgNetworkState) ReadAll() ([]core.State, error) {
 	return s.StateDriver.ReadAllState(networkConfigPathPrefix, s, json.Unmarshal)
@@ -94,17 +134,21 @@ func (s *CfgNetworkState) Clear() error {
 
 // IncrEpCount Increments endpoint count
 func (s *CfgNetworkState) IncrEpCount() error {
-	s.EpCount++
-	return s.Write()
+	return core.UpdateState(s, s.ID, func() error {
+		s.EpCount++
+		return nil
+	})
 }
 
 // DecrEpCount decrements endpoint count
 func (s *CfgNetworkState) DecrEpCount() error {
-	s.EpCount--
-	return s.Write()
+	return core.UpdateState(s, s.ID, func() error {
+		s.EpCount--
+		return nil
+	})
 }
 
-//GetNwCfgKey returns the key for network state
+// GetNwCfgKey returns the key for network state
 func GetNwCfgKey(network, tenant string) string {
 	return network + "." + tenant
 }
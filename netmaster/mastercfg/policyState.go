@@ -20,11 +20,13 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/contiv/contivmodel"
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/utils/netutils"
 	"github.com/contiv/ofnet"
 )
 
@@ -33,10 +35,58 @@ const (
 	policyConfigPath       = policyConfigPathPrefix + "%s"
 )
 
+// icmpTypes maps ICMP type names to type numbers
+var icmpTypes = map[string]int{
+	"echo-reply":       0,
+	"dest-unreachable": 3,
+	"echo-request":     8,
+	"time-exceeded":    11,
+}
+
+// ParseIcmpType parses an ICMP type name or number
+func ParseIcmpType(icmpType string) (uint8, error) {
+	if num, ok := icmpTypes[icmpType]; ok {
+		return uint8(num), nil
+	}
+
+	num, err := strconv.Atoi(icmpType)
+	if err != nil || num < 0 || num > 255 {
+		return 0, core.Errorf("invalid ICMP type %s", icmpType)
+	}
+
+	return uint8(num), nil
+}
+
+// ParseIcmpCode parses an ICMP code number
+func ParseIcmpCode(icmpCode string) (uint8, error) {
+	num, err := strconv.Atoi(icmpCode)
+	if err != nil || num < 0 || num > 255 {
+		return 0, core.Errorf("invalid ICMP code %s", icmpCode)
+	}
+
+	return uint8(num), nil
+}
+
+// tcpFlags are the TCP flag matches supported by ofnet
+var tcpFlags = []string{"syn", "ack", "syn,ack", "syn,!ack", "!syn,ack"}
+
+// CheckTcpFlags verifies a TCP flags match is supported
+func CheckTcpFlags(flags string) error {
+	for _, f := range tcpFlags {
+		if flags == f {
+			return nil
+		}
+	}
+
+	return core.Errorf("invalid TCP flags %s, supported flags are %s",
+		flags, strings.Join(tcpFlags, " | "))
+}
+
 // RuleMap maps a policy rule to list of ofnet rules
 type RuleMap struct {
 	Rule       *contivModel.Rule                 // policy rule
 	OfnetRules map[string]*ofnet.OfnetPolicyRule // Ofnet rules associated with this policy rule
+	Generation int                               // incremented every time the rule is updated
 }
 
 // EpgPolicy has an instance of policy attached to an endpoint group
@@ -138,11 +188,12 @@ func restoreEpgPolicies(stateDriver core.StateDriver) error {
 				delete(epgp.RuleMaps, ruleKey)
 
 				// Add the rule to epg Policy
-				err := epgp.AddRule(ruleMap.Rule)
+				newMap, err := epgp.createRuleMap(ruleMap.Rule, ruleMap.Generation)
 				if err != nil {
 					log.Errorf("Error restoring rule %s. Err: %v", ruleKey, err)
 					return err
 				}
+				epgp.RuleMaps[ruleKey] = newMap
 			}
 		}
 	}
@@ -163,12 +214,18 @@ func (gp *EpgPolicy) Delete() error {
 	return gp.Clear()
 }
 
-// createOfnetRule creates a directional ofnet rule
-func (gp *EpgPolicy) createOfnetRule(rule *contivModel.Rule, dir string) (*ofnet.OfnetPolicyRule, error) {
+// createOfnetRule creates a directional ofnet rule for a (masked) port
+func (gp *EpgPolicy) createOfnetRule(rule *contivModel.Rule, dir string, gen int, pm netutils.PortMask) (*ofnet.OfnetPolicyRule, error) {
 	var remoteEpgID int
 	var err error
 
-	ruleID := gp.EpgPolicyKey + ":" + rule.Key + ":" + dir
+	ruleID := ofnetRuleID(gp.EpgPolicyKey, rule, dir, gen, pm)
+
+	// a full mask is an exact port match
+	portMask := pm.Mask
+	if portMask == 0xffff {
+		portMask = 0
+	}
 
 	// Create an ofnet rule
 	ofnetRule := new(ofnet.OfnetPolicyRule)
@@ -230,6 +287,23 @@ func (gp *EpgPolicy) createOfnetRule(rule *contivModel.Rule, dir string) (*ofnet
 		}
 	}
 
+	// Set icmp type and code
+	if rule.Protocol == "icmp" && rule.IcmpType != "" {
+		icmpType, err := ParseIcmpType(rule.IcmpType)
+		if err != nil {
+			return nil, err
+		}
+		ofnetRule.IcmpType = &icmpType
+
+		if rule.IcmpCode != "" {
+			icmpCode, err := ParseIcmpCode(rule.IcmpCode)
+			if err != nil {
+				return nil, err
+			}
+			ofnetRule.IcmpCode = &icmpCode
+		}
+	}
+
 	// Set directional parameters
 	switch dir {
 	case "inRx":
@@ -241,12 +315,12 @@ func (gp *EpgPolicy) createOfnetRule(rule *contivModel.Rule, dir string) (*ofnet
 		ofnetRule.SrcIpAddr = rule.FromIpAddress
 
 		// set port numbers
-		ofnetRule.DstPort = uint16(rule.Port)
+		ofnetRule.DstPort = pm.Port
+		ofnetRule.DstPortMask = portMask
+		ofnetRule.SrcPort = uint16(rule.SrcPort)
 
 		// set tcp flags
-		if rule.Protocol == "tcp" && rule.Port == 0 {
-			ofnetRule.TcpFlags = "syn,!ack"
-		}
+		ofnetRule.TcpFlags = ruleTcpFlags(rule)
 	case "inTx":
 		// Set src/dest endpoint group
 		ofnetRule.SrcEndpointGroup = gp.EndpointGroupID
@@ -256,7 +330,9 @@ func (gp *EpgPolicy) createOfnetRule(rule *contivModel.Rule, dir string) (*ofnet
 		ofnetRule.DstIpAddr = rule.FromIpAddress
 
 		// set port numbers
-		ofnetRule.SrcPort = uint16(rule.Port)
+		ofnetRule.SrcPort = pm.Port
+		ofnetRule.SrcPortMask = portMask
+		ofnetRule.DstPort = uint16(rule.SrcPort)
 	case "outRx":
 		// Set src/dest endpoint group
 		ofnetRule.DstEndpointGroup = gp.EndpointGroupID
@@ -266,7 +342,9 @@ func (gp *EpgPolicy) createOfnetRule(rule *contivModel.Rule, dir string) (*ofnet
 		ofnetRule.SrcIpAddr = rule.ToIpAddress
 
 		// set port numbers
-		ofnetRule.SrcPort = uint16(rule.Port)
+		ofnetRule.SrcPort = pm.Port
+		ofnetRule.SrcPortMask = portMask
+		ofnetRule.DstPort = uint16(rule.SrcPort)
 	case "outTx":
 		// Set src/dest endpoint group
 		ofnetRule.SrcEndpointGroup = gp.EndpointGroupID
@@ -276,12 +354,12 @@ func (gp *EpgPolicy) createOfnetRule(rule *contivModel.Rule, dir string) (*ofnet
 		ofnetRule.DstIpAddr = rule.ToIpAddress
 
 		// set port numbers
-		ofnetRule.DstPort = uint16(rule.Port)
+		ofnetRule.DstPort = pm.Port
+		ofnetRule.DstPortMask = portMask
+		ofnetRule.SrcPort = uint16(rule.SrcPort)
 
 		// set tcp flags
-		if rule.Protocol == "tcp" && rule.Port == 0 {
-			ofnetRule.TcpFlags = "syn,!ack"
-		}
+		ofnetRule.TcpFlags = ruleTcpFlags(rule)
 	default:
 		log.Fatalf("Unknown rule direction %s", dir)
 	}
@@ -300,30 +378,102 @@ func (gp *EpgPolicy) createOfnetRule(rule *contivModel.Rule, dir string) (*ofnet
 
 // AddRule adds a rule to epg policy
 func (gp *EpgPolicy) AddRule(rule *contivModel.Rule) error {
-	var dirs []string
-
 	// check if the rule exists already
 	if gp.RuleMaps[rule.Key] != nil {
-		// FIXME: see if we can update the rule
 		return core.Errorf("Rule already exists")
 	}
 
-	// Figure out all the directional rules we need to install
+	ruleMap, err := gp.createRuleMap(rule, 0)
+	if err != nil {
+		return err
+	}
+
+	// save the rulemap
+	gp.RuleMaps[rule.Key] = ruleMap
+
+	return nil
+}
+
+// UpdateRule replaces a rule in epg policy. The new ofnet rules are installed
+// before the old ones are removed so that there is no window where traffic
+// is not matched by either of them.
+func (gp *EpgPolicy) UpdateRule(rule *contivModel.Rule) error {
+	// check if the rule exists
+	oldMap := gp.RuleMaps[rule.Key]
+	if oldMap == nil {
+		return core.Errorf("Rule does not exists")
+	}
+
+	ruleMap, err := gp.createRuleMap(rule, oldMap.Generation+1)
+	if err != nil {
+		return err
+	}
+
+	gp.deleteOfnetRules(oldMap)
+
+	// save the rulemap
+	gp.RuleMaps[rule.Key] = ruleMap
+
+	return nil
+}
+
+// ruleHasPorts checks if the rule matches on specific ports
+func ruleHasPorts(rule *contivModel.Rule) bool {
+	return rule.Port != 0 || rule.Ports != "" || rule.SrcPort != 0
+}
+
+// ruleTcpFlags returns the tcp flags to match in the rule's direction.
+// Rules without ports only match connection setup unless the user asked
+// for specific flags.
+func ruleTcpFlags(rule *contivModel.Rule) string {
+	if rule.Protocol != "tcp" {
+		return ""
+	}
+	if rule.TcpFlags != "" {
+		return rule.TcpFlags
+	}
+	if !ruleHasPorts(rule) {
+		return "syn,!ack"
+	}
+
+	return ""
+}
+
+// ofnetRuleID returns the id of the ofnet rule for a direction and port mask
+// of a policy rule
+func ofnetRuleID(epgpKey string, rule *contivModel.Rule, dir string, gen int, pm netutils.PortMask) string {
+	ruleID := epgpKey + ":" + rule.Key + ":" + dir
+	if rule.Ports != "" {
+		// port lists expand to multiple ofnet rules per direction
+		ruleID = ruleID + fmt.Sprintf(":%d/%x", pm.Port, pm.Mask)
+	}
+	if gen != 0 {
+		// updated rules need a new id since they coexist with the old rule
+		ruleID = ruleID + ":" + strconv.Itoa(gen)
+	}
+
+	return ruleID
+}
+
+// ruleDirections returns the directional ofnet rules a policy rule needs
+func ruleDirections(rule *contivModel.Rule) []string {
+	var dirs []string
+
 	switch rule.Direction {
 	case "in":
-		if (rule.Protocol == "udp" || rule.Protocol == "tcp") && rule.Port != 0 {
+		if (rule.Protocol == "udp" || rule.Protocol == "tcp") && ruleHasPorts(rule) {
 			dirs = []string{"inRx", "inTx"}
 		} else {
 			dirs = []string{"inRx"}
 		}
 	case "out":
-		if (rule.Protocol == "udp" || rule.Protocol == "tcp") && rule.Port != 0 {
+		if (rule.Protocol == "udp" || rule.Protocol == "tcp") && ruleHasPorts(rule) {
 			dirs = []string{"outRx", "outTx"}
 		} else {
 			dirs = []string{"outTx"}
 		}
 	case "both":
-		if (rule.Protocol == "udp" || rule.Protocol == "tcp") && rule.Port != 0 {
+		if (rule.Protocol == "udp" || rule.Protocol == "tcp") && ruleHasPorts(rule) {
 			dirs = []string{"inRx", "inTx", "outRx", "outTx"}
 		} else {
 			dirs = []string{"inRx", "outTx"}
@@ -331,37 +481,81 @@ func (gp *EpgPolicy) AddRule(rule *contivModel.Rule) error {
 
 	}
 
+	return dirs
+}
+
+// rulePortMasks expands the port list of a policy rule into masked port matches
+func rulePortMasks(rule *contivModel.Rule) ([]netutils.PortMask, error) {
+	if rule.Ports == "" {
+		return []netutils.PortMask{{Port: uint16(rule.Port)}}, nil
+	}
+
+	portRanges, err := netutils.ParsePortRanges(rule.Ports)
+	if err != nil {
+		log.Errorf("Error parsing ports %s for rule %s. Err: %v", rule.Ports, rule.Key, err)
+		return nil, err
+	}
+
+	return netutils.GetPortMasks(portRanges), nil
+}
+
+// OfnetRuleIDs returns the ids of the ofnet rules that would be installed
+// for a policy rule in an epg policy, without installing them
+func OfnetRuleIDs(epgpKey string, rule *contivModel.Rule, gen int) ([]string, error) {
+	portMasks, err := rulePortMasks(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	ruleIDs := []string{}
+	for _, dir := range ruleDirections(rule) {
+		for _, pm := range portMasks {
+			ruleIDs = append(ruleIDs, ofnetRuleID(epgpKey, rule, dir, gen, pm))
+		}
+	}
+
+	return ruleIDs, nil
+}
+
+// createRuleMap installs the ofnet rules for a policy rule
+func (gp *EpgPolicy) createRuleMap(rule *contivModel.Rule, gen int) (*RuleMap, error) {
+	// Figure out all the directional rules we need to install
+	dirs := ruleDirections(rule)
+
+	// Expand the port list into masked port matches
+	portMasks, err := rulePortMasks(rule)
+	if err != nil {
+		return nil, err
+	}
+
 	// create a ruleMap
 	ruleMap := new(RuleMap)
 	ruleMap.OfnetRules = make(map[string]*ofnet.OfnetPolicyRule)
 	ruleMap.Rule = rule
+	ruleMap.Generation = gen
 
 	// Create ofnet rules
 	for _, dir := range dirs {
-		ofnetRule, err := gp.createOfnetRule(rule, dir)
-		if err != nil {
-			log.Errorf("Error creating %s ofnet rule for {%+v}. Err: %v", dir, rule, err)
-			return err
-		}
+		for _, pm := range portMasks {
+			ofnetRule, err := gp.createOfnetRule(rule, dir, gen, pm)
+			if err != nil {
+				log.Errorf("Error creating %s ofnet rule for {%+v}. Err: %v", dir, rule, err)
+
+				// cleanup the rules we installed so far
+				gp.deleteOfnetRules(ruleMap)
+				return nil, err
+			}
 
-		// add it to the rule map
-		ruleMap.OfnetRules[ofnetRule.RuleId] = ofnetRule
+			// add it to the rule map
+			ruleMap.OfnetRules[ofnetRule.RuleId] = ofnetRule
+		}
 	}
 
-	// save the rulemap
-	gp.RuleMaps[rule.Key] = ruleMap
-
-	return nil
+	return ruleMap, nil
 }
 
-// DelRule removes a rule from epg policy
-func (gp *EpgPolicy) DelRule(rule *contivModel.Rule) error {
-	// check if the rule exists
-	ruleMap := gp.RuleMaps[rule.Key]
-	if ruleMap == nil {
-		return core.Errorf("Rule does not exists")
-	}
-
+// deleteOfnetRules removes all ofnet rules of a rule map
+func (gp *EpgPolicy) deleteOfnetRules(ruleMap *RuleMap) {
 	// Delete each ofnet rule under this policy rule
 	for _, ofnetRule := range ruleMap.OfnetRules {
 		log.Infof("Deleting rule {%+v} from policyDB", ofnetRule)
@@ -372,6 +566,17 @@ func (gp *EpgPolicy) DelRule(rule *contivModel.Rule) error {
 			log.Errorf("Error deleting the ofnet rule {%+v}. Err: %v", ofnetRule, err)
 		}
 	}
+}
+
+// DelRule removes a rule from epg policy
+func (gp *EpgPolicy) DelRule(rule *contivModel.Rule) error {
+	// check if the rule exists
+	ruleMap := gp.RuleMaps[rule.Key]
+	if ruleMap == nil {
+		return core.Errorf("Rule does not exists")
+	}
+
+	gp.deleteOfnetRules(ruleMap)
 
 	// delete the cache
 	delete(gp.RuleMaps, rule.Key)
@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/intent"
 	"sync"
 )
 
@@ -29,13 +30,17 @@ const (
 
 //ServiceLBInfo holds service information
 type ServiceLBInfo struct {
-	ServiceName string               //Service name
-	IPAddress   string               //Service IP
-	Tenant      string               //Tenant name of the service
-	Network     string               // service network
-	Ports       []string             //Service_port:Provider_port:protocol
-	Selectors   map[string]string    // selector labels associated with a service
-	Providers   map[string]*Provider //map of providers for a service keyed by provider ip
+	ServiceName   string                   //Service name
+	IPAddress     string                   //Service IP
+	Tenant        string                   //Tenant name of the service
+	Network       string                   // service network
+	Ports         []string                 //Service_port:Provider_port:protocol
+	Selectors     map[string]string        // selector labels associated with a service
+	SelectorExprs []intent.SelectorExpr    // set-based selectors of the service
+	Providers     map[string]*Provider     //map of providers for a service keyed by provider ip
+	HealthCheck   intent.ConfigHealthCheck // provider health check of the service
+	Unhealthy     map[string]bool          // providers taken out by the health check
+	LBPolicy      intent.ConfigLBPolicy    // session affinity and provider weights
 }
 
 //ServiceLBDb is map of all services
@@ -47,13 +52,17 @@ var SvcMutex sync.RWMutex
 // CfgServiceLBState is the service object configuration
 type CfgServiceLBState struct {
 	core.CommonState
-	ServiceName string               `json:"servicename"`
-	Tenant      string               `json:"tenantname"`
-	Network     string               `json:"subnet"`
-	Ports       []string             `json:"ports"`
-	Selectors   map[string]string    `json:"selectors"`
-	IPAddress   string               `json:"ipaddress"`
-	Providers   map[string]*Provider `json:"providers"`
+	ServiceName   string                   `json:"servicename"`
+	Tenant        string                   `json:"tenantname"`
+	Network       string                   `json:"subnet"`
+	Ports         []string                 `json:"ports"`
+	Selectors     map[string]string        `json:"selectors"`
+	SelectorExprs []intent.SelectorExpr    `json:"selectorExprs,omitempty"`
+	IPAddress     string                   `json:"ipaddress"`
+	Providers     map[string]*Provider     `json:"providers"`
+	HealthCheck   intent.ConfigHealthCheck `json:"healthCheck"`
+	Unhealthy     map[string]bool          `json:"unhealthy,omitempty"`
+	LBPolicy      intent.ConfigLBPolicy    `json:"lbPolicy"`
 }
 
 // Write the state
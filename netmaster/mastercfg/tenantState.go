@@ -0,0 +1,60 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	tenantConfigPathPrefix = StateConfigPath + "tenants/"
+	tenantConfigPath       = tenantConfigPathPrefix + "%s"
+)
+
+// CfgTenantState is the vlan and vxlan ranges owned by a tenant. The ranges
+// are sub-ranges of the global ranges, networks of the tenant allocate their
+// pkt tags from them.
+type CfgTenantState struct {
+	core.CommonState
+	VLANs  string `json:"vlans,omitempty"`
+	VXLANs string `json:"vxlans,omitempty"`
+}
+
+// Write the state.
+func (s *CfgTenantState) Write() error {
+	key := fmt.Sprintf(tenantConfigPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state for a given identifier.
+func (s *CfgTenantState) Read(id string) error {
+	key := fmt.Sprintf(tenantConfigPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll state and return the collection.
+func (s *CfgTenantState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(tenantConfigPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the state.
+func (s *CfgTenantState) Clear() error {
+	key := fmt.Sprintf(tenantConfigPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
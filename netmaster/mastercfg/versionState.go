@@ -0,0 +1,45 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/contiv/netplugin/core"
+)
+
+// modelObjectPath is the key under which the object model stores the config
+// of an object, by model type and key
+const modelObjectPath = "/contiv.io/obj/modeldb/%s/%s"
+
+// ReadObjectVersion returns the resource version of an object of the object
+// model, or 0 if the object does not exist. The version is the revision of
+// the stored object: every write of the object changes it, and an object
+// that is deleted and created again never gets back an old version.
+func ReadObjectVersion(stateDriver core.StateDriver, modelType, key string) (uint64, error) {
+	// only the revision of the object is needed, not its config
+	revision, err := stateDriver.ReadStateRevision(fmt.Sprintf(modelObjectPath, modelType, key), nil,
+		func([]byte, interface{}) error { return nil })
+	if err != nil {
+		if strings.Contains(err.Error(), "Key not found") {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return revision, nil
+}
@@ -0,0 +1,63 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/contiv/netplugin/state"
+)
+
+func TestObjectVersions(t *testing.T) {
+	stateDriver := &state.FakeStateDriver{}
+	stateDriver.Init(nil)
+	net1Key := fmt.Sprintf(modelObjectPath, "network", "default:net1")
+
+	version, err := ReadObjectVersion(stateDriver, "network", "default:net1")
+	if err != nil || version != 0 {
+		t.Fatalf("Unexpected version %d of new object. Err: %v", version, err)
+	}
+
+	// every write of an object gives it a new version
+	versions := map[string]uint64{}
+	for _, name := range []string{"default:net1", "default:net2", "default:net1"} {
+		if err := stateDriver.Write(fmt.Sprintf(modelObjectPath, "network", name), []byte("{}")); err != nil {
+			t.Fatalf("Error writing %s. Err: %v", name, err)
+		}
+		version, err := ReadObjectVersion(stateDriver, "network", name)
+		if err != nil || version <= versions["default:net1"] || version <= versions["default:net2"] {
+			t.Fatalf("Unexpected version %d of %s after %v. Err: %v", version, name, versions, err)
+		}
+		versions[name] = version
+	}
+
+	// a recreated object gets a newer version
+	if err := stateDriver.ClearState(net1Key); err != nil {
+		t.Fatalf("Error clearing net1. Err: %v", err)
+	}
+	version, _ = ReadObjectVersion(stateDriver, "network", "default:net1")
+	if version != 0 {
+		t.Fatalf("Version %d of deleted net1", version)
+	}
+	if err := stateDriver.Write(net1Key, []byte("{}")); err != nil {
+		t.Fatalf("Error writing net1. Err: %v", err)
+	}
+	version, err = ReadObjectVersion(stateDriver, "network", "default:net1")
+	if err != nil || version <= versions["default:net1"] {
+		t.Fatalf("Unexpected version %d of recreated net1. Err: %v", version, err)
+	}
+}
@@ -18,8 +18,10 @@ package objApi
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"encoding/json"
 	"github.com/contiv/contivmodel"
@@ -37,6 +39,7 @@ import (
 	"net/http"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/ofnet"
 	"github.com/gorilla/mux"
 	bgpconf "github.com/osrg/gobgp/config"
 )
@@ -83,6 +86,11 @@ func NewAPIController(router *mux.Router, objdbClient objdb.API, storeURL string
 	contivModel.RegisterAciGwCallbacks(ctrler)
 	// Register routes
 	contivModel.AddRoutes(router)
+	ctrler.addApplyRoutes(router)
+	ctrler.addExportRoutes(router)
+	ctrler.addAuditRoutes(router)
+	ctrler.addIPReservationRoutes(router)
+	ctrler.addIPAMCheckRoutes(router)
 
 	// Init global state
 	gc := contivModel.FindGlobal("global")
@@ -583,31 +591,42 @@ func endpointGroupCleanup(endpointGroup *contivModel.EndpointGroup) error {
 // FIXME: hack to allocate unique endpoint group ids
 var globalEpgID = 1
 
-// EndpointGroupCreate creates Endpoint Group
-func (ac *APIController) EndpointGroupCreate(endpointGroup *contivModel.EndpointGroup) error {
-	log.Infof("Received EndpointGroupCreate: %+v", endpointGroup)
-
+// validateEndpointGroupCreate verifies that an endpoint group can be created
+// and returns its tenant and network
+func validateEndpointGroupCreate(endpointGroup *contivModel.EndpointGroup) (*contivModel.Tenant, *contivModel.Network, error) {
 	// Find the tenant
 	tenant := contivModel.FindTenant(endpointGroup.TenantName)
 	if tenant == nil {
-		return core.Errorf("Tenant not found")
+		return nil, nil, core.Errorf("Tenant not found")
 	}
 	// Find the network
 	nwObjKey := endpointGroup.TenantName + ":" + endpointGroup.NetworkName
 	network := contivModel.FindNetwork(nwObjKey)
 	if network == nil {
-		return core.Errorf("Network %s not found", endpointGroup.NetworkName)
+		return nil, nil, core.Errorf("Network %s not found", endpointGroup.NetworkName)
 	}
 	// If there is a Network with the same name as this endpointGroup, reject.
 	nameClash := contivModel.FindNetwork(endpointGroup.Key)
 	if nameClash != nil {
-		return core.Errorf("Network %s conflicts with the endpointGroup name",
+		return nil, nil, core.Errorf("Network %s conflicts with the endpointGroup name",
 			nameClash.NetworkName)
 	}
 
+	return tenant, network, nil
+}
+
+// EndpointGroupCreate creates Endpoint Group
+func (ac *APIController) EndpointGroupCreate(endpointGroup *contivModel.EndpointGroup) error {
+	log.Infof("Received EndpointGroupCreate: %+v", endpointGroup)
+
+	tenant, network, err := validateEndpointGroupCreate(endpointGroup)
+	if err != nil {
+		return err
+	}
+
 	// create the endpoint group state
-	err := master.CreateEndpointGroup(endpointGroup.TenantName, endpointGroup.NetworkName,
-		endpointGroup.IpPool, endpointGroup.GroupName)
+	err = master.CreateEndpointGroup(endpointGroup.TenantName, endpointGroup.NetworkName,
+		endpointGroup.IpPool, endpointGroup.Ipv6Pool, endpointGroup.GroupName)
 	if err != nil {
 		log.Errorf("Error creating endpoint group %+v. Err: %v", endpointGroup, err)
 		return err
@@ -703,17 +722,37 @@ func (ac *APIController) EndpointGroupCreate(endpointGroup *contivModel.Endpoint
 	return nil
 }
 
+// validateEndpointGroupUpdate verifies that an endpoint group update only
+// changes attributes that can be updated
+func validateEndpointGroupUpdate(endpointGroup, params *contivModel.EndpointGroup) error {
+	// if the network association was changed, reject the update.
+	if endpointGroup.NetworkName != params.NetworkName {
+		return core.Errorf("Cannot change network association after epg is created.")
+	}
+
+	if endpointGroup.Ipv6Pool != params.Ipv6Pool {
+		return core.Errorf("Cannot change IPv6 pool after epg is created.")
+	}
+
+	return nil
+}
+
 // EndpointGroupUpdate updates endpoint group
 func (ac *APIController) EndpointGroupUpdate(endpointGroup, params *contivModel.EndpointGroup) error {
 	log.Infof("Received EndpointGroupUpdate: %+v, params: %+v", endpointGroup, params)
 
-	// if the network association was changed, reject the update.
-	if endpointGroup.NetworkName != params.NetworkName {
-		return core.Errorf("Cannot change network association after epg is created.")
+	if err := validateEndpointGroupUpdate(endpointGroup, params); err != nil {
+		return err
 	}
 
+	// ip pool ranges can be added and removed while the epg has endpoints
 	if endpointGroup.IpPool != params.IpPool {
-		return core.Errorf("Cannot change IP pool after epg is created.")
+		err := master.UpdateEndpointGroupPool(endpointGroup.TenantName, endpointGroup.GroupName, params.IpPool)
+		if err != nil {
+			log.Errorf("Error updating ip pool of epg %s. Err: %v", endpointGroup.Key, err)
+			return err
+		}
+		endpointGroup.IpPool = params.IpPool
 	}
 
 	// Only update policy attachments
@@ -919,10 +958,10 @@ func (ac *APIController) EndpointGroupGetOper(endpointGroup *contivModel.Endpoin
 	endpointGroup.Oper.ExternalPktTag = epgCfg.ExtPktTag
 	endpointGroup.Oper.PktTag = epgCfg.PktTag
 	endpointGroup.Oper.NumEndpoints = epgCfg.EpCount
-	endpointGroup.Oper.AvailableIPAddresses = netutils.ListAvailableIPs(epgCfg.EPGIPAllocMap,
-		nwCfg.SubnetIP, nwCfg.SubnetLen)
-	endpointGroup.Oper.AllocatedIPAddresses = netutils.ListAllocatedIPs(epgCfg.EPGIPAllocMap,
-		epgCfg.IPPool, nwCfg.SubnetIP, nwCfg.SubnetLen)
+	endpointGroup.Oper.AvailableIPAddresses = master.ListEPGAvailableIPs(nwCfg, epgCfg)
+	endpointGroup.Oper.AllocatedIPAddresses = master.ListEPGAllocatedIPs(nwCfg, epgCfg)
+	endpointGroup.Oper.QuarantinedIPAddresses = master.ListEPGQuarantinedIPs(nwCfg, epgCfg)
+	endpointGroup.Oper.IpPoolUsage = master.ListEPGPoolUsage(nwCfg, epgCfg)
 	readEp := &mastercfg.CfgEndpointState{}
 	readEp.StateDriver = stateDriver
 	epCfgs, err := readEp.ReadAll()
@@ -952,16 +991,25 @@ func (ac *APIController) EndpointGroupGetOper(endpointGroup *contivModel.Endpoin
 	return nil
 }
 
-// EndpointGroupDelete deletes end point group
-func (ac *APIController) EndpointGroupDelete(endpointGroup *contivModel.EndpointGroup) error {
-	log.Infof("Received EndpointGroupDelete: %+v", endpointGroup)
-
+// validateEndpointGroupDelete verifies that an endpoint group can be deleted
+func validateEndpointGroupDelete(endpointGroup *contivModel.EndpointGroup) error {
 	// if this is associated with an app profile, reject the delete
 	if endpointGroup.Links.AppProfile.ObjKey != "" {
 		return core.Errorf("Cannot delete %s, associated to appProfile %s",
 			endpointGroup.GroupName, endpointGroup.Links.AppProfile.ObjKey)
 	}
 
+	return nil
+}
+
+// EndpointGroupDelete deletes end point group
+func (ac *APIController) EndpointGroupDelete(endpointGroup *contivModel.EndpointGroup) error {
+	log.Infof("Received EndpointGroupDelete: %+v", endpointGroup)
+
+	if err := validateEndpointGroupDelete(endpointGroup); err != nil {
+		return err
+	}
+
 	// get the netprofile structure by finding the netprofile
 	profileKey := GetNetprofileKey(endpointGroup.TenantName, endpointGroup.NetProfile)
 	netprofile := contivModel.FindNetprofile(profileKey)
@@ -980,21 +1028,14 @@ func (ac *APIController) EndpointGroupDelete(endpointGroup *contivModel.Endpoint
 
 }
 
-// NetworkCreate creates network
-func (ac *APIController) NetworkCreate(network *contivModel.Network) error {
-	log.Infof("Received NetworkCreate: %+v", network)
-
-	// Make sure tenant exists
-	if network.TenantName == "" {
-		return core.Errorf("Invalid tenant name")
-	}
-
-	tenant := contivModel.FindTenant(network.TenantName)
-	if tenant == nil {
-		return core.Errorf("Tenant not found")
-	}
-
+// checkNetworkOverlap verifies that the subnets of a network don't overlap
+// with other networks of the tenant
+func checkNetworkOverlap(tenant *contivModel.Tenant, networkKey, subnet, ipv6Subnet string) error {
 	for key := range tenant.LinkSets.Networks {
+		if key == networkKey {
+			continue
+		}
+
 		networkDetail := contivModel.FindNetwork(key)
 		if networkDetail == nil {
 			log.Errorf("Network key %s not found", key)
@@ -1002,31 +1043,64 @@ func (ac *APIController) NetworkCreate(network *contivModel.Network) error {
 		}
 
 		// Check for overlapping subnetv6 if existing and current subnetv6 is non-empty
-		if network.Ipv6Subnet != "" && networkDetail.Ipv6Subnet != "" {
-			flagv6 := netutils.IsOverlappingSubnetv6(network.Ipv6Subnet, networkDetail.Ipv6Subnet)
+		if ipv6Subnet != "" && networkDetail.Ipv6Subnet != "" {
+			flagv6 := netutils.IsOverlappingSubnetv6(ipv6Subnet, networkDetail.Ipv6Subnet)
 			if flagv6 == true {
 				log.Errorf("Overlapping of Subnetv6 Networks")
-				return errors.New("Network " + networkDetail.NetworkName + " conflicts with subnetv6  " + network.Ipv6Subnet)
+				return errors.New("Network " + networkDetail.NetworkName + " conflicts with subnetv6  " + ipv6Subnet)
 			}
 		}
 
 		// Check for overlapping subnet if existing and current subnet is non-empty
-		if network.Subnet != "" && networkDetail.Subnet != "" {
-			flag := netutils.IsOverlappingSubnet(network.Subnet, networkDetail.Subnet)
+		if subnet != "" && networkDetail.Subnet != "" {
+			flag := netutils.IsOverlappingSubnet(subnet, networkDetail.Subnet)
 			if flag == true {
 				log.Errorf("Overlapping of Networks")
-				return errors.New("Network " + networkDetail.NetworkName + " conflicts with subnet " + network.Subnet)
+				return errors.New("Network " + networkDetail.NetworkName + " conflicts with subnet " + subnet)
 			}
 		}
 	}
 
+	return nil
+}
+
+// validateNetworkCreate verifies that a network can be created and returns
+// its tenant
+func validateNetworkCreate(network *contivModel.Network) (*contivModel.Tenant, error) {
+	// Make sure tenant exists
+	if network.TenantName == "" {
+		return nil, core.Errorf("Invalid tenant name")
+	}
+
+	tenant := contivModel.FindTenant(network.TenantName)
+	if tenant == nil {
+		return nil, core.Errorf("Tenant not found")
+	}
+
+	err := checkNetworkOverlap(tenant, network.Key, network.Subnet, network.Ipv6Subnet)
+	if err != nil {
+		return nil, err
+	}
+
 	// If there is an EndpointGroup with the same name as this network, reject.
 	nameClash := contivModel.FindEndpointGroup(network.Key)
 	if nameClash != nil {
-		return core.Errorf("EndpointGroup %s conflicts with the network name",
+		return nil, core.Errorf("EndpointGroup %s conflicts with the network name",
 			nameClash.GroupName)
 	}
 
+	return tenant, nil
+}
+
+// NetworkCreate creates network
+func (ac *APIController) NetworkCreate(network *contivModel.Network) error {
+	log.Infof("Received NetworkCreate: %+v", network)
+
+	tenant, err := validateNetworkCreate(network)
+	if err != nil {
+		return err
+	}
+
 	// Get the state driver
 	stateDriver, err := utils.GetStateDriver()
 	if err != nil {
@@ -1035,14 +1109,17 @@ func (ac *APIController) NetworkCreate(network *contivModel.Network) error {
 
 	// Build network config
 	networkCfg := intent.ConfigNetwork{
-		Name:           network.NetworkName,
-		NwType:         network.NwType,
-		PktTagType:     network.Encap,
-		PktTag:         network.PktTag,
-		SubnetCIDR:     network.Subnet,
-		Gateway:        network.Gateway,
-		IPv6SubnetCIDR: network.Ipv6Subnet,
-		IPv6Gateway:    network.Ipv6Gateway,
+		Name:                network.NetworkName,
+		NwType:              network.NwType,
+		PktTagType:          network.Encap,
+		PktTag:              network.PktTag,
+		SubnetCIDR:          network.Subnet,
+		Gateway:             network.Gateway,
+		IPv6SubnetCIDR:      network.Ipv6Subnet,
+		IPv6Gateway:         network.Ipv6Gateway,
+		AddrQuarantineTime:  network.AddrQuarantineTime,
+		AddrQuarantineCount: network.AddrQuarantineCount,
+		ServiceIPPool:       network.ServiceIpPool,
 	}
 
 	// Create the network
@@ -1084,9 +1161,13 @@ func (ac *APIController) NetworkGetOper(network *contivModel.NetworkInspect) err
 		return err
 	}
 
-	network.Oper.AllocatedAddressesCount = nwCfg.EpAddrCount
+	network.Oper.AllocatedAddressesCount = master.NetworkAllocatedCount(nwCfg)
 	network.Oper.AvailableIPAddresses = master.ListAvailableIPs(nwCfg)
 	network.Oper.AllocatedIPAddresses = master.ListAllocatedIPs(nwCfg)
+	network.Oper.QuarantinedIPAddresses = master.ListQuarantinedIPs(nwCfg)
+	network.Oper.ServiceIPPool = nwCfg.ServiceIPPool
+	network.Oper.AllocatedServiceIPs = master.ListServiceAllocatedIPs(nwCfg)
+	network.Oper.AvailableServiceIPs = master.ListServiceAvailableIPs(nwCfg)
 	network.Oper.ExternalPktTag = nwCfg.ExtPktTag
 	network.Oper.NumEndpoints = nwCfg.EpCount
 	network.Oper.PktTag = nwCfg.PktTag
@@ -1119,35 +1200,105 @@ func (ac *APIController) NetworkGetOper(network *contivModel.NetworkInspect) err
 	return nil
 }
 
-// NetworkUpdate updates network
+// validateNetworkUpdate verifies that a network update only changes
+// attributes that can be updated
+func validateNetworkUpdate(network, params *contivModel.Network) error {
+	if params.NwType != network.NwType || params.Encap != network.Encap ||
+		(params.PktTag != 0 && params.PktTag != network.PktTag) {
+		return core.Errorf("Cant change network type, encap or pkt tag after its created")
+	}
+
+	tenant := contivModel.FindTenant(network.TenantName)
+	if tenant == nil {
+		return core.Errorf("Tenant not found")
+	}
+
+	return checkNetworkOverlap(tenant, network.Key, params.Subnet, params.Ipv6Subnet)
+}
+
+// NetworkUpdate updates network. In docker mode, the subnet and gateways
+// of a network with endpoints can't be changed.
 func (ac *APIController) NetworkUpdate(network, params *contivModel.Network) error {
 	log.Infof("Received NetworkUpdate: %+v, params: %+v", network, params)
-	return core.Errorf("Cant change network parameters after its created")
-}
 
-// NetworkDelete deletes network
-func (ac *APIController) NetworkDelete(network *contivModel.Network) error {
-	log.Infof("Received NetworkDelete: %+v", network)
+	err := validateNetworkUpdate(network, params)
+	if err != nil {
+		return err
+	}
+
+	// Get the state driver
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return err
+	}
+
+	// Build network config
+	networkCfg := intent.ConfigNetwork{
+		Name:                network.NetworkName,
+		NwType:              network.NwType,
+		PktTagType:          network.Encap,
+		PktTag:              network.PktTag,
+		SubnetCIDR:          params.Subnet,
+		Gateway:             params.Gateway,
+		IPv6SubnetCIDR:      params.Ipv6Subnet,
+		IPv6Gateway:         params.Ipv6Gateway,
+		AddrQuarantineTime:  params.AddrQuarantineTime,
+		AddrQuarantineCount: params.AddrQuarantineCount,
+		ServiceIPPool:       params.ServiceIpPool,
+	}
+
+	// Update the network
+	err = master.UpdateNetwork(networkCfg, stateDriver, network.TenantName)
+	if err != nil {
+		log.Errorf("Error updating network {%+v}. Err: %v", network, err)
+		return err
+	}
+
+	network.Subnet = params.Subnet
+	network.Gateway = params.Gateway
+	network.Ipv6Subnet = params.Ipv6Subnet
+	network.Ipv6Gateway = params.Ipv6Gateway
+	network.AddrQuarantineTime = params.AddrQuarantineTime
+	network.AddrQuarantineCount = params.AddrQuarantineCount
+	network.ServiceIpPool = params.ServiceIpPool
 
+	return nil
+}
+
+// validateNetworkDelete verifies that a network can be deleted and returns
+// its tenant
+func validateNetworkDelete(network *contivModel.Network) (*contivModel.Tenant, error) {
 	// Find the tenant
 	tenant := contivModel.FindTenant(network.TenantName)
 	if tenant == nil {
-		return core.Errorf("Tenant not found")
+		return nil, core.Errorf("Tenant not found")
 	}
 
 	// if the network has associated epgs, fail the delete
 	epgCount := len(network.LinkSets.EndpointGroups)
 	if epgCount != 0 {
-		return core.Errorf("cannot delete %s has %d endpoint groups",
+		return nil, core.Errorf("cannot delete %s has %d endpoint groups",
 			network.NetworkName, epgCount)
 	}
 
 	svcCount := len(network.LinkSets.Servicelbs)
 	if svcCount != 0 {
-		return core.Errorf("cannot delete %s has %d services ",
+		return nil, core.Errorf("cannot delete %s has %d services ",
 			network.NetworkName, svcCount)
 	}
 
+	return tenant, nil
+}
+
+// NetworkDelete deletes network
+func (ac *APIController) NetworkDelete(network *contivModel.Network) error {
+	log.Infof("Received NetworkDelete: %+v", network)
+
+	tenant, err := validateNetworkDelete(network)
+	if err != nil {
+		return err
+	}
+
 	// Remove link
 	modeldb.RemoveLinkSet(&tenant.LinkSets.Networks, network)
 
@@ -1348,9 +1499,138 @@ func (ac *APIController) PolicyGetOper(policy *contivModel.PolicyInspect) error
 
 	policy.Oper.NumEndpoints = policyEPCount
 
+	// Get the rule hit counters from all hosts
+	ruleStats := ac.getPolicyRuleStats()
+	ruleKeys := []string{}
+	for ruleKey := range policy.Config.LinkSets.Rules {
+		ruleKeys = append(ruleKeys, ruleKey)
+	}
+	sort.Strings(ruleKeys)
+	for _, ruleKey := range ruleKeys {
+		rule := contivModel.FindRule(ruleKey)
+		if rule == nil {
+			log.Errorf("Error finding rule %s", ruleKey)
+			continue
+		}
+
+		ruleOper := policyRuleOper(&policy.Config, rule, ruleStats)
+		policy.Oper.Rules = append(policy.Oper.Rules, ruleOper)
+
+		// packets dropped by deny rules are policy violations
+		if rule.Action == "deny" {
+			policy.Oper.PolicyViolations += ruleOper.Packets
+		}
+	}
+
 	return nil
 }
 
+// policyStatsTimeout is the timeout for fetching policy stats from all hosts
+const policyStatsTimeout = 5 * time.Second
+
+// hostPolicyRuleStats holds the policy rule hit counters fetched from a host
+type hostPolicyRuleStats struct {
+	host  string
+	stats map[string]*ofnet.OfnetPolicyRuleStats
+	err   error
+}
+
+// getPolicyRuleStats collects policy rule hit counters from all netplugin
+// hosts and sums them up by ofnet rule id. Hosts are polled concurrently,
+// hosts that don't answer within policyStatsTimeout are left out.
+func (ac *APIController) getPolicyRuleStats() map[string]*ofnet.OfnetPolicyRuleStats {
+	ruleStats := make(map[string]*ofnet.OfnetPolicyRuleStats)
+
+	srvList, err := ac.objdbClient.GetService("netplugin")
+	if err != nil {
+		log.Errorf("Error getting netplugin nodes. Err: %v", err)
+		return ruleStats
+	}
+
+	// netplugin registers multiple services per host
+	hosts := make(map[string]bool)
+	for _, srv := range srvList {
+		hosts[srv.HostAddr] = true
+	}
+
+	// the channel is buffered so that late hosts don't block
+	results := make(chan hostPolicyRuleStats, len(hosts))
+	for host := range hosts {
+		go func(host string) {
+			hostStats, err := getHostPolicyRuleStats(host)
+			results <- hostPolicyRuleStats{host: host, stats: hostStats, err: err}
+		}(host)
+	}
+
+	deadline := time.After(policyStatsTimeout)
+	for pending := len(hosts); pending > 0; pending-- {
+		var res hostPolicyRuleStats
+		select {
+		case res = <-results:
+		case <-deadline:
+			// dont fail the inspect if hosts are unreachable
+			log.Errorf("Timeout getting policy stats from %d hosts", pending)
+			return ruleStats
+		}
+
+		if res.err != nil {
+			log.Errorf("Error getting policy stats from host %s. Err: %v", res.host, res.err)
+			continue
+		}
+
+		for ruleID, stats := range res.stats {
+			if ruleStats[ruleID] == nil {
+				ruleStats[ruleID] = &ofnet.OfnetPolicyRuleStats{RuleId: ruleID}
+			}
+			ruleStats[ruleID].PacketCount += stats.PacketCount
+			ruleStats[ruleID].ByteCount += stats.ByteCount
+		}
+	}
+
+	return ruleStats
+}
+
+// getHostPolicyRuleStats gets policy rule hit counters from a netplugin host
+func getHostPolicyRuleStats(host string) (map[string]*ofnet.OfnetPolicyRuleStats, error) {
+	var hostStats map[string]*ofnet.OfnetPolicyRuleStats
+
+	client := http.Client{Timeout: policyStatsTimeout}
+	r, err := client.Get("http://" + host + ":9090/policystats")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	response, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.StatusCode != int(200) {
+		return nil, errors.New(string(response))
+	}
+
+	if err := json.Unmarshal(response, &hostStats); err != nil {
+		return nil, err
+	}
+
+	return hostStats, nil
+}
+
+// policyRuleOper returns the hit counters of a rule within a policy
+func policyRuleOper(policy *contivModel.Policy, rule *contivModel.Rule,
+	ruleStats map[string]*ofnet.OfnetPolicyRuleStats) contivModel.RuleOper {
+	ruleOper := contivModel.RuleOper{RuleID: rule.RuleID}
+	for _, ruleID := range master.PolicyRuleIDs(policy, rule) {
+		if stats := ruleStats[ruleID]; stats != nil {
+			ruleOper.Packets += int(stats.PacketCount)
+			ruleOper.Bytes += int(stats.ByteCount)
+		}
+	}
+
+	return ruleOper
+}
+
 // PolicyUpdate updates policy
 func (ac *APIController) PolicyUpdate(policy, params *contivModel.Policy) error {
 	log.Infof("Received PolicyUpdate: %+v, params: %+v", policy, params)
@@ -1437,36 +1717,82 @@ func syncAppProfile(profMap map[string]bool) {
 	}
 }
 
-// RuleCreate Creates the rule within a policy
-func (ac *APIController) RuleCreate(rule *contivModel.Rule) error {
-	log.Infof("Received RuleCreate: %+v", rule)
+// validateRule verifies rule parameters and returns the endpoint group the
+// rule matches on, if any
+func validateRule(rule *contivModel.Rule) (*contivModel.EndpointGroup, error) {
 	var epg *contivModel.EndpointGroup
-	epg = nil
 
 	// verify parameter values
 	if rule.Direction == "in" {
 		if rule.ToNetwork != "" || rule.ToEndpointGroup != "" || rule.ToIpAddress != "" {
-			return errors.New("Can not specify 'to' parameters in incoming rule")
+			return nil, errors.New("Can not specify 'to' parameters in incoming rule")
 		}
 		if rule.FromNetwork != "" && rule.FromIpAddress != "" {
-			return errors.New("Can not specify both from network and from ip address")
+			return nil, errors.New("Can not specify both from network and from ip address")
 		}
 
 		if rule.FromNetwork != "" && rule.FromEndpointGroup != "" {
-			return errors.New("Can not specify both from network and from EndpointGroup")
+			return nil, errors.New("Can not specify both from network and from EndpointGroup")
 		}
 	} else if rule.Direction == "out" {
 		if rule.FromNetwork != "" || rule.FromEndpointGroup != "" || rule.FromIpAddress != "" {
-			return errors.New("Can not specify 'from' parameters in outgoing rule")
+			return nil, errors.New("Can not specify 'from' parameters in outgoing rule")
 		}
 		if rule.ToNetwork != "" && rule.ToIpAddress != "" {
-			return errors.New("Can not specify both to-network and to-ip address")
+			return nil, errors.New("Can not specify both to-network and to-ip address")
 		}
 		if rule.ToNetwork != "" && rule.ToEndpointGroup != "" {
-			return errors.New("Can not specify both to-network and to-EndpointGroup")
+			return nil, errors.New("Can not specify both to-network and to-EndpointGroup")
 		}
 	} else {
-		return errors.New("Invalid direction for the rule")
+		return nil, errors.New("Invalid direction for the rule")
+	}
+
+	// verify port list
+	if rule.Ports != "" {
+		if rule.Port != 0 {
+			return nil, errors.New("Can not specify both port and port list")
+		}
+		if rule.Protocol != "tcp" && rule.Protocol != "udp" {
+			return nil, errors.New("Port list requires tcp or udp protocol")
+		}
+
+		portRanges, err := netutils.ParsePortRanges(rule.Ports)
+		if err != nil {
+			return nil, err
+		}
+
+		// store the port list in its compact form
+		rule.Ports = netutils.FormatPortRanges(portRanges)
+	}
+
+	// verify protocol specific matches
+	if rule.SrcPort != 0 && rule.Protocol != "tcp" && rule.Protocol != "udp" {
+		return nil, errors.New("Source port requires tcp or udp protocol")
+	}
+	if rule.TcpFlags != "" {
+		if rule.Protocol != "tcp" {
+			return nil, errors.New("TCP flags require tcp protocol")
+		}
+		if err := mastercfg.CheckTcpFlags(rule.TcpFlags); err != nil {
+			return nil, err
+		}
+	}
+	if rule.IcmpType != "" || rule.IcmpCode != "" {
+		if rule.Protocol != "icmp" {
+			return nil, errors.New("ICMP type and code require icmp protocol")
+		}
+		if rule.IcmpType == "" {
+			return nil, errors.New("Can not specify ICMP code without ICMP type")
+		}
+		if _, err := mastercfg.ParseIcmpType(rule.IcmpType); err != nil {
+			return nil, err
+		}
+		if rule.IcmpCode != "" {
+			if _, err := mastercfg.ParseIcmpCode(rule.IcmpCode); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	// Make sure endpoint groups and networks referred exists.
@@ -1476,7 +1802,7 @@ func (ac *APIController) RuleCreate(rule *contivModel.Rule) error {
 		epg = contivModel.FindEndpointGroup(epgKey)
 		if epg == nil {
 			log.Errorf("Error finding endpoint group %s", epgKey)
-			return errors.New("endpoint group not found")
+			return nil, errors.New("endpoint group not found")
 		}
 	} else if rule.ToEndpointGroup != "" {
 		epgKey := rule.TenantName + ":" + rule.ToEndpointGroup
@@ -1485,7 +1811,7 @@ func (ac *APIController) RuleCreate(rule *contivModel.Rule) error {
 		epg = contivModel.FindEndpointGroup(epgKey)
 		if epg == nil {
 			log.Errorf("Error finding endpoint group %s", epgKey)
-			return errors.New("endpoint group not found")
+			return nil, errors.New("endpoint group not found")
 		}
 	} else if rule.FromNetwork != "" {
 		netKey := rule.TenantName + ":" + rule.FromNetwork
@@ -1493,7 +1819,7 @@ func (ac *APIController) RuleCreate(rule *contivModel.Rule) error {
 		net := contivModel.FindNetwork(netKey)
 		if net == nil {
 			log.Errorf("Network %s not found", netKey)
-			return errors.New("From Network not found")
+			return nil, errors.New("From Network not found")
 		}
 	} else if rule.ToNetwork != "" {
 		netKey := rule.TenantName + ":" + rule.ToNetwork
@@ -1501,10 +1827,39 @@ func (ac *APIController) RuleCreate(rule *contivModel.Rule) error {
 		net := contivModel.FindNetwork(netKey)
 		if net == nil {
 			log.Errorf("Network %s not found", netKey)
-			return errors.New("To Network not found")
+			return nil, errors.New("To Network not found")
 		}
 	}
 
+	return epg, nil
+}
+
+// RuleGetOper inspects a rule
+func (ac *APIController) RuleGetOper(rule *contivModel.RuleInspect) error {
+	log.Infof("Received RuleInspect: %+v", rule)
+
+	policyKey := rule.Config.TenantName + ":" + rule.Config.PolicyName
+	policy := contivModel.FindPolicy(policyKey)
+	if policy == nil {
+		log.Errorf("Error finding policy %s", policyKey)
+		return core.Errorf("Policy not found")
+	}
+
+	rule.Oper = policyRuleOper(policy, &rule.Config, ac.getPolicyRuleStats())
+
+	return nil
+}
+
+// RuleCreate Creates the rule within a policy
+func (ac *APIController) RuleCreate(rule *contivModel.Rule) error {
+	log.Infof("Received RuleCreate: %+v", rule)
+
+	// verify parameter values
+	epg, err := validateRule(rule)
+	if err != nil {
+		return err
+	}
+
 	policyKey := GetpolicyKey(rule.TenantName, rule.PolicyName)
 
 	// find the policy
@@ -1515,7 +1870,7 @@ func (ac *APIController) RuleCreate(rule *contivModel.Rule) error {
 	}
 
 	// Trigger policyDB Update
-	err := master.PolicyAddRule(policy, rule)
+	err = master.PolicyAddRule(policy, rule)
 	if err != nil {
 		log.Errorf("Error adding rule %s to policy %s. Err: %v", rule.Key, policy.Key, err)
 		return err
@@ -1549,7 +1904,68 @@ func (ac *APIController) RuleCreate(rule *contivModel.Rule) error {
 // RuleUpdate updates the rule within a policy
 func (ac *APIController) RuleUpdate(rule, params *contivModel.Rule) error {
 	log.Infof("Received RuleUpdate: %+v, params: %+v", rule, params)
-	return errors.New("Can not update a rule after its created")
+
+	// verify parameter values
+	epg, err := validateRule(params)
+	if err != nil {
+		return err
+	}
+
+	policyKey := GetpolicyKey(rule.TenantName, rule.PolicyName)
+
+	// find the policy
+	policy := contivModel.FindPolicy(policyKey)
+	if policy == nil {
+		log.Errorf("Error finding policy %s", policyKey)
+		return core.Errorf("Policy not found")
+	}
+
+	// build the updated rule, keeping the existing links
+	newRule := *params
+	newRule.LinkSets = rule.LinkSets
+	newRule.Links = rule.Links
+
+	// Trigger policyDB Update. New rules are installed before old ones are
+	// removed, and a failed update leaves the original rule in every epg
+	err = master.PolicyUpdateRule(policy, &newRule)
+	if err != nil {
+		log.Errorf("Error updating rule %s in policy %s. Err: %v", rule.Key, policy.Key, err)
+		return err
+	}
+
+	// relink the rule if the matching epg changed
+	var oldEpg *contivModel.EndpointGroup
+	oldEpgKey := rule.Links.MatchEndpointGroup.ObjKey
+	if oldEpgKey != "" {
+		oldEpg = contivModel.FindEndpointGroup(oldEpgKey)
+	}
+	if oldEpg != nil && (epg == nil || oldEpg.Key != epg.Key) {
+		modeldb.RemoveLinkSet(&oldEpg.LinkSets.MatchRules, rule)
+		modeldb.RemoveLink(&newRule.Links.MatchEndpointGroup, oldEpg)
+		err = oldEpg.Write()
+		if err != nil {
+			return err
+		}
+	}
+	if epg != nil && (oldEpg == nil || oldEpg.Key != epg.Key) {
+		modeldb.AddLinkSet(&epg.LinkSets.MatchRules, rule)
+		modeldb.AddLink(&newRule.Links.MatchEndpointGroup, epg)
+		err = epg.Write()
+		if err != nil {
+			return err
+		}
+	}
+
+	*rule = newRule
+
+	// Update any affected app profiles
+	pMap := getAffectedProfs(policy, epg)
+	for prof := range getAffectedProfs(policy, oldEpg) {
+		pMap[prof] = true
+	}
+	syncAppProfile(pMap)
+
+	return nil
 }
 
 // RuleDelete deletes the rule within a policy
@@ -1616,6 +2032,8 @@ func (ac *APIController) TenantCreate(tenant *contivModel.Tenant) error {
 	tenantCfg := intent.ConfigTenant{
 		Name:           tenant.TenantName,
 		DefaultNetwork: tenant.DefaultNetwork,
+		VLANs:          tenant.Vlans,
+		VXLANs:         tenant.Vxlans,
 	}
 
 	// Create the tenant
@@ -1655,9 +2073,13 @@ func getTenantNetworks(tenant *contivModel.TenantInspect) error {
 			}
 			numEPs = numEPs + nwCfg.EpCount
 			netOper := contivModel.NetworkOper{}
-			netOper.AllocatedAddressesCount = nwCfg.EpAddrCount
+			netOper.AllocatedAddressesCount = master.NetworkAllocatedCount(nwCfg)
 			netOper.AvailableIPAddresses = master.ListAvailableIPs(nwCfg)
 			netOper.AllocatedIPAddresses = master.ListAllocatedIPs(nwCfg)
+			netOper.QuarantinedIPAddresses = master.ListQuarantinedIPs(nwCfg)
+			netOper.ServiceIPPool = nwCfg.ServiceIPPool
+			netOper.AllocatedServiceIPs = master.ListServiceAllocatedIPs(nwCfg)
+			netOper.AvailableServiceIPs = master.ListServiceAvailableIPs(nwCfg)
 			netOper.ExternalPktTag = nwCfg.ExtPktTag
 			netOper.PktTag = nwCfg.PktTag
 			netOper.NumEndpoints = nwCfg.EpCount
@@ -1756,6 +2178,41 @@ func getTenantEPGs(tenant *contivModel.TenantInspect) error {
 	return nil
 }
 
+// Get the usage of the vlan and vxlan ranges owned by the tenant
+func getTenantTagUsage(tenant *contivModel.TenantInspect) error {
+
+	// Get the state driver
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return err
+	}
+
+	tenantID := tenant.Config.TenantName
+	vlanUsage, err := master.GetTenantTagUsage(stateDriver, tenantID, "vlan")
+	if err != nil {
+		log.Errorf("Error fetching vlan usage of tenant %s. Err: %v", tenantID, err)
+		return err
+	}
+	if vlanUsage != nil {
+		tenant.Oper.VlansInUse = vlanUsage.InUse
+		tenant.Oper.FreeVlans = int(vlanUsage.NumFree)
+		tenant.Oper.VlansExhausted = vlanUsage.NumFree == 0
+	}
+
+	vxlanUsage, err := master.GetTenantTagUsage(stateDriver, tenantID, "vxlan")
+	if err != nil {
+		log.Errorf("Error fetching vxlan usage of tenant %s. Err: %v", tenantID, err)
+		return err
+	}
+	if vxlanUsage != nil {
+		tenant.Oper.VxlansInUse = vxlanUsage.InUse
+		tenant.Oper.FreeVxlans = int(vxlanUsage.NumFree)
+		tenant.Oper.VxlansExhausted = vxlanUsage.NumFree == 0
+	}
+
+	return nil
+}
+
 // TenantGetOper inspects tenant
 func (ac *APIController) TenantGetOper(tenant *contivModel.TenantInspect) error {
 	log.Infof("Received TenantInspect: %+v", tenant)
@@ -1773,6 +2230,9 @@ func (ac *APIController) TenantGetOper(tenant *contivModel.TenantInspect) error
 	//Get all the EPGs config and oper parmeters under this tenant
 	getTenantEPGs(tenant)
 
+	//Get the usage of the vlan and vxlan ranges of the tenant
+	getTenantTagUsage(tenant)
+
 	return nil
 
 }
@@ -2037,18 +2497,41 @@ func (ac *APIController) ServiceLBCreate(serviceCfg *contivModel.ServiceLB) erro
 		Tenant:      serviceCfg.TenantName,
 		Network:     serviceCfg.NetworkName,
 		IPAddress:   serviceCfg.IpAddress,
+		HealthCheck: intent.ConfigHealthCheck{
+			Type:               serviceCfg.HealthCheck,
+			Port:               serviceCfg.HealthCheckPort,
+			Path:               serviceCfg.HealthCheckPath,
+			Interval:           serviceCfg.HealthCheckInterval,
+			Timeout:            serviceCfg.HealthCheckTimeout,
+			HealthyThreshold:   serviceCfg.HealthyThreshold,
+			UnhealthyThreshold: serviceCfg.UnhealthyThreshold,
+		},
+		LBPolicy: intent.ConfigLBPolicy{
+			SessionAffinity: serviceCfg.SessionAffinity,
+			AffinityTimeout: serviceCfg.SessionAffinityTimeout,
+			WeightLabel:     serviceCfg.WeightLabel,
+		},
 	}
 	serviceIntentCfg.Ports = append(serviceIntentCfg.Ports, serviceCfg.Ports...)
 
+	if serviceCfg.WeightLabel != "" && !isSelectorKey(serviceCfg.WeightLabel) {
+		return core.Errorf("Invalid weight label %s", serviceCfg.WeightLabel)
+	}
+
 	serviceIntentCfg.Selectors = make(map[string]string)
 
 	for _, selector := range serviceCfg.Selectors {
-		if validateSelectors(selector) {
+		expr, ok := validateSelectors(selector)
+		if !ok {
+			return core.Errorf("Invalid selector %s. selector format is key1=value1, key in (value1,value2), "+
+				"key notin (value1,value2), key exists or key !exists", selector)
+		}
+		if expr == nil {
 			key := strings.Split(selector, "=")[0]
 			value := strings.Split(selector, "=")[1]
-			serviceIntentCfg.Selectors[key] = value
+			serviceIntentCfg.Selectors[strings.TrimSpace(key)] = strings.TrimSpace(value)
 		} else {
-			return core.Errorf("Invalid selector %s. selector format is key1=value1", selector)
+			serviceIntentCfg.SelectorExprs = append(serviceIntentCfg.SelectorExprs, *expr)
 		}
 	}
 	// Add the service object
@@ -2085,6 +2568,16 @@ func (ac *APIController) ServiceLBUpdate(oldServiceCfg *contivModel.ServiceLB, s
 	oldServiceCfg.TenantName = serviceCfg.TenantName
 	oldServiceCfg.NetworkName = serviceCfg.NetworkName
 	oldServiceCfg.IpAddress = serviceCfg.IpAddress
+	oldServiceCfg.HealthCheck = serviceCfg.HealthCheck
+	oldServiceCfg.HealthCheckPort = serviceCfg.HealthCheckPort
+	oldServiceCfg.HealthCheckPath = serviceCfg.HealthCheckPath
+	oldServiceCfg.HealthCheckInterval = serviceCfg.HealthCheckInterval
+	oldServiceCfg.HealthCheckTimeout = serviceCfg.HealthCheckTimeout
+	oldServiceCfg.HealthyThreshold = serviceCfg.HealthyThreshold
+	oldServiceCfg.UnhealthyThreshold = serviceCfg.UnhealthyThreshold
+	oldServiceCfg.SessionAffinity = serviceCfg.SessionAffinity
+	oldServiceCfg.SessionAffinityTimeout = serviceCfg.SessionAffinityTimeout
+	oldServiceCfg.WeightLabel = serviceCfg.WeightLabel
 	oldServiceCfg.Selectors = nil
 	oldServiceCfg.Ports = nil
 	oldServiceCfg.Selectors = append(oldServiceCfg.Selectors, serviceCfg.Selectors...)
@@ -2150,7 +2643,7 @@ func (ac *APIController) ServiceLBGetOper(serviceLB *contivModel.ServiceLBInspec
 	}
 	serviceLB.Oper.ServiceVip = service.IPAddress
 	count := 0
-	for _, provider := range service.Providers {
+	for providerID, provider := range service.Providers {
 
 		epCfg := &mastercfg.CfgEndpointState{}
 		epCfg.StateDriver = stateDriver
@@ -2172,6 +2665,7 @@ func (ac *APIController) ServiceLBGetOper(serviceLB *contivModel.ServiceLBInspec
 		epOper.Labels = fmt.Sprintf("%s", epCfg.Labels)
 		epOper.ContainerID = epCfg.ContainerID
 		epOper.ContainerName = epCfg.EPCommonName
+		epOper.HealthStatus = master.ProviderHealthStatus(service, providerID)
 		serviceLB.Oper.Providers = append(serviceLB.Oper.Providers, epOper)
 		count++
 		epCfg = nil
@@ -2181,8 +2675,60 @@ func (ac *APIController) ServiceLBGetOper(serviceLB *contivModel.ServiceLBInspec
 
 }
 
-func validateSelectors(selector string) bool {
-	return strings.Count(selector, "=") == 1
+// validateSelectors parses a service selector. key=value selectors return a
+// nil expression, set-based selectors are "key in (value1,value2)",
+// "key notin (value1,value2)", "key exists" or "key !exists". "key" and
+// "!key" are short for exists and !exists.
+func validateSelectors(selector string) (*intent.SelectorExpr, bool) {
+	if strings.Contains(selector, "=") {
+		if strings.Count(selector, "=") != 1 {
+			return nil, false
+		}
+		return nil, isSelectorKey(strings.TrimSpace(strings.Split(selector, "=")[0]))
+	}
+
+	fields := strings.Fields(selector)
+	switch {
+	case len(fields) == 1 && strings.HasPrefix(fields[0], "!"):
+		fields = []string{strings.TrimPrefix(fields[0], "!"), intent.SelectorOpNotExists}
+	case len(fields) == 1:
+		fields = append(fields, intent.SelectorOpExists)
+	case len(fields) < 2:
+		return nil, false
+	}
+
+	expr := &intent.SelectorExpr{Key: fields[0], Operator: fields[1]}
+	if !isSelectorKey(expr.Key) {
+		return nil, false
+	}
+
+	switch expr.Operator {
+	case intent.SelectorOpExists, intent.SelectorOpNotExists:
+		if len(fields) != 2 {
+			return nil, false
+		}
+	case intent.SelectorOpIn, intent.SelectorOpNotIn:
+		values := strings.Join(fields[2:], "")
+		if !strings.HasPrefix(values, "(") || !strings.HasSuffix(values, ")") {
+			return nil, false
+		}
+		for _, value := range strings.Split(strings.Trim(values, "()"), ",") {
+			if !isSelectorKey(value) {
+				return nil, false
+			}
+			expr.Values = append(expr.Values, value)
+		}
+	default:
+		return nil, false
+	}
+
+	return expr, true
+}
+
+// isSelectorKey checks that a selector key or value is not empty and has no
+// selector syntax in it
+func isSelectorKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, "!=(), \t")
 }
 
 func validatePorts(ports []string) bool {
@@ -0,0 +1,476 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objApi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/contivmodel"
+	"github.com/contiv/netplugin/core"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// ApplyRoute is the REST route to apply a desired state
+	ApplyRoute = "/api/v1/apply/"
+	// DiffRoute is the REST route to compute the changes for a desired state
+	DiffRoute = "/api/v1/diff/"
+)
+
+// applyMutex serializes applying desired states
+var applyMutex sync.Mutex
+
+// DesiredState is the full configuration of a set of tenants. Objects of
+// these tenants that are not part of the desired state are deleted.
+type DesiredState struct {
+	Tenants        []contivModel.Tenant        `json:"tenants,omitempty"`
+	Networks       []contivModel.Network       `json:"networks,omitempty"`
+	Netprofiles    []contivModel.Netprofile    `json:"netprofiles,omitempty"`
+	Policies       []contivModel.Policy        `json:"policies,omitempty"`
+	Rules          []contivModel.Rule          `json:"rules,omitempty"`
+	EndpointGroups []contivModel.EndpointGroup `json:"endpointGroups,omitempty"`
+	ServiceLBs     []contivModel.ServiceLB     `json:"serviceLBs,omitempty"`
+}
+
+// ConfigChange is a single object change needed to reach a desired state
+type ConfigChange struct {
+	Operation  string `json:"operation"`  // create, update or delete
+	ObjectType string `json:"objectType"` // type of the object
+	ObjectKey  string `json:"objectKey"`  // key of the object
+
+	apply func() error
+}
+
+// ApplyResult lists the changes for a desired state and how many of them
+// were applied
+type ApplyResult struct {
+	Changes []ConfigChange `json:"changes"`         // changes in the order they are applied
+	Applied int            `json:"applied"`         // number of changes applied
+	Error   string         `json:"error,omitempty"` // error that stopped applying changes
+}
+
+// configObjects holds the objects of one type keyed by object key
+type configObjects map[string]interface{}
+
+// objectDiff describes how to find the changes for one object type
+type objectDiff struct {
+	objType string
+	desired configObjects
+	current configObjects
+	create  func(obj interface{}) error
+	remove  func(key string) error
+}
+
+// addApplyRoutes registers the REST routes for desired state changes
+func (ac *APIController) addApplyRoutes(router *mux.Router) {
+	router.Path(ApplyRoute).Methods("POST").HandlerFunc(makeApplyHandler(true))
+	router.Path(DiffRoute).Methods("POST").HandlerFunc(makeApplyHandler(false))
+}
+
+// makeApplyHandler returns an http handler that computes the changes for a
+// desired state and applies them if requested
+func makeApplyHandler(apply bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var desired DesiredState
+		var result *ApplyResult
+
+		err := json.NewDecoder(r.Body).Decode(&desired)
+		if err == nil {
+			result, err = applyDesiredState(&desired, apply, clientAddress(r))
+		}
+		if err != nil {
+			log.Errorf("Handler for %s %s returned error: %s", r.Method, r.URL, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Errorf("Error generating json. Err: %v", err)
+		}
+	}
+}
+
+// applyDesiredState computes the changes needed to reach a desired state.
+// If apply is set, the changes are applied in dependency order and applying
+// stops at the first failure.
+func applyDesiredState(desired *DesiredState, apply bool, client string) (*ApplyResult, error) {
+	applyMutex.Lock()
+	defer applyMutex.Unlock()
+
+	diffs, err := desiredStateDiffs(desired)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ApplyResult{Changes: []ConfigChange{}}
+
+	// creates and updates go in dependency order
+	for _, diff := range diffs {
+		result.Changes = append(result.Changes, diff.updates()...)
+	}
+
+	// deletes go in reverse dependency order
+	for idx := len(diffs) - 1; idx >= 0; idx-- {
+		result.Changes = append(result.Changes, diffs[idx].deletes()...)
+	}
+
+	if apply {
+		applyChanges(result, client)
+	}
+
+	return result, nil
+}
+
+// applyChanges applies the changes of a result in order and stops at the
+// first failure. Applied changes are versioned and recorded in the audit log.
+func applyChanges(result *ApplyResult, client string) {
+	for _, change := range result.Changes {
+		log.Infof("Applying %s of %s %s", change.Operation, change.ObjectType, change.ObjectKey)
+
+		err := changeObject(client, change.ObjectType, change.ObjectKey,
+			change.Operation == "delete", "", change.apply)
+		if err != nil {
+			log.Errorf("Error applying %s of %s %s. Err: %v", change.Operation,
+				change.ObjectType, change.ObjectKey, err)
+			result.Error = err.Error()
+			break
+		}
+		result.Applied++
+	}
+}
+
+// objectConfig returns the configured attributes of an object
+func objectConfig(obj interface{}) map[string]interface{} {
+	cfg := map[string]interface{}{}
+
+	jdata, err := json.Marshal(obj)
+	if err == nil {
+		err = json.Unmarshal(jdata, &cfg)
+	}
+	if err != nil {
+		log.Errorf("Error reading config of %+v. Err: %v", obj, err)
+	}
+
+	delete(cfg, "key")
+	delete(cfg, "link-sets")
+	delete(cfg, "links")
+
+	return cfg
+}
+
+// sortedKeys returns the keys of config objects in sorted order
+func (objs configObjects) sortedKeys() []string {
+	keys := []string{}
+	for key := range objs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// updates returns the creates and updates for an object type
+func (diff *objectDiff) updates() []ConfigChange {
+	changes := []ConfigChange{}
+	for _, key := range diff.desired.sortedKeys() {
+		obj := diff.desired[key]
+		operation := "create"
+		if current, ok := diff.current[key]; ok {
+			if reflect.DeepEqual(objectConfig(obj), objectConfig(current)) {
+				continue
+			}
+			operation = "update"
+		}
+
+		changes = append(changes, ConfigChange{
+			Operation:  operation,
+			ObjectType: diff.objType,
+			ObjectKey:  key,
+			apply:      func() error { return diff.create(obj) },
+		})
+	}
+
+	return changes
+}
+
+// deletes returns the deletes for an object type
+func (diff *objectDiff) deletes() []ConfigChange {
+	changes := []ConfigChange{}
+	keys := diff.current.sortedKeys()
+	for idx := len(keys) - 1; idx >= 0; idx-- {
+		key := keys[idx]
+		if _, ok := diff.desired[key]; ok {
+			continue
+		}
+
+		changes = append(changes, ConfigChange{
+			Operation:  "delete",
+			ObjectType: diff.objType,
+			ObjectKey:  key,
+			apply:      func() error { return diff.remove(key) },
+		})
+	}
+
+	return changes
+}
+
+// desiredStateDiffs validates a desired state and returns the current and
+// desired objects of each type in dependency order
+func desiredStateDiffs(desired *DesiredState) ([]*objectDiff, error) {
+	tenants := map[string]*contivModel.Tenant{}
+	diffs := []*objectDiff{}
+
+	// checkTenant verifies that an object belongs to a tenant of the
+	// desired state
+	checkTenant := func(objType, key, tenantName string) error {
+		if tenants[tenantName] == nil {
+			return core.Errorf("tenant %s of %s %s is not part of the desired state", tenantName, objType, key)
+		}
+		return nil
+	}
+
+	// checkKey verifies that each object appears once
+	checkKey := func(objs configObjects, objType, key string) error {
+		if objs[key] != nil {
+			return core.Errorf("%s %s is specified more than once", objType, key)
+		}
+		return nil
+	}
+
+	// tenants are created but never deleted
+	tenantDiff := &objectDiff{
+		objType: "tenants",
+		desired: configObjects{},
+		current: configObjects{},
+		create:  func(obj interface{}) error { return contivModel.CreateTenant(obj.(*contivModel.Tenant)) },
+		remove:  contivModel.DeleteTenant,
+	}
+	for idx := range desired.Tenants {
+		obj := &desired.Tenants[idx]
+		obj.Key = obj.TenantName
+		if err := checkKey(tenantDiff.desired, "tenant", obj.Key); err != nil {
+			return nil, err
+		}
+		if err := contivModel.ValidateTenant(obj); err != nil {
+			return nil, err
+		}
+		tenantDiff.desired[obj.Key] = obj
+		tenants[obj.Key] = obj
+		if current := contivModel.FindTenant(obj.Key); current != nil {
+			tenantDiff.current[obj.Key] = current
+		}
+	}
+	diffs = append(diffs, tenantDiff)
+
+	// currentTenants returns the existing tenants of the desired state
+	currentTenants := func() []*contivModel.Tenant {
+		tenantList := []*contivModel.Tenant{}
+		for key := range tenantDiff.current {
+			tenantList = append(tenantList, tenantDiff.current[key].(*contivModel.Tenant))
+		}
+		return tenantList
+	}
+
+	networkDiff := &objectDiff{
+		objType: "networks",
+		desired: configObjects{},
+		current: configObjects{},
+		create:  func(obj interface{}) error { return contivModel.CreateNetwork(obj.(*contivModel.Network)) },
+		remove:  contivModel.DeleteNetwork,
+	}
+	for idx := range desired.Networks {
+		obj := &desired.Networks[idx]
+		obj.Key = obj.TenantName + ":" + obj.NetworkName
+		if err := checkTenant("network", obj.Key, obj.TenantName); err != nil {
+			return nil, err
+		}
+		if err := checkKey(networkDiff.desired, "network", obj.Key); err != nil {
+			return nil, err
+		}
+		if err := contivModel.ValidateNetwork(obj); err != nil {
+			return nil, err
+		}
+		networkDiff.desired[obj.Key] = obj
+	}
+	for _, tenant := range currentTenants() {
+		for key := range tenant.LinkSets.Networks {
+			if current := contivModel.FindNetwork(key); current != nil {
+				networkDiff.current[key] = current
+			}
+		}
+	}
+	diffs = append(diffs, networkDiff)
+
+	netprofileDiff := &objectDiff{
+		objType: "netprofiles",
+		desired: configObjects{},
+		current: configObjects{},
+		create:  func(obj interface{}) error { return contivModel.CreateNetprofile(obj.(*contivModel.Netprofile)) },
+		remove:  contivModel.DeleteNetprofile,
+	}
+	for idx := range desired.Netprofiles {
+		obj := &desired.Netprofiles[idx]
+		obj.Key = GetNetprofileKey(obj.TenantName, obj.ProfileName)
+		if err := checkTenant("netprofile", obj.Key, obj.TenantName); err != nil {
+			return nil, err
+		}
+		if err := checkKey(netprofileDiff.desired, "netprofile", obj.Key); err != nil {
+			return nil, err
+		}
+		if err := contivModel.ValidateNetprofile(obj); err != nil {
+			return nil, err
+		}
+		netprofileDiff.desired[obj.Key] = obj
+	}
+	for _, tenant := range currentTenants() {
+		for key := range tenant.LinkSets.NetProfiles {
+			if current := contivModel.FindNetprofile(key); current != nil {
+				netprofileDiff.current[key] = current
+			}
+		}
+	}
+	diffs = append(diffs, netprofileDiff)
+
+	policyDiff := &objectDiff{
+		objType: "policys",
+		desired: configObjects{},
+		current: configObjects{},
+		create:  func(obj interface{}) error { return contivModel.CreatePolicy(obj.(*contivModel.Policy)) },
+		remove:  contivModel.DeletePolicy,
+	}
+	for idx := range desired.Policies {
+		obj := &desired.Policies[idx]
+		obj.Key = GetpolicyKey(obj.TenantName, obj.PolicyName)
+		if err := checkTenant("policy", obj.Key, obj.TenantName); err != nil {
+			return nil, err
+		}
+		if err := checkKey(policyDiff.desired, "policy", obj.Key); err != nil {
+			return nil, err
+		}
+		if err := contivModel.ValidatePolicy(obj); err != nil {
+			return nil, err
+		}
+		policyDiff.desired[obj.Key] = obj
+	}
+	for _, tenant := range currentTenants() {
+		for key := range tenant.LinkSets.Policies {
+			if current := contivModel.FindPolicy(key); current != nil {
+				policyDiff.current[key] = current
+			}
+		}
+	}
+	diffs = append(diffs, policyDiff)
+
+	// endpoint groups go before the rules that refer to them
+	epgDiff := &objectDiff{
+		objType: "endpointGroups",
+		desired: configObjects{},
+		current: configObjects{},
+		create:  func(obj interface{}) error { return contivModel.CreateEndpointGroup(obj.(*contivModel.EndpointGroup)) },
+		remove:  contivModel.DeleteEndpointGroup,
+	}
+	for idx := range desired.EndpointGroups {
+		obj := &desired.EndpointGroups[idx]
+		obj.Key = obj.TenantName + ":" + obj.GroupName
+		if err := checkTenant("endpointGroup", obj.Key, obj.TenantName); err != nil {
+			return nil, err
+		}
+		if err := checkKey(epgDiff.desired, "endpointGroup", obj.Key); err != nil {
+			return nil, err
+		}
+		if err := contivModel.ValidateEndpointGroup(obj); err != nil {
+			return nil, err
+		}
+		epgDiff.desired[obj.Key] = obj
+	}
+	for _, tenant := range currentTenants() {
+		for key := range tenant.LinkSets.EndpointGroups {
+			if current := contivModel.FindEndpointGroup(key); current != nil {
+				epgDiff.current[key] = current
+			}
+		}
+	}
+	diffs = append(diffs, epgDiff)
+
+	ruleDiff := &objectDiff{
+		objType: "rules",
+		desired: configObjects{},
+		current: configObjects{},
+		create:  func(obj interface{}) error { return contivModel.CreateRule(obj.(*contivModel.Rule)) },
+		remove:  contivModel.DeleteRule,
+	}
+	for idx := range desired.Rules {
+		obj := &desired.Rules[idx]
+		obj.Key = obj.TenantName + ":" + obj.PolicyName + ":" + obj.RuleID
+		if err := checkTenant("rule", obj.Key, obj.TenantName); err != nil {
+			return nil, err
+		}
+		if err := checkKey(ruleDiff.desired, "rule", obj.Key); err != nil {
+			return nil, err
+		}
+		if err := contivModel.ValidateRule(obj); err != nil {
+			return nil, err
+		}
+		ruleDiff.desired[obj.Key] = obj
+	}
+	for key := range policyDiff.current {
+		for ruleKey := range policyDiff.current[key].(*contivModel.Policy).LinkSets.Rules {
+			if current := contivModel.FindRule(ruleKey); current != nil {
+				ruleDiff.current[ruleKey] = current
+			}
+		}
+	}
+	diffs = append(diffs, ruleDiff)
+
+	serviceDiff := &objectDiff{
+		objType: "serviceLBs",
+		desired: configObjects{},
+		current: configObjects{},
+		create:  func(obj interface{}) error { return contivModel.CreateServiceLB(obj.(*contivModel.ServiceLB)) },
+		remove:  contivModel.DeleteServiceLB,
+	}
+	for idx := range desired.ServiceLBs {
+		obj := &desired.ServiceLBs[idx]
+		obj.Key = obj.TenantName + ":" + obj.ServiceName
+		if err := checkTenant("serviceLB", obj.Key, obj.TenantName); err != nil {
+			return nil, err
+		}
+		if err := checkKey(serviceDiff.desired, "serviceLB", obj.Key); err != nil {
+			return nil, err
+		}
+		if err := contivModel.ValidateServiceLB(obj); err != nil {
+			return nil, err
+		}
+		serviceDiff.desired[obj.Key] = obj
+	}
+	for _, tenant := range currentTenants() {
+		for key := range tenant.LinkSets.Servicelbs {
+			if current := contivModel.FindServiceLB(key); current != nil {
+				serviceDiff.current[key] = current
+			}
+		}
+	}
+	diffs = append(diffs, serviceDiff)
+
+	return diffs, nil
+}
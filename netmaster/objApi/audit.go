@@ -0,0 +1,172 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objApi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/contivmodel"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+	"github.com/gorilla/mux"
+)
+
+// AuditRoute is the REST route to query the audit log
+const AuditRoute = "/api/v1/audit/"
+
+// modelTypes are the object types whose changes are versioned and recorded
+// in the audit log, along with the function to find an object of the type
+var modelTypes = map[string]func(key string) interface{}{
+	"globals":            func(key string) interface{} { return contivModel.FindGlobal(key) },
+	"aciGws":             func(key string) interface{} { return contivModel.FindAciGw(key) },
+	"Bgps":               func(key string) interface{} { return contivModel.FindBgp(key) },
+	"tenants":            func(key string) interface{} { return contivModel.FindTenant(key) },
+	"networks":           func(key string) interface{} { return contivModel.FindNetwork(key) },
+	"netprofiles":        func(key string) interface{} { return contivModel.FindNetprofile(key) },
+	"policys":            func(key string) interface{} { return contivModel.FindPolicy(key) },
+	"rules":              func(key string) interface{} { return contivModel.FindRule(key) },
+	"extContractsGroups": func(key string) interface{} { return contivModel.FindExtContractsGroup(key) },
+	"endpointGroups":     func(key string) interface{} { return contivModel.FindEndpointGroup(key) },
+	"appProfiles":        func(key string) interface{} { return contivModel.FindAppProfile(key) },
+	"serviceLBs":         func(key string) interface{} { return contivModel.FindServiceLB(key) },
+	"volumes":            func(key string) interface{} { return contivModel.FindVolume(key) },
+	"volumeProfiles":     func(key string) interface{} { return contivModel.FindVolumeProfile(key) },
+}
+
+// auditSnapshot returns the config of an object as json, or nil if the
+// object does not exist
+func auditSnapshot(objType, key string) json.RawMessage {
+	obj := modelTypes[objType](key)
+	if reflect.ValueOf(obj).IsNil() {
+		return nil
+	}
+
+	jdata, err := json.Marshal(obj)
+	if err == nil {
+		var cfg map[string]interface{}
+		if cfg, err = modelObjConfig(jdata); err == nil {
+			jdata, err = json.Marshal(cfg)
+		}
+	}
+	if err != nil {
+		log.Errorf("Error reading %s %s for audit log. Err: %v", objType, key, err)
+		return nil
+	}
+
+	return jdata
+}
+
+// auditTenant returns the tenant of an audited object
+func auditTenant(objType, key string, snapshot json.RawMessage) string {
+	if objType == "tenants" {
+		return key
+	}
+
+	var obj struct {
+		TenantName string `json:"tenantName"`
+	}
+	json.Unmarshal(snapshot, &obj)
+
+	return obj.TenantName
+}
+
+// auditChange makes a change to an object and records it in the audit log
+// along with the object before and after the change. Failed changes are
+// not recorded.
+func auditChange(client, objType, key string, remove bool, apply func() error) error {
+	before := auditSnapshot(objType, key)
+	if err := apply(); err != nil {
+		return err
+	}
+	after := auditSnapshot(objType, key)
+
+	operation := "update"
+	if remove {
+		operation = "delete"
+	} else if before == nil {
+		operation = "create"
+	}
+
+	tenant := auditTenant(objType, key, after)
+	if tenant == "" {
+		tenant = auditTenant(objType, key, before)
+	}
+
+	entry := &mastercfg.CfgAuditEntry{
+		Timestamp:  time.Now(),
+		Client:     client,
+		Tenant:     tenant,
+		ObjectType: objType,
+		ObjectKey:  key,
+		Operation:  operation,
+		Before:     before,
+		After:      after,
+	}
+
+	stateDriver, err := utils.GetStateDriver()
+	if err == nil {
+		err = mastercfg.RecordAuditEntry(stateDriver, entry)
+	}
+	if err != nil {
+		log.Errorf("Error recording %s of %s %s in audit log. Err: %v", operation, objType, key, err)
+	}
+
+	return nil
+}
+
+// addAuditRoutes registers the REST routes for the audit log
+func (ac *APIController) addAuditRoutes(router *mux.Router) {
+	router.Path(AuditRoute).Methods("GET").HandlerFunc(httpListAudit)
+}
+
+// httpListAudit returns the audit log entries from oldest to newest. The
+// tenant and object query parameters select the entries of a tenant and of
+// an object type or key.
+func httpListAudit(w http.ResponseWriter, r *http.Request) {
+	entries, err := listAuditEntries(r.URL.Query().Get("tenant"), r.URL.Query().Get("object"))
+	writeResult(w, r, entries, err)
+}
+
+// listAuditEntries returns the audit log entries of a tenant and object.
+// Empty filters select all entries.
+func listAuditEntries(tenant, object string) ([]*mastercfg.CfgAuditEntry, error) {
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := mastercfg.ReadAuditEntries(stateDriver)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []*mastercfg.CfgAuditEntry{}
+	for _, entry := range entries {
+		if tenant != "" && entry.Tenant != tenant {
+			continue
+		}
+		if object != "" && entry.ObjectType != object && entry.ObjectKey != object {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+
+	return filtered, nil
+}
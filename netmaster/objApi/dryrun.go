@@ -0,0 +1,581 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objApi
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/contivmodel"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+)
+
+// Create, update and delete calls of networks, endpoint groups and rules
+// with the dryrun query parameter set to true return the change plan of the
+// call instead of making it. Dry-runs of other object types are rejected.
+
+// ChangePlan describes what a create, update or delete of an object would
+// change. Building a change plan does not write anything.
+type ChangePlan struct {
+	Operation         string   `json:"operation"`                   // create, update or delete
+	ObjectType        string   `json:"objectType"`                  // networks, endpointGroups or rules
+	ObjectKey         string   `json:"objectKey"`                   // key of the object
+	PktTagType        string   `json:"pktTagType,omitempty"`        // vlan or vxlan
+	PktTag            int      `json:"pktTag,omitempty"`            // vlan or vxlan allocated or freed
+	EndpointGroups    []string `json:"endpointGroups,omitempty"`    // affected endpoint groups
+	AppProfiles       []string `json:"appProfiles,omitempty"`       // affected app profiles
+	OfnetRulesAdded   []string `json:"ofnetRulesAdded,omitempty"`   // ofnet rules that would be added
+	OfnetRulesDeleted []string `json:"ofnetRulesDeleted,omitempty"` // ofnet rules that would be removed
+}
+
+// dryRunError is a dry-run failure that is not a validation failure
+type dryRunError struct {
+	status int
+	err    error
+}
+
+func (err *dryRunError) Error() string {
+	return err.err.Error()
+}
+
+// storeError is returned when the state store can't be used for a dry-run
+func storeError(err error) error {
+	return &dryRunError{status: http.StatusInternalServerError, err: err}
+}
+
+// notFoundError is returned for dry-run deletes of objects that don't exist
+func notFoundError(objType string) error {
+	return &dryRunError{status: http.StatusNotFound, err: core.Errorf("%s not found", objType)}
+}
+
+// isDryRun returns true if a request asks for a change plan
+func isDryRun(r *http.Request) bool {
+	return r.Method != "GET" && r.URL.Query().Get("dryrun") == "true"
+}
+
+// serveDryRun writes the change plan of a create, update or delete call.
+// Changes that fail validation are rejected with 400.
+func serveDryRun(w http.ResponseWriter, r *http.Request, objType, key string) {
+	var plan *ChangePlan
+	var err error
+	if r.Method == "DELETE" {
+		plan, err = dryRunDelete(objType, key)
+	} else {
+		plan, err = dryRunCreate(r, objType, key)
+	}
+	if err != nil {
+		log.Errorf("Dry-run of %s %s returned error: %s", r.Method, r.URL, err)
+		status := http.StatusBadRequest
+		if dryErr, ok := err.(*dryRunError); ok {
+			status = dryErr.status
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(plan); err != nil {
+		log.Errorf("Error generating json. Err: %v", err)
+	}
+}
+
+// dryRunCreate builds the change plan of a create call. Like the real create
+// call, it turns into an update if the object already exists.
+func dryRunCreate(r *http.Request, objType, key string) (*ChangePlan, error) {
+	switch objType {
+	case "networks":
+		var network contivModel.Network
+		if err := json.NewDecoder(r.Body).Decode(&network); err != nil {
+			return nil, err
+		}
+		network.Key = key
+		if err := contivModel.ValidateNetwork(&network); err != nil {
+			return nil, err
+		}
+		if existing := contivModel.FindNetwork(key); existing != nil {
+			return planNetworkUpdate(existing, &network)
+		}
+		return planNetworkCreate(&network)
+
+	case "endpointGroups":
+		var endpointGroup contivModel.EndpointGroup
+		if err := json.NewDecoder(r.Body).Decode(&endpointGroup); err != nil {
+			return nil, err
+		}
+		endpointGroup.Key = key
+		if err := contivModel.ValidateEndpointGroup(&endpointGroup); err != nil {
+			return nil, err
+		}
+		if existing := contivModel.FindEndpointGroup(key); existing != nil {
+			return planEndpointGroupUpdate(existing, &endpointGroup)
+		}
+		return planEndpointGroupCreate(&endpointGroup)
+
+	case "rules":
+		var rule contivModel.Rule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			return nil, err
+		}
+		rule.Key = key
+		if err := contivModel.ValidateRule(&rule); err != nil {
+			return nil, err
+		}
+		return planRuleChange(contivModel.FindRule(key), &rule)
+	}
+
+	return nil, core.Errorf("dry-run is not supported for %s", objType)
+}
+
+// dryRunDelete builds the change plan of a delete call
+func dryRunDelete(objType, key string) (*ChangePlan, error) {
+	switch objType {
+	case "networks":
+		network := contivModel.FindNetwork(key)
+		if network == nil {
+			return nil, notFoundError("network")
+		}
+		return planNetworkDelete(network)
+
+	case "endpointGroups":
+		endpointGroup := contivModel.FindEndpointGroup(key)
+		if endpointGroup == nil {
+			return nil, notFoundError("endpointGroup")
+		}
+		return planEndpointGroupDelete(endpointGroup)
+
+	case "rules":
+		rule := contivModel.FindRule(key)
+		if rule == nil {
+			return nil, notFoundError("rule")
+		}
+		return planRuleDelete(rule)
+	}
+
+	return nil, core.Errorf("dry-run is not supported for %s", objType)
+}
+
+// setPktTags fills in the pkt tags of a network in a change plan
+func (plan *ChangePlan) setPktTags(nwCfg *mastercfg.CfgNetworkState) {
+	plan.PktTagType = nwCfg.PktTagType
+	plan.PktTag = nwCfg.PktTag
+	if nwCfg.PktTagType == "vxlan" {
+		plan.PktTag = nwCfg.ExtPktTag
+	}
+}
+
+// addProfiles adds app profiles to a change plan
+func (plan *ChangePlan) addProfiles(profMap map[string]bool) {
+	for prof := range profMap {
+		if !stringInSlice(prof, plan.AppProfiles) {
+			plan.AppProfiles = append(plan.AppProfiles, prof)
+		}
+	}
+}
+
+// sort orders the lists in a change plan
+func (plan *ChangePlan) sort() {
+	sort.Strings(plan.EndpointGroups)
+	sort.Strings(plan.AppProfiles)
+	sort.Strings(plan.OfnetRulesAdded)
+	sort.Strings(plan.OfnetRulesDeleted)
+}
+
+// planNetworkCreate runs the NetworkCreate validation and returns the pkt
+// tags the network would get
+func planNetworkCreate(network *contivModel.Network) (*ChangePlan, error) {
+	if _, err := validateNetworkCreate(network); err != nil {
+		return nil, err
+	}
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	networkCfg := intent.ConfigNetwork{
+		Name:                network.NetworkName,
+		NwType:              network.NwType,
+		PktTagType:          network.Encap,
+		PktTag:              network.PktTag,
+		SubnetCIDR:          network.Subnet,
+		Gateway:             network.Gateway,
+		IPv6SubnetCIDR:      network.Ipv6Subnet,
+		IPv6Gateway:         network.Ipv6Gateway,
+		AddrQuarantineTime:  network.AddrQuarantineTime,
+		AddrQuarantineCount: network.AddrQuarantineCount,
+		ServiceIPPool:       network.ServiceIpPool,
+	}
+
+	nwCfg, err := master.PreviewNetwork(networkCfg, stateDriver, network.TenantName)
+	if err != nil {
+		log.Errorf("Error validating network {%+v}. Err: %v", network, err)
+		return nil, err
+	}
+
+	plan := &ChangePlan{Operation: "create", ObjectType: "networks", ObjectKey: network.Key}
+	plan.setPktTags(nwCfg)
+
+	return plan, nil
+}
+
+// planNetworkUpdate runs the NetworkUpdate validation and returns the
+// endpoint groups on the network
+func planNetworkUpdate(network, params *contivModel.Network) (*ChangePlan, error) {
+	if err := validateNetworkUpdate(network, params); err != nil {
+		return nil, err
+	}
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	networkCfg := intent.ConfigNetwork{
+		Name:                network.NetworkName,
+		NwType:              network.NwType,
+		PktTagType:          network.Encap,
+		PktTag:              network.PktTag,
+		SubnetCIDR:          params.Subnet,
+		Gateway:             params.Gateway,
+		IPv6SubnetCIDR:      params.Ipv6Subnet,
+		IPv6Gateway:         params.Ipv6Gateway,
+		AddrQuarantineTime:  params.AddrQuarantineTime,
+		AddrQuarantineCount: params.AddrQuarantineCount,
+		ServiceIPPool:       params.ServiceIpPool,
+	}
+
+	nwCfg, err := master.PreviewNetworkUpdate(networkCfg, stateDriver, network.TenantName)
+	if err != nil {
+		log.Errorf("Error validating network update {%+v}. Err: %v", params, err)
+		return nil, err
+	}
+
+	plan := &ChangePlan{Operation: "update", ObjectType: "networks", ObjectKey: network.Key}
+	plan.setPktTags(nwCfg)
+	for epgKey := range network.LinkSets.EndpointGroups {
+		plan.EndpointGroups = append(plan.EndpointGroups, epgKey)
+		if epg := contivModel.FindEndpointGroup(epgKey); epg != nil && epg.Links.AppProfile.ObjKey != "" {
+			plan.addProfiles(map[string]bool{epg.Links.AppProfile.ObjKey: true})
+		}
+	}
+	plan.sort()
+
+	return plan, nil
+}
+
+// planNetworkDelete runs the NetworkDelete validation and returns the pkt
+// tags that would be freed
+func planNetworkDelete(network *contivModel.Network) (*ChangePlan, error) {
+	if _, err := validateNetworkDelete(network); err != nil {
+		return nil, err
+	}
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, storeError(err)
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	networkID := network.NetworkName + "." + network.TenantName
+	if err := nwCfg.Read(networkID); err != nil {
+		log.Errorf("network %s is not operational", networkID)
+		return nil, storeError(err)
+	}
+
+	plan := &ChangePlan{Operation: "delete", ObjectType: "networks", ObjectKey: network.Key}
+	plan.setPktTags(nwCfg)
+
+	return plan, nil
+}
+
+// policyRuleIDs returns the ofnet rules a policy would install for an
+// endpoint group
+func policyRuleIDs(endpointGroup *contivModel.EndpointGroup, policy *contivModel.Policy) ([]string, error) {
+	ruleIDs := []string{}
+	epgpKey := endpointGroup.Key + ":" + policy.Key
+	for ruleKey := range policy.LinkSets.Rules {
+		rule := contivModel.FindRule(ruleKey)
+		if rule == nil {
+			log.Errorf("Error finding the rule %s", ruleKey)
+			continue
+		}
+
+		ids, err := mastercfg.OfnetRuleIDs(epgpKey, rule, 0)
+		if err != nil {
+			return nil, err
+		}
+		ruleIDs = append(ruleIDs, ids...)
+	}
+
+	return ruleIDs, nil
+}
+
+// installedRuleIDs returns the ofnet rules installed for a policy in an
+// endpoint group
+func installedRuleIDs(endpointGroup *contivModel.EndpointGroup, policy *contivModel.Policy) []string {
+	ruleIDs := []string{}
+	gp := mastercfg.FindEpgPolicy(endpointGroup.Key + ":" + policy.Key)
+	if gp == nil {
+		return ruleIDs
+	}
+
+	for _, ruleMap := range gp.RuleMaps {
+		for ruleID := range ruleMap.OfnetRules {
+			ruleIDs = append(ruleIDs, ruleID)
+		}
+	}
+
+	return ruleIDs
+}
+
+// findEpgPolicy finds a policy referred by an endpoint group
+func findEpgPolicy(endpointGroup *contivModel.EndpointGroup, policyName string) (*contivModel.Policy, error) {
+	policyKey := GetpolicyKey(endpointGroup.TenantName, policyName)
+	policy := contivModel.FindPolicy(policyKey)
+	if policy == nil {
+		log.Errorf("Could not find policy %s", policyName)
+		return nil, core.Errorf("Policy not found")
+	}
+
+	return policy, nil
+}
+
+// planEndpointGroupCreate runs the EndpointGroupCreate validation and returns
+// the ofnet rules the attached policies would install
+func planEndpointGroupCreate(endpointGroup *contivModel.EndpointGroup) (*ChangePlan, error) {
+	_, _, err := validateEndpointGroupCreate(endpointGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	err = master.PreviewEndpointGroup(endpointGroup.TenantName, endpointGroup.NetworkName,
+		endpointGroup.IpPool, endpointGroup.Ipv6Pool)
+	if err != nil {
+		log.Errorf("Error validating endpoint group %+v. Err: %v", endpointGroup, err)
+		return nil, err
+	}
+
+	plan := &ChangePlan{Operation: "create", ObjectType: "endpointGroups", ObjectKey: endpointGroup.Key}
+	plan.EndpointGroups = []string{endpointGroup.Key}
+
+	for _, policyName := range endpointGroup.Policies {
+		policy, err := findEpgPolicy(endpointGroup, policyName)
+		if err != nil {
+			return nil, err
+		}
+
+		ruleIDs, err := policyRuleIDs(endpointGroup, policy)
+		if err != nil {
+			return nil, err
+		}
+		plan.OfnetRulesAdded = append(plan.OfnetRulesAdded, ruleIDs...)
+	}
+
+	if endpointGroup.NetProfile != "" {
+		profileKey := GetNetprofileKey(endpointGroup.TenantName, endpointGroup.NetProfile)
+		if contivModel.FindNetprofile(profileKey) == nil {
+			log.Errorf("Error finding netprofile: %s", profileKey)
+			return nil, core.Errorf("Netprofile not found")
+		}
+	}
+
+	for _, contractsGrp := range endpointGroup.ExtContractsGrps {
+		if contivModel.FindExtContractsGroup(endpointGroup.TenantName+":"+contractsGrp) == nil {
+			return nil, core.Errorf("External contracts group %s not found", contractsGrp)
+		}
+	}
+	plan.sort()
+
+	return plan, nil
+}
+
+// planEndpointGroupUpdate runs the EndpointGroupUpdate validation and returns
+// the ofnet rules added and removed by policy changes
+func planEndpointGroupUpdate(endpointGroup, params *contivModel.EndpointGroup) (*ChangePlan, error) {
+	if err := validateEndpointGroupUpdate(endpointGroup, params); err != nil {
+		return nil, err
+	}
+
+	if endpointGroup.IpPool != params.IpPool {
+		err := master.PreviewEndpointGroupPool(endpointGroup.TenantName, endpointGroup.GroupName, params.IpPool)
+		if err != nil {
+			log.Errorf("Error validating ip pool of epg %s. Err: %v", endpointGroup.Key, err)
+			return nil, err
+		}
+	}
+
+	plan := &ChangePlan{Operation: "update", ObjectType: "endpointGroups", ObjectKey: endpointGroup.Key}
+	plan.EndpointGroups = []string{endpointGroup.Key}
+	if endpointGroup.Links.AppProfile.ObjKey != "" {
+		plan.AppProfiles = []string{endpointGroup.Links.AppProfile.ObjKey}
+	}
+
+	// look for policy adds
+	for _, policyName := range params.Policies {
+		if stringInSlice(policyName, endpointGroup.Policies) {
+			continue
+		}
+
+		policy, err := findEpgPolicy(endpointGroup, policyName)
+		if err != nil {
+			return nil, err
+		}
+
+		ruleIDs, err := policyRuleIDs(endpointGroup, policy)
+		if err != nil {
+			return nil, err
+		}
+		plan.OfnetRulesAdded = append(plan.OfnetRulesAdded, ruleIDs...)
+	}
+
+	// look for policy removals
+	for _, policyName := range endpointGroup.Policies {
+		if stringInSlice(policyName, params.Policies) {
+			continue
+		}
+
+		policy, err := findEpgPolicy(endpointGroup, policyName)
+		if err != nil {
+			return nil, err
+		}
+		plan.OfnetRulesDeleted = append(plan.OfnetRulesDeleted, installedRuleIDs(endpointGroup, policy)...)
+	}
+
+	if params.NetProfile != "" {
+		profileKey := GetNetprofileKey(params.TenantName, params.NetProfile)
+		if contivModel.FindNetprofile(profileKey) == nil {
+			log.Errorf("Error finding netprofile: %s", profileKey)
+			return nil, core.Errorf("Netprofile not found")
+		}
+	}
+	plan.sort()
+
+	return plan, nil
+}
+
+// planEndpointGroupDelete runs the EndpointGroupDelete validation and returns
+// the ofnet rules that would be removed
+func planEndpointGroupDelete(endpointGroup *contivModel.EndpointGroup) (*ChangePlan, error) {
+	if err := validateEndpointGroupDelete(endpointGroup); err != nil {
+		return nil, err
+	}
+
+	plan := &ChangePlan{Operation: "delete", ObjectType: "endpointGroups", ObjectKey: endpointGroup.Key}
+	plan.EndpointGroups = []string{endpointGroup.Key}
+	for policyKey := range endpointGroup.LinkSets.Policies {
+		policy := contivModel.FindPolicy(policyKey)
+		if policy == nil {
+			log.Errorf("Could not find policy %s", policyKey)
+			continue
+		}
+		plan.OfnetRulesDeleted = append(plan.OfnetRulesDeleted, installedRuleIDs(endpointGroup, policy)...)
+	}
+	plan.sort()
+
+	return plan, nil
+}
+
+// findRulePolicy finds the policy a rule belongs to
+func findRulePolicy(rule *contivModel.Rule) (*contivModel.Policy, error) {
+	policyKey := GetpolicyKey(rule.TenantName, rule.PolicyName)
+	policy := contivModel.FindPolicy(policyKey)
+	if policy == nil {
+		log.Errorf("Error finding policy %s", policyKey)
+		return nil, core.Errorf("Policy not found")
+	}
+
+	return policy, nil
+}
+
+// planRuleChange runs the RuleCreate or RuleUpdate validation and returns the
+// ofnet rules that would be added and removed in every endpoint group the
+// policy is attached to
+func planRuleChange(rule, params *contivModel.Rule) (*ChangePlan, error) {
+	epg, err := validateRule(params)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := findRulePolicy(params)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ChangePlan{Operation: "create", ObjectType: "rules", ObjectKey: params.Key}
+	if rule != nil {
+		plan.Operation = "update"
+	}
+
+	for epgKey := range policy.LinkSets.EndpointGroups {
+		epgpKey := epgKey + ":" + policy.Key
+		plan.EndpointGroups = append(plan.EndpointGroups, epgKey)
+
+		// updated rules are installed with the next generation
+		gen := 0
+		if gp := mastercfg.FindEpgPolicy(epgpKey); gp != nil && gp.RuleMaps[params.Key] != nil {
+			ruleMap := gp.RuleMaps[params.Key]
+			gen = ruleMap.Generation + 1
+			for ruleID := range ruleMap.OfnetRules {
+				plan.OfnetRulesDeleted = append(plan.OfnetRulesDeleted, ruleID)
+			}
+		}
+
+		ruleIDs, err := mastercfg.OfnetRuleIDs(epgpKey, params, gen)
+		if err != nil {
+			return nil, err
+		}
+		plan.OfnetRulesAdded = append(plan.OfnetRulesAdded, ruleIDs...)
+	}
+
+	plan.addProfiles(getAffectedProfs(policy, epg))
+	if rule != nil && rule.Links.MatchEndpointGroup.ObjKey != "" {
+		plan.addProfiles(getAffectedProfs(policy, contivModel.FindEndpointGroup(rule.Links.MatchEndpointGroup.ObjKey)))
+	}
+	plan.sort()
+
+	return plan, nil
+}
+
+// planRuleDelete returns the ofnet rules a rule delete would remove
+func planRuleDelete(rule *contivModel.Rule) (*ChangePlan, error) {
+	policy, err := findRulePolicy(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ChangePlan{Operation: "delete", ObjectType: "rules", ObjectKey: rule.Key}
+	for epgKey := range policy.LinkSets.EndpointGroups {
+		plan.EndpointGroups = append(plan.EndpointGroups, epgKey)
+	}
+	plan.OfnetRulesDeleted = master.PolicyRuleIDs(policy, rule)
+
+	var epg *contivModel.EndpointGroup
+	if rule.Links.MatchEndpointGroup.ObjKey != "" {
+		epg = contivModel.FindEndpointGroup(rule.Links.MatchEndpointGroup.ObjKey)
+	}
+	plan.addProfiles(getAffectedProfs(policy, epg))
+	plan.sort()
+
+	return plan, nil
+}
@@ -0,0 +1,355 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objApi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/contivmodel"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+	"github.com/contiv/objdb/modeldb"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// ExportRoute is the REST route to export the cluster config
+	ExportRoute = "/api/v1/export/"
+	// ImportRoute is the REST route to import a cluster config
+	ImportRoute = "/api/v1/import/"
+
+	// ExportVersion is the version of the cluster config document
+	ExportVersion = 1
+)
+
+// ClusterConfig is a versioned document holding every object of the
+// contiv object model
+type ClusterConfig struct {
+	Version            int                             `json:"version"`
+	Global             []contivModel.Global            `json:"global,omitempty"`
+	AciGws             []contivModel.AciGw             `json:"aciGws,omitempty"`
+	Bgps               []contivModel.Bgp               `json:"bgps,omitempty"`
+	Tenants            []contivModel.Tenant            `json:"tenants,omitempty"`
+	Networks           []contivModel.Network           `json:"networks,omitempty"`
+	Netprofiles        []contivModel.Netprofile        `json:"netprofiles,omitempty"`
+	Policies           []contivModel.Policy            `json:"policies,omitempty"`
+	Rules              []contivModel.Rule              `json:"rules,omitempty"`
+	ExtContractsGroups []contivModel.ExtContractsGroup `json:"extContractsGroups,omitempty"`
+	EndpointGroups     []contivModel.EndpointGroup     `json:"endpointGroups,omitempty"`
+	AppProfiles        []contivModel.AppProfile        `json:"appProfiles,omitempty"`
+	ServiceLBs         []contivModel.ServiceLB         `json:"serviceLBs,omitempty"`
+	Allocations        ConfigAllocations               `json:"allocations"`
+}
+
+// ConfigAllocations holds the resources allocated to the exported objects
+type ConfigAllocations struct {
+	PktTags    map[string]int    `json:"pktTags,omitempty"`    // vlan or vxlan of each network
+	ServiceIPs map[string]string `json:"serviceIPs,omitempty"` // ip address of each service
+}
+
+// addExportRoutes registers the REST routes for exporting and importing
+// the cluster config
+func (ac *APIController) addExportRoutes(router *mux.Router) {
+	router.Path(ExportRoute).Methods("GET").HandlerFunc(httpExportConfig)
+	router.Path(ImportRoute).Methods("POST").HandlerFunc(httpImportConfig)
+}
+
+// writeResult writes the json result of an export or import request
+func writeResult(w http.ResponseWriter, r *http.Request, result interface{}, err error) {
+	if err != nil {
+		log.Errorf("Handler for %s %s returned error: %s", r.Method, r.URL, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Errorf("Error generating json. Err: %v", err)
+	}
+}
+
+// httpExportConfig returns the cluster config document
+func httpExportConfig(w http.ResponseWriter, r *http.Request) {
+	config, err := exportConfig()
+	writeResult(w, r, config, err)
+}
+
+// httpImportConfig recreates the objects of a cluster config document.
+// Allocated pkt-tags and service ips are kept when the keepAllocations
+// query parameter is set.
+func httpImportConfig(w http.ResponseWriter, r *http.Request) {
+	var config ClusterConfig
+	var result *ApplyResult
+
+	err := json.NewDecoder(r.Body).Decode(&config)
+	if err == nil {
+		keepAllocations := r.URL.Query().Get("keepAllocations") == "true"
+		result, err = importConfig(&config, keepAllocations, clientAddress(r))
+	}
+	writeResult(w, r, result, err)
+}
+
+// modelObjConfig parses a model object and leaves out its links
+func modelObjConfig(objStr []byte) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(objStr, &obj); err != nil {
+		log.Errorf("Error parsing object %s, Err %v", objStr, err)
+		return nil, err
+	}
+
+	delete(obj, "link-sets")
+	delete(obj, "links")
+
+	return obj, nil
+}
+
+// readModelObjs reads all objects of a model type into objs, which must
+// point to a slice of the model type. Links are left out since they are
+// rebuilt when the objects are created.
+func readModelObjs(objType string, objs interface{}) error {
+	strList, err := modeldb.ReadAllObj(objType)
+	if err != nil {
+		return err
+	}
+
+	objList := []map[string]interface{}{}
+	for _, objStr := range strList {
+		obj, err := modelObjConfig([]byte(objStr))
+		if err != nil {
+			return err
+		}
+		objList = append(objList, obj)
+	}
+
+	jdata, err := json.Marshal(objList)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(jdata, objs)
+}
+
+// exportConfig returns every object of the contiv object model along with
+// the pkt-tags and ips allocated to them
+func exportConfig() (*ClusterConfig, error) {
+	applyMutex.Lock()
+	defer applyMutex.Unlock()
+
+	config := &ClusterConfig{
+		Version: ExportVersion,
+		Allocations: ConfigAllocations{
+			PktTags:    map[string]int{},
+			ServiceIPs: map[string]string{},
+		},
+	}
+
+	modelObjs := []struct {
+		objType string
+		objs    interface{}
+	}{
+		{"global", &config.Global},
+		{"aciGw", &config.AciGws},
+		{"Bgp", &config.Bgps},
+		{"tenant", &config.Tenants},
+		{"network", &config.Networks},
+		{"netprofile", &config.Netprofiles},
+		{"policy", &config.Policies},
+		{"rule", &config.Rules},
+		{"extContractsGroup", &config.ExtContractsGroups},
+		{"endpointGroup", &config.EndpointGroups},
+		{"appProfile", &config.AppProfiles},
+		{"serviceLB", &config.ServiceLBs},
+	}
+	for _, model := range modelObjs {
+		if err := readModelObjs(model.objType, model.objs); err != nil {
+			log.Errorf("Error reading %s objects. Err: %v", model.objType, err)
+			return nil, err
+		}
+	}
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, network := range config.Networks {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = stateDriver
+		if err := nwCfg.Read(network.NetworkName + "." + network.TenantName); err != nil {
+			log.Errorf("Error reading network %s. Err: %v", network.Key, err)
+			return nil, err
+		}
+
+		if nwCfg.PktTagType == "vxlan" {
+			config.Allocations.PktTags[network.Key] = nwCfg.ExtPktTag
+		} else {
+			config.Allocations.PktTags[network.Key] = nwCfg.PktTag
+		}
+	}
+
+	mastercfg.SvcMutex.RLock()
+	defer mastercfg.SvcMutex.RUnlock()
+	for _, serviceLB := range config.ServiceLBs {
+		service := mastercfg.ServiceLBDb[master.GetServiceID(serviceLB.ServiceName, serviceLB.TenantName)]
+		if service != nil && service.IPAddress != "" {
+			config.Allocations.ServiceIPs[serviceLB.Key] = service.IPAddress
+		}
+	}
+
+	return config, nil
+}
+
+// checkEmptyCluster verifies that the cluster holds no objects besides the
+// global config and the default tenant
+func checkEmptyCluster() error {
+	if contivModel.GetTenantCount() > 1 ||
+		(contivModel.GetTenantCount() == 1 && contivModel.FindTenant("default") == nil) {
+		return core.Errorf("cluster has tenants other than default")
+	}
+
+	counts := map[string]int{
+		"Bgp":               contivModel.GetBgpCount(),
+		"network":           contivModel.GetNetworkCount(),
+		"netprofile":        contivModel.GetNetprofileCount(),
+		"policy":            contivModel.GetPolicyCount(),
+		"rule":              contivModel.GetRuleCount(),
+		"extContractsGroup": contivModel.GetExtContractsGroupCount(),
+		"endpointGroup":     contivModel.GetEndpointGroupCount(),
+		"appProfile":        contivModel.GetAppProfileCount(),
+		"serviceLB":         contivModel.GetServiceLBCount(),
+	}
+	for objType, count := range counts {
+		if count != 0 {
+			return core.Errorf("cluster is not empty, it has %d %s objects", count, objType)
+		}
+	}
+
+	return nil
+}
+
+// importConfig recreates the objects of a cluster config in dependency
+// order on an empty cluster. Importing stops at the first failure.
+func importConfig(config *ClusterConfig, keepAllocations bool, client string) (*ApplyResult, error) {
+	applyMutex.Lock()
+	defer applyMutex.Unlock()
+
+	if config.Version != ExportVersion {
+		return nil, core.Errorf("unsupported config version %d, expected %d", config.Version, ExportVersion)
+	}
+
+	if err := checkEmptyCluster(); err != nil {
+		return nil, err
+	}
+
+	result := &ApplyResult{Changes: []ConfigChange{}}
+
+	// addChange adds the change creating an object. Objects that exist on
+	// an empty cluster are updated unless their config is the same.
+	addChange := func(objType, key string, obj, current interface{}, create func() error) {
+		operation := "create"
+		if !reflect.ValueOf(current).IsNil() {
+			if reflect.DeepEqual(objectConfig(obj), objectConfig(current)) {
+				return
+			}
+			operation = "update"
+		}
+
+		result.Changes = append(result.Changes, ConfigChange{
+			Operation:  operation,
+			ObjectType: objType,
+			ObjectKey:  key,
+			apply:      create,
+		})
+	}
+
+	for idx := range config.Global {
+		obj := &config.Global[idx]
+		addChange("globals", obj.Key, obj, contivModel.FindGlobal(obj.Key),
+			func() error { return contivModel.CreateGlobal(obj) })
+	}
+	for idx := range config.AciGws {
+		obj := &config.AciGws[idx]
+		addChange("aciGws", obj.Key, obj, contivModel.FindAciGw(obj.Key),
+			func() error { return contivModel.CreateAciGw(obj) })
+	}
+	for idx := range config.Bgps {
+		obj := &config.Bgps[idx]
+		addChange("Bgps", obj.Key, obj, contivModel.FindBgp(obj.Key),
+			func() error { return contivModel.CreateBgp(obj) })
+	}
+	for idx := range config.Tenants {
+		obj := &config.Tenants[idx]
+		addChange("tenants", obj.Key, obj, contivModel.FindTenant(obj.Key),
+			func() error { return contivModel.CreateTenant(obj) })
+	}
+	for idx := range config.Networks {
+		obj := &config.Networks[idx]
+		if keepAllocations && obj.PktTag == 0 {
+			obj.PktTag = config.Allocations.PktTags[obj.Key]
+		}
+		addChange("networks", obj.Key, obj, contivModel.FindNetwork(obj.Key),
+			func() error { return contivModel.CreateNetwork(obj) })
+	}
+	for idx := range config.Netprofiles {
+		obj := &config.Netprofiles[idx]
+		addChange("netprofiles", obj.Key, obj, contivModel.FindNetprofile(obj.Key),
+			func() error { return contivModel.CreateNetprofile(obj) })
+	}
+	for idx := range config.Policies {
+		obj := &config.Policies[idx]
+		addChange("policys", obj.Key, obj, contivModel.FindPolicy(obj.Key),
+			func() error { return contivModel.CreatePolicy(obj) })
+	}
+	for idx := range config.ExtContractsGroups {
+		obj := &config.ExtContractsGroups[idx]
+		addChange("extContractsGroups", obj.Key, obj, contivModel.FindExtContractsGroup(obj.Key),
+			func() error { return contivModel.CreateExtContractsGroup(obj) })
+	}
+	for idx := range config.EndpointGroups {
+		obj := &config.EndpointGroups[idx]
+		addChange("endpointGroups", obj.Key, obj, contivModel.FindEndpointGroup(obj.Key),
+			func() error { return contivModel.CreateEndpointGroup(obj) })
+	}
+	// rules can refer to endpoint groups and go after them
+	for idx := range config.Rules {
+		obj := &config.Rules[idx]
+		addChange("rules", obj.Key, obj, contivModel.FindRule(obj.Key),
+			func() error { return contivModel.CreateRule(obj) })
+	}
+	for idx := range config.AppProfiles {
+		obj := &config.AppProfiles[idx]
+		addChange("appProfiles", obj.Key, obj, contivModel.FindAppProfile(obj.Key),
+			func() error { return contivModel.CreateAppProfile(obj) })
+	}
+	for idx := range config.ServiceLBs {
+		obj := &config.ServiceLBs[idx]
+		if keepAllocations && obj.IpAddress == "" {
+			obj.IpAddress = config.Allocations.ServiceIPs[obj.Key]
+		}
+		addChange("serviceLBs", obj.Key, obj, contivModel.FindServiceLB(obj.Key),
+			func() error { return contivModel.CreateServiceLB(obj) })
+	}
+
+	applyChanges(result, client)
+
+	return result, nil
+}
@@ -0,0 +1,62 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objApi
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/utils"
+	"github.com/gorilla/mux"
+)
+
+// IPAMCheckRoute is the REST route to check the address allocations of
+// networks. GET reports leaked, double allocated and unallocated addresses,
+// POST repairs them as well. The tenant and network query parameters select
+// the networks to check.
+const IPAMCheckRoute = "/api/v1/ipamCheck/"
+
+// addIPAMCheckRoutes registers the REST routes for the ipam check
+func (ac *APIController) addIPAMCheckRoutes(router *mux.Router) {
+	router.Path(IPAMCheckRoute).Methods("GET").HandlerFunc(httpCheckIPAM)
+	router.Path(IPAMCheckRoute).Methods("POST").HandlerFunc(httpCheckIPAM)
+}
+
+// httpCheckIPAM checks, and for POST requests repairs, the address
+// allocations of networks
+func httpCheckIPAM(w http.ResponseWriter, r *http.Request) {
+	repair := r.Method == "POST"
+	tenant := r.URL.Query().Get("tenant")
+	network := r.URL.Query().Get("network")
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		writeResult(w, r, nil, err)
+		return
+	}
+
+	issues, err := master.CheckIPAM(stateDriver, tenant, network, repair)
+	if err == nil && repair {
+		for _, issue := range issues {
+			if issue.Repaired {
+				log.Infof("Repaired %s address %s in network %s/%s", issue.Problem,
+					issue.IPAddress, issue.Tenant, issue.Network)
+			}
+		}
+	}
+	writeResult(w, r, issues, err)
+}
@@ -0,0 +1,104 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objApi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// IPReservationRoute is the REST route to list and create ip reservations
+	IPReservationRoute = "/api/v1/ipReservations/"
+	// IPReservationKeyRoute is the REST route to read and delete an ip
+	// reservation. The key of a reservation is tenant:network:ip-address.
+	IPReservationKeyRoute = "/api/v1/ipReservations/{key}/"
+)
+
+// addIPReservationRoutes registers the REST routes for ip reservations
+func (ac *APIController) addIPReservationRoutes(router *mux.Router) {
+	router.Path(IPReservationRoute).Methods("GET").HandlerFunc(httpListIPReservations)
+	router.Path(IPReservationRoute).Methods("POST").HandlerFunc(httpCreateIPReservation)
+	router.Path(IPReservationKeyRoute).Methods("GET").HandlerFunc(httpGetIPReservation)
+	router.Path(IPReservationKeyRoute).Methods("DELETE").HandlerFunc(httpDeleteIPReservation)
+}
+
+// httpListIPReservations returns the ip reservations. The tenant and
+// network query parameters select the reservations of a tenant and network.
+func httpListIPReservations(w http.ResponseWriter, r *http.Request) {
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		writeResult(w, r, nil, err)
+		return
+	}
+
+	resvList, err := master.ListIPReservations(stateDriver, r.URL.Query().Get("tenant"),
+		r.URL.Query().Get("network"))
+	writeResult(w, r, resvList, err)
+}
+
+// httpCreateIPReservation creates an ip reservation, or changes the
+// workload match of an existing one
+func httpCreateIPReservation(w http.ResponseWriter, r *http.Request) {
+	resv := &mastercfg.CfgIPReservationState{}
+	if err := json.NewDecoder(r.Body).Decode(resv); err != nil {
+		writeResult(w, r, nil, err)
+		return
+	}
+
+	stateDriver, err := utils.GetStateDriver()
+	if err == nil {
+		err = master.CreateIPReservation(stateDriver, resv)
+	}
+	if err == nil {
+		log.Infof("Created ip reservation %s", resv.ID)
+	}
+	writeResult(w, r, resv, err)
+}
+
+// httpGetIPReservation returns an ip reservation
+func httpGetIPReservation(w http.ResponseWriter, r *http.Request) {
+	resv := &mastercfg.CfgIPReservationState{}
+	stateDriver, err := utils.GetStateDriver()
+	if err == nil {
+		resv.StateDriver = stateDriver
+		err = resv.Read(mux.Vars(r)["key"])
+	}
+	if err != nil {
+		http.Error(w, "ip reservation not found", http.StatusNotFound)
+		return
+	}
+	writeResult(w, r, resv, nil)
+}
+
+// httpDeleteIPReservation deletes an ip reservation
+func httpDeleteIPReservation(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	stateDriver, err := utils.GetStateDriver()
+	if err == nil {
+		err = master.DeleteIPReservation(stateDriver, key)
+	}
+	if err == nil {
+		log.Infof("Deleted ip reservation %s", key)
+	}
+	writeResult(w, r, key, err)
+}
@@ -14,7 +14,10 @@ limitations under the License.
 package objApi
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
@@ -50,6 +53,7 @@ const (
 var contivClient *client.ContivClient
 var apiController *APIController
 var stateStore core.StateDriver
+var ofnetMaster *ofnet.OfnetMaster
 
 // initStateDriver initialize etcd state driver
 func initStateDriver() (core.StateDriver, error) {
@@ -78,6 +82,7 @@ func TestMain(m *testing.M) {
 	router := mux.NewRouter()
 	s := router.Headers("Content-Type", "application/json").Methods("Post").Subrouter()
 	s.HandleFunc("/plugin/updateEndpoint", makeHTTPHandler(master.UpdateEndpointHandler))
+	s.HandleFunc("/plugin/updateProviderHealth", makeHTTPHandler(master.UpdateProviderHealthHandler))
 	s.HandleFunc("/plugin/createEndpoint", makeHTTPHandler(master.CreateEndpointHandler))
 	s = router.Methods("Get").Subrouter()
 
@@ -90,7 +95,7 @@ func TestMain(m *testing.M) {
 	// Create a new api controller
 	apiController = NewAPIController(router, objdbClient, "etcd://127.0.0.1:2379")
 
-	ofnetMaster := ofnet.NewOfnetMaster("127.0.0.1", ofnet.OFNET_MASTER_PORT)
+	ofnetMaster = ofnet.NewOfnetMaster("127.0.0.1", ofnet.OFNET_MASTER_PORT)
 	if ofnetMaster == nil {
 		log.Fatalf("Error creating ofnet master")
 	}
@@ -99,7 +104,7 @@ func TestMain(m *testing.M) {
 	mastercfg.InitPolicyMgr(stateStore, ofnetMaster)
 
 	// Create HTTP server
-	go http.ListenAndServe(netmasterTestListenURL, router)
+	go http.ListenAndServe(netmasterTestListenURL, ObjectHandler(router))
 	time.Sleep(time.Second)
 
 	// create a new contiv client
@@ -1137,6 +1142,38 @@ func TestNetworkAddDelete(t *testing.T) {
 	checkDeleteNetwork(t, true, "default", "contiv")
 }
 
+// TestNetworkUpdate tests in-place network updates
+func TestNetworkUpdate(t *testing.T) {
+	checkCreateNetwork(t, false, "default", "contiv", "", "vxlan", "10.1.1.1/24", "10.1.1.254", 1, "", "")
+	checkCreateEpg(t, false, "default", "contiv", "group1", []string{}, []string{})
+
+	// change the gateway
+	checkCreateNetwork(t, false, "default", "contiv", "", "vxlan", "10.1.1.1/24", "10.1.1.1", 1, "", "")
+	checkInspectNetwork(t, false, "default", "contiv", "10.1.1.1", 1, 0)
+	verifyNetworkState(t, "default", "contiv", "data", "vxlan", "10.1.1.1", "10.1.1.1", 24, 1, 1, "", "", 0)
+
+	// expand the subnet, allocated addresses are preserved
+	checkCreateNetwork(t, false, "default", "contiv", "", "vxlan", "10.1.0.0/23", "10.1.1.1", 1, "", "")
+	checkInspectNetwork(t, false, "default", "contiv", "10.1.1.1", 1, 0)
+	verifyNetworkState(t, "default", "contiv", "data", "vxlan", "10.1.0.0", "10.1.1.1", 23, 1, 1, "", "", 0)
+
+	// add an ipv6 subnet and gateway
+	checkCreateNetwork(t, false, "default", "contiv", "", "vxlan", "10.1.0.0/23", "10.1.1.1", 1, "2016:0617::/120", "2016:0617::1")
+	verifyNetworkState(t, "default", "contiv", "data", "vxlan", "10.1.0.0", "10.1.1.1", 23, 1, 1, "2016:0617::", "2016:0617::1", 120)
+
+	// subnet shrink and gateway outside the subnet are rejected
+	checkCreateNetwork(t, true, "default", "contiv", "", "vxlan", "10.1.1.0/25", "10.1.1.1", 1, "2016:0617::/120", "2016:0617::1")
+	checkCreateNetwork(t, true, "default", "contiv", "", "vxlan", "10.1.0.0/23", "10.1.2.1", 1, "2016:0617::/120", "2016:0617::1")
+	verifyNetworkState(t, "default", "contiv", "data", "vxlan", "10.1.0.0", "10.1.1.1", 23, 1, 1, "2016:0617::", "2016:0617::1", 120)
+
+	// encap and pkt tag can not be changed
+	checkCreateNetwork(t, true, "default", "contiv", "", "vlan", "10.1.0.0/23", "10.1.1.1", 1, "2016:0617::/120", "2016:0617::1")
+	checkCreateNetwork(t, true, "default", "contiv", "", "vxlan", "10.1.0.0/23", "10.1.1.1", 2, "2016:0617::/120", "2016:0617::1")
+
+	checkDeleteEpg(t, false, "default", "contiv", "group1")
+	checkDeleteNetwork(t, false, "default", "contiv")
+}
+
 func TestDynamicGlobalVlanRange(t *testing.T) {
 
 	// Basic vlan network creation
@@ -1371,8 +1408,8 @@ func TestPolicyRules(t *testing.T) {
 	checkCreateRule(t, false, "default", "policy1", "6", "in", "", "group1", "", "", "", "", "", "deny", 1, 0)
 	checkCreateRule(t, false, "default", "policy1", "7", "out", "", "", "", "", "group1", "", "tcp", "allow", 1, 80)
 
-	// verify duplicate rule id fails
-	checkCreateRule(t, true, "default", "policy1", "1", "in", "", "", "", "", "", "", "tcp", "allow", 1, 80)
+	// verify duplicate rule id updates the rule
+	checkCreateRule(t, false, "default", "policy1", "1", "in", "", "", "", "", "", "", "tcp", "allow", 1, 80)
 
 	// verify unknown directions fail
 	checkCreateRule(t, true, "default", "policy1", "100", "both", "", "", "", "", "", "", "tcp", "allow", 1, 0)
@@ -1435,6 +1472,844 @@ func TestPolicyRules(t *testing.T) {
 	checkDeleteNetwork(t, false, "default", "contiv")
 }
 
+// verifyRuleUpdate verifies epg policy has the updated rule installed
+func verifyRuleUpdate(t *testing.T, tenant, group, policy, ruleID, action string, prio, gen int) {
+	epgpKey := tenant + ":" + group + ":" + tenant + ":" + policy
+	ruleKey := tenant + ":" + policy + ":" + ruleID
+
+	gp := mastercfg.FindEpgPolicy(epgpKey)
+	if gp == nil {
+		t.Fatalf("Error finding EPG policy %s", epgpKey)
+	}
+
+	ruleMap := gp.RuleMaps[ruleKey]
+	if ruleMap == nil {
+		t.Fatalf("Rule %s not found in EPG policy %s", ruleKey, epgpKey)
+	}
+	if ruleMap.Generation != gen {
+		t.Fatalf("Rule %s generation %d did not match expected %d", ruleKey, ruleMap.Generation, gen)
+	}
+
+	for _, ofnetRule := range ruleMap.OfnetRules {
+		if ofnetRule.Action != action || ofnetRule.Priority != prio {
+			t.Fatalf("Ofnet rule {%+v} did not match action %s priority %d", ofnetRule, action, prio)
+		}
+	}
+}
+
+// TestPolicyRuleUpdate tests updating rules in a policy attached to EPG
+func TestPolicyRuleUpdate(t *testing.T) {
+	checkCreateNetwork(t, false, "default", "contiv", "data", "vxlan", "10.1.1.1/16", "10.1.1.254", 1, "", "")
+	checkCreatePolicy(t, false, "default", "policy1")
+	checkCreateRule(t, false, "default", "policy1", "1", "in", "", "", "", "", "", "", "tcp", "allow", 1, 80)
+	checkCreateEpg(t, false, "default", "contiv", "group1", []string{"policy1"}, []string{})
+	checkCreateEpg(t, false, "default", "contiv", "group2", []string{}, []string{})
+	verifyRuleUpdate(t, "default", "group1", "policy1", "1", "allow", 1, 0)
+
+	// change action, priority and port
+	checkCreateRule(t, false, "default", "policy1", "1", "in", "", "", "", "", "", "", "tcp", "deny", 1, 80)
+	verifyRuleUpdate(t, "default", "group1", "policy1", "1", "deny", 1, 1)
+	checkCreateRule(t, false, "default", "policy1", "1", "in", "", "", "", "", "", "", "tcp", "deny", 5, 8080)
+	verifyRuleUpdate(t, "default", "group1", "policy1", "1", "deny", 5, 2)
+
+	// change the matching epg
+	checkCreateRule(t, false, "default", "policy1", "1", "in", "", "group2", "", "", "", "", "tcp", "deny", 5, 8080)
+	verifyRuleUpdate(t, "default", "group1", "policy1", "1", "deny", 5, 3)
+	epg := contivModel.FindEndpointGroup("default:group2")
+	if epg == nil || len(epg.LinkSets.MatchRules) != 1 {
+		t.Fatalf("Rule not linked to endpoint group group2: %+v", epg)
+	}
+
+	// invalid updates are rejected and leave the rule untouched
+	checkCreateRule(t, true, "default", "policy1", "1", "in", "", "", "", "", "", "invalid", "tcp", "allow", 1, 80)
+	checkCreateRule(t, true, "default", "policy1", "1", "xyz", "", "", "", "", "", "", "tcp", "allow", 1, 80)
+	verifyRuleUpdate(t, "default", "group1", "policy1", "1", "deny", 5, 3)
+
+	// an update failing in one epg leaves the original rule in every epg
+	checkCreateEpg(t, false, "default", "contiv", "group3", []string{"policy1"}, []string{})
+	blocker := &ofnet.OfnetPolicyRule{RuleId: "default:group3:default:policy1:default:policy1:1:inRx:1"}
+	if err := ofnetMaster.AddRule(blocker); err != nil {
+		t.Fatalf("Error adding ofnet rule. Err: %v", err)
+	}
+	checkCreateRule(t, true, "default", "policy1", "1", "in", "", "group2", "", "", "", "", "tcp", "allow", 5, 8080)
+	gp := mastercfg.FindEpgPolicy("default:group1:default:policy1")
+	for _, ofnetRule := range gp.RuleMaps["default:policy1:1"].OfnetRules {
+		if ofnetRule.Action != "deny" || ofnetRule.Priority != 5 {
+			t.Fatalf("Ofnet rule {%+v} was not restored", ofnetRule)
+		}
+	}
+	verifyRuleUpdate(t, "default", "group3", "policy1", "1", "deny", 5, 0)
+	if err := ofnetMaster.DelRule(blocker); err != nil {
+		t.Fatalf("Error deleting ofnet rule. Err: %v", err)
+	}
+	checkDeleteEpg(t, false, "default", "contiv", "group3")
+
+	checkDeleteEpg(t, false, "default", "contiv", "group1")
+	checkDeleteRule(t, false, "default", "policy1", "1")
+	epg = contivModel.FindEndpointGroup("default:group2")
+	if epg == nil || len(epg.LinkSets.MatchRules) != 0 {
+		t.Fatalf("Rule still linked to endpoint group group2: %+v", epg)
+	}
+	checkDeleteEpg(t, false, "default", "contiv", "group2")
+	checkDeletePolicy(t, false, "default", "policy1")
+	checkDeleteNetwork(t, false, "default", "contiv")
+}
+
+// TestPolicyRulePorts tests rules with port lists and ranges
+func TestPolicyRulePorts(t *testing.T) {
+	checkCreateNetwork(t, false, "default", "contiv", "data", "vxlan", "10.1.1.1/16", "10.1.1.254", 1, "", "")
+	checkCreatePolicy(t, false, "default", "policy1")
+	checkCreateEpg(t, false, "default", "contiv", "group1", []string{"policy1"}, []string{})
+
+	rule := client.Rule{
+		TenantName: "default",
+		PolicyName: "policy1",
+		RuleID:     "1",
+		Direction:  "in",
+		Priority:   1,
+		Protocol:   "tcp",
+		Ports:      "8000-8100,443,80,444",
+		Action:     "allow",
+	}
+	err := contivClient.RulePost(&rule)
+	if err != nil {
+		t.Fatalf("Error creating rule {%+v}. Err: %v", rule, err)
+	}
+
+	// verify the port list is stored in compact form
+	ruleObj, err := contivClient.RuleGet("default", "policy1", "1")
+	if err != nil {
+		t.Fatalf("Error getting rule. Err: %v", err)
+	}
+	if ruleObj.Ports != "80,443-444,8000-8100" {
+		t.Fatalf("Rule ports %q did not match expected", ruleObj.Ports)
+	}
+
+	// 80, 443-444 and 8000-8100 expand to 7 masked matches in each direction
+	gp := mastercfg.FindEpgPolicy("default:group1:default:policy1")
+	if gp == nil {
+		t.Fatalf("Error finding EPG policy")
+	}
+	ruleMap := gp.RuleMaps["default:policy1:1"]
+	if ruleMap == nil || len(ruleMap.OfnetRules) != 14 {
+		t.Fatalf("Unexpected ofnet rules for port list: %+v", ruleMap)
+	}
+
+	// verify invalid port lists are rejected
+	for _, ports := range []string{"80-70", "0", "70000", "80,,81"} {
+		rule.Ports = ports
+		if err := contivClient.RulePost(&rule); err == nil {
+			t.Fatalf("Creating rule with ports %q succeeded while expecting error", ports)
+		}
+	}
+	rule.Ports = "80,81"
+	rule.Port = 80
+	if err := contivClient.RulePost(&rule); err == nil {
+		t.Fatalf("Creating rule with both port and ports succeeded while expecting error")
+	}
+	rule.Port = 0
+	rule.Protocol = "icmp"
+	if err := contivClient.RulePost(&rule); err == nil {
+		t.Fatalf("Creating icmp rule with ports succeeded while expecting error")
+	}
+
+	checkDeleteEpg(t, false, "default", "contiv", "group1")
+	checkDeleteRule(t, false, "default", "policy1", "1")
+	checkDeletePolicy(t, false, "default", "policy1")
+	checkDeleteNetwork(t, false, "default", "contiv")
+}
+
+// TestPolicyRuleMatches tests rules with tcp flags, icmp and source port matches
+func TestPolicyRuleMatches(t *testing.T) {
+	checkCreateNetwork(t, false, "default", "contiv", "data", "vxlan", "10.1.1.1/16", "10.1.1.254", 1, "", "")
+	checkCreatePolicy(t, false, "default", "policy1")
+	checkCreateEpg(t, false, "default", "contiv", "group1", []string{"policy1"}, []string{})
+
+	rules := []client.Rule{
+		{RuleID: "1", Protocol: "tcp", TcpFlags: "ack"},
+		{RuleID: "2", Protocol: "icmp", IcmpType: "echo-request", IcmpCode: "0"},
+		{RuleID: "3", Protocol: "udp", Port: 53, SrcPort: 1053},
+	}
+	for _, rule := range rules {
+		rule.TenantName = "default"
+		rule.PolicyName = "policy1"
+		rule.Direction = "in"
+		rule.Action = "allow"
+		if err := contivClient.RulePost(&rule); err != nil {
+			t.Fatalf("Error creating rule {%+v}. Err: %v", rule, err)
+		}
+	}
+
+	gp := mastercfg.FindEpgPolicy("default:group1:default:policy1")
+	if gp == nil {
+		t.Fatalf("Error finding EPG policy")
+	}
+
+	// verify the matches reach the ofnet rules
+	for _, ofnetRule := range gp.RuleMaps["default:policy1:1"].OfnetRules {
+		if ofnetRule.TcpFlags != "ack" {
+			t.Fatalf("Ofnet rule {%+v} did not match tcp flags", ofnetRule)
+		}
+	}
+	for _, ofnetRule := range gp.RuleMaps["default:policy1:2"].OfnetRules {
+		if ofnetRule.IcmpType == nil || *ofnetRule.IcmpType != 8 ||
+			ofnetRule.IcmpCode == nil || *ofnetRule.IcmpCode != 0 {
+			t.Fatalf("Ofnet rule {%+v} did not match icmp type/code", ofnetRule)
+		}
+	}
+	ruleMap := gp.RuleMaps["default:policy1:3"]
+	if len(ruleMap.OfnetRules) != 2 {
+		t.Fatalf("Unexpected ofnet rules for source port rule: %+v", ruleMap)
+	}
+	for _, ofnetRule := range ruleMap.OfnetRules {
+		if (ofnetRule.DstPort != 53 || ofnetRule.SrcPort != 1053) &&
+			(ofnetRule.DstPort != 1053 || ofnetRule.SrcPort != 53) {
+			t.Fatalf("Ofnet rule {%+v} did not match ports", ofnetRule)
+		}
+	}
+
+	// verify invalid matches are rejected
+	invalidRules := []client.Rule{
+		{RuleID: "4", Protocol: "udp", TcpFlags: "ack"},
+		{RuleID: "4", Protocol: "tcp", TcpFlags: "fin"},
+		{RuleID: "4", Protocol: "tcp", IcmpType: "8"},
+		{RuleID: "4", Protocol: "icmp", IcmpCode: "1"},
+		{RuleID: "4", Protocol: "icmp", IcmpType: "256"},
+		{RuleID: "4", Protocol: "icmp", SrcPort: 80},
+	}
+	for _, rule := range invalidRules {
+		rule.TenantName = "default"
+		rule.PolicyName = "policy1"
+		rule.Direction = "in"
+		rule.Action = "allow"
+		if err := contivClient.RulePost(&rule); err == nil {
+			t.Fatalf("Creating rule {%+v} succeeded while expecting error", rule)
+		}
+	}
+
+	// verify tcp flags are validated on their own
+	if _, err := validateRule(&contivModel.Rule{Direction: "in", Protocol: "tcp", TcpFlags: "syn,fin"}); err == nil {
+		t.Fatalf("Validating unsupported tcp flags succeeded while expecting error")
+	}
+
+	// verify invalid rules are rejected with 400
+	body, _ := json.Marshal(&client.Rule{TenantName: "default", PolicyName: "policy1", RuleID: "4",
+		Direction: "in", Action: "allow", Protocol: "udp", TcpFlags: "syn"})
+	resp, err := http.Post(netmasterTestURL+"/api/v1/rules/default:policy1:4/", "application/json",
+		bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error sending rule request. Err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Creating invalid rule returned status %d, expected %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	checkDeleteEpg(t, false, "default", "contiv", "group1")
+	for _, rule := range rules {
+		checkDeleteRule(t, false, "default", "policy1", rule.RuleID)
+	}
+	checkDeletePolicy(t, false, "default", "policy1")
+	checkDeleteNetwork(t, false, "default", "contiv")
+}
+
+// TestPolicyRuleStats tests rule hit counters in policy and rule inspect
+func TestPolicyRuleStats(t *testing.T) {
+	checkCreateNetwork(t, false, "default", "contiv", "data", "vxlan", "10.1.1.1/16", "10.1.1.254", 1, "", "")
+	checkCreatePolicy(t, false, "default", "policy1")
+	checkCreateRule(t, false, "default", "policy1", "1", "in", "", "", "", "", "", "", "tcp", "allow", 1, 80)
+	checkCreateRule(t, false, "default", "policy1", "2", "in", "", "", "", "", "", "", "", "deny", 1, 0)
+	checkCreateEpg(t, false, "default", "contiv", "group1", []string{"policy1"}, []string{})
+
+	// verify every rule is reported in policy inspect
+	insp, err := contivClient.PolicyInspect("default", "policy1")
+	if err != nil {
+		t.Fatalf("Error inspecting policy. Err: %v", err)
+	}
+	if len(insp.Oper.Rules) != 2 || insp.Oper.Rules[0].RuleID != "1" || insp.Oper.Rules[1].RuleID != "2" {
+		t.Fatalf("Unexpected rule stats in policy inspect: %+v", insp.Oper)
+	}
+
+	// verify rule inspect
+	ruleInsp, err := contivClient.RuleInspect("default", "policy1", "2")
+	if err != nil {
+		t.Fatalf("Error inspecting rule. Err: %v", err)
+	}
+	if ruleInsp.Oper.RuleID != "2" {
+		t.Fatalf("Unexpected rule stats in rule inspect: %+v", ruleInsp.Oper)
+	}
+
+	checkDeleteEpg(t, false, "default", "contiv", "group1")
+	checkDeleteRule(t, false, "default", "policy1", "1")
+	checkDeleteRule(t, false, "default", "policy1", "2")
+	checkDeletePolicy(t, false, "default", "policy1")
+	checkDeleteNetwork(t, false, "default", "contiv")
+}
+
+// checkDryRun requests the change plan of a create, or a delete when obj is nil
+func checkDryRun(t *testing.T, expStatus int, objType, key string, obj interface{}) *ChangePlan {
+	method := "DELETE"
+	body := []byte{}
+	if obj != nil {
+		method = "POST"
+		body, _ = json.Marshal(obj)
+	}
+
+	url := netmasterTestURL + "/api/v1/" + objType + "/" + key + "/?dryrun=true"
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error creating dry-run request. Err: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending dry-run request. Err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	content, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != expStatus {
+		t.Fatalf("Dry-run of %s %s returned status %d, expected %d. %s", objType, key,
+			resp.StatusCode, expStatus, content)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	plan := &ChangePlan{}
+	if err := json.Unmarshal(content, plan); err != nil {
+		t.Fatalf("Error decoding change plan %s. Err: %v", content, err)
+	}
+
+	return plan
+}
+
+// TestDryRun tests change plans for network, epg and rule changes
+func TestDryRun(t *testing.T) {
+	// network create allocates the next vlan without writing anything
+	plan := checkDryRun(t, http.StatusOK, "networks", "default:contiv", &client.Network{
+		TenantName:  "default",
+		NetworkName: "contiv",
+		NwType:      "data",
+		Encap:       "vlan",
+		Subnet:      "10.1.1.1/16",
+		Gateway:     "10.1.1.254",
+		PktTag:      10,
+	})
+	if plan.Operation != "create" || plan.PktTagType != "vlan" || plan.PktTag != 10 {
+		t.Fatalf("Unexpected network change plan: %+v", plan)
+	}
+	if contivModel.FindNetwork("default:contiv") != nil {
+		t.Fatalf("Dry-run created network default:contiv")
+	}
+	checkInspectGlobal(t, false, "", "")
+
+	// invalid gateway and overlapping subnets are rejected
+	checkDryRun(t, http.StatusBadRequest, "networks", "default:contiv", &client.Network{
+		TenantName:  "default",
+		NetworkName: "contiv",
+		NwType:      "data",
+		Encap:       "vlan",
+		Subnet:      "10.1.1.1/24",
+		Gateway:     "10.1.2.254",
+	})
+	checkCreateNetwork(t, false, "default", "contiv", "data", "vlan", "10.1.1.1/16", "10.1.1.254", 10, "", "")
+	checkDryRun(t, http.StatusBadRequest, "networks", "default:contiv2", &client.Network{
+		TenantName:  "default",
+		NetworkName: "contiv2",
+		NwType:      "data",
+		Encap:       "vlan",
+		Subnet:      "10.1.2.1/24",
+	})
+
+	// epg create lists the ofnet rules of its policies
+	checkCreatePolicy(t, false, "default", "policy1")
+	checkCreateRule(t, false, "default", "policy1", "1", "in", "", "", "", "", "", "", "tcp", "allow", 1, 80)
+	plan = checkDryRun(t, http.StatusOK, "endpointGroups", "default:group1", &client.EndpointGroup{
+		TenantName:  "default",
+		NetworkName: "contiv",
+		GroupName:   "group1",
+		Policies:    []string{"policy1"},
+	})
+	expRules := []string{
+		"default:group1:default:policy1:default:policy1:1:inRx",
+		"default:group1:default:policy1:default:policy1:1:inTx",
+	}
+	if plan.Operation != "create" || !reflect.DeepEqual(plan.OfnetRulesAdded, expRules) {
+		t.Fatalf("Unexpected epg change plan: %+v", plan)
+	}
+	if contivModel.FindEndpointGroup("default:group1") != nil {
+		t.Fatalf("Dry-run created endpoint group default:group1")
+	}
+	checkDryRun(t, http.StatusBadRequest, "endpointGroups", "default:group1", &client.EndpointGroup{
+		TenantName:  "default",
+		NetworkName: "contiv",
+		GroupName:   "group1",
+		Policies:    []string{"invalid"},
+	})
+
+	// rule create and update list the ofnet rules added and removed
+	checkCreateEpg(t, false, "default", "contiv", "group1", []string{"policy1"}, []string{})
+	rule := &client.Rule{
+		TenantName: "default",
+		PolicyName: "policy1",
+		RuleID:     "2",
+		Direction:  "in",
+		Protocol:   "icmp",
+		Action:     "deny",
+		Priority:   1,
+	}
+	plan = checkDryRun(t, http.StatusOK, "rules", "default:policy1:2", rule)
+	if plan.Operation != "create" || !reflect.DeepEqual(plan.EndpointGroups, []string{"default:group1"}) ||
+		!reflect.DeepEqual(plan.OfnetRulesAdded, []string{"default:group1:default:policy1:default:policy1:2:inRx"}) {
+		t.Fatalf("Unexpected rule change plan: %+v", plan)
+	}
+	if contivModel.FindRule("default:policy1:2") != nil {
+		t.Fatalf("Dry-run created rule default:policy1:2")
+	}
+
+	rule.RuleID = "1"
+	rule.Protocol = "tcp"
+	rule.Port = 8080
+	plan = checkDryRun(t, http.StatusOK, "rules", "default:policy1:1", rule)
+	expAdded := []string{
+		"default:group1:default:policy1:default:policy1:1:inRx:1",
+		"default:group1:default:policy1:default:policy1:1:inTx:1",
+	}
+	if plan.Operation != "update" || !reflect.DeepEqual(plan.OfnetRulesAdded, expAdded) ||
+		!reflect.DeepEqual(plan.OfnetRulesDeleted, expRules) {
+		t.Fatalf("Unexpected rule change plan: %+v", plan)
+	}
+	verifyRuleUpdate(t, "default", "group1", "policy1", "1", "allow", 1, 0)
+
+	rule.ToEndpointGroup = "invalid"
+	checkDryRun(t, http.StatusBadRequest, "rules", "default:policy1:1", rule)
+
+	// deletes list what would be removed and run the same checks
+	plan = checkDryRun(t, http.StatusOK, "rules", "default:policy1:1", nil)
+	if plan.Operation != "delete" || !reflect.DeepEqual(plan.OfnetRulesDeleted, expRules) {
+		t.Fatalf("Unexpected rule change plan: %+v", plan)
+	}
+	plan = checkDryRun(t, http.StatusOK, "endpointGroups", "default:group1", nil)
+	if plan.Operation != "delete" || !reflect.DeepEqual(plan.OfnetRulesDeleted, expRules) {
+		t.Fatalf("Unexpected epg change plan: %+v", plan)
+	}
+	checkDryRun(t, http.StatusBadRequest, "networks", "default:contiv", nil)
+	checkDryRun(t, http.StatusBadRequest, "tenants", "default", nil)
+	checkDryRun(t, http.StatusNotFound, "rules", "default:policy1:3", nil)
+
+	checkDeleteEpg(t, false, "default", "contiv", "group1")
+	plan = checkDryRun(t, http.StatusOK, "networks", "default:contiv", nil)
+	if plan.Operation != "delete" || plan.PktTagType != "vlan" || plan.PktTag != 10 {
+		t.Fatalf("Unexpected network change plan: %+v", plan)
+	}
+	checkDeleteRule(t, false, "default", "policy1", "1")
+	checkDeletePolicy(t, false, "default", "policy1")
+	checkDeleteNetwork(t, false, "default", "contiv")
+}
+
+// checkApply posts a desired state to the apply or diff route, or a cluster
+// config to the import route
+func checkApply(t *testing.T, expError bool, route string, desired interface{}) *ApplyResult {
+	body, _ := json.Marshal(desired)
+	resp, err := http.Post(netmasterTestURL+route, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error posting desired state. Err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	content, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		if !expError {
+			t.Fatalf("Posting desired state failed. Status: %d, %s", resp.StatusCode, content)
+		}
+		return nil
+	} else if expError {
+		t.Fatalf("Posting desired state succeeded while expecting error")
+	}
+
+	result := &ApplyResult{}
+	if err := json.Unmarshal(content, result); err != nil {
+		t.Fatalf("Error decoding apply result %s. Err: %v", content, err)
+	}
+
+	return result
+}
+
+// verifyChanges checks the changes of an apply result
+func verifyChanges(t *testing.T, result *ApplyResult, expChanges []string) {
+	changes := []string{}
+	for _, change := range result.Changes {
+		changes = append(changes, change.Operation+" "+change.ObjectType+" "+change.ObjectKey)
+	}
+	if !reflect.DeepEqual(changes, expChanges) {
+		t.Fatalf("Unexpected changes. Expected: %v, Got: %v", expChanges, changes)
+	}
+}
+
+// TestApplyDesiredState tests declarative configuration of a tenant
+func TestApplyDesiredState(t *testing.T) {
+	desired := &DesiredState{
+		Tenants: []contivModel.Tenant{{TenantName: "tenant1"}},
+		Networks: []contivModel.Network{{
+			TenantName:  "tenant1",
+			NetworkName: "net1",
+			Encap:       "vxlan",
+			Subnet:      "10.1.1.1/24",
+			Gateway:     "10.1.1.254",
+		}},
+		Policies: []contivModel.Policy{{TenantName: "tenant1", PolicyName: "policy1"}},
+		Rules: []contivModel.Rule{{
+			TenantName: "tenant1",
+			PolicyName: "policy1",
+			RuleID:     "1",
+			Direction:  "in",
+			Protocol:   "tcp",
+			Port:       80,
+			Priority:   1,
+			Action:     "allow",
+		}, {
+			TenantName:        "tenant1",
+			PolicyName:        "policy1",
+			RuleID:            "2",
+			Direction:         "in",
+			Protocol:          "tcp",
+			Port:              8080,
+			FromEndpointGroup: "group1",
+			Priority:          1,
+			Action:            "allow",
+		}},
+		EndpointGroups: []contivModel.EndpointGroup{{
+			TenantName:  "tenant1",
+			NetworkName: "net1",
+			GroupName:   "group1",
+			Policies:    []string{"policy1"},
+		}},
+	}
+	allChanges := []string{
+		"create tenants tenant1",
+		"create networks tenant1:net1",
+		"create policys tenant1:policy1",
+		"create endpointGroups tenant1:group1",
+		"create rules tenant1:policy1:1",
+		"create rules tenant1:policy1:2",
+	}
+
+	// diff does not change anything
+	result := checkApply(t, false, DiffRoute, desired)
+	verifyChanges(t, result, allChanges)
+	if contivModel.FindTenant("tenant1") != nil {
+		t.Fatalf("Diff created tenant tenant1")
+	}
+
+	// apply creates everything in dependency order
+	result = checkApply(t, false, ApplyRoute, desired)
+	verifyChanges(t, result, allChanges)
+	if result.Applied != len(allChanges) || result.Error != "" {
+		t.Fatalf("Unexpected apply result: %+v", result)
+	}
+	verifyEpgPolicy(t, "tenant1", "net1", "group1", "policy1")
+
+	// applying the same state again is a no-op
+	result = checkApply(t, false, ApplyRoute, desired)
+	verifyChanges(t, result, []string{})
+
+	// update a rule and remove the epg and policy
+	desired.Rules[0].Action = "deny"
+	desired.Rules = desired.Rules[:1]
+	desired.EndpointGroups = nil
+	result = checkApply(t, false, DiffRoute, desired)
+	verifyChanges(t, result, []string{
+		"update rules tenant1:policy1:1",
+		"delete rules tenant1:policy1:2",
+		"delete endpointGroups tenant1:group1",
+	})
+	desired.Policies = nil
+	desired.Rules = nil
+	result = checkApply(t, false, ApplyRoute, desired)
+	verifyChanges(t, result, []string{
+		"delete rules tenant1:policy1:2",
+		"delete rules tenant1:policy1:1",
+		"delete endpointGroups tenant1:group1",
+		"delete policys tenant1:policy1",
+	})
+	if contivModel.FindPolicy("tenant1:policy1") != nil || contivModel.FindEndpointGroup("tenant1:group1") != nil {
+		t.Fatalf("Apply did not delete the epg and policy")
+	}
+
+	// apply stops at the first failure
+	desired.Networks = append(desired.Networks, contivModel.Network{
+		TenantName:  "tenant1",
+		NetworkName: "net2",
+		Encap:       "vxlan",
+		Subnet:      "10.1.1.1/16",
+	}, contivModel.Network{
+		TenantName:  "tenant1",
+		NetworkName: "net3",
+		Encap:       "vxlan",
+		Subnet:      "10.3.1.1/24",
+	})
+	result = checkApply(t, false, ApplyRoute, desired)
+	if result.Applied != 0 || result.Error == "" || len(result.Changes) != 2 {
+		t.Fatalf("Unexpected apply result: %+v", result)
+	}
+	if contivModel.FindNetwork("tenant1:net3") != nil {
+		t.Fatalf("Apply continued after a failure")
+	}
+
+	// objects of tenants outside the desired state are rejected
+	desired.Networks = []contivModel.Network{{TenantName: "default", NetworkName: "net1", Subnet: "10.1.1.1/24"}}
+	checkApply(t, true, ApplyRoute, desired)
+
+	// remove everything but the tenant
+	desired.Networks = nil
+	result = checkApply(t, false, ApplyRoute, desired)
+	verifyChanges(t, result, []string{"delete networks tenant1:net1"})
+	checkDeleteTenant(t, false, "tenant1")
+}
+
+// checkExport gets the cluster config from the export route
+func checkExport(t *testing.T) *ClusterConfig {
+	resp, err := http.Get(netmasterTestURL + ExportRoute)
+	if err != nil {
+		t.Fatalf("Error exporting cluster config. Err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	content, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Exporting cluster config failed. Status: %d, %s", resp.StatusCode, content)
+	}
+
+	config := &ClusterConfig{}
+	if err := json.Unmarshal(content, config); err != nil {
+		t.Fatalf("Error decoding cluster config %s. Err: %v", content, err)
+	}
+
+	return config
+}
+
+// TestExportImport tests exporting the cluster config and importing it
+// into an empty cluster
+func TestExportImport(t *testing.T) {
+	checkCreateTenant(t, false, "tenant1")
+	checkCreateNetwork(t, false, "tenant1", "net1", "data", "vxlan", "10.1.1.1/24", "10.1.1.254", 0, "", "")
+	checkCreatePolicy(t, false, "tenant1", "policy1")
+	checkCreateRule(t, false, "tenant1", "policy1", "1", "in", "", "", "", "", "", "", "tcp", "allow", 1, 80)
+	checkCreateEpg(t, false, "tenant1", "net1", "group1", []string{"policy1"}, []string{})
+	checkCreateRule(t, false, "tenant1", "policy1", "2", "in", "", "group1", "", "", "", "", "tcp", "allow", 1, 8080)
+	checkServiceCreate(t, "tenant1", "net1", "svc1", []string{"80:8080:TCP"}, []string{"app=web"}, "")
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateStore
+	if err := nwCfg.Read("net1.tenant1"); err != nil {
+		t.Fatalf("Error reading network state. Err: %v", err)
+	}
+	serviceIP := mastercfg.ServiceLBDb["svc1:tenant1"].IPAddress
+
+	config := checkExport(t)
+	if config.Version != ExportVersion || len(config.Global) != 1 ||
+		len(config.Networks) != 1 || config.Networks[0].Key != "tenant1:net1" ||
+		len(config.Rules) != 2 || len(config.EndpointGroups) != 1 || len(config.ServiceLBs) != 1 {
+		t.Fatalf("Unexpected cluster config: %+v", config)
+	}
+	if config.Allocations.PktTags["tenant1:net1"] != nwCfg.ExtPktTag ||
+		config.Allocations.ServiceIPs["tenant1:svc1"] != serviceIP {
+		t.Fatalf("Unexpected allocations: %+v", config.Allocations)
+	}
+
+	// import requires an empty cluster
+	checkApply(t, true, ImportRoute, config)
+
+	checkServiceDelete(t, "tenant1", "svc1")
+	checkDeleteRule(t, false, "tenant1", "policy1", "2")
+	checkDeleteEpg(t, false, "tenant1", "net1", "group1")
+	checkDeleteRule(t, false, "tenant1", "policy1", "1")
+	checkDeletePolicy(t, false, "tenant1", "policy1")
+	checkDeleteNetwork(t, false, "tenant1", "net1")
+	checkDeleteTenant(t, false, "tenant1")
+
+	// documents of other versions are rejected
+	config.Version = ExportVersion + 1
+	checkApply(t, true, ImportRoute, config)
+	config.Version = ExportVersion
+
+	// import recreates the objects and keeps their allocations
+	result := checkApply(t, false, ImportRoute+"?keepAllocations=true", config)
+	verifyChanges(t, result, []string{
+		"create tenants tenant1",
+		"create networks tenant1:net1",
+		"create policys tenant1:policy1",
+		"create endpointGroups tenant1:group1",
+		"create rules tenant1:policy1:1",
+		"create rules tenant1:policy1:2",
+		"create serviceLBs tenant1:svc1",
+	})
+	if result.Applied != len(result.Changes) || result.Error != "" {
+		t.Fatalf("Unexpected import result: %+v", result)
+	}
+	verifyNetworkState(t, "tenant1", "net1", "data", "vxlan", "10.1.1.1", "10.1.1.254", 24,
+		0, nwCfg.ExtPktTag, "", "", 0)
+	verifyEpgPolicy(t, "tenant1", "net1", "group1", "policy1")
+	verifyServiceCreate(t, "tenant1", "net1", "svc1", []string{"80:8080:TCP"}, []string{"app=web"}, serviceIP)
+
+	checkServiceDelete(t, "tenant1", "svc1")
+	checkDeleteRule(t, false, "tenant1", "policy1", "2")
+	checkDeleteEpg(t, false, "tenant1", "net1", "group1")
+	checkDeleteRule(t, false, "tenant1", "policy1", "1")
+	checkDeletePolicy(t, false, "tenant1", "policy1")
+	checkDeleteNetwork(t, false, "tenant1", "net1")
+	checkDeleteTenant(t, false, "tenant1")
+}
+
+// checkAuditLog gets the audit log entries of a tenant and object
+func checkAuditLog(t *testing.T, tenant, object string) []*mastercfg.CfgAuditEntry {
+	url := fmt.Sprintf("%s%s?tenant=%s&object=%s", netmasterTestURL, AuditRoute, tenant, object)
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Error reading audit log. Err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	content, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Reading audit log failed. Status: %d, %s", resp.StatusCode, content)
+	}
+
+	entries := []*mastercfg.CfgAuditEntry{}
+	if err := json.Unmarshal(content, &entries); err != nil {
+		t.Fatalf("Error decoding audit log %s. Err: %v", content, err)
+	}
+
+	return entries
+}
+
+// TestAuditLog tests recording configuration changes in the audit log
+func TestAuditLog(t *testing.T) {
+	checkCreatePolicy(t, false, "default", "auditPolicy")
+	checkCreateRule(t, false, "default", "auditPolicy", "1", "in", "", "", "", "", "", "", "tcp", "allow", 1, 80)
+	checkCreateRule(t, false, "default", "auditPolicy", "1", "in", "", "", "", "", "", "", "tcp", "deny", 1, 80)
+	checkDeleteRule(t, false, "default", "auditPolicy", "1")
+	checkDeletePolicy(t, false, "default", "auditPolicy")
+
+	// failed changes are not recorded
+	checkCreateRule(t, true, "default", "auditPolicy", "1", "in", "", "", "", "", "", "", "tcp", "allow", 1, 80)
+
+	entries := checkAuditLog(t, "default", "default:auditPolicy:1")
+	operations := []string{}
+	for _, entry := range entries {
+		if entry.ObjectType != "rules" || entry.Client == "" || entry.Tenant != "default" {
+			t.Fatalf("Unexpected audit entry: %+v", entry)
+		}
+		operations = append(operations, entry.Operation)
+	}
+	if !reflect.DeepEqual(operations, []string{"create", "update", "delete"}) {
+		t.Fatalf("Unexpected audit operations: %v", operations)
+	}
+
+	var before, after contivModel.Rule
+	if err := json.Unmarshal(entries[1].Before, &before); err != nil {
+		t.Fatalf("Error decoding audit entry before. Err: %v", err)
+	}
+	if err := json.Unmarshal(entries[1].After, &after); err != nil {
+		t.Fatalf("Error decoding audit entry after. Err: %v", err)
+	}
+	if before.Action != "allow" || after.Action != "deny" || entries[2].After != nil {
+		t.Fatalf("Unexpected audit entries: %+v", entries)
+	}
+
+	// changes made by apply are recorded as well
+	desired := &DesiredState{Tenants: []contivModel.Tenant{{TenantName: "auditTenant"}}}
+	checkApply(t, false, ApplyRoute, desired)
+	entries = checkAuditLog(t, "auditTenant", "tenants")
+	if len(entries) != 1 || entries[0].Operation != "create" || entries[0].ObjectKey != "auditTenant" {
+		t.Fatalf("Unexpected audit entries: %+v", entries)
+	}
+	checkDeleteTenant(t, false, "auditTenant")
+	entries = checkAuditLog(t, "auditTenant", "")
+	if len(entries) != 2 || entries[1].Operation != "delete" {
+		t.Fatalf("Unexpected audit entries: %+v", entries)
+	}
+}
+
+// checkObjectVersion reads an object and returns its resource version
+func checkObjectVersion(t *testing.T, objType, key string) string {
+	resp, err := http.Get(fmt.Sprintf("%s/api/v1/%s/%s/", netmasterTestURL, objType, key))
+	if err != nil {
+		t.Fatalf("Error reading %s %s. Err: %v", objType, key, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Reading %s %s failed. Status: %d", objType, key, resp.StatusCode)
+	}
+
+	return resp.Header.Get("ETag")
+}
+
+// checkVersionedUpdate posts an object with an expected resource version
+// and returns the response status
+func checkVersionedUpdate(t *testing.T, objType, key, version string, obj interface{}) int {
+	body, _ := json.Marshal(obj)
+	url := fmt.Sprintf("%s/api/v1/%s/%s/", netmasterTestURL, objType, key)
+	req, _ := http.NewRequest("PUT", url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", version)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error updating %s %s. Err: %v", objType, key, err)
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+// TestResourceVersions tests rejecting updates with stale resource versions
+func TestResourceVersions(t *testing.T) {
+	checkCreateNetwork(t, false, "default", "vnet", "", "vxlan", "10.1.1.1/24", "10.1.1.254", 1, "", "")
+	checkCreatePolicy(t, false, "default", "vpolicy1")
+	checkCreatePolicy(t, false, "default", "vpolicy2")
+	checkCreateEpg(t, false, "default", "vnet", "vgroup", []string{}, []string{})
+
+	version := checkObjectVersion(t, "endpointGroups", "default:vgroup")
+	if version == "" {
+		t.Fatalf("Endpoint group has no resource version")
+	}
+
+	epg := &client.EndpointGroup{
+		TenantName:  "default",
+		NetworkName: "vnet",
+		GroupName:   "vgroup",
+		Policies:    []string{"vpolicy1"},
+	}
+	if status := checkVersionedUpdate(t, "endpointGroups", "default:vgroup", version, epg); status != http.StatusOK {
+		t.Fatalf("Update with current version failed. Status: %d", status)
+	}
+
+	newVersion := checkObjectVersion(t, "endpointGroups", "default:vgroup")
+	if newVersion == "" || newVersion == version {
+		t.Fatalf("Resource version %s was not updated from %s", newVersion, version)
+	}
+
+	// an update based on the old version is rejected
+	epg.Policies = []string{"vpolicy2"}
+	if status := checkVersionedUpdate(t, "endpointGroups", "default:vgroup", version, epg); status != http.StatusConflict {
+		t.Fatalf("Update with stale version returned status %d", status)
+	}
+	verifyEpgPolicy(t, "default", "vnet", "vgroup", "vpolicy1")
+	checkEpgPolicyDeleted(t, "default", "vnet", "vgroup", "vpolicy2")
+
+	// updates without a version are not checked
+	checkCreateEpg(t, false, "default", "vnet", "vgroup", []string{}, []string{})
+
+	checkDeleteEpg(t, false, "default", "vnet", "vgroup")
+	checkDeletePolicy(t, false, "default", "vpolicy1")
+	checkDeletePolicy(t, false, "default", "vpolicy2")
+	checkDeleteNetwork(t, false, "default", "vnet")
+}
+
 // TestEpgPolicies tests attaching policy to EPG
 func TestEpgPolicies(t *testing.T) {
 	// create network
@@ -1938,6 +2813,30 @@ func TestServiceProviderUpdateServiceAdd(t *testing.T) {
 	deleteNetwork(t, "yellow", "default")
 }
 
+func TestServiceSelectorParse(t *testing.T) {
+	for selector, expExpr := range map[string]*intent.SelectorExpr{
+		"key1=value1":             nil,
+		"key1 in (value1,value2)": {Key: "key1", Operator: intent.SelectorOpIn, Values: []string{"value1", "value2"}},
+		"key1 notin (value1)":     {Key: "key1", Operator: intent.SelectorOpNotIn, Values: []string{"value1"}},
+		"key1 exists":             {Key: "key1", Operator: intent.SelectorOpExists},
+		"key1 !exists":            {Key: "key1", Operator: intent.SelectorOpNotExists},
+		"key1":                    {Key: "key1", Operator: intent.SelectorOpExists},
+		"!key1":                   {Key: "key1", Operator: intent.SelectorOpNotExists},
+	} {
+		expr, ok := validateSelectors(selector)
+		if !ok || !reflect.DeepEqual(expr, expExpr) {
+			t.Fatalf("selector %q parsed as %+v, expected %+v", selector, expr, expExpr)
+		}
+	}
+
+	for _, selector := range []string{"", "key1=value1=value2", "=value1", "key1 in value1", "key1 in ()",
+		"key1 in (value1,)", "key1 exists value1", "key1 like (value1)", "key!1 exists"} {
+		if _, ok := validateSelectors(selector); ok {
+			t.Fatalf("invalid selector %q was accepted", selector)
+		}
+	}
+}
+
 func TestServicePreferredIP(t *testing.T) {
 
 	labels := []string{"key1=value1", "key2=value2"}
@@ -2270,6 +3169,150 @@ func TestEPCreate(t *testing.T) {
 	checkInspectNetwork(t, false, "teatwo", "t2-net", "60.1.1.1-60.1.1.3, 60.1.1.254", 1, 3)
 }
 
+// checkCreateEpgIPv6Pool creates an EPG with an IPv6 pool
+func checkCreateEpgIPv6Pool(t *testing.T, expError bool, tenant, network, group, ipv6Pool string) {
+	epg := client.EndpointGroup{
+		TenantName:  tenant,
+		NetworkName: network,
+		GroupName:   group,
+		Ipv6Pool:    ipv6Pool,
+	}
+	err := contivClient.EndpointGroupPost(&epg)
+	if err != nil && !expError {
+		t.Fatalf("Error creating epg {%+v}. Err: %v", epg, err)
+	} else if err == nil && expError {
+		t.Fatalf("Create epg {%+v} succeeded while expecting error", epg)
+	}
+}
+
+// verifyEPIPv6Address verifies the IPv6 address allocated to an endpoint
+func verifyEPIPv6Address(t *testing.T, tenant, network, endpointID, ipv6Addr string) {
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = stateStore
+	err := epCfg.Read(network + "." + tenant + "-" + endpointID)
+	if err != nil {
+		t.Fatalf("Endpoint state for %s not found. Err: %v", endpointID, err)
+	}
+	if epCfg.IPv6Address != ipv6Addr {
+		t.Fatalf("Endpoint %s got IPv6 address %s, expected %s", endpointID, epCfg.IPv6Address, ipv6Addr)
+	}
+}
+
+func TestEpgIPv6Pool(t *testing.T) {
+	checkCreateTenant(t, false, "teathree")
+	checkCreateNetwork(t, false, "teathree", "t3-net", "data", "vxlan",
+		"70.1.1.1/24", "70.1.1.254", 1, "2016:0617::/120", "2016:0617::1")
+
+	// pools must be within the subnet and must not overlap
+	checkCreateEpgIPv6Pool(t, true, "teathree", "t3-net", "t3-epgA", "2016:0618::10-2016:0618::1f")
+	checkCreateEpgIPv6Pool(t, true, "teathree", "t3-net", "t3-epgA", "2016:0617::1-2016:0617::1f")
+	checkCreateEpgIPv6Pool(t, false, "teathree", "t3-net", "t3-epgA", "2016:0617::10-2016:0617::1f")
+	checkCreateEpgIPv6Pool(t, true, "teathree", "t3-net", "t3-epgB", "2016:0617::1f-2016:0617::2f")
+	checkCreateEpgIPv6Pool(t, false, "teathree", "t3-net", "t3-epgB", "2016:0617::20-2016:0617::2f")
+
+	// the pool of a group can't be changed
+	checkCreateEpgIPv6Pool(t, true, "teathree", "t3-net", "t3-epgA", "2016:0617::30-2016:0617::3f")
+
+	// endpoints get addresses from the pool of their group, others from the
+	// rest of the subnet
+	checkCreateEpg(t, false, "teathree", "t3-net", "t3-epgC", []string{}, []string{})
+	for _, ep := range []struct{ epg, id, ipv6Addr string }{
+		{"t3-epgA", "c3001", "2016:617::10"},
+		{"t3-epgB", "c3002", "2016:617::20"},
+		{"t3-epgA", "c3003", "2016:617::11"},
+		{"t3-epgC", "c3004", "2016:617::2"},
+	} {
+		if err := AddEP("teathree", "t3-net", ep.epg, ep.id); err != nil {
+			t.Fatalf("Error creating ep %s. Err: %v", ep.id, err)
+		}
+		verifyEPIPv6Address(t, "teathree", "t3-net", ep.id, ep.ipv6Addr)
+	}
+}
+
+// checkCreateIPReservation creates an ip reservation
+func checkCreateIPReservation(t *testing.T, expError bool, resv *mastercfg.CfgIPReservationState) {
+	body, _ := json.Marshal(resv)
+	resp, err := http.Post(netmasterTestURL+IPReservationRoute, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error creating ip reservation. Err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	content, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK && !expError {
+		t.Fatalf("Creating ip reservation %+v failed. Status: %d, %s", resv, resp.StatusCode, content)
+	} else if resp.StatusCode == http.StatusOK && expError {
+		t.Fatalf("Creating ip reservation %+v succeeded while expecting error", resv)
+	}
+}
+
+// checkDeleteIPReservation deletes an ip reservation
+func checkDeleteIPReservation(t *testing.T, expError bool, key string) {
+	req, _ := http.NewRequest("DELETE", netmasterTestURL+IPReservationRoute+key+"/", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error deleting ip reservation. Err: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && !expError {
+		t.Fatalf("Deleting ip reservation %s failed. Status: %d", key, resp.StatusCode)
+	} else if resp.StatusCode == http.StatusOK && expError {
+		t.Fatalf("Deleting ip reservation %s succeeded while expecting error", key)
+	}
+}
+
+// checkListIPReservations lists the ip reservations of a network
+func checkListIPReservations(t *testing.T, tenant, network string, expAddrs []string) {
+	url := fmt.Sprintf("%s%s?tenant=%s&network=%s", netmasterTestURL, IPReservationRoute, tenant, network)
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Error listing ip reservations. Err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	resvList := []*mastercfg.CfgIPReservationState{}
+	content, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(content, &resvList); err != nil {
+		t.Fatalf("Error decoding ip reservations %s. Err: %v", content, err)
+	}
+
+	addrs := []string{}
+	for _, resv := range resvList {
+		addrs = append(addrs, resv.IPAddress)
+	}
+	if !reflect.DeepEqual(addrs, expAddrs) {
+		t.Fatalf("Unexpected reserved addresses %v, expected %v", addrs, expAddrs)
+	}
+}
+
+// TestIPReservations tests creating and deleting ip reservations
+func TestIPReservations(t *testing.T) {
+	checkCreateNetwork(t, false, "default", "resv-net", "data", "vxlan", "80.1.1.1/24", "80.1.1.254", 1, "", "")
+
+	checkCreateIPReservation(t, false, &mastercfg.CfgIPReservationState{
+		Tenant: "default", Network: "resv-net", IPAddress: "80.1.1.10", ContainerName: "db"})
+	checkCreateIPReservation(t, false, &mastercfg.CfgIPReservationState{
+		Tenant: "default", Network: "resv-net", IPAddress: "80.1.1.11", Labels: map[string]string{"app": "web"}})
+	checkCreateIPReservation(t, true, &mastercfg.CfgIPReservationState{
+		Tenant: "default", Network: "resv-net", IPAddress: "80.1.1.254", ContainerName: "gw"})
+	checkCreateIPReservation(t, true, &mastercfg.CfgIPReservationState{
+		Tenant: "default", Network: "resv-net", IPAddress: "80.1.1.12"})
+	checkListIPReservations(t, "default", "resv-net", []string{"80.1.1.10", "80.1.1.11"})
+
+	// reserved addresses are allocated in the network
+	checkInspectNetwork(t, false, "default", "resv-net", "80.1.1.10-80.1.1.11, 80.1.1.254", 1, 0)
+
+	// networks with reservations can't be deleted
+	checkDeleteNetwork(t, true, "default", "resv-net")
+	checkDeleteIPReservation(t, false, "default:resv-net:80.1.1.10")
+	checkDeleteIPReservation(t, false, "default:resv-net:80.1.1.11")
+	checkDeleteIPReservation(t, true, "default:resv-net:80.1.1.11")
+	checkListIPReservations(t, "default", "resv-net", []string{})
+	checkInspectNetwork(t, false, "default", "resv-net", "80.1.1.254", 1, 0)
+	checkDeleteNetwork(t, false, "default", "resv-net")
+}
+
 // TestClusterMode verifies cluster mode is correctly reflected.
 func TestClusterMode(t *testing.T) {
 
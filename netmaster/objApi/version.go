@@ -0,0 +1,239 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objApi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/contivmodel"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+)
+
+// objectMutex serializes object changes so that version checks and the
+// changes they guard are atomic
+var objectMutex sync.Mutex
+
+// versionConflict is returned when a change presents a stale version
+type versionConflict struct {
+	objType  string
+	key      string
+	expected string
+	current  string
+}
+
+func (err *versionConflict) Error() string {
+	if err.current == "" {
+		return fmt.Sprintf("%s %s has no version, expected %s", err.objType, err.key, err.expected)
+	}
+	return fmt.Sprintf("%s %s has version %s, expected %s", err.objType, err.key, err.current, err.expected)
+}
+
+// statusRecorder remembers the status code written by an http handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code and writes it to the response
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// ObjectHandler wraps the netmaster REST handler to check the versions of
+// the objects of the object model and record their changes in the audit log.
+// Object reads return the resource version in the ETag header. Changes that
+// carry an If-Match header with a stale version fail with 409, and requests
+// fail with 500 if the version can't be read. Dry-run changes are answered
+// with their change plan.
+func ObjectHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		objType, key, ok := objectRoute(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// dry-runs don't change the object
+		if isDryRun(r) {
+			serveDryRun(w, r, objType, key)
+			return
+		}
+
+		// the object model fails every rejected change with 500, so invalid
+		// rules are rejected here
+		if objType == "rules" && r.Method != "GET" && r.Method != "DELETE" {
+			if err := checkRuleRequest(r, key); err != nil {
+				log.Errorf("Handler for %s %s returned error: %s", r.Method, r.URL, err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if r.Method == "GET" {
+			version, err := objectVersion(objType, key)
+			if err != nil {
+				log.Errorf("Handler for %s %s returned error: %s", r.Method, r.URL, err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if version != "" {
+				w.Header().Set("ETag", version)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// once called, the wrapped handler writes its own response
+		served := false
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		err := changeObject(clientAddress(r), objType, key, r.Method == "DELETE", r.Header.Get("If-Match"),
+			func() error {
+				served = true
+				next.ServeHTTP(rec, r)
+				if rec.status != http.StatusOK {
+					return core.Errorf("request failed with status %d", rec.status)
+				}
+				return nil
+			})
+		if err == nil || served {
+			return
+		}
+
+		log.Errorf("Handler for %s %s returned error: %s", r.Method, r.URL, err)
+		if _, ok := err.(*versionConflict); ok {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// checkRuleRequest validates the rule in a create or update request. The
+// request body is restored for the object model.
+func checkRuleRequest(r *http.Request, key string) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var rule contivModel.Rule
+	if err := json.Unmarshal(body, &rule); err != nil {
+		return err
+	}
+	rule.Key = key
+	if err := contivModel.ValidateRule(&rule); err != nil {
+		return err
+	}
+
+	_, err = validateRule(&rule)
+	return err
+}
+
+// objectRoute returns the type and key of the object a request is for, if
+// the object is part of the object model
+func objectRoute(r *http.Request) (string, string, bool) {
+	switch r.Method {
+	case "GET", "POST", "PUT", "DELETE":
+	default:
+		return "", "", false
+	}
+
+	// object routes are of the form /api/v1/<objType>/<key>/
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(path) != 4 || path[0] != "api" || path[1] != "v1" || modelTypes[path[2]] == nil {
+		return "", "", false
+	}
+
+	return path[2], path[3], true
+}
+
+// clientAddress returns the address of the client that sent a request.
+// Requests proxied by a follower carry the client address in the
+// X-Forwarded-For header.
+func clientAddress(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// formatVersion formats a resource version as an ETag value
+func formatVersion(version uint64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// objectVersion returns the resource version of an object as an ETag
+// value, or an empty string if the object does not exist. Objects are
+// stored by the object model under the singular of their route type.
+func objectVersion(objType, key string) (string, error) {
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return "", err
+	}
+
+	version, err := mastercfg.ReadObjectVersion(stateDriver, strings.TrimSuffix(objType, "s"), key)
+	if err != nil {
+		log.Errorf("Error reading version of %s %s. Err: %v", objType, key, err)
+		return "", err
+	}
+	if version == 0 {
+		return "", nil
+	}
+
+	return formatVersion(version), nil
+}
+
+// changeObject makes a change to an object of the object model. If
+// expVersion is set, the change is only made if the object is at that
+// version. Successful changes are recorded in the audit log, writing the
+// object gives it a new version.
+func changeObject(client, objType, key string, remove bool, expVersion string, apply func() error) error {
+	if modelTypes[objType] == nil {
+		return apply()
+	}
+
+	objectMutex.Lock()
+	defer objectMutex.Unlock()
+
+	if expVersion != "" && expVersion != "*" {
+		current, err := objectVersion(objType, key)
+		if err != nil {
+			return err
+		}
+		if current != expVersion {
+			return &versionConflict{objType: objType, key: key, expected: expVersion, current: current}
+		}
+	}
+
+	return auditChange(client, objType, key, remove, apply)
+}
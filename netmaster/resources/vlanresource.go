@@ -39,6 +39,28 @@ const (
 	vLANResourceOperPath         = vLANResourceOperPathPrefix + "%s"
 )
 
+// TagRequest requests a vlan, or a vxlan, from the allowed tags of a
+// resource. A non-zero Tag must be in Allowed. A nil Allowed doesn't restrict
+// the allocation.
+type TagRequest struct {
+	Tag     uint
+	Allowed *bitset.BitSet
+}
+
+// tagRequest converts the value passed to Allocate to a TagRequest
+func tagRequest(reqVal interface{}) (TagRequest, error) {
+	switch req := reqVal.(type) {
+	case nil:
+		return TagRequest{}, nil
+	case uint:
+		return TagRequest{Tag: req}, nil
+	case TagRequest:
+		return req, nil
+	}
+
+	return TagRequest{}, core.Errorf("Invalid type for tag request")
+}
+
 // AutoVLANCfgResource implements the Resource interface for an 'auto-vlan' resource.
 // 'auto-vlan' resource allocates a vlan from a range of vlan encaps specified
 // at time of resource instantiation
@@ -176,24 +198,30 @@ func (r *AutoVLANCfgResource) GetList() (uint, string) {
 	}
 	oper.FreeVLANs.InPlaceSymmetricDifference(cfg.VLANs)
 
-	numVlans := uint(0)
+	return ListTags(oper.FreeVLANs, 0)
+}
+
+// ListTags returns the number of tags set in a bitset and a stringified list
+// of their ranges. start is added to the bit index of each tag.
+func ListTags(tags *bitset.BitSet, start uint) (uint, string) {
+	numTags := uint(0)
 	idx := uint(0)
 	startIdx := idx
 	list := []string{}
 	inRange := false
 
 	for {
-		foundValue, found := oper.FreeVLANs.NextSet(idx)
+		foundValue, found := tags.NextSet(idx)
 		if !found {
 			break
 		}
-		numVlans++
+		numTags++
 
 		if !inRange { // begin of range
 			startIdx = foundValue
 			inRange = true
 		} else if foundValue > idx { // end of range
-			thisRange := rangePrint(startIdx, idx-1)
+			thisRange := rangePrint(startIdx+start, idx-1+start)
 			list = append(list, thisRange)
 			startIdx = foundValue
 		}
@@ -202,38 +230,32 @@ func (r *AutoVLANCfgResource) GetList() (uint, string) {
 
 	// list end with allocated value
 	if inRange {
-		thisRange := rangePrint(startIdx, idx-1)
+		thisRange := rangePrint(startIdx+start, idx-1+start)
 		list = append(list, thisRange)
 	}
 
-	return numVlans, strings.Join(list, ", ")
+	return numTags, strings.Join(list, ", ")
 }
 
 // Allocate a resource.
 func (r *AutoVLANCfgResource) Allocate(reqVal interface{}) (interface{}, error) {
-	oper := &AutoVLANOperResource{}
-	oper.StateDriver = r.StateDriver
-	err := oper.Read(r.ID)
+	req, err := tagRequest(reqVal)
 	if err != nil {
 		return nil, err
 	}
 
 	var vlan uint
-	if (reqVal != nil) && (reqVal.(uint) != 0) {
-		vlan = reqVal.(uint)
-		if !oper.FreeVLANs.Test(vlan) {
-			return nil, fmt.Errorf("requested vlan not available - vlan:%d", vlan)
-		}
-	} else {
-		ok := false
-		vlan, ok = oper.FreeVLANs.NextSet(0)
-		if !ok {
-			return nil, errors.New("no vlans available")
+	oper := &AutoVLANOperResource{}
+	oper.StateDriver = r.StateDriver
+	err = core.UpdateState(oper, r.ID, func() error {
+		var err error
+		vlan, err = oper.NextVLANIn(req.Tag, req.Allowed)
+		if err != nil {
+			return err
 		}
-	}
-	oper.FreeVLANs.Clear(vlan)
-
-	err = oper.Write()
+		oper.FreeVLANs.Clear(vlan)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -242,23 +264,17 @@ func (r *AutoVLANCfgResource) Allocate(reqVal interface{}) (interface{}, error)
 
 // Deallocate the resource.
 func (r *AutoVLANCfgResource) Deallocate(value interface{}) error {
-	oper := &AutoVLANOperResource{}
-	oper.StateDriver = r.StateDriver
-	err := oper.Read(r.ID)
-	if err != nil {
-		return err
-	}
-
 	vlan, ok := value.(uint)
 	if !ok {
 		return core.Errorf("Invalid type for vlan value")
 	}
-	if oper.FreeVLANs.Test(vlan) {
-		return nil
-	}
-	oper.FreeVLANs.Set(vlan)
 
-	return oper.Write()
+	oper := &AutoVLANOperResource{}
+	oper.StateDriver = r.StateDriver
+	return core.UpdateState(oper, r.ID, func() error {
+		oper.FreeVLANs.Set(vlan)
+		return nil
+	})
 }
 
 // AutoVLANOperResource is an implementation of core.State.
@@ -267,6 +283,40 @@ type AutoVLANOperResource struct {
 	FreeVLANs *bitset.BitSet `json:"freeVLANs"`
 }
 
+// NextVLAN returns the vlan an allocation would pick without allocating it.
+// A non-zero reqVlan is returned only if it is available.
+func (r *AutoVLANOperResource) NextVLAN(reqVlan uint) (uint, error) {
+	return r.NextVLANIn(reqVlan, nil)
+}
+
+// NextVLANIn is NextVLAN restricted to the allowed vlans, a nil allowed
+// doesn't restrict it.
+func (r *AutoVLANOperResource) NextVLANIn(reqVlan uint, allowed *bitset.BitSet) (uint, error) {
+	if reqVlan != 0 {
+		if allowed != nil && !allowed.Test(reqVlan) {
+			return 0, fmt.Errorf("requested vlan not in allowed range - vlan:%d", reqVlan)
+		}
+		if !r.FreeVLANs.Test(reqVlan) {
+			return 0, fmt.Errorf("requested vlan not available - vlan:%d", reqVlan)
+		}
+		return reqVlan, nil
+	}
+
+	if allowed != nil {
+		vlan, ok := r.FreeVLANs.Intersection(allowed).NextSet(0)
+		if !ok {
+			return 0, errors.New("no vlans available in allowed range")
+		}
+		return vlan, nil
+	}
+
+	vlan, ok := r.FreeVLANs.NextSet(0)
+	if !ok {
+		return 0, errors.New("no vlans available")
+	}
+	return vlan, nil
+}
+
 // Write the state.
 func (r *AutoVLANOperResource) Write() error {
 	key := fmt.Sprintf(vLANResourceOperPath, r.ID)
@@ -279,6 +329,19 @@ func (r *AutoVLANOperResource) Read(id string) error {
 	return r.StateDriver.ReadState(key, r, json.Unmarshal)
 }
 
+// ReadRevision reads the state for a given identifier along with its revision
+func (r *AutoVLANOperResource) ReadRevision(id string) (uint64, error) {
+	*r = AutoVLANOperResource{CommonState: r.CommonState}
+	key := fmt.Sprintf(vLANResourceOperPath, id)
+	return r.StateDriver.ReadStateRevision(key, r, json.Unmarshal)
+}
+
+// WriteIfRevision writes the state if it is still at the given revision
+func (r *AutoVLANOperResource) WriteIfRevision(revision uint64) error {
+	key := fmt.Sprintf(vLANResourceOperPath, r.ID)
+	return r.StateDriver.WriteStateIfRevision(key, r, json.Marshal, revision)
+}
+
 // ReadAll state for this path.
 func (r *AutoVLANOperResource) ReadAll() ([]core.State, error) {
 	return r.StateDriver.ReadAllState(vLANResourceOperPathPrefix, r,
@@ -21,12 +21,13 @@ import (
 	"testing"
 
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/internal/testutil"
 	"github.com/jainvipin/bitset"
 
 	log "github.com/Sirupsen/logrus"
 )
 
-var vlanRsrcStateDriver = &testVlanRsrcStateDriver{}
+var vlanRsrcStateDriver = testutil.NoRevisions(&testVlanRsrcStateDriver{})
 
 type vlanRsrcValidator struct {
 	// slice (stack) of expected config and oper states.
@@ -465,3 +466,41 @@ func TestAutoVLANCfgResourceGetList(t *testing.T) {
 		t.Fatalf("GetList failure, got %s vlanlist (%d vlans), expected %s", vlansInUse, numVlans, expectedList)
 	}
 }
+
+func TestAutoVLANOperResourceNextVLAN(t *testing.T) {
+	oper := &AutoVLANOperResource{FreeVLANs: bitset.New(10)}
+	oper.FreeVLANs.Set(3).Set(5)
+
+	vlan, err := oper.NextVLAN(0)
+	if err != nil || vlan != 3 {
+		t.Fatalf("Next vlan mismatch. expected: 3, rcvd: %d, err: %v", vlan, err)
+	}
+	vlan, err = oper.NextVLAN(5)
+	if err != nil || vlan != 5 {
+		t.Fatalf("Next vlan mismatch. expected: 5, rcvd: %d, err: %v", vlan, err)
+	}
+	if _, err = oper.NextVLAN(4); err == nil {
+		t.Fatalf("Next vlan succeeded for an allocated vlan")
+	}
+	if oper.FreeVLANs.Count() != 2 {
+		t.Fatalf("Next vlan modified the free vlans: %s", oper.FreeVLANs.DumpAsBits())
+	}
+}
+
+func TestAutoVLANOperResourceNextVLANIn(t *testing.T) {
+	oper := &AutoVLANOperResource{FreeVLANs: bitset.New(10)}
+	oper.FreeVLANs.Set(3).Set(5).Set(7)
+	allowed := bitset.New(10).Set(5).Set(6)
+
+	vlan, err := oper.NextVLANIn(0, allowed)
+	if err != nil || vlan != 5 {
+		t.Fatalf("Next vlan mismatch. expected: 5, rcvd: %d, err: %v", vlan, err)
+	}
+	if _, err = oper.NextVLANIn(3, allowed); err == nil {
+		t.Fatalf("Next vlan succeeded for a vlan outside of the allowed vlans")
+	}
+	oper.FreeVLANs.Clear(5)
+	if _, err = oper.NextVLANIn(0, allowed); err == nil {
+		t.Fatalf("Next vlan succeeded with no free allowed vlans")
+	}
+}
@@ -19,7 +19,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/contiv/netplugin/core"
@@ -45,16 +44,9 @@ const (
 type AutoVXLANCfgResource struct {
 	core.CommonState
 	VXLANs          *bitset.BitSet `json:"vxlans"`
-	LocalVLANs      *bitset.BitSet `json:"LocalVLANs"`
 	FreeVXLANsStart uint           `json:"FreeVXLANsStart"`
 }
 
-// VXLANVLANPair Pairs a VXLAN tag with a VLAN tag.
-type VXLANVLANPair struct {
-	VXLAN uint
-	VLAN  uint
-}
-
 // Write the state.
 func (r *AutoVXLANCfgResource) Write() error {
 	key := fmt.Sprintf(vXLANResourceConfigPath, r.ID)
@@ -86,7 +78,6 @@ func (r *AutoVXLANCfgResource) Init(rsrcCfg interface{}) error {
 		return core.Errorf("Invalid vxlan resource config.")
 	}
 	r.VXLANs = cfg.VXLANs
-	r.LocalVLANs = cfg.LocalVLANs
 	err := r.Write()
 	if err != nil {
 		return err
@@ -97,7 +88,7 @@ func (r *AutoVXLANCfgResource) Init(rsrcCfg interface{}) error {
 		}
 	}()
 
-	oper := &AutoVXLANOperResource{FreeVXLANs: r.VXLANs, FreeLocalVLANs: r.LocalVLANs}
+	oper := &AutoVXLANOperResource{FreeVXLANs: r.VXLANs}
 	oper.StateDriver = r.StateDriver
 	oper.ID = r.ID
 	return oper.Write()
@@ -132,7 +123,6 @@ func (r *AutoVXLANCfgResource) Reinit(rsrcCfg interface{}) error {
 	prevFreeStart := r.FreeVXLANsStart
 
 	r.VXLANs = cfg.VXLANs
-	r.LocalVLANs = cfg.LocalVLANs
 	r.FreeVXLANsStart = cfg.FreeVXLANsStart
 
 	err := r.Write()
@@ -162,8 +152,6 @@ func (r *AutoVXLANCfgResource) Reinit(rsrcCfg interface{}) error {
 		oper.FreeVXLANs.Clear(vxlan - r.FreeVXLANsStart)
 	}
 
-	oper.FreeLocalVLANs = oper.FreeLocalVLANs.Intersection(r.LocalVLANs)
-
 	return oper.Write()
 }
 
@@ -189,103 +177,89 @@ func (r *AutoVXLANCfgResource) GetList() (uint, string) {
 	}
 	oper.FreeVXLANs.InPlaceSymmetricDifference(cfg.VXLANs)
 
-	numVlans := uint(0)
-	idx := uint(0)
-	startIdx := idx
-	list := []string{}
-	inRange := false
-
-	for {
-		foundValue, found := oper.FreeVXLANs.NextSet(idx)
-		if !found {
-			break
-		}
-		numVlans++
-
-		if !inRange { // begin of range
-			startIdx = foundValue
-			inRange = true
-		} else if foundValue > idx { // end of range
-			thisRange := rangePrint(startIdx+cfg.FreeVXLANsStart, idx-1+cfg.FreeVXLANsStart)
-			list = append(list, thisRange)
-			startIdx = foundValue
-		}
-		idx = foundValue + 1
-	}
-
-	// list end with allocated value
-	if inRange {
-		thisRange := rangePrint(startIdx+cfg.FreeVXLANsStart, idx-1+cfg.FreeVXLANsStart)
-		list = append(list, thisRange)
-	}
-
-	return numVlans, strings.Join(list, ", ")
+	return ListTags(oper.FreeVXLANs, cfg.FreeVXLANsStart)
 }
 
 // Allocate allocates a new resource.
 func (r *AutoVXLANCfgResource) Allocate(reqVal interface{}) (interface{}, error) {
-	oper := &AutoVXLANOperResource{}
-	oper.StateDriver = r.StateDriver
-	err := oper.Read(r.ID)
+	req, err := tagRequest(reqVal)
 	if err != nil {
 		return nil, err
 	}
 
 	var vxlan uint
-	if (reqVal != nil) && (reqVal.(uint) != 0) {
-		vxlan = reqVal.(uint)
-		if !oper.FreeVXLANs.Test(vxlan) {
-			return nil, fmt.Errorf("requested vxlan not available")
-		}
-	} else {
-		ok := false
-		vxlan, ok = oper.FreeVXLANs.NextSet(0)
-		if !ok {
-			return nil, errors.New("no vxlans available")
+	oper := &AutoVXLANOperResource{}
+	oper.StateDriver = r.StateDriver
+	err = core.UpdateState(oper, r.ID, func() error {
+		var err error
+		vxlan, err = oper.NextVXLANIn(req.Tag, req.Allowed)
+		if err != nil {
+			return err
 		}
-	}
 
-	vlan, ok := oper.FreeLocalVLANs.NextSet(0)
-	if !ok {
-		return nil, errors.New("no local vlans available")
-	}
-
-	oper.FreeVXLANs.Clear(vxlan)
-	oper.FreeLocalVLANs.Clear(vlan)
-
-	err = oper.Write()
+		oper.FreeVXLANs.Clear(vxlan)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	return VXLANVLANPair{VXLAN: vxlan, VLAN: vlan}, nil
+	return vxlan, nil
 }
 
 // Deallocate removes and cleans up a resource.
 func (r *AutoVXLANCfgResource) Deallocate(value interface{}) error {
-	oper := &AutoVXLANOperResource{}
-	oper.StateDriver = r.StateDriver
-	err := oper.Read(r.ID)
-	if err != nil {
-		return err
-	}
-
-	pair, ok := value.(VXLANVLANPair)
+	vxlan, ok := value.(uint)
 	if !ok {
-		return core.Errorf("Invalid type for vxlan-vlan pair")
+		return core.Errorf("Invalid type for vxlan value")
 	}
-	vxlan := pair.VXLAN
-	oper.FreeVXLANs.Set(vxlan)
-	vlan := pair.VLAN
-	oper.FreeLocalVLANs.Set(vlan)
 
-	return oper.Write()
+	oper := &AutoVXLANOperResource{}
+	oper.StateDriver = r.StateDriver
+	return core.UpdateState(oper, r.ID, func() error {
+		oper.FreeVXLANs.Set(vxlan)
+		return nil
+	})
 }
 
 // AutoVXLANOperResource is an implementation of core.State
 type AutoVXLANOperResource struct {
 	core.CommonState
-	FreeVXLANs     *bitset.BitSet `json:"freeVXLANs"`
-	FreeLocalVLANs *bitset.BitSet `json:"freeLocalVLANs"`
+	FreeVXLANs *bitset.BitSet `json:"freeVXLANs"`
+}
+
+// NextVXLAN returns the vxlan an allocation would pick without allocating
+// it. A non-zero reqVxlan is returned only if it is
+// available.
+func (r *AutoVXLANOperResource) NextVXLAN(reqVxlan uint) (uint, error) {
+	return r.NextVXLANIn(reqVxlan, nil)
+}
+
+// NextVXLANIn is NextVXLAN restricted to the allowed vxlans, a nil allowed
+// doesn't restrict it.
+func (r *AutoVXLANOperResource) NextVXLANIn(reqVxlan uint, allowed *bitset.BitSet) (uint, error) {
+	vxlan := reqVxlan
+	if reqVxlan != 0 {
+		if allowed != nil && !allowed.Test(reqVxlan) {
+			return 0, fmt.Errorf("requested vxlan not in allowed range")
+		}
+		if !r.FreeVXLANs.Test(reqVxlan) {
+			return 0, fmt.Errorf("requested vxlan not available")
+		}
+	} else if allowed != nil {
+		ok := false
+		vxlan, ok = r.FreeVXLANs.Intersection(allowed).NextSet(0)
+		if !ok {
+			return 0, errors.New("no vxlans available in allowed range")
+		}
+	} else {
+		ok := false
+		vxlan, ok = r.FreeVXLANs.NextSet(0)
+		if !ok {
+			return 0, errors.New("no vxlans available")
+		}
+	}
+
+	return vxlan, nil
 }
 
 // Write the state.
@@ -300,6 +274,19 @@ func (r *AutoVXLANOperResource) Read(id string) error {
 	return r.StateDriver.ReadState(key, r, json.Unmarshal)
 }
 
+// ReadRevision reads the state for a given identifier along with its revision
+func (r *AutoVXLANOperResource) ReadRevision(id string) (uint64, error) {
+	*r = AutoVXLANOperResource{CommonState: r.CommonState}
+	key := fmt.Sprintf(vXLANResourceOperPath, id)
+	return r.StateDriver.ReadStateRevision(key, r, json.Unmarshal)
+}
+
+// WriteIfRevision writes the state if it is still at the given revision
+func (r *AutoVXLANOperResource) WriteIfRevision(revision uint64) error {
+	key := fmt.Sprintf(vXLANResourceOperPath, r.ID)
+	return r.StateDriver.WriteStateIfRevision(key, r, json.Marshal, revision)
+}
+
 // ReadAll the state for the given type.
 func (r *AutoVXLANOperResource) ReadAll() ([]core.State, error) {
 	return r.StateDriver.ReadAllState(vXLANResourceOperPathPrefix, r,
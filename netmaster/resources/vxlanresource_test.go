@@ -21,12 +21,13 @@ import (
 	"testing"
 
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/internal/testutil"
 	"github.com/jainvipin/bitset"
 
 	log "github.com/Sirupsen/logrus"
 )
 
-var vxlanRsrcStateDriver = &testVXLANRsrcStateDriver{}
+var vxlanRsrcStateDriver = testutil.NoRevisions(&testVXLANRsrcStateDriver{})
 
 type vxlanRsrcValidator struct {
 	// slice (stack) of expected config and oper states.
@@ -59,8 +60,7 @@ func (vt *vxlanRsrcValidator) ValidateState(state core.State) error {
 	if okCfg {
 		log.Debugf("cfg length: %d", len(vt.expCfg))
 		if rcvdCfg.ID != vt.expCfg[0].ID ||
-			!rcvdCfg.VXLANs.Equal(vt.expCfg[0].VXLANs) ||
-			!rcvdCfg.LocalVLANs.Equal(vt.expCfg[0].LocalVLANs) {
+			!rcvdCfg.VXLANs.Equal(vt.expCfg[0].VXLANs) {
 			errStr := fmt.Sprintf("cfg mismatch. Expctd: %+v, Rcvd: %+v",
 				vt.expCfg[0], rcvdCfg)
 			//panic so we can catch the exact backtrace
@@ -74,11 +74,9 @@ func (vt *vxlanRsrcValidator) ValidateState(state core.State) error {
 	if okOper {
 		log.Debugf("oper length: %d", len(vt.expOper))
 		if rcvdOper.ID != vt.expOper[0].ID ||
-			!rcvdOper.FreeVXLANs.Equal(vt.expOper[0].FreeVXLANs) ||
-			!rcvdOper.FreeLocalVLANs.Equal(vt.expOper[0].FreeLocalVLANs) {
+			!rcvdOper.FreeVXLANs.Equal(vt.expOper[0].FreeVXLANs) {
 			fmt.Printf("rcvdOper.ID = %s expOperId = %s \n", rcvdOper.ID, vt.expOper[0].ID)
 			fmt.Printf("RcvdFreeVXLANs = %s, ExpFreeVXLANs = %s\n", rcvdOper.FreeVXLANs.DumpAsBits(), vt.expOper[0].FreeVXLANs.DumpAsBits())
-			fmt.Printf("RcvdFreeLocalVLANs = %s, ExpFreeLocalVLANs = %s\n", rcvdOper.FreeLocalVLANs.DumpAsBits(), vt.expOper[0].FreeLocalVLANs.DumpAsBits())
 			errStr := fmt.Sprintf("oper mismatch. Expctd: %+v, Rcvd: %+v",
 				vt.expOper[0], rcvdOper)
 			//panic so we can catch the exact backtrace
@@ -96,7 +94,6 @@ func (vt *vxlanRsrcValidator) CopyState(state core.State) error {
 	if okCfg {
 		rcvdCfg.ID = vt.expCfg[0].ID
 		rcvdCfg.VXLANs = vt.expCfg[0].VXLANs.Clone()
-		rcvdCfg.LocalVLANs = vt.expCfg[0].LocalVLANs.Clone()
 		vt.nextCfgState()
 		return nil
 	}
@@ -105,7 +102,6 @@ func (vt *vxlanRsrcValidator) CopyState(state core.State) error {
 	if okOper {
 		rcvdOper.ID = vt.expOper[0].ID
 		rcvdOper.FreeVXLANs = vt.expOper[0].FreeVXLANs.Clone()
-		rcvdOper.FreeLocalVLANs = vt.expOper[0].FreeLocalVLANs.Clone()
 		vt.nextOperState()
 		return nil
 	}
@@ -120,7 +116,6 @@ const (
 	VXLANRsrcValidDeinitID          = "VXLANRsrcValidDeinitID"
 	VXLANRsrcAllocateID             = "VXLANRsrcAllocateID"
 	VXLANRsrcAllocateExhaustVXLANID = "VXLANRsrcAllocateExhaustVXLANID"
-	VXLANRsrcAllocateExhaustVLANID  = "VXLANRsrcAllocateExhaustVLANID"
 	VXLANRsrcDeallocateID           = "VXLANRsrcDeallocateID"
 	VXLANRsrcGetListID              = "VXLANRsrcGetListID"
 
@@ -135,14 +130,12 @@ var vxlanRsrcValidationStateMap = map[string]*vxlanRsrcValidator{
 			{
 				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcValidInitID},
 				VXLANs:      bitset.New(1).Set(0),
-				LocalVLANs:  bitset.New(1).Set(0),
 			},
 		},
 		expOper: []AutoVXLANOperResource{
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcValidInitID},
-				FreeVXLANs:     bitset.New(1).Set(0),
-				FreeLocalVLANs: bitset.New(1).Set(0),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcValidInitID},
+				FreeVXLANs:  bitset.New(1).Set(0),
 			},
 		},
 	},
@@ -151,19 +144,16 @@ var vxlanRsrcValidationStateMap = map[string]*vxlanRsrcValidator{
 			{
 				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcValidDeinitID},
 				VXLANs:      bitset.New(1).Set(0),
-				LocalVLANs:  bitset.New(1).Set(0),
 			},
 		},
 		expOper: []AutoVXLANOperResource{
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcValidDeinitID},
-				FreeVXLANs:     bitset.New(1).Set(0),
-				FreeLocalVLANs: bitset.New(1).Set(0),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcValidDeinitID},
+				FreeVXLANs:  bitset.New(1).Set(0),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcValidDeinitID},
-				FreeVXLANs:     bitset.New(1).Set(0),
-				FreeLocalVLANs: bitset.New(1).Set(0),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcValidDeinitID},
+				FreeVXLANs:  bitset.New(1).Set(0),
 			},
 		},
 	},
@@ -172,24 +162,20 @@ var vxlanRsrcValidationStateMap = map[string]*vxlanRsrcValidator{
 			{
 				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateID},
 				VXLANs:      bitset.New(1).Set(0),
-				LocalVLANs:  bitset.New(1).Set(0),
 			},
 		},
 		expOper: []AutoVXLANOperResource{
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateID},
-				FreeVXLANs:     bitset.New(1).Set(0),
-				FreeLocalVLANs: bitset.New(1).Set(0),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateID},
+				FreeVXLANs:  bitset.New(1).Set(0),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateID},
-				FreeVXLANs:     bitset.New(1).Set(0),
-				FreeLocalVLANs: bitset.New(1).Set(0),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateID},
+				FreeVXLANs:  bitset.New(1).Set(0),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateID},
-				FreeVXLANs:     bitset.New(1).Clear(0),
-				FreeLocalVLANs: bitset.New(1).Clear(0),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateID},
+				FreeVXLANs:  bitset.New(1).Clear(0),
 			},
 		},
 	},
@@ -198,40 +184,16 @@ var vxlanRsrcValidationStateMap = map[string]*vxlanRsrcValidator{
 			{
 				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateExhaustVXLANID},
 				VXLANs:      bitset.New(1).Clear(0),
-				LocalVLANs:  bitset.New(1).Clear(0),
 			},
 		},
 		expOper: []AutoVXLANOperResource{
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateExhaustVXLANID},
-				FreeVXLANs:     bitset.New(1).Clear(0),
-				FreeLocalVLANs: bitset.New(1).Clear(0),
-			},
-			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateExhaustVXLANID},
-				FreeVXLANs:     bitset.New(1).Clear(0),
-				FreeLocalVLANs: bitset.New(1).Clear(0),
-			},
-		},
-	},
-	VXLANRsrcAllocateExhaustVLANID: {
-		expCfg: []AutoVXLANCfgResource{
-			{
-				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateExhaustVLANID},
-				VXLANs:      bitset.New(1).Set(0),
-				LocalVLANs:  bitset.New(1).Clear(0),
-			},
-		},
-		expOper: []AutoVXLANOperResource{
-			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateExhaustVLANID},
-				FreeVXLANs:     bitset.New(1).Set(0),
-				FreeLocalVLANs: bitset.New(1).Clear(0),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateExhaustVXLANID},
+				FreeVXLANs:  bitset.New(1).Clear(0),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateExhaustVLANID},
-				FreeVXLANs:     bitset.New(1).Set(0),
-				FreeLocalVLANs: bitset.New(1).Clear(0),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcAllocateExhaustVXLANID},
+				FreeVXLANs:  bitset.New(1).Clear(0),
 			},
 		},
 	},
@@ -240,34 +202,28 @@ var vxlanRsrcValidationStateMap = map[string]*vxlanRsrcValidator{
 			{
 				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcDeallocateID},
 				VXLANs:      bitset.New(1).Set(0),
-				LocalVLANs:  bitset.New(1).Set(0),
 			},
 		},
 		expOper: []AutoVXLANOperResource{
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcDeallocateID},
-				FreeVXLANs:     bitset.New(1).Set(0),
-				FreeLocalVLANs: bitset.New(1).Set(0),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcDeallocateID},
+				FreeVXLANs:  bitset.New(1).Set(0),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcDeallocateID},
-				FreeVXLANs:     bitset.New(1).Set(0),
-				FreeLocalVLANs: bitset.New(1).Set(0),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcDeallocateID},
+				FreeVXLANs:  bitset.New(1).Set(0),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcDeallocateID},
-				FreeVXLANs:     bitset.New(1).Clear(0),
-				FreeLocalVLANs: bitset.New(1).Clear(0),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcDeallocateID},
+				FreeVXLANs:  bitset.New(1).Clear(0),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcDeallocateID},
-				FreeVXLANs:     bitset.New(1).Clear(0),
-				FreeLocalVLANs: bitset.New(1).Clear(0),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcDeallocateID},
+				FreeVXLANs:  bitset.New(1).Clear(0),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcDeallocateID},
-				FreeVXLANs:     bitset.New(1).Set(0),
-				FreeLocalVLANs: bitset.New(1).Set(0),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcDeallocateID},
+				FreeVXLANs:  bitset.New(1).Set(0),
 			},
 		},
 	},
@@ -276,69 +232,56 @@ var vxlanRsrcValidationStateMap = map[string]*vxlanRsrcValidator{
 			{
 				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
 				VXLANs:      bitset.New(150).Complement().Clear(0).Clear(149),
-				LocalVLANs:  bitset.New(20).Complement().Clear(0).Clear(19),
 			},
 			{
 				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
 				VXLANs:      bitset.New(150).Complement().Clear(0).Clear(149),
-				LocalVLANs:  bitset.New(20).Complement().Clear(0).Clear(19),
 			},
 			{
 				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
 				VXLANs:      bitset.New(150).Complement().Clear(0).Clear(149),
-				LocalVLANs:  bitset.New(20).Complement().Clear(0).Clear(19),
 			},
 		},
 		expOper: []AutoVXLANOperResource{
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
-				FreeVXLANs:     bitset.New(150).Complement().Clear(0).Clear(149),
-				FreeLocalVLANs: bitset.New(20).Complement().Clear(0).Clear(19),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
+				FreeVXLANs:  bitset.New(150).Complement().Clear(0).Clear(149),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
-				FreeVXLANs:     bitset.New(150).Complement().Clear(0).Clear(149),
-				FreeLocalVLANs: bitset.New(20).Complement().Clear(0).Clear(19),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
+				FreeVXLANs:  bitset.New(150).Complement().Clear(0).Clear(149),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
-				FreeVXLANs:     bitset.New(150).Complement().Clear(0).Clear(1).Clear(149),
-				FreeLocalVLANs: bitset.New(20).Complement().Clear(0).Clear(1).Clear(19),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
+				FreeVXLANs:  bitset.New(150).Complement().Clear(0).Clear(1).Clear(149),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
-				FreeVXLANs:     bitset.New(150).Complement().Clear(0).Clear(1).Clear(149),
-				FreeLocalVLANs: bitset.New(20).Complement().Clear(0).Clear(1).Clear(19),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
+				FreeVXLANs:  bitset.New(150).Complement().Clear(0).Clear(1).Clear(149),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
-				FreeVXLANs:     bitset.New(150).Complement().Clear(0).Clear(1).Clear(100).Clear(149),
-				FreeLocalVLANs: bitset.New(20).Complement().Clear(0).Clear(1).Clear(2).Clear(19),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
+				FreeVXLANs:  bitset.New(150).Complement().Clear(0).Clear(1).Clear(100).Clear(149),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
-				FreeVXLANs:     bitset.New(150).Complement().Clear(0).Clear(1).Clear(100).Clear(149),
-				FreeLocalVLANs: bitset.New(20).Complement().Clear(0).Clear(1).Clear(2).Clear(19),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
+				FreeVXLANs:  bitset.New(150).Complement().Clear(0).Clear(1).Clear(100).Clear(149),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
-				FreeVXLANs:     bitset.New(150).Complement().Clear(0).Clear(1).Clear(100).Clear(101).Clear(149),
-				FreeLocalVLANs: bitset.New(20).Complement().Clear(0).Clear(1).Clear(2).Clear(3).Clear(19),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
+				FreeVXLANs:  bitset.New(150).Complement().Clear(0).Clear(1).Clear(100).Clear(101).Clear(149),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
-				FreeVXLANs:     bitset.New(150).Complement().Clear(0).Clear(1).Clear(100).Clear(101).Clear(149),
-				FreeLocalVLANs: bitset.New(20).Complement().Clear(0).Clear(1).Clear(2).Clear(3).Clear(19),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
+				FreeVXLANs:  bitset.New(150).Complement().Clear(0).Clear(1).Clear(100).Clear(101).Clear(149),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
-				FreeVXLANs:     bitset.New(150).Complement().Clear(0).Clear(1).Clear(100).Clear(101).Clear(149),
-				FreeLocalVLANs: bitset.New(20).Complement().Clear(0).Clear(1).Clear(2).Clear(3).Clear(19),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
+				FreeVXLANs:  bitset.New(150).Complement().Clear(0).Clear(1).Clear(100).Clear(101).Clear(149),
 			},
 			{
-				CommonState:    core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
-				FreeVXLANs:     bitset.New(150).Complement().Clear(0).Clear(1).Clear(100).Clear(101).Clear(149),
-				FreeLocalVLANs: bitset.New(20).Complement().Clear(0).Clear(1).Clear(2).Clear(3).Clear(19),
+				CommonState: core.CommonState{StateDriver: nil, ID: VXLANRsrcGetListID},
+				FreeVXLANs:  bitset.New(150).Complement().Clear(0).Clear(1).Clear(100).Clear(101).Clear(149),
 			},
 		},
 	},
@@ -452,13 +395,12 @@ func TestAutoVXLANCfgResourceAllocate(t *testing.T) {
 		t.Fatalf("VXLAN resource init failed. Error: %s", err)
 	}
 
-	p, err1 := rsrc.Allocate(uint(0))
+	vxlan, err1 := rsrc.Allocate(uint(0))
 	if err1 != nil {
 		t.Fatalf("VXLAN resource allocation failed. Error: %s", err1)
 	}
-	pair := p.(VXLANVLANPair)
-	if pair.VXLAN != 0 || pair.VLAN != 0 {
-		t.Fatalf("Allocated vxlan mismatch. expected: (0,0), rcvd: %v", pair)
+	if vxlan != uint(0) {
+		t.Fatalf("Allocated vxlan mismatch. expected: 0, rcvd: %v", vxlan)
 	}
 }
 
@@ -481,25 +423,6 @@ func TestAutoVXLANCfgResourceAllocateVXLANExhaustion(t *testing.T) {
 	}
 }
 
-func TestAutoVXLANCfgResourceAllocateVLANExhaustion(t *testing.T) {
-	rsrc := &AutoVXLANCfgResource{}
-	rsrc.StateDriver = vxlanRsrcStateDriver
-	rsrc.ID = VXLANRsrcAllocateExhaustVLANID
-	err := rsrc.Init(&vxlanRsrcValidationStateMap[rsrc.ID].expCfg[0])
-	if err != nil {
-		t.Fatalf("VXLAN resource init failed. Error: %s", err)
-	}
-
-	_, err = rsrc.Allocate(uint(0))
-	if err == nil {
-		t.Fatalf("VXLAN resource allocation succeeded, expected to fail!")
-	}
-	if !strings.Contains(err.Error(), "no local vlans available") {
-		t.Fatalf("VXLAN resource allocation failure reason mismatch. Expected: %s, rcvd: %s",
-			"no local vlans available", err)
-	}
-}
-
 func TestAutoVXLANCfgResourceDeAllocate(t *testing.T) {
 	rsrc := &AutoVXLANCfgResource{}
 	rsrc.StateDriver = vxlanRsrcStateDriver
@@ -509,12 +432,12 @@ func TestAutoVXLANCfgResourceDeAllocate(t *testing.T) {
 		t.Fatalf("VXLAN resource init failed. Error: %s", err)
 	}
 
-	pair, err1 := rsrc.Allocate(uint(0))
+	vxlan, err1 := rsrc.Allocate(uint(0))
 	if err1 != nil {
 		t.Fatalf("VXLAN resource allocation failed. Error: %s", err1)
 	}
 
-	err = rsrc.Deallocate(pair)
+	err = rsrc.Deallocate(vxlan)
 	if err != nil {
 		t.Fatalf("VXLAN resource deallocation failed. Error: %s", err)
 	}
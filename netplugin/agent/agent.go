@@ -143,6 +143,7 @@ func (ag *Agent) ProcessCurrentState() error {
 			log.Debugf("read svc key[%d] %s for tenant %s, populating state \n", idx,
 				serviceLb.ServiceName, serviceLb.Tenant)
 			processServiceLBEvent(ag.netPlugin, serviceLb, false)
+			processServiceHealthChecks(ag.netPlugin.StateDriver, serviceLb, opts.HostLabel, false)
 		}
 	}
 
@@ -249,6 +250,16 @@ func (ag *Agent) serveRequests() {
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(stats)
 	})
+	s.HandleFunc("/policystats", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := ag.netPlugin.GetPolicyRuleStats()
+		if err != nil {
+			log.Errorf("Error fetching policy stats from driver. Err: %v", err)
+			http.Error(w, "Error fetching policy stats from driver", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(stats)
+	})
 	s.HandleFunc("/inspect/driver", func(w http.ResponseWriter, r *http.Request) {
 		driverState, err := ag.netPlugin.InspectState()
 		if err != nil {
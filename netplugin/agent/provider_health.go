@@ -0,0 +1,284 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/netplugin/cluster"
+	"github.com/docker/engine-api/client"
+	"github.com/vishvananda/netns"
+	"golang.org/x/net/context"
+)
+
+// The providers of a service with a health check are checked by the
+// netplugin on their host. The service lb state has the health check, the
+// providers and the providers the netmaster took out. A provider is taken
+// out after UnhealthyThreshold consecutive failed checks and put back after
+// HealthyThreshold consecutive passed checks, health changes are posted to
+// the netmaster. A change the netmaster didn't take is posted again after
+// the next check. Provider addresses are not reachable from the host, the
+// checks connect from inside the network namespace of the provider
+// container.
+
+// providerCheck is the health check of a provider of a service
+type providerCheck struct {
+	serviceName string
+	tenant      string
+	ipAddress   string
+	containerID string
+	healthCheck intent.ConfigHealthCheck
+	healthy     bool
+	passed      int
+	failed      int
+	stop        chan bool
+}
+
+// providerChecks has the health checks of the local providers of each
+// service, keyed by service id and provider id
+var providerChecks = struct {
+	sync.Mutex
+	services map[string]map[string]*providerCheck
+}{services: make(map[string]map[string]*providerCheck)}
+
+// postProviderHealth posts a provider health change to the netmaster
+var postProviderHealth = func(healthReq *master.ProviderHealthRequest) error {
+	var healthResp master.ProviderHealthResponse
+	return cluster.MasterPostReq("/plugin/updateProviderHealth", healthReq, &healthResp)
+}
+
+// dialFunc opens a connection to a provider
+type dialFunc func(network, addr string) (net.Conn, error)
+
+// providerDialer returns a dial function that connects from inside the
+// network namespace of a provider container
+var providerDialer = func(containerID string, timeout time.Duration) (dialFunc, error) {
+	defaultHeaders := map[string]string{"User-Agent": "engine-api-cli-1.0"}
+	cli, err := client.NewClient("unix:///var/run/docker.sock", "v1.21", nil, defaultHeaders)
+	if err != nil {
+		return nil, err
+	}
+	containerInfo, err := cli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return nil, err
+	}
+	if containerInfo.State == nil || containerInfo.State.Pid == 0 {
+		return nil, fmt.Errorf("container %s is not running", containerID)
+	}
+	pid := containerInfo.State.Pid
+
+	return func(network, addr string) (net.Conn, error) {
+		// the socket is created in the namespace of the thread
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		hostNs, err := netns.Get()
+		if err != nil {
+			return nil, err
+		}
+		defer hostNs.Close()
+		containerNs, err := netns.GetFromPid(pid)
+		if err != nil {
+			return nil, err
+		}
+		defer containerNs.Close()
+
+		if err := netns.Set(containerNs); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := netns.Set(hostNs); err != nil {
+				log.Errorf("Error restoring the host network namespace. Err: %v", err)
+			}
+		}()
+
+		return net.DialTimeout(network, addr, timeout)
+	}, nil
+}
+
+// isLocalProvider checks if a provider is homed on this host
+func isLocalProvider(stateDriver core.StateDriver, provider *mastercfg.Provider, hostLabel string) bool {
+	if provider.EpIDKey == "" {
+		return false
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = stateDriver
+	if err := epCfg.Read(provider.EpIDKey); err != nil {
+		return false
+	}
+
+	return epCfg.VtepIP == "" && epCfg.HomingHost == hostLabel
+}
+
+// processServiceHealthChecks starts the health checks of the local providers
+// of a service and stops the checks of removed providers. Checks of providers
+// whose health check didn't change keep running.
+func processServiceHealthChecks(stateDriver core.StateDriver, svcLBCfg *mastercfg.CfgServiceLBState,
+	hostLabel string, isDelete bool) {
+	checks := make(map[string]*providerCheck)
+	if !isDelete && svcLBCfg.HealthCheck.Type != "" {
+		for providerID, provider := range svcLBCfg.Providers {
+			if !isLocalProvider(stateDriver, provider, hostLabel) {
+				continue
+			}
+			checks[providerID] = &providerCheck{
+				serviceName: svcLBCfg.ServiceName,
+				tenant:      svcLBCfg.Tenant,
+				ipAddress:   provider.IPAddress,
+				containerID: provider.ContainerID,
+				healthCheck: svcLBCfg.HealthCheck,
+				healthy:     !svcLBCfg.Unhealthy[providerID],
+			}
+		}
+	}
+
+	providerChecks.Lock()
+	defer providerChecks.Unlock()
+
+	serviceChecks := providerChecks.services[svcLBCfg.ID]
+	for providerID, check := range serviceChecks {
+		if newCheck, found := checks[providerID]; found && newCheck.healthCheck == check.healthCheck {
+			checks[providerID] = check
+			continue
+		}
+		log.Infof("Stopping health check of provider %s of service %s", providerID, svcLBCfg.ID)
+		close(check.stop)
+	}
+
+	for providerID, check := range checks {
+		if check.stop == nil {
+			log.Infof("Starting %s health check of provider %s of service %s", check.healthCheck.Type,
+				providerID, svcLBCfg.ID)
+			check.stop = make(chan bool)
+			go check.run()
+		}
+	}
+
+	if len(checks) == 0 {
+		delete(providerChecks.services, svcLBCfg.ID)
+	} else {
+		providerChecks.services[svcLBCfg.ID] = checks
+	}
+}
+
+// run checks the provider every interval until the check is stopped
+func (c *providerCheck) run() {
+	ticker := time.NewTicker(time.Duration(c.healthCheck.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			timeout := time.Duration(c.healthCheck.Timeout) * time.Second
+			dial, err := providerDialer(c.containerID, timeout)
+			if err == nil {
+				err = probeProvider(dial, c.ipAddress, c.healthCheck)
+			}
+			if err != nil {
+				log.Debugf("Health check of provider %s of service %s failed. Err: %v", c.ipAddress,
+					c.serviceName, err)
+			}
+			c.update(err == nil)
+		}
+	}
+}
+
+// update counts a check result and posts a health change once a threshold of
+// consecutive results is reached
+func (c *providerCheck) update(passed bool) {
+	if passed {
+		c.passed++
+		c.failed = 0
+	} else {
+		c.failed++
+		c.passed = 0
+	}
+
+	healthy := c.healthy
+	if !c.healthy && c.passed >= c.healthCheck.HealthyThreshold {
+		healthy = true
+	} else if c.healthy && c.failed >= c.healthCheck.UnhealthyThreshold {
+		healthy = false
+	}
+	if healthy == c.healthy {
+		return
+	}
+
+	healthReq := &master.ProviderHealthRequest{
+		ServiceName: c.serviceName,
+		Tenant:      c.tenant,
+		IPAddress:   c.ipAddress,
+		Healthy:     healthy,
+	}
+	if err := postProviderHealth(healthReq); err != nil {
+		log.Errorf("Error posting health of provider %s of service %s. Err: %v", c.ipAddress, c.serviceName, err)
+		return
+	}
+
+	status := master.ProviderUnhealthy
+	if healthy {
+		status = master.ProviderHealthy
+	}
+	log.Infof("Provider %s of service %s is %s", c.ipAddress, c.serviceName, status)
+	c.healthy = healthy
+}
+
+// probeProvider runs a tcp connect or http get health check of a provider
+// over the connections of a dial function, http checks pass on 2xx and 3xx
+// responses
+func probeProvider(dial dialFunc, ipAddress string, healthCheck intent.ConfigHealthCheck) error {
+	addr := net.JoinHostPort(ipAddress, strconv.Itoa(healthCheck.Port))
+	timeout := time.Duration(healthCheck.Timeout) * time.Second
+
+	if healthCheck.Type == intent.HealthCheckHTTP {
+		client := &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{Dial: dial, DisableKeepAlives: true},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		resp, err := client.Get("http://" + addr + healthCheck.Path)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("http status %d", resp.StatusCode)
+		}
+		return nil
+	}
+
+	conn, err := dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,176 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/master"
+)
+
+// providerServer serves health checks of a provider, dialProvider reaches
+// it as the namespace dialer reaches a provider address
+func providerServer(status int) (*httptest.Server, dialFunc) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	dialProvider := func(network, addr string) (net.Conn, error) {
+		return net.DialTimeout(network, server.Listener.Addr().String(), time.Second)
+	}
+	return server, dialProvider
+}
+
+// stubProviderHealth records the health changes posted to the netmaster
+func stubProviderHealth(postErr *error) chan master.ProviderHealthRequest {
+	posted := make(chan master.ProviderHealthRequest, 10)
+	postProviderHealth = func(healthReq *master.ProviderHealthRequest) error {
+		if *postErr != nil {
+			return *postErr
+		}
+		posted <- *healthReq
+		return nil
+	}
+	return posted
+}
+
+func checkPosted(t *testing.T, posted chan master.ProviderHealthRequest, expHealthy []bool) {
+	for _, healthy := range expHealthy {
+		select {
+		case healthReq := <-posted:
+			if healthReq.Healthy != healthy || healthReq.IPAddress != "10.1.1.2" {
+				t.Fatalf("unexpected health change %+v, expected healthy %v", healthReq, healthy)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("health change to healthy %v was not posted", healthy)
+		}
+	}
+	select {
+	case healthReq := <-posted:
+		t.Fatalf("unexpected health change %+v", healthReq)
+	default:
+	}
+}
+
+func TestProbeProvider(t *testing.T) {
+	healthCheck := intent.ConfigHealthCheck{
+		Type:    intent.HealthCheckHTTP,
+		Port:    8080,
+		Path:    "/health",
+		Timeout: 1,
+	}
+
+	server, dial := providerServer(http.StatusOK)
+	if err := probeProvider(dial, "10.1.1.2", healthCheck); err != nil {
+		t.Fatalf("http check of a healthy provider failed. Err: %v", err)
+	}
+	healthCheck.Type = intent.HealthCheckTCP
+	if err := probeProvider(dial, "10.1.1.2", healthCheck); err != nil {
+		t.Fatalf("tcp check of a healthy provider failed. Err: %v", err)
+	}
+	server.Close()
+	if err := probeProvider(dial, "10.1.1.2", healthCheck); err == nil {
+		t.Fatalf("tcp check of a stopped provider passed")
+	}
+
+	server, dial = providerServer(http.StatusServiceUnavailable)
+	defer server.Close()
+	healthCheck.Type = intent.HealthCheckHTTP
+	if err := probeProvider(dial, "10.1.1.2", healthCheck); err == nil {
+		t.Fatalf("http check of an unavailable provider passed")
+	}
+}
+
+func TestProviderCheckUpdate(t *testing.T) {
+	var postErr error
+	posted := stubProviderHealth(&postErr)
+	check := &providerCheck{
+		serviceName: "svc1",
+		tenant:      "default",
+		ipAddress:   "10.1.1.2",
+		healthCheck: intent.ConfigHealthCheck{HealthyThreshold: 2, UnhealthyThreshold: 3},
+		healthy:     true,
+	}
+
+	// a provider goes down after consecutive failed checks
+	check.update(false)
+	check.update(false)
+	check.update(true)
+	check.update(false)
+	check.update(false)
+	checkPosted(t, posted, nil)
+	check.update(false)
+	checkPosted(t, posted, []bool{false})
+
+	// a change the netmaster didn't take is posted after the next check
+	check.update(true)
+	postErr = errors.New("netmaster is down")
+	check.update(true)
+	if check.healthy {
+		t.Fatalf("provider is healthy without a posted change")
+	}
+	postErr = nil
+	check.update(true)
+	checkPosted(t, posted, []bool{true})
+	if !check.healthy {
+		t.Fatalf("provider is not healthy after a posted change")
+	}
+}
+
+func TestProviderCheckRun(t *testing.T) {
+	var postErr error
+	posted := stubProviderHealth(&postErr)
+	server, dial := providerServer(http.StatusOK)
+	defer server.Close()
+	providerDialer = func(containerID string, timeout time.Duration) (dialFunc, error) {
+		if containerID != "c1" {
+			return nil, errors.New("container not found")
+		}
+		return dial, nil
+	}
+
+	check := &providerCheck{
+		serviceName: "svc1",
+		tenant:      "default",
+		ipAddress:   "10.1.1.2",
+		containerID: "c1",
+		healthCheck: intent.ConfigHealthCheck{
+			Type:               intent.HealthCheckTCP,
+			Port:               8080,
+			Interval:           1,
+			Timeout:            1,
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 1,
+		},
+		stop: make(chan bool),
+	}
+	go check.run()
+	defer close(check.stop)
+
+	// checks connect through the dialer of the provider container
+	checkPosted(t, posted, []bool{true})
+	server.Close()
+	checkPosted(t, posted, []bool{false})
+}
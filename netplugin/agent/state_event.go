@@ -133,6 +133,42 @@ func processNetEvent(netPlugin *plugin.NetPlugin, nwCfg *mastercfg.CfgNetworkSta
 	return
 }
 
+// isNetParamsChanged checks if the gateway or subnet of a network changed
+func isNetParamsChanged(prevCfg, nwCfg *mastercfg.CfgNetworkState) bool {
+	return prevCfg.Gateway != nwCfg.Gateway || prevCfg.SubnetIP != nwCfg.SubnetIP ||
+		prevCfg.SubnetLen != nwCfg.SubnetLen || prevCfg.IPv6Subnet != nwCfg.IPv6Subnet ||
+		prevCfg.IPv6SubnetLen != nwCfg.IPv6SubnetLen || prevCfg.IPv6Gateway != nwCfg.IPv6Gateway
+}
+
+// processNetUpdateEvent applies in-place network parameter changes
+func processNetUpdateEvent(netPlugin *plugin.NetPlugin, prevCfg, nwCfg *mastercfg.CfgNetworkState) (err error) {
+	err = netPlugin.UpdateNetwork(nwCfg.ID, prevCfg.Gateway)
+	if err != nil {
+		log.Errorf("Network operation update failed. Error: %s", err)
+		return err
+	}
+
+	// Re-address the host interface of infra network on subnet change
+	if nwCfg.NwType == "infra" && prevCfg.SubnetLen != nwCfg.SubnetLen {
+		ipAddr, err := netutils.GetInterfaceIP(nwCfg.NetworkName)
+		if err != nil {
+			log.Errorf("Could not get ip of %s: %s", nwCfg.NetworkName, err)
+			return err
+		}
+		err = netutils.ReplaceInterfaceIP(nwCfg.NetworkName,
+			fmt.Sprintf("%s/%d", ipAddr, prevCfg.SubnetLen),
+			fmt.Sprintf("%s/%d", ipAddr, nwCfg.SubnetLen))
+		if err != nil {
+			log.Errorf("Could not assign ip: %s", err)
+			return err
+		}
+	}
+
+	log.Infof("Network operation update succeeded")
+
+	return nil
+}
+
 // processEpState restores endpoint state
 func processEpState(netPlugin *plugin.NetPlugin, opts core.InstanceInfo, epID string) error {
 	// take a lock to ensure we are programming one event at a time.
@@ -333,8 +369,18 @@ func processServiceLBEvent(netPlugin *plugin.NetPlugin, svcLBCfg *mastercfg.CfgS
 	}
 
 	spec := &core.ServiceSpec{
-		IPAddress: svcLBCfg.IPAddress,
-		Ports:     portSpecList,
+		IPAddress:       svcLBCfg.IPAddress,
+		Ports:           portSpecList,
+		SessionAffinity: svcLBCfg.LBPolicy.SessionAffinity,
+		AffinityTimeout: uint32(svcLBCfg.LBPolicy.AffinityTimeout),
+	}
+
+	//provider weights are only sent when the service has a weight label
+	if svcLBCfg.LBPolicy.WeightLabel != "" {
+		spec.Weights = make(map[string]uint16)
+		for _, provider := range svcLBCfg.Providers {
+			spec.Weights[provider.IPAddress] = uint16(master.ProviderWeight(provider, svcLBCfg.LBPolicy.WeightLabel))
+		}
 	}
 
 	operStr := ""
@@ -403,9 +449,21 @@ func processStateEvent(netPlugin *plugin.NetPlugin, opts core.InstanceInfo, rsps
 				processGlobalConfigUpdEvent(netPlugin, opts, prevCfg, gCfg)
 			}
 
-			// Ignore modify event on network state
+			// Network state is modified on every address allocation,
+			// only act on changes to the network parameters
 			if nwCfg, ok := currentState.(*mastercfg.CfgNetworkState); ok {
-				log.Debugf("Received a modify event on network %q, ignoring it", nwCfg.ID)
+				prevCfg, ok := rsp.Prev.(*mastercfg.CfgNetworkState)
+				if !ok {
+					log.Errorf("Received a modify event on network %q with unexpected previous state %+v",
+						nwCfg.ID, rsp.Prev)
+					continue
+				}
+				if isNetParamsChanged(prevCfg, nwCfg) {
+					log.Infof("Received \"update\" for network: %q", nwCfg.ID)
+					processNetUpdateEvent(netPlugin, prevCfg, nwCfg)
+				} else {
+					log.Debugf("Received a modify event on network %q, ignoring it", nwCfg.ID)
+				}
 				continue
 			}
 
@@ -438,6 +496,7 @@ func processStateEvent(netPlugin *plugin.NetPlugin, opts core.InstanceInfo, rsps
 			log.Infof("Received %q for Service %s on tenant %s", eventStr,
 				serviceLbCfg.ServiceName, serviceLbCfg.Tenant)
 			processServiceLBEvent(netPlugin, serviceLbCfg, isDelete)
+			processServiceHealthChecks(netPlugin.StateDriver, serviceLbCfg, opts.HostLabel, isDelete)
 		}
 		if svcProvider, ok := currentState.(*mastercfg.SvcProvider); ok {
 			log.Infof("Received %q for Service %s on tenant %s", eventStr,
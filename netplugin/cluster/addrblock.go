@@ -0,0 +1,193 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"sync"
+
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+	"github.com/contiv/netplugin/utils/netutils"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Addresses and endpoints are allocated from the address blocks netmaster
+// delegates to the host, so that containers can be started while netmaster
+// is down. Requests go to netmaster when the host has no block address.
+
+// addrBlockRequests serializes the address block requests of a network
+var (
+	addrBlockMutex    sync.Mutex
+	addrBlockRequests = make(map[string]*sync.Mutex)
+)
+
+// AllocAddress allocates an address from the address block of the host and
+// asks netmaster for the address when the block has none
+func AllocAddress(host string, allocReq *master.AddressAllocRequest) (string, error) {
+	if allocReq.NetworkID != "" && !netutils.IsIPv6(allocReq.AddressPool) {
+		addr, err := allocBlockAddress(host, allocReq.NetworkID)
+		if err == nil && addr != "" {
+			return addr, nil
+		}
+		if err != nil && err != master.ErrAddrsNotDelegated {
+			log.Warnf("Unable to allocate address from the address block of %s. Err: %v",
+				allocReq.NetworkID, err)
+		}
+	}
+
+	// Make a REST call to master
+	var allocResp master.AddressAllocResponse
+	err := MasterPostReq("/plugin/allocAddress", allocReq, &allocResp)
+	if err != nil {
+		return "", err
+	}
+
+	return allocResp.IPv4Address, nil
+}
+
+// CreateEndpoint creates the state of an endpoint with an address from the
+// address block of the host, and asks netmaster to create the endpoint when
+// it can not be created with a block address
+func CreateEndpoint(host string, epReq *master.CreateEndpointRequest) (*master.CreateEndpointResponse, error) {
+	epCfg, err := createBlockEndpoint(host, epReq)
+	if err == nil {
+		return &master.CreateEndpointResponse{EndpointConfig: *epCfg}, nil
+	}
+	if err != master.ErrAddrsNotDelegated {
+		log.Warnf("Unable to create endpoint %s with a block address. Err: %v",
+			epReq.ConfigEP.Container, err)
+	}
+
+	// Make a REST call to master
+	var epResp master.CreateEndpointResponse
+	err = MasterPostReq("/plugin/createEndpoint", epReq, &epResp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &epResp, nil
+}
+
+// JoinEndpoint checks the addresses of an endpoint against the ip
+// reservations of the workload it joins. The check only reads the state, so
+// it does not need netmaster either.
+func JoinEndpoint(joinReq *master.JoinEndpointRequest) error {
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return err
+	}
+
+	_, err = master.JoinEndpoint(stateDriver, joinReq)
+	return err
+}
+
+// createBlockEndpoint creates an endpoint with an address from the address
+// block of the host. An empty block is refilled by netmaster before the
+// endpoint is created.
+func createBlockEndpoint(host string, epReq *master.CreateEndpointRequest) (*mastercfg.CfgEndpointState, error) {
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	allocID := master.EndpointAllocID(epReq)
+	epCfg, err := master.CreateBlockEndpoint(stateDriver, host, epReq)
+	if err == master.ErrAddrBlockEmpty {
+		if err := requestAddrBlock(host, allocID); err != nil {
+			return nil, err
+		}
+		epCfg, err = master.CreateBlockEndpoint(stateDriver, host, epReq)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refillAddrBlock(host, allocID, master.AddrBlockFreeCount(stateDriver, host, allocID))
+	return epCfg, nil
+}
+
+// allocBlockAddress allocates an address from the address block of the host
+// without netmaster. An empty block is refilled by netmaster before the
+// address is allocated.
+func allocBlockAddress(host, networkID string) (string, error) {
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return "", err
+	}
+
+	addr, free, err := master.AllocBlockAddress(stateDriver, host, networkID)
+	if err != nil {
+		return "", err
+	}
+	if addr == "" {
+		if err := requestAddrBlock(host, networkID); err != nil {
+			return "", err
+		}
+		addr, free, err = master.AllocBlockAddress(stateDriver, host, networkID)
+		if err != nil || addr == "" {
+			return "", err
+		}
+	}
+
+	refillAddrBlock(host, networkID, free)
+	return addr, nil
+}
+
+// refillAddrBlock refills a block that runs low in the background
+func refillAddrBlock(host, networkID string, free int) {
+	if free >= master.AddrBlockLowWater {
+		return
+	}
+
+	go func() {
+		if err := requestAddrBlock(host, networkID); err != nil {
+			log.Warnf("Unable to refill the address block of %s. Err: %v", networkID, err)
+		}
+	}()
+}
+
+// requestAddrBlock asks netmaster for more addresses in the address block
+// of the host, concurrent requests for a network are sent once
+func requestAddrBlock(host, networkID string) error {
+	addrBlockMutex.Lock()
+	pending := addrBlockRequests[networkID]
+	if pending == nil {
+		pending = &sync.Mutex{}
+		addrBlockRequests[networkID] = pending
+	}
+	addrBlockMutex.Unlock()
+
+	pending.Lock()
+	defer pending.Unlock()
+
+	// another request may have refilled the block
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return err
+	}
+	if master.AddrBlockFreeCount(stateDriver, host, networkID) >= master.AddrBlockLowWater {
+		return nil
+	}
+
+	blockReq := master.AddrBlockRequest{
+		Host:      host,
+		NetworkID: networkID,
+	}
+	var blockResp master.AddrBlockResponse
+	return MasterPostReq("/plugin/allocAddrBlock", &blockReq, &blockResp)
+}
@@ -16,6 +16,7 @@ import (
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/internal/testutil"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/miekg/dns"
 	"os"
@@ -92,7 +93,7 @@ func (ds *dummyState) ClearState(key string) error {
 
 func TestEpChanError(t *testing.T) {
 	ns := new(NetpluginNameServer)
-	ds := new(dummyState)
+	ds := testutil.NoRevisions(new(dummyState))
 	err := ns.Init(ds)
 	assertOnErr(t, err, "namespace init")
 
@@ -107,7 +108,7 @@ func TestEpChanError(t *testing.T) {
 
 func TestSvcChanError(t *testing.T) {
 	ns := new(NetpluginNameServer)
-	ds := new(dummyState)
+	ds := testutil.NoRevisions(new(dummyState))
 	err := ns.Init(ds)
 	assertOnErr(t, err, "namespace init")
 
@@ -311,7 +312,7 @@ func verifyEndpointID(ens *NetpluginNameServer, vrf string, v6 bool, count int)
 
 func enpointOperation(t *testing.T, count int) {
 	ns := new(NetpluginNameServer)
-	ds := new(dummyState)
+	ds := testutil.NoRevisions(new(dummyState))
 	err := ns.Init(ds)
 	assertOnErr(t, err, "namespace init")
 	vrf := "tenant1"
@@ -342,7 +343,7 @@ func TestEnpointOperations(t *testing.T) {
 
 func servicesOperation(t *testing.T, count int) {
 	ns := new(NetpluginNameServer)
-	ds := new(dummyState)
+	ds := testutil.NoRevisions(new(dummyState))
 	err := ns.Init(ds)
 	assertOnErr(t, err, "namespace init")
 	vrf := "tenant1"
@@ -364,7 +365,7 @@ func TestServicesOperations(t *testing.T) {
 
 func TestInspectState(t *testing.T) {
 	ns := new(NetpluginNameServer)
-	ds := new(dummyState)
+	ds := testutil.NoRevisions(new(dummyState))
 	err := ns.Init(ds)
 	assertOnErr(t, err, "namespace init")
 	vrf := "tenant1"
@@ -402,7 +403,7 @@ func TestInspectState(t *testing.T) {
 
 func TestV4EndPointLookup(t *testing.T) {
 	ns := new(NetpluginNameServer)
-	ds := new(dummyState)
+	ds := testutil.NoRevisions(new(dummyState))
 	err := ns.Init(ds)
 	assertOnErr(t, err, "namespace init")
 	vrf := "tenant1"
@@ -473,7 +474,7 @@ func TestV4EndPointLookup(t *testing.T) {
 
 func TestV4EndPointLookupMultiRecord(t *testing.T) {
 	ns := new(NetpluginNameServer)
-	ds := new(dummyState)
+	ds := testutil.NoRevisions(new(dummyState))
 	err := ns.Init(ds)
 	assertOnErr(t, err, "namespace init")
 
@@ -552,7 +553,7 @@ func TestV4EndPointLookupMultiRecord(t *testing.T) {
 
 func TestV4ServiceLookup(t *testing.T) {
 	ns := new(NetpluginNameServer)
-	ds := new(dummyState)
+	ds := testutil.NoRevisions(new(dummyState))
 	err := ns.Init(ds)
 	assertOnErr(t, err, "namespace init")
 
@@ -624,7 +625,7 @@ func TestV4ServiceLookup(t *testing.T) {
 
 func TestV6EndPointLookup(t *testing.T) {
 	ns := new(NetpluginNameServer)
-	ds := new(dummyState)
+	ds := testutil.NoRevisions(new(dummyState))
 	err := ns.Init(ds)
 	assertOnErr(t, err, "namespace init")
 	vrf := "tenant1"
@@ -718,7 +719,7 @@ func TestV6EndPointLookup(t *testing.T) {
 
 func TestEndPointGroupMax(t *testing.T) {
 	ns := new(NetpluginNameServer)
-	ds := new(dummyState)
+	ds := testutil.NoRevisions(new(dummyState))
 	err := ns.Init(ds)
 	assertOnErr(t, err, "namespace init")
 	vrf := "tenant1"
@@ -750,7 +751,7 @@ func TestEndPointGroupMax(t *testing.T) {
 
 func TestK8sLbSvc(t *testing.T) {
 	ns := new(NetpluginNameServer)
-	ds := new(dummyState)
+	ds := testutil.NoRevisions(new(dummyState))
 	err := ns.Init(ds)
 	assertOnErr(t, err, "namespace init")
 	ns.AddLbService(commonK8sTenant, "lb1", "10.36.27.101")
@@ -787,7 +788,7 @@ func TestK8sLbSvc(t *testing.T) {
 
 func TestK8sSvcLookup(t *testing.T) {
 	ns := new(NetpluginNameServer)
-	ds := new(dummyState)
+	ds := testutil.NoRevisions(new(dummyState))
 	err := ns.Init(ds)
 	assertOnErr(t, err, "namespace init")
 
@@ -835,7 +836,7 @@ func TestK8sSvcLookup(t *testing.T) {
 
 func TestK8sMultiTenantServiceLookup(t *testing.T) {
 	ns := new(NetpluginNameServer)
-	ds := new(dummyState)
+	ds := testutil.NoRevisions(new(dummyState))
 	err := ns.Init(ds)
 	assertOnErr(t, err, "namespace init")
 
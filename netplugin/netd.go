@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/drivers"
 	"github.com/contiv/netplugin/netplugin/agent"
 	"github.com/contiv/netplugin/netplugin/cluster"
 	"github.com/contiv/netplugin/netplugin/plugin"
@@ -76,6 +77,8 @@ type cliOpts struct {
 	vlanIntf   StringSlice // Uplink interface for VLAN switching
 	version    bool
 	dbURL      string // state store URL
+	nodeProxy  string // node proxy could be iptables | ipvs
+	ipvsSched  string // ipvs scheduler could be rr | lc
 }
 
 func configureSyslog(syslogParam string) {
@@ -159,6 +162,14 @@ func main() {
 		"cluster-store",
 		"etcd://127.0.0.1:2379",
 		"state store url")
+	flagSet.StringVar(&opts.nodeProxy,
+		"node-proxy",
+		drivers.NodeProxyIPTables,
+		"node port proxy iptables|ipvs")
+	flagSet.StringVar(&opts.ipvsSched,
+		"ipvs-scheduler",
+		drivers.IPVSSchedulerRR,
+		"ipvs node proxy scheduler rr|lc")
 
 	err = flagSet.Parse(os.Args[1:])
 	if err != nil {
@@ -214,6 +225,13 @@ func main() {
 	}
 	stateStore := parts[0]
 
+	if opts.nodeProxy != drivers.NodeProxyIPTables && opts.nodeProxy != drivers.NodeProxyIPVS {
+		log.Fatalf("Invalid node-proxy %s", opts.nodeProxy)
+	}
+	if opts.ipvsSched != drivers.IPVSSchedulerRR && opts.ipvsSched != drivers.IPVSSchedulerLC {
+		log.Fatalf("Invalid ipvs-scheduler %s", opts.ipvsSched)
+	}
+
 	// initialize the config
 	pluginConfig := plugin.Config{
 		Drivers: plugin.Drivers{
@@ -221,12 +239,14 @@ func main() {
 			State:   stateStore,
 		},
 		Instance: core.InstanceInfo{
-			HostLabel:  opts.hostLabel,
-			CtrlIP:     opts.ctrlIP,
-			VtepIP:     opts.vtepIP,
-			UplinkIntf: opts.vlanIntf,
-			DbURL:      opts.dbURL,
-			PluginMode: opts.pluginMode,
+			HostLabel:     opts.hostLabel,
+			CtrlIP:        opts.ctrlIP,
+			VtepIP:        opts.vtepIP,
+			UplinkIntf:    opts.vlanIntf,
+			DbURL:         opts.dbURL,
+			PluginMode:    opts.pluginMode,
+			NodeProxy:     opts.nodeProxy,
+			IPVSScheduler: opts.ipvsSched,
 		},
 	}
 
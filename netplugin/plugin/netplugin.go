@@ -123,6 +123,13 @@ func (p *NetPlugin) DeleteNetwork(id, nwType, encap string, pktTag, extPktTag in
 	return p.NetworkDriver.DeleteNetwork(id, nwType, encap, pktTag, extPktTag, Gw, tenant)
 }
 
+// UpdateNetwork updates the gateway/subnet of a network provided by the ID.
+func (p *NetPlugin) UpdateNetwork(id, prevGateway string) error {
+	p.Lock()
+	defer p.Unlock()
+	return p.NetworkDriver.UpdateNetwork(id, prevGateway)
+}
+
 // FetchNetwork retrieves a network's state given an ID.
 func (p *NetPlugin) FetchNetwork(id string) (core.State, error) {
 	return nil, core.Errorf("Not implemented")
@@ -238,6 +245,13 @@ func (p *NetPlugin) GetEndpointStats() ([]byte, error) {
 	return p.NetworkDriver.GetEndpointStats()
 }
 
+// GetPolicyRuleStats returns all policy rule stats
+func (p *NetPlugin) GetPolicyRuleStats() ([]byte, error) {
+	p.Lock()
+	defer p.Unlock()
+	return p.NetworkDriver.GetPolicyRuleStats()
+}
+
 // InspectState returns current state of the plugin
 func (p *NetPlugin) InspectState() ([]byte, error) {
 	p.Lock()
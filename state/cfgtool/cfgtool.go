@@ -159,7 +159,7 @@ func processResource(stateDriver core.StateDriver, rsrcName, rsrcVal string) err
 
 			// set vlan values
 			for _, val := range values {
-				_, _, err = gCfg.AllocVXLAN(val)
+				_, err = gCfg.AllocVXLAN(val)
 				if err != nil {
 					log.Errorf("Error setting vxlan: %d. Err: %v", val, err)
 				}
@@ -307,3 +307,68 @@ func (d *ConsulStateDriver) WriteState(key string, value core.State,
 
 	return d.Write(key, encodedState)
 }
+
+// ReadStateRevision reads key into a core.State with the unmarshaling
+// function and returns the consul index the key was last modified at.
+func (d *ConsulStateDriver) ReadStateRevision(key string, value core.State,
+	unmarshal func([]byte, interface{}) error) (uint64, error) {
+	key = processKey(key)
+	kv, _, err := d.Client.KV().Get(key, nil)
+	if err != nil && (api.IsServerError(err) || strings.Contains(err.Error(), "EOF") ||
+		strings.Contains(err.Error(), "connection refused")) {
+		for i := 0; i < maxConsulRetries; i++ {
+			kv, _, err = d.Client.KV().Get(key, nil)
+			if err == nil {
+				break
+			}
+
+			// Retry after a delay
+			time.Sleep(time.Second)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	// Consul returns success and a nil kv when a key is not found,
+	// translate it to 'Key not found' error
+	if kv == nil {
+		return 0, core.Errorf("Key not found")
+	}
+
+	return kv.ModifyIndex, unmarshal(kv.Value, value)
+}
+
+// WriteStateIfRevision writes a value of core.State into a key with a given
+// marshaling function, if the key was last modified at the given consul index.
+func (d *ConsulStateDriver) WriteStateIfRevision(key string, value core.State,
+	marshal func(interface{}) ([]byte, error), revision uint64) error {
+	key = processKey(key)
+	encodedState, err := marshal(value)
+	if err != nil {
+		return err
+	}
+
+	// a modify index of 0 makes consul write the key only if it doesn't exist
+	kv := &api.KVPair{Key: key, Value: encodedState, ModifyIndex: revision}
+	ok, _, err := d.Client.KV().CAS(kv, nil)
+	if err != nil && (api.IsServerError(err) || strings.Contains(err.Error(), "EOF") ||
+		strings.Contains(err.Error(), "connection refused")) {
+		for i := 0; i < maxConsulRetries; i++ {
+			ok, _, err = d.Client.KV().CAS(kv, nil)
+			if err == nil {
+				break
+			}
+
+			// Retry after a delay
+			time.Sleep(time.Second)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return core.Errorf("%s for key %s", core.ErrRevisionMismatch, key)
+	}
+
+	return nil
+}
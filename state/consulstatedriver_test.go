@@ -84,6 +84,11 @@ func TestConsulStateDriverReadStateAfterClear(t *testing.T) {
 	commonTestStateDriverReadStateAfterClear(t, driver)
 }
 
+func TestConsulStateDriverWriteStateIfRevision(t *testing.T) {
+	driver := setupConsulDriver(t)
+	commonTestStateDriverWriteStateIfRevision(t, driver)
+}
+
 func TestConsulStateDriverWatchAllStateCreate(t *testing.T) {
 	driver := setupConsulDriver(t)
 	commonTestStateDriverWatchAllStateCreate(t, driver)
@@ -334,3 +334,71 @@ func (d *EtcdStateDriver) WriteState(key string, value core.State,
 
 	return d.Write(key, encodedState)
 }
+
+// ReadStateRevision reads key into a core.State with the unmarshaling
+// function and returns the etcd index the key was last modified at.
+func (d *EtcdStateDriver) ReadStateRevision(key string, value core.State,
+	unmarshal func([]byte, interface{}) error) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	defer cancel()
+
+	resp, err := d.KeysAPI.Get(ctx, key, &client.GetOptions{Quorum: true})
+	if err != nil && err.Error() == client.ErrClusterUnavailable.Error() {
+		// Retry few times if cluster is unavailable
+		for i := 0; i < maxEtcdRetries; i++ {
+			resp, err = d.KeysAPI.Get(ctx, key, &client.GetOptions{Quorum: true})
+			if err == nil {
+				break
+			}
+
+			// Retry after a delay
+			time.Sleep(time.Second)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if resp == nil || resp.Node == nil {
+		return 0, fmt.Errorf("Error reading from etcd")
+	}
+
+	return resp.Node.ModifiedIndex, unmarshal([]byte(resp.Node.Value), value)
+}
+
+// WriteStateIfRevision writes a value of core.State into a key with a given
+// marshaling function, if the key was last modified at the given etcd index.
+func (d *EtcdStateDriver) WriteStateIfRevision(key string, value core.State,
+	marshal func(interface{}) ([]byte, error), revision uint64) error {
+	encodedState, err := marshal(value)
+	if err != nil {
+		return err
+	}
+
+	opts := &client.SetOptions{PrevIndex: revision}
+	if revision == 0 {
+		opts.PrevExist = client.PrevNoExist
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	defer cancel()
+
+	_, err = d.KeysAPI.Set(ctx, key, string(encodedState), opts)
+	if err != nil && err.Error() == client.ErrClusterUnavailable.Error() {
+		// Retry few times if cluster is unavailable
+		for i := 0; i < maxEtcdRetries; i++ {
+			_, err = d.KeysAPI.Set(ctx, key, string(encodedState), opts)
+			if err == nil {
+				break
+			}
+
+			// Retry after a delay
+			time.Sleep(time.Second)
+		}
+	}
+	if etcdErr, ok := err.(client.Error); ok &&
+		(etcdErr.Code == client.ErrorCodeTestFailed || etcdErr.Code == client.ErrorCodeNodeExist) {
+		return core.Errorf("%s for key %s: %s", core.ErrRevisionMismatch, key, etcdErr.Message)
+	}
+
+	return err
+}
@@ -272,6 +272,53 @@ func TestEtcdStateDriverReadStateAfterClear(t *testing.T) {
 	commonTestStateDriverReadStateAfterClear(t, driver)
 }
 
+func commonTestStateDriverWriteStateIfRevision(t *testing.T, d core.StateDriver) {
+	state := &testState{IntField: 1234, StrField: "testString"}
+	key := "testKeyWriteIfRevision"
+	d.ClearState(key)
+
+	err := d.WriteStateIfRevision(key, state, json.Marshal, 0)
+	if err != nil {
+		t.Fatalf("failed to create state. Error: %s", err)
+	}
+
+	err = d.WriteStateIfRevision(key, state, json.Marshal, 0)
+	if !core.IsRevisionMismatch(err) {
+		t.Fatalf("Able to create existing state. Error: %v", err)
+	}
+
+	readState := &testState{}
+	revision, err := d.ReadStateRevision(key, readState, json.Unmarshal)
+	if err != nil {
+		t.Fatalf("failed to read state. Error: %s", err)
+	}
+	if readState.IntField != state.IntField || readState.StrField != state.StrField {
+		t.Fatalf("Read state didn't match state written. Wrote: %v Read: %v",
+			state, readState)
+	}
+
+	state.StrField = "testStringUpdated"
+	err = d.WriteStateIfRevision(key, state, json.Marshal, revision)
+	if err != nil {
+		t.Fatalf("failed to update state at revision %d. Error: %s", revision, err)
+	}
+
+	err = d.WriteStateIfRevision(key, state, json.Marshal, revision)
+	if !core.IsRevisionMismatch(err) {
+		t.Fatalf("Able to update state at stale revision %d. Error: %v", revision, err)
+	}
+
+	newRevision, err := d.ReadStateRevision(key, readState, json.Unmarshal)
+	if err != nil || newRevision == revision || readState.StrField != state.StrField {
+		t.Fatalf("Unexpected state %v at revision %d. Error: %v", readState, newRevision, err)
+	}
+}
+
+func TestEtcdStateDriverWriteStateIfRevision(t *testing.T) {
+	driver := setupEtcdDriver(t)
+	commonTestStateDriverWriteStateIfRevision(t, driver)
+}
+
 func commonTestStateDriverWatchAllStateCreate(t *testing.T, d core.StateDriver) {
 	state := &testState{IntField: 1234, StrField: "testString"}
 	baseKey := "create"
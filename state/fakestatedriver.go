@@ -9,7 +9,8 @@ import (
 )
 
 type valueData struct {
-	value []byte
+	value    []byte
+	revision uint64
 }
 
 // FakeStateDriverConfig represents the configuration of the fake statedriver,
@@ -20,6 +21,7 @@ type FakeStateDriverConfig struct{}
 // unit-tests
 type FakeStateDriver struct {
 	TestState map[string]valueData
+	revision  uint64
 }
 
 // Init the driver
@@ -36,7 +38,8 @@ func (d *FakeStateDriver) Deinit() {
 
 // Write value to key
 func (d *FakeStateDriver) Write(key string, value []byte) error {
-	val := valueData{value: value}
+	d.revision++
+	val := valueData{value: value, revision: d.revision}
 	d.TestState[key] = val
 
 	return nil
@@ -111,6 +114,29 @@ func (d *FakeStateDriver) WriteState(key string, value core.State,
 	return d.Write(key, encodedState)
 }
 
+// ReadStateRevision unmarshals state into a core.State and returns its
+// revision
+func (d *FakeStateDriver) ReadStateRevision(key string, value core.State,
+	unmarshal func([]byte, interface{}) error) (uint64, error) {
+	val, ok := d.TestState[key]
+	if !ok {
+		return 0, core.Errorf("Key not found! key: %v", key)
+	}
+
+	return val.revision, unmarshal(val.value, value)
+}
+
+// WriteStateIfRevision writes a core.State to key if it is at the given
+// revision
+func (d *FakeStateDriver) WriteStateIfRevision(key string, value core.State,
+	marshal func(interface{}) ([]byte, error), revision uint64) error {
+	if d.TestState[key].revision != revision {
+		return core.Errorf("%s for key %s", core.ErrRevisionMismatch, key)
+	}
+
+	return d.WriteState(key, value, marshal)
+}
+
 // DumpState is a debugging tool.
 func (d *FakeStateDriver) DumpState() {
 	for key := range d.TestState {
@@ -0,0 +1,42 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"testing"
+)
+
+func setupFakeDriver(t *testing.T) *FakeStateDriver {
+	driver := &FakeStateDriver{}
+
+	err := driver.Init(nil)
+	if err != nil {
+		t.Fatalf("driver init failed. Error: %s", err)
+		return nil
+	}
+
+	return driver
+}
+
+func TestFakeStateDriverReadState(t *testing.T) {
+	driver := setupFakeDriver(t)
+	commonTestStateDriverReadState(t, driver)
+}
+
+func TestFakeStateDriverWriteStateIfRevision(t *testing.T) {
+	driver := setupFakeDriver(t)
+	commonTestStateDriverWriteStateIfRevision(t, driver)
+}
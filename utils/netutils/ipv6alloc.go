@@ -0,0 +1,358 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netutils
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/contiv/netplugin/core"
+)
+
+// IPv6Range is an inclusive range of IPv6 addresses
+type IPv6Range struct {
+	Start net.IP `json:"start"`
+	End   net.IP `json:"end"`
+}
+
+// IPv6Allocator allocates the addresses of an IPv6 address range. Allocated
+// and reserved addresses are kept as a sorted list of disjoint ranges, so
+// the cost of allocating, reserving and releasing addresses depends on the
+// number of ranges, not the number of addresses.
+type IPv6Allocator struct {
+	First     net.IP      `json:"first"`
+	Last      net.IP      `json:"last"`
+	Allocated []IPv6Range `json:"allocated"`
+}
+
+// ParseIPv6Range parses an IPv6 address range of the form
+// <start-address>-<end-address>, or a single address
+func ParseIPv6Range(ipRange string) (net.IP, net.IP, error) {
+	addrs := strings.Split(ipRange, "-")
+	if len(addrs) > 2 {
+		return nil, nil, core.Errorf("invalid IPv6 range %s", ipRange)
+	}
+
+	start := parseIPv6(addrs[0])
+	end := parseIPv6(addrs[len(addrs)-1])
+	if start == nil || end == nil || compareIPv6(start, end) > 0 {
+		return nil, nil, core.Errorf("invalid IPv6 range %s", ipRange)
+	}
+
+	return start, end, nil
+}
+
+// IsIPv6RangeContained checks if an IPv6 address range is contained in the
+// IPv6 subnet subnetAddr/subnetLen
+func IsIPv6RangeContained(ipRange, subnetAddr string, subnetLen uint) bool {
+	start, end, err := ParseIPv6Range(ipRange)
+	if err != nil {
+		return false
+	}
+
+	_, subnet, err := net.ParseCIDR(subnetAddr + "/" + strconv.Itoa(int(subnetLen)))
+	if err != nil {
+		return false
+	}
+
+	return subnet.Contains(start) && subnet.Contains(end)
+}
+
+// InitSubnet initializes the allocator with the addresses of the IPv6 subnet
+// subnetAddr/subnetLen, except the subnet-router anycast address
+func (a *IPv6Allocator) InitSubnet(subnetAddr string, subnetLen uint) error {
+	_, subnet, err := net.ParseCIDR(subnetAddr + "/" + strconv.Itoa(int(subnetLen)))
+	if err != nil || subnet.IP.To4() != nil || subnetLen == 128 {
+		return core.Errorf("invalid IPv6 subnet %s/%d", subnetAddr, subnetLen)
+	}
+
+	last := make(net.IP, net.IPv6len)
+	for i := range last {
+		last[i] = subnet.IP[i] | ^subnet.Mask[i]
+	}
+
+	a.First = nextIPv6(subnet.IP)
+	a.Last = last
+	a.Allocated = nil
+	return nil
+}
+
+// InitRange initializes the allocator with the addresses of an IPv6 address
+// range of the form <start-address>-<end-address>
+func (a *IPv6Allocator) InitRange(ipRange string) error {
+	start, end, err := ParseIPv6Range(ipRange)
+	if err != nil {
+		return err
+	}
+
+	a.First = start
+	a.Last = end
+	a.Allocated = nil
+	return nil
+}
+
+// Allocate allocates the lowest free address
+func (a *IPv6Allocator) Allocate() (string, error) {
+	if a.First == nil {
+		return "", core.Errorf("IPv6 allocator is not initialized")
+	}
+
+	next := a.First
+	idx := a.search(next)
+	if idx < len(a.Allocated) && compareIPv6(a.Allocated[idx].Start, next) <= 0 {
+		// ranges are merged, so the address after the range is free
+		if isLastIPv6(a.Allocated[idx].End) {
+			return "", core.Errorf("IPv6 address exhaustion in range %s-%s", a.First, a.Last)
+		}
+		next = nextIPv6(a.Allocated[idx].End)
+	}
+	if compareIPv6(next, a.Last) > 0 {
+		return "", core.Errorf("IPv6 address exhaustion in range %s-%s", a.First, a.Last)
+	}
+
+	a.add(next, next)
+	return next.String(), nil
+}
+
+// Reserve marks an address as allocated
+func (a *IPv6Allocator) Reserve(addr string) error {
+	return a.ReserveRange(addr)
+}
+
+// ReserveRange marks an address range of the form
+// <start-address>-<end-address> as allocated
+func (a *IPv6Allocator) ReserveRange(ipRange string) error {
+	start, end, err := a.parseRange(ipRange)
+	if err != nil {
+		return err
+	}
+
+	a.add(start, end)
+	return nil
+}
+
+// Release frees an address and returns whether it was allocated
+func (a *IPv6Allocator) Release(addr string) bool {
+	allocated := a.IsAllocated(addr)
+	a.ReleaseRange(addr)
+	return allocated
+}
+
+// ReleaseRange frees an address range of the form
+// <start-address>-<end-address>
+func (a *IPv6Allocator) ReleaseRange(ipRange string) error {
+	start, end, err := a.parseRange(ipRange)
+	if err != nil {
+		return err
+	}
+
+	a.remove(start, end)
+	return nil
+}
+
+// IsAllocated checks if an address is allocated
+func (a *IPv6Allocator) IsAllocated(addr string) bool {
+	ip := parseIPv6(addr)
+	if ip == nil {
+		return false
+	}
+
+	idx := a.search(ip)
+	return idx < len(a.Allocated) && compareIPv6(a.Allocated[idx].Start, ip) <= 0
+}
+
+// IsRangeFree checks if none of the addresses of an address range of the
+// form <start-address>-<end-address> are allocated
+func (a *IPv6Allocator) IsRangeFree(ipRange string) bool {
+	start, end, err := a.parseRange(ipRange)
+	if err != nil {
+		return false
+	}
+
+	idx := a.search(start)
+	return idx == len(a.Allocated) || compareIPv6(a.Allocated[idx].Start, end) > 0
+}
+
+// IsEmpty checks if no addresses are allocated
+func (a *IPv6Allocator) IsEmpty() bool {
+	return len(a.Allocated) == 0
+}
+
+// Clone returns a copy of the allocator
+func (a *IPv6Allocator) Clone() *IPv6Allocator {
+	return &IPv6Allocator{
+		First:     a.First,
+		Last:      a.Last,
+		Allocated: append([]IPv6Range(nil), a.Allocated...),
+	}
+}
+
+// List returns the allocated addresses, lowest first. At most limit
+// addresses are returned.
+func (a *IPv6Allocator) List(limit int) []string {
+	addrs := []string{}
+	for _, r := range a.Allocated {
+		for ip := r.Start; len(addrs) < limit; ip = nextIPv6(ip) {
+			addrs = append(addrs, ip.String())
+			if compareIPv6(ip, r.End) >= 0 {
+				break
+			}
+		}
+	}
+
+	return addrs
+}
+
+// parseRange parses an address range and checks that it is within the
+// addresses of the allocator
+func (a *IPv6Allocator) parseRange(ipRange string) (net.IP, net.IP, error) {
+	start, end, err := ParseIPv6Range(ipRange)
+	if err != nil {
+		return nil, nil, err
+	}
+	if a.First == nil || compareIPv6(start, a.First) < 0 || compareIPv6(end, a.Last) > 0 {
+		return nil, nil, core.Errorf("%s is outside of IPv6 range %s-%s", ipRange, a.First, a.Last)
+	}
+
+	return start, end, nil
+}
+
+// search returns the index of the first range that ends at or after ip
+func (a *IPv6Allocator) search(ip net.IP) int {
+	return sort.Search(len(a.Allocated), func(i int) bool {
+		return compareIPv6(a.Allocated[i].End, ip) >= 0
+	})
+}
+
+// add marks the range start-end as allocated, merging it with the ranges it
+// overlaps or adjoins
+func (a *IPv6Allocator) add(start, end net.IP) {
+	lo := start
+	if !isFirstIPv6(start) {
+		lo = prevIPv6(start)
+	}
+	hi := end
+	if !isLastIPv6(end) {
+		hi = nextIPv6(end)
+	}
+
+	first := a.search(lo)
+	last := sort.Search(len(a.Allocated), func(i int) bool {
+		return compareIPv6(a.Allocated[i].Start, hi) > 0
+	})
+
+	merged := IPv6Range{Start: start, End: end}
+	if first < last {
+		if compareIPv6(a.Allocated[first].Start, start) < 0 {
+			merged.Start = a.Allocated[first].Start
+		}
+		if compareIPv6(a.Allocated[last-1].End, end) > 0 {
+			merged.End = a.Allocated[last-1].End
+		}
+	}
+
+	a.replace(first, last, merged)
+}
+
+// remove marks the range start-end as free, splitting the ranges it
+// partially overlaps
+func (a *IPv6Allocator) remove(start, end net.IP) {
+	first := a.search(start)
+	last := sort.Search(len(a.Allocated), func(i int) bool {
+		return compareIPv6(a.Allocated[i].Start, end) > 0
+	})
+	if first >= last {
+		return
+	}
+
+	remaining := []IPv6Range{}
+	if compareIPv6(a.Allocated[first].Start, start) < 0 {
+		remaining = append(remaining, IPv6Range{Start: a.Allocated[first].Start, End: prevIPv6(start)})
+	}
+	if compareIPv6(a.Allocated[last-1].End, end) > 0 {
+		remaining = append(remaining, IPv6Range{Start: nextIPv6(end), End: a.Allocated[last-1].End})
+	}
+
+	a.replace(first, last, remaining...)
+}
+
+// replace replaces the ranges first to last-1 with the given ranges. Ranges
+// are replaced in place, so merging an address into an existing range does
+// not copy the list.
+func (a *IPv6Allocator) replace(first, last int, ranges ...IPv6Range) {
+	size := len(a.Allocated) - (last - first) + len(ranges)
+	end := first + len(ranges)
+	if end > last {
+		a.Allocated = append(a.Allocated, make([]IPv6Range, end-last)...)
+	}
+	copy(a.Allocated[end:], a.Allocated[last:])
+	copy(a.Allocated[first:], ranges)
+	a.Allocated = a.Allocated[:size]
+}
+
+// parseIPv6 parses an IPv6 address, returning nil for IPv4 addresses
+func parseIPv6(addr string) net.IP {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil || ip.To4() != nil {
+		return nil
+	}
+
+	return ip
+}
+
+func compareIPv6(a, b net.IP) int {
+	return bytes.Compare(a.To16(), b.To16())
+}
+
+func isFirstIPv6(ip net.IP) bool {
+	return ip.To16().Equal(net.IPv6unspecified)
+}
+
+func isLastIPv6(ip net.IP) bool {
+	return bytes.Equal(ip.To16(), bytes.Repeat([]byte{0xff}, net.IPv6len))
+}
+
+// nextIPv6 returns the address after ip, wrapping around at the last address
+func nextIPv6(ip net.IP) net.IP {
+	next := make(net.IP, net.IPv6len)
+	copy(next, ip.To16())
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+
+	return next
+}
+
+// prevIPv6 returns the address before ip, wrapping around at the first
+// address
+func prevIPv6(ip net.IP) net.IP {
+	prev := make(net.IP, net.IPv6len)
+	copy(prev, ip.To16())
+	for i := len(prev) - 1; i >= 0; i-- {
+		prev[i]--
+		if prev[i] != 0xff {
+			break
+		}
+	}
+
+	return prev
+}
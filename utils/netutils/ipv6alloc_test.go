@@ -0,0 +1,165 @@
+/***
+Copyright 2017 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func checkIPv6Allocate(t *testing.T, a *IPv6Allocator, expAddr string) {
+	addr, err := a.Allocate()
+	if err != nil || addr != expAddr {
+		t.Fatalf("Allocated %s, expected %s. Err: %v", addr, expAddr, err)
+	}
+}
+
+func checkIPv6Ranges(t *testing.T, a *IPv6Allocator, expRanges ...string) {
+	ranges := []string{}
+	for _, r := range a.Allocated {
+		ranges = append(ranges, r.Start.String()+"-"+r.End.String())
+	}
+	if fmt.Sprint(ranges) != fmt.Sprint(expRanges) {
+		t.Fatalf("Allocated ranges %v, expected %v", ranges, expRanges)
+	}
+}
+
+func TestIPv6AllocatorSubnet(t *testing.T) {
+	a := &IPv6Allocator{}
+	if err := a.InitSubnet("2001:db8::", 64); err != nil {
+		t.Fatalf("Error initializing allocator. Err: %v", err)
+	}
+	if a.First.String() != "2001:db8::1" || a.Last.String() != "2001:db8::ffff:ffff:ffff:ffff" {
+		t.Fatalf("Unexpected allocator range %s-%s", a.First, a.Last)
+	}
+
+	checkIPv6Allocate(t, a, "2001:db8::1")
+	checkIPv6Allocate(t, a, "2001:db8::2")
+
+	// reserved ranges are skipped and merged with adjoining allocations
+	if err := a.ReserveRange("2001:db8::3-2001:db8::ff"); err != nil {
+		t.Fatalf("Error reserving range. Err: %v", err)
+	}
+	checkIPv6Allocate(t, a, "2001:db8::100")
+	checkIPv6Ranges(t, a, "2001:db8::1-2001:db8::100")
+
+	// released addresses are reused lowest first
+	if !a.Release("2001:db8::2") || a.Release("2001:db8::2") {
+		t.Fatalf("Unexpected release result of 2001:db8::2")
+	}
+	if !a.Release("2001:db8::10") {
+		t.Fatalf("Unexpected release result of 2001:db8::10")
+	}
+	checkIPv6Ranges(t, a, "2001:db8::1-2001:db8::1", "2001:db8::3-2001:db8::f", "2001:db8::11-2001:db8::100")
+	checkIPv6Allocate(t, a, "2001:db8::2")
+	checkIPv6Allocate(t, a, "2001:db8::10")
+	checkIPv6Ranges(t, a, "2001:db8::1-2001:db8::100")
+
+	if a.IsAllocated("2001:db8::101") || !a.IsAllocated("2001:db8::50") {
+		t.Fatalf("Unexpected allocation state")
+	}
+	if a.IsRangeFree("2001:db8::100-2001:db8::200") || !a.IsRangeFree("2001:db8::101-2001:db8::200") {
+		t.Fatalf("Unexpected range state")
+	}
+
+	if err := a.Reserve("2001:db9::1"); err == nil {
+		t.Fatalf("Reserved address outside of subnet")
+	}
+
+	// the allocation state survives a round trip through the state store
+	jdata, err := json.Marshal(a)
+	if err != nil {
+		t.Fatalf("Error marshaling allocator. Err: %v", err)
+	}
+	b := &IPv6Allocator{}
+	if err := json.Unmarshal(jdata, b); err != nil {
+		t.Fatalf("Error unmarshaling allocator. Err: %v", err)
+	}
+	checkIPv6Ranges(t, b, "2001:db8::1-2001:db8::100")
+	checkIPv6Allocate(t, b, "2001:db8::101")
+}
+
+func TestIPv6AllocatorExhaustion(t *testing.T) {
+	a := &IPv6Allocator{}
+	if err := a.InitRange("2001:db8::fffe-2001:db8::1:1"); err != nil {
+		t.Fatalf("Error initializing allocator. Err: %v", err)
+	}
+
+	for _, addr := range []string{"2001:db8::fffe", "2001:db8::ffff", "2001:db8::1:0", "2001:db8::1:1"} {
+		checkIPv6Allocate(t, a, addr)
+	}
+	if addr, err := a.Allocate(); err == nil {
+		t.Fatalf("Allocated %s from exhausted range", addr)
+	}
+
+	a.ReleaseRange("2001:db8::ffff-2001:db8::1:0")
+	checkIPv6Ranges(t, a, "2001:db8::fffe-2001:db8::fffe", "2001:db8::1:1-2001:db8::1:1")
+	checkIPv6Allocate(t, a, "2001:db8::ffff")
+}
+
+func TestIPv6AllocatorScale(t *testing.T) {
+	a := &IPv6Allocator{}
+	if err := a.InitSubnet("2001:db8::", 64); err != nil {
+		t.Fatalf("Error initializing allocator. Err: %v", err)
+	}
+
+	// a million allocations in a /64 stay a single range
+	for i := 0; i < 1000000; i++ {
+		if _, err := a.Allocate(); err != nil {
+			t.Fatalf("Error allocating address %d. Err: %v", i, err)
+		}
+	}
+	checkIPv6Ranges(t, a, "2001:db8::1-2001:db8::f:4240")
+}
+
+func TestInvalidIPv6Ranges(t *testing.T) {
+	for _, ipRange := range []string{"", "10.1.1.1-10.1.1.10", "2001:db8::10-2001:db8::1", "2001:db8::1-2001:db8::2-2001:db8::3"} {
+		if _, _, err := ParseIPv6Range(ipRange); err == nil {
+			t.Fatalf("Parsed invalid IPv6 range %q", ipRange)
+		}
+	}
+
+	if !IsIPv6RangeContained("2001:db8::10-2001:db8::20", "2001:db8::", 64) ||
+		IsIPv6RangeContained("2001:db8::10-2001:db9::20", "2001:db8::", 64) {
+		t.Fatalf("Unexpected IPv6 range containment")
+	}
+}
+
+func TestIPv6AllocatorCloneList(t *testing.T) {
+	a := &IPv6Allocator{}
+	if err := a.InitRange("2001:db8::1-2001:db8::100"); err != nil {
+		t.Fatalf("Error initializing allocator. Err: %v", err)
+	}
+	if err := a.ReserveRange("2001:db8::10-2001:db8::12"); err != nil {
+		t.Fatalf("Error reserving range. Err: %v", err)
+	}
+	checkIPv6Allocate(t, a, "2001:db8::1")
+
+	// releasing from a clone leaves the allocator alone
+	c := a.Clone()
+	c.Release("2001:db8::11")
+	checkIPv6Ranges(t, a, "2001:db8::1-2001:db8::1", "2001:db8::10-2001:db8::12")
+	checkIPv6Ranges(t, c, "2001:db8::1-2001:db8::1", "2001:db8::10-2001:db8::10",
+		"2001:db8::12-2001:db8::12")
+
+	if addrs := fmt.Sprint(a.List(10)); addrs != "[2001:db8::1 2001:db8::10 2001:db8::11 2001:db8::12]" {
+		t.Fatalf("Unexpected allocated addresses %s", addrs)
+	}
+	if addrs := fmt.Sprint(a.List(2)); addrs != "[2001:db8::1 2001:db8::10]" {
+		t.Fatalf("Unexpected allocated addresses %s", addrs)
+	}
+}
@@ -19,11 +19,11 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"math"
 	"math/big"
 	"net"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"unsafe"
@@ -326,6 +326,169 @@ func ClearBitsOutsideRange(ipAllocMap *bitset.BitSet, ipRange string, subnetLen
 	}
 }
 
+// GetIPPools returns the address ranges of a comma separated list of ip
+// pools. A pool of a single address is returned as a range of one address.
+func GetIPPools(ipPools string) []string {
+	pools := []string{}
+	for _, pool := range strings.Split(ipPools, ",") {
+		pool = strings.TrimSpace(pool)
+		if pool == "" {
+			continue
+		}
+		if !strings.Contains(pool, "-") {
+			pool = pool + "-" + pool
+		}
+		pools = append(pools, pool)
+	}
+
+	return pools
+}
+
+// GetIPPoolHostRange returns the host ids of the first and last address of
+// an ip pool
+func GetIPPoolHostRange(ipPool string, subnetIP string, subnetLen uint) (uint, uint, error) {
+	addrRangeList := strings.Split(ipPool, "-")
+	if len(addrRangeList) != 2 {
+		return 0, 0, core.Errorf("invalid ip pool %s", ipPool)
+	}
+
+	hostMin, err := GetIPNumber(subnetIP, subnetLen, 32, addrRangeList[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	hostMax, err := GetIPNumber(subnetIP, subnetLen, 32, addrRangeList[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if hostMin > hostMax {
+		return 0, 0, core.Errorf("invalid ip pool %s", ipPool)
+	}
+
+	return hostMin, hostMax, nil
+}
+
+// poolsBitset returns a bitset with the addresses of a list of ip pools set
+func poolsBitset(ipPools string, subnetIP string, subnetLen uint) (*bitset.BitSet, error) {
+	poolMap := &bitset.BitSet{}
+	for _, pool := range GetIPPools(ipPools) {
+		hostMin, hostMax, err := GetIPPoolHostRange(pool, subnetIP, subnetLen)
+		if err != nil {
+			return nil, err
+		}
+		for i := hostMin; i <= hostMax; i++ {
+			poolMap.Set(i)
+		}
+	}
+
+	return poolMap, nil
+}
+
+// SetBitsOutsidePools sets all IPs outside a list of ip pools as used
+func SetBitsOutsidePools(ipAllocMap *bitset.BitSet, ipPools string, subnetIP string, subnetLen uint) error {
+	poolMap, err := poolsBitset(ipPools, subnetIP, subnetLen)
+	if err != nil {
+		return err
+	}
+
+	maxHosts := uint(1 << (32 - subnetLen))
+	for i := uint(0); i < maxHosts; i++ {
+		if !poolMap.Test(i) {
+			ipAllocMap.Set(i)
+		}
+	}
+
+	return nil
+}
+
+// ClearBitsOutsidePools clears all IPs outside a list of ip pools
+func ClearBitsOutsidePools(ipAllocMap *bitset.BitSet, ipPools string, subnetIP string, subnetLen uint) error {
+	poolMap, err := poolsBitset(ipPools, subnetIP, subnetLen)
+	if err != nil {
+		return err
+	}
+
+	maxHosts := uint(1 << (32 - subnetLen))
+	for i, found := ipAllocMap.NextSet(0); found && i < maxHosts; i, found = ipAllocMap.NextSet(i + 1) {
+		if !poolMap.Test(i) {
+			ipAllocMap.Clear(i)
+		}
+	}
+
+	return nil
+}
+
+// NextClearInPools returns the first free IP of a list of ip pools. The pools
+// are used in order, the next pool is used when a pool is exhausted.
+func NextClearInPools(ipAllocMap bitset.BitSet, ipPools string, subnetIP string, subnetLen uint) (uint, bool) {
+	for _, pool := range GetIPPools(ipPools) {
+		hostMin, hostMax, err := GetIPPoolHostRange(pool, subnetIP, subnetLen)
+		if err != nil {
+			log.Errorf("Error parsing ip pool %s. Err: %v", pool, err)
+			continue
+		}
+
+		value, found := NextClear(ipAllocMap, hostMin, subnetLen)
+		if found && value <= hostMax {
+			return value, true
+		}
+	}
+
+	return 0, false
+}
+
+// IsIPAddrRangeContained checks if the ip address range is fully contained in
+// the outer ip address range. Both ranges are in the format returned by GetIPAddrRange
+func IsIPAddrRangeContained(ipRange, outerRange string) bool {
+	rangeMin, err := ipv4ToUint32(getFirstAddrInRange(ipRange))
+	if err != nil {
+		return false
+	}
+	rangeMax, err := ipv4ToUint32(getLastAddrInRange(ipRange, 32))
+	if err != nil {
+		return false
+	}
+	outerMin, err := ipv4ToUint32(getFirstAddrInRange(outerRange))
+	if err != nil {
+		return false
+	}
+	outerMax, err := ipv4ToUint32(getLastAddrInRange(outerRange, 32))
+	if err != nil {
+		return false
+	}
+
+	return rangeMin >= outerMin && rangeMax <= outerMax
+}
+
+// RebaseIPAllocMap moves the allocated addresses in allocMap from subnetIP/subnetLen
+// to newSubnetIP/newSubnetLen. Reserved entries and bits outside ipRange are not
+// carried over, callers need to mark them again for the new subnet.
+func RebaseIPAllocMap(allocMap *bitset.BitSet, ipRange string, subnetIP string, subnetLen uint,
+	newSubnetIP string, newSubnetLen uint) (bitset.BitSet, error) {
+	var newMap bitset.BitSet
+
+	oldMap := allocMap.Clone()
+	ClearReservedEntries(oldMap, subnetLen)
+	ClearBitsOutsideRange(oldMap, ipRange, subnetLen)
+
+	maxHosts := uint(1 << (32 - subnetLen))
+	idx, found := oldMap.NextSet(0)
+	for found && idx < maxHosts {
+		ipAddr, err := GetSubnetIP(subnetIP, subnetLen, 32, idx)
+		if err != nil {
+			return newMap, err
+		}
+		newIdx, err := GetIPNumber(newSubnetIP, newSubnetLen, 32, ipAddr)
+		if err != nil {
+			return newMap, err
+		}
+		newMap.Set(newIdx)
+
+		idx, found = oldMap.NextSet(idx + 1)
+	}
+
+	return newMap, nil
+}
+
 // CreateBitset initializes a bit set with 2^numBitsWide bits
 func CreateBitset(numBitsWide uint) *bitset.BitSet {
 	maxSize := 1 << numBitsWide
@@ -423,73 +586,6 @@ func GetIPNumber(subnetIP string, subnetLen uint, allocSubnetLen uint, hostIP st
 	return uint(hostID), nil
 }
 
-// ReserveIPv6HostID sets the hostId in the AllocMap
-func ReserveIPv6HostID(hostID string, IPv6AllocMap *map[string]bool) {
-	if hostID == "" {
-		return
-	}
-	if *IPv6AllocMap == nil {
-		*IPv6AllocMap = make(map[string]bool)
-	}
-	(*IPv6AllocMap)[hostID] = true
-}
-
-// GetNextIPv6HostID returns the next available hostId in the AllocMap
-func GetNextIPv6HostID(hostID, subnetAddr string, subnetLen uint, IPv6AllocMap map[string]bool) (string, error) {
-	if hostID == "" {
-		hostID = "::"
-	}
-	if subnetLen == 0 {
-		return "", core.Errorf("subnet length %d is invalid", subnetLen)
-	}
-
-	hostidIP := net.ParseIP(hostID)
-
-	// start with the carryOver 1 to get the next hostID
-	var carryOver = 1
-	var allocd = true
-
-	for allocd == true {
-		// Add 1 to hostID
-		for i := len(hostidIP) - 1; i >= 0; i-- {
-			var temp int
-			temp = int(hostidIP[i]) + carryOver
-			if temp > int(0xFF) {
-				hostidIP[i] = 0
-				carryOver = 1
-			} else {
-				hostidIP[i] = uint8(temp)
-				carryOver = 0
-				break
-			}
-		}
-		// Check if this hostID is already allocated
-		if _, allocd = IPv6AllocMap[hostidIP.String()]; allocd == true {
-			// Already allocated find the next hostID
-			carryOver = 1
-		} else {
-			// allocd == false. check if we reached MaxHosts
-			offset := (subnetLen - 1) / 8
-			masklen := subnetLen % 8
-			mask := ((1 << masklen) - 1) << (8 - masklen)
-			if (hostidIP[offset] & byte(mask)) != 0 {
-				// if hostID is outside subnet range,
-				//	check if we have reached MaxHosts
-				maxHosts := math.Pow(2, float64(128-subnetLen)) - 1
-				if float64(len(IPv6AllocMap)) < maxHosts {
-					hostID = "::"
-					hostidIP = net.ParseIP(hostID)
-					carryOver = 1
-					allocd = true // continue the loop
-				} else {
-					return "", core.Errorf("Reached MaxHosts (%v). Cannot allocate more hosts", maxHosts)
-				}
-			}
-		}
-	}
-	return hostidIP.String(), nil
-}
-
 // GetSubnetIPv6 given a subnet IP and host identifier, calculates an IPv6 address
 // within the subnet for use.
 func GetSubnetIPv6(subnetAddr string, subnetLen uint, hostID string) (string, error) {
@@ -503,7 +599,7 @@ func GetSubnetIPv6(subnetAddr string, subnetLen uint, hostID string) (string, er
 
 	subnetIP := net.ParseIP(subnetAddr)
 	hostidIP := net.ParseIP(hostID)
-	hostIP := net.IPv6zero
+	hostIP := make(net.IP, net.IPv6len)
 
 	var offset int
 	for offset = 0; offset < int(subnetLen/8); offset++ {
@@ -531,7 +627,7 @@ func GetIPv6HostID(subnetAddr string, subnetLen uint, hostAddr string) (string,
 		return "", core.Errorf("subnet length %d not supported", subnetLen)
 	}
 	// Initialize hostID
-	hostID := net.IPv6zero
+	hostID := make(net.IP, net.IPv6len)
 
 	var offset uint
 
@@ -627,6 +723,106 @@ func ParseTagRanges(ranges string, tagType string) ([]TagRange, error) {
 	return tagRanges, nil
 }
 
+// PortMask is a port number with a bit mask to match a block of ports
+type PortMask struct {
+	Port uint16
+	Mask uint16
+}
+
+// tagRangeList sorts tag ranges by their min value
+type tagRangeList []TagRange
+
+func (l tagRangeList) Len() int           { return len(l) }
+func (l tagRangeList) Less(i, j int) bool { return l[i].Min < l[j].Min }
+func (l tagRangeList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// ParsePortRanges takes a string such as 80,443,8000-8100 and turns it into
+// a sorted series of non overlapping TagRange.
+func ParsePortRanges(ports string) ([]TagRange, error) {
+	var err error
+
+	portRanges := []TagRange{}
+	for _, onePortStr := range strings.Split(ports, ",") {
+		var portRange TagRange
+
+		onePortStr = strings.Trim(onePortStr, " ")
+		portNums := strings.Split(onePortStr, "-")
+		if len(portNums) > 2 {
+			return nil, core.Errorf("invalid ports %s, correct '80,443,8000-8100'", onePortStr)
+		}
+		portRange.Min, err = strconv.Atoi(portNums[0])
+		if err != nil {
+			return nil, core.Errorf("invalid port %s", portNums[0])
+		}
+		portRange.Max = portRange.Min
+		if len(portNums) == 2 {
+			portRange.Max, err = strconv.Atoi(portNums[1])
+			if err != nil {
+				return nil, core.Errorf("invalid port %s", portNums[1])
+			}
+		}
+
+		if portRange.Min > portRange.Max {
+			return nil, core.Errorf("invalid range %s, min is greater than max", onePortStr)
+		}
+		if portRange.Min < 1 || portRange.Max > 65535 {
+			return nil, core.Errorf("invalid range %s, ports must be between 1 and 65535", onePortStr)
+		}
+
+		portRanges = append(portRanges, portRange)
+	}
+
+	// merge overlapping and adjacent ranges
+	sort.Sort(tagRangeList(portRanges))
+	merged := []TagRange{portRanges[0]}
+	for _, portRange := range portRanges[1:] {
+		last := &merged[len(merged)-1]
+		if portRange.Min <= last.Max+1 {
+			if portRange.Max > last.Max {
+				last.Max = portRange.Max
+			}
+		} else {
+			merged = append(merged, portRange)
+		}
+	}
+
+	return merged, nil
+}
+
+// FormatPortRanges returns the compact string form of port ranges
+func FormatPortRanges(portRanges []TagRange) string {
+	list := []string{}
+	for _, portRange := range portRanges {
+		if portRange.Min == portRange.Max {
+			list = append(list, strconv.Itoa(portRange.Min))
+		} else {
+			list = append(list, fmt.Sprintf("%d-%d", portRange.Min, portRange.Max))
+		}
+	}
+
+	return strings.Join(list, ",")
+}
+
+// GetPortMasks returns the minimal set of masked port matches that exactly
+// cover the port ranges
+func GetPortMasks(portRanges []TagRange) []PortMask {
+	portMasks := []PortMask{}
+	for _, portRange := range portRanges {
+		port := portRange.Min
+		for port <= portRange.Max {
+			// find the largest aligned block starting at port within the range
+			size := 1
+			for port&(size*2-1) == 0 && port+size*2-1 <= portRange.Max {
+				size *= 2
+			}
+			portMasks = append(portMasks, PortMask{Port: uint16(port), Mask: uint16(0xffff &^ (size - 1))})
+			port += size
+		}
+	}
+
+	return portMasks
+}
+
 // ParseCIDR parses a CIDR string into a gateway IP and length.
 func ParseCIDR(cidrStr string) (string, uint, error) {
 	strs := strings.Split(cidrStr, "/")
@@ -682,6 +878,26 @@ func SetInterfaceIP(name string, ipstr string) error {
 	return netlink.AddrAdd(iface, ipaddr)
 }
 
+// ReplaceInterfaceIP : Replace an IP address of an interface
+func ReplaceInterfaceIP(name string, oldIPStr string, newIPStr string) error {
+	iface, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	oldAddr, err := netlink.ParseAddr(oldIPStr)
+	if err != nil {
+		return err
+	}
+	newAddr, err := netlink.ParseAddr(newIPStr)
+	if err != nil {
+		return err
+	}
+	if err := netlink.AddrDel(iface, oldAddr); err != nil {
+		log.Warnf("Error deleting address %s from %s. Err: %v", oldIPStr, name, err)
+	}
+	return netlink.AddrAdd(iface, newAddr)
+}
+
 // SetInterfaceMac : Set mac address of an interface
 func SetInterfaceMac(name string, macaddr string) error {
 	iface, err := netlink.LinkByName(name)
@@ -18,6 +18,7 @@ package netutils
 import (
 	"fmt"
 	"github.com/jainvipin/bitset"
+	"strings"
 	"testing"
 )
 
@@ -176,45 +177,6 @@ func TestInvalidGetSubnetIPv6(t *testing.T) {
 	}
 }
 
-type testHostID struct {
-	hostID     string
-	nextHostID string
-	subnetAddr string
-	subnetLen  uint
-}
-
-var testHostIDs = []testHostID{
-	// verify initial case
-	{hostID: "", nextHostID: "::1", subnetAddr: "1234::", subnetLen: 100},
-
-	// lasthost set to 0 and ::1 allocated above
-	{hostID: "::", nextHostID: "::2", subnetAddr: "1234::", subnetLen: 100},
-
-	// find next of 4
-	{hostID: "::4:FFFF:FFFF", nextHostID: "::5:0:0", subnetAddr: "1234::", subnetLen: 100},
-
-	// this time next of 4 should skip 5, as it was alloc'd above
-	{hostID: "::4:ffff:ffff", nextHostID: "::5:0:1", subnetAddr: "1234::", subnetLen: 100},
-
-	// verify the corner case
-	{hostID: "::FFFE", nextHostID: "::ffff", subnetAddr: "1234::", subnetLen: 100},
-
-	// verify the wrap around case, with subnetLen 124
-	{hostID: "::F", nextHostID: "::3", subnetAddr: "1234::", subnetLen: 124},
-}
-
-func TestGetNextIPv6HostID(t *testing.T) {
-	var allocMap map[string]bool
-	for _, te := range testHostIDs {
-		nextHostID, err := GetNextIPv6HostID(te.hostID, te.subnetAddr, te.subnetLen, allocMap)
-		if nextHostID != te.nextHostID || err != nil {
-			t.Fatalf("obtained nextHostID %s doesn't match expected ID %s for %s\n",
-				nextHostID, te.nextHostID, te.hostID)
-		}
-		ReserveIPv6HostID(nextHostID, &allocMap)
-	}
-}
-
 func TestValidRange(t *testing.T) {
 	rangeStr := "5-100, 101-200"
 	_, err := ParseTagRanges(rangeStr, "vlan")
@@ -523,3 +485,133 @@ func TestClearIPAddrRange(t *testing.T) {
 	}
 
 }
+
+func TestIPAddrRangeContained(t *testing.T) {
+	testRanges := []struct {
+		ipRange    string
+		outerRange string
+		status     bool
+	}{
+		{ipRange: "10.36.1.0-10.36.1.255", outerRange: "10.36.0.0-10.36.1.255", status: true},
+		{ipRange: "10.36.1.10-10.36.1.20", outerRange: "10.36.1.10-10.36.1.20", status: true},
+		{ipRange: "10.36.1.1-10.36.1.1", outerRange: "10.36.1.0-10.36.1.255", status: true},
+		{ipRange: "10.36.1.0-10.36.1.255", outerRange: "10.36.1.0-10.36.1.127", status: false},
+		{ipRange: "10.36.1.0-10.36.1.255", outerRange: "10.36.2.0-10.36.3.255", status: false},
+	}
+
+	for _, i := range testRanges {
+		assertOnTrue(t, IsIPAddrRangeContained(i.ipRange, i.outerRange) != i.status,
+			fmt.Sprintf("failed for data %+v", i))
+	}
+}
+
+func TestRebaseIPAllocMap(t *testing.T) {
+	var amap bitset.BitSet
+
+	InitSubnetBitset(&amap, 24)
+	for _, ip := range []string{"10.36.1.1", "10.36.1.5", "10.36.1.254"} {
+		hostID, err := GetIPNumber("10.36.1.0", 24, 32, ip)
+		assertOnTrue(t, err != nil, fmt.Sprintf("error getting host id for %s: %s", ip, err))
+		amap.Set(hostID)
+	}
+
+	newMap, err := RebaseIPAllocMap(&amap, "10.36.1.0-10.36.1.255", "10.36.1.0", 24, "10.36.0.0", 23)
+	assertOnTrue(t, err != nil, fmt.Sprintf("rebase failed %s", err))
+	InitSubnetBitset(&newMap, 23)
+
+	a := ListAllocatedIPs(newMap, "10.36.0.0-10.36.1.255", "10.36.0.0", 23)
+	assertOnTrue(t, a != "10.36.1.1, 10.36.1.5, 10.36.1.254",
+		fmt.Sprintf("got allocated addr: [%s] after rebase", a))
+
+	// original map must be left untouched
+	assertOnTrue(t, !amap.Test(0) || !amap.Test(255), "reserved entries cleared in original map")
+}
+
+func TestIPPools(t *testing.T) {
+	var amap bitset.BitSet
+
+	pools := GetIPPools("10.36.1.20-10.36.1.21, 10.36.1.10,,10.36.1.30-10.36.1.31")
+	assertOnTrue(t, strings.Join(pools, ",") != "10.36.1.20-10.36.1.21,10.36.1.10-10.36.1.10,10.36.1.30-10.36.1.31",
+		fmt.Sprintf("got pools %v", pools))
+
+	ipPools := strings.Join(pools, ",")
+	InitSubnetBitset(&amap, 24)
+	err := SetBitsOutsidePools(&amap, ipPools, "10.36.1.0", 24)
+	assertOnTrue(t, err != nil, fmt.Sprintf("error setting bits outside pools: %s", err))
+	a := ListAvailableIPs(amap, "10.36.1.0", 24)
+	assertOnTrue(t, a != "10.36.1.10, 10.36.1.20-10.36.1.21, 10.36.1.30-10.36.1.31",
+		fmt.Sprintf("got available addr: [%s]", a))
+
+	// pools are used in order
+	allocated := []string{}
+	for {
+		hostID, found := NextClearInPools(amap, ipPools, "10.36.1.0", 24)
+		if !found {
+			break
+		}
+		amap.Set(hostID)
+		ipAddr, err := GetSubnetIP("10.36.1.0", 24, 32, hostID)
+		assertOnTrue(t, err != nil, fmt.Sprintf("error getting ip for host id %d: %s", hostID, err))
+		allocated = append(allocated, ipAddr)
+	}
+	assertOnTrue(t, strings.Join(allocated, ",") != "10.36.1.20,10.36.1.21,10.36.1.10,10.36.1.30,10.36.1.31",
+		fmt.Sprintf("got allocation order %v", allocated))
+
+	err = ClearBitsOutsidePools(&amap, "10.36.1.30-10.36.1.31", "10.36.1.0", 24)
+	assertOnTrue(t, err != nil, fmt.Sprintf("error clearing bits outside pools: %s", err))
+	a = ListAllocatedIPs(amap, "10.36.1.0-10.36.1.255", "10.36.1.0", 24)
+	assertOnTrue(t, a != "10.36.1.30-10.36.1.31", fmt.Sprintf("got allocated addr: [%s]", a))
+
+	_, _, err = GetIPPoolHostRange("10.36.1.31-10.36.1.30", "10.36.1.0", 24)
+	assertOnTrue(t, err == nil, "reversed ip pool accepted")
+}
+
+func TestParsePortRanges(t *testing.T) {
+	testPorts := []struct {
+		ports   string
+		compact string
+		status  bool
+	}{
+		{ports: "80", compact: "80", status: true},
+		{ports: "443,80,8443", compact: "80,443,8443", status: true},
+		{ports: "8000-8100,8050-8200,8201", compact: "8000-8201", status: true},
+		{ports: "80,81,82-90", compact: "80-90", status: true},
+		{ports: "0", status: false},
+		{ports: "65536", status: false},
+		{ports: "100-90", status: false},
+		{ports: "80-90-100", status: false},
+		{ports: "80,abc", status: false},
+	}
+
+	for _, i := range testPorts {
+		portRanges, err := ParsePortRanges(i.ports)
+		assertOnTrue(t, (err == nil) != i.status, fmt.Sprintf("err: %v, failed for data %+v", err, i))
+		if i.status {
+			c := FormatPortRanges(portRanges)
+			assertOnTrue(t, c != i.compact, fmt.Sprintf("got [%s], expected [%s]", c, i.compact))
+		}
+	}
+}
+
+func TestGetPortMasks(t *testing.T) {
+	testPorts := []struct {
+		ports string
+		masks []PortMask
+	}{
+		{ports: "80", masks: []PortMask{{80, 0xffff}}},
+		{ports: "80-81", masks: []PortMask{{80, 0xfffe}}},
+		{ports: "1024-2047", masks: []PortMask{{1024, 0xfc00}}},
+		{ports: "8000-8100", masks: []PortMask{{8000, 0xffc0}, {8064, 0xffe0}, {8096, 0xfffc}, {8100, 0xffff}}},
+		{ports: "1-65535", masks: []PortMask{{1, 0xffff}, {2, 0xfffe}, {4, 0xfffc}, {8, 0xfff8},
+			{16, 0xfff0}, {32, 0xffe0}, {64, 0xffc0}, {128, 0xff80}, {256, 0xff00}, {512, 0xfe00},
+			{1024, 0xfc00}, {2048, 0xf800}, {4096, 0xf000}, {8192, 0xe000}, {16384, 0xc000}, {32768, 0x8000}}},
+	}
+
+	for _, i := range testPorts {
+		portRanges, err := ParsePortRanges(i.ports)
+		assertOnTrue(t, err != nil, fmt.Sprintf("err: %v, failed for data %+v", err, i))
+		masks := GetPortMasks(portRanges)
+		assertOnTrue(t, fmt.Sprintf("%v", masks) != fmt.Sprintf("%v", i.masks),
+			fmt.Sprintf("got %v, expected %v", masks, i.masks))
+	}
+}